@@ -9,7 +9,7 @@ require (
 	github.com/zapscloud/golib-platform-service v0.0.0-20231122105022-d0d0f2a614b1
 	github.com/zapscloud/golib-sales-repository v0.0.0-20240528064031-65194f32420a
 	github.com/zapscloud/golib-utils v1.0.1-0.20231117081529-93ad4f30cea1
-
+	go.mongodb.org/mongo-driver v1.12.1
 )
 
 require (
@@ -31,7 +31,6 @@ require (
 	github.com/xdg-go/stringprep v1.0.4 // indirect
 	github.com/youmark/pkcs8 v0.0.0-20201027041543-1326539a0a0a // indirect
 	github.com/zapscloud/golib v1.0.4 // indirect
-	go.mongodb.org/mongo-driver v1.12.1 // indirect
 	golang.org/x/crypto v0.2.0 // indirect
 	golang.org/x/sync v0.1.0 // indirect
 	golang.org/x/sys v0.5.0 // indirect
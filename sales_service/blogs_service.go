@@ -35,6 +35,7 @@ type blogBaseService struct {
 	db_utils.DatabaseService
 	dbRegion    db_utils.DatabaseService
 	daoBlog     sales_repository.BlogDao
+	seoSlugs    *seoSlugs
 	daoBusiness platform_repository.BusinessDao
 	child       BlogService
 	businessId  string
@@ -94,6 +95,7 @@ func (p *blogBaseService) initializeService() {
 	log.Printf("BlogService:: GetBusinessDao ")
 	p.daoBusiness = platform_repository.NewBusinessDao(p.GetClient())
 	p.daoBlog = sales_repository.NewBlogDao(p.dbRegion.GetClient(), p.businessId)
+	p.seoSlugs = newSeoSlugs(p.dbRegion, p.businessId)
 }
 
 // List - List All records
@@ -146,6 +148,12 @@ func (p *blogBaseService) Create(indata utils.Map) (utils.Map, error) {
 	indata[sales_common.FLD_BUSINESS_ID] = p.businessId
 	indata[sales_common.FLD_BLOG_ID] = blogId
 
+	// Slug is generated from the name when not given
+	err := p.seoSlugs.assignOnCreate(SEO_ENTITY_BLOG, blogId, indata)
+	if err != nil {
+		return utils.Map{}, err
+	}
+
 	data, err := p.daoBlog.Create(indata)
 	if err != nil {
 		return utils.Map{}, err
@@ -160,6 +168,18 @@ func (p *blogBaseService) Update(blogId string, indata utils.Map) (utils.Map, er
 
 	log.Println("BlogService::Update - Begin")
 
+	// Changed slug keeps the old one for the redirect
+	if _, ok := indata[FLD_SEO_SLUG]; ok {
+		current, err := p.daoBlog.Get(blogId)
+		if err != nil {
+			return nil, err
+		}
+		err = p.seoSlugs.assignOnUpdate(SEO_ENTITY_BLOG, current, indata)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	data, err := p.daoBlog.Update(blogId, indata)
 
 	log.Println("BlogService::Update - End ")
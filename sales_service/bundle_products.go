@@ -0,0 +1,373 @@
+package sales_service
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/zapscloud/golib-sales-repository/sales_common"
+	"github.com/zapscloud/golib-sales-repository/sales_repository"
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+const (
+	// Bundle fields of the product
+	FLD_PRODUCT_IS_BUNDLE      = "is_bundle"
+	FLD_BUNDLE_COMPONENTS      = "bundle_components"
+	FLD_BUNDLE_PRICE           = "bundle_price"
+	FLD_BUNDLE_DISCOUNT        = "bundle_discount_percent"
+	FLD_BUNDLE_LIST_PRICE      = "list_price"
+	FLD_BUNDLE_COMPONENT       = "component"
+	FLD_BUNDLE_ALLOCATED_PRICE = "allocated_price"
+
+	// Order fields for the fulfillment of the bundles
+	FLD_ORDER_ITEMS             = "items"
+	FLD_ORDER_FULFILLMENT_LINES = "fulfillment_lines"
+	FLD_ORDER_BUNDLE_ID         = "bundle_product_id"
+)
+
+// bundleComponent - Product or variant with its quantity in the bundle
+type bundleComponent struct {
+	productId string
+	quantity  int
+}
+
+// isBundle - Check whether the product is a bundle
+func isBundle(product utils.Map) bool {
+	bundle, _ := product[FLD_PRODUCT_IS_BUNDLE].(bool)
+	return bundle
+}
+
+// bundleComponents - Get the components of the bundle product
+func bundleComponents(product utils.Map) []bundleComponent {
+	components := []bundleComponent{}
+	for _, item := range toMapSlice(product[FLD_BUNDLE_COMPONENTS]) {
+		productId, _ := item[sales_common.FLD_PRODUCT_ID].(string)
+		quantity := toInt(item[FLD_RESERVATION_QUANTITY])
+		if len(productId) > 0 && quantity > 0 {
+			components = append(components, bundleComponent{productId: productId, quantity: quantity})
+		}
+	}
+	return components
+}
+
+// loadBundleComponents - Get the component products of the bundle
+func loadBundleComponents(daoProduct sales_repository.ProductDao, bundle utils.Map) (map[string]utils.Map, error) {
+	products := map[string]utils.Map{}
+	for _, component := range bundleComponents(bundle) {
+		product, err := daoProduct.Get(component.productId)
+		if err != nil {
+			return nil, err
+		}
+		products[component.productId] = withScheduledPrice(product, time.Now())
+	}
+	return products, nil
+}
+
+// bundleListPrice - Total price of the components bought separately
+func bundleListPrice(bundle utils.Map, products map[string]utils.Map) float64 {
+	total := 0.0
+	for _, component := range bundleComponents(bundle) {
+		total += toFloat(products[component.productId][FLD_PRODUCT_PRICE]) * float64(component.quantity)
+	}
+	return roundAmount(total)
+}
+
+// bundleSellingPrice - Fixed price of the bundle, else the list price less the bundle discount
+func bundleSellingPrice(bundle utils.Map, listPrice float64) float64 {
+	if price := toFloat(bundle[FLD_BUNDLE_PRICE]); price > 0 {
+		return roundAmount(price)
+	}
+	discount := math.Min(math.Max(toFloat(bundle[FLD_BUNDLE_DISCOUNT]), 0), 100)
+	return roundAmount(listPrice * (100 - discount) / 100)
+}
+
+// bundleAvailable - Number of bundles the available stock of the components can make
+func bundleAvailable(bundle utils.Map, products map[string]utils.Map, now time.Time) int {
+	components := bundleComponents(bundle)
+	if len(components) == 0 {
+		return 0
+	}
+
+	available := math.MaxInt
+	for _, component := range components {
+		product := products[component.productId]
+		reservations, _ := activeReservations(product, now)
+		stock := productOnHand(product) - reservedQuantity(reservations)
+		available = minInt(available, stock/component.quantity)
+	}
+	if available < 0 {
+		return 0
+	}
+	return available
+}
+
+// getPricedProduct - Get the product with the price of the due schedules, the price of the bundle is derived from its components
+func getPricedProduct(daoProduct sales_repository.ProductDao, productId string) (utils.Map, error) {
+	product, err := daoProduct.Get(productId)
+	if err != nil {
+		return nil, err
+	}
+	return pricedProduct(daoProduct, product)
+}
+
+// pricedProduct - Product with the price in effect, the bundle price is derived from its components
+func pricedProduct(daoProduct sales_repository.ProductDao, product utils.Map) (utils.Map, error) {
+	if !isBundle(product) {
+		return withScheduledPrice(product, time.Now()), nil
+	}
+
+	products, err := loadBundleComponents(daoProduct, product)
+	if err != nil {
+		return nil, err
+	}
+
+	product = utils.CopyMap(product)
+	product[FLD_PRODUCT_PRICE] = bundleSellingPrice(product, bundleListPrice(product, products))
+	return product, nil
+}
+
+// expandBundleItems - Replace the bundle items with their components, items are [{product_id, quantity}]
+// The items without product are kept as they are, left to be rejected by the caller
+func expandBundleItems(daoProduct sales_repository.ProductDao, items []utils.Map) ([]utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "13"
+
+	expanded := []utils.Map{}
+	for _, item := range items {
+		productId, _ := item[sales_common.FLD_PRODUCT_ID].(string)
+		if len(productId) == 0 {
+			expanded = append(expanded, item)
+			continue
+		}
+
+		product, err := daoProduct.Get(productId)
+		if err != nil {
+			log.Println("expandBundleItems - Product not loaded", productId, err)
+			err := &utils.AppError{
+				ErrorCode:   funcode + "01",
+				ErrorMsg:    "Invalid order item",
+				ErrorDetail: fmt.Sprintf("Given product %s is not exist", productId)}
+			return nil, err
+		}
+		if !isBundle(product) {
+			expanded = append(expanded, item)
+			continue
+		}
+
+		quantity := toInt(item[FLD_RESERVATION_QUANTITY])
+		for _, component := range bundleComponents(product) {
+			line := utils.CopyMap(item)
+			line[sales_common.FLD_PRODUCT_ID] = component.productId
+			line[FLD_RESERVATION_QUANTITY] = component.quantity * quantity
+			line[FLD_ORDER_BUNDLE_ID] = productId
+			expanded = append(expanded, line)
+		}
+	}
+	return expanded, nil
+}
+
+// ExplodeBundleLines - Build the fulfillment lines of the order items, bundles are replaced with their components.
+// The price of the bundle is allocated to the components in proportion to their list price.
+func ExplodeBundleLines(daoProduct sales_repository.ProductDao, items []utils.Map) ([]utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "13"
+
+	lines := []utils.Map{}
+	for _, item := range items {
+		productId, _ := item[sales_common.FLD_PRODUCT_ID].(string)
+		quantity := toInt(item[FLD_RESERVATION_QUANTITY])
+
+		product, err := daoProduct.Get(productId)
+		if err != nil {
+			err := &utils.AppError{
+				ErrorCode:   funcode + "01",
+				ErrorMsg:    "Invalid order item",
+				ErrorDetail: fmt.Sprintf("Given product %s is not exist", productId)}
+			return nil, err
+		}
+		if !isBundle(product) {
+			lines = append(lines, utils.Map{
+				sales_common.FLD_PRODUCT_ID: productId,
+				FLD_RESERVATION_QUANTITY:    quantity,
+			})
+			continue
+		}
+
+		products, err := loadBundleComponents(daoProduct, product)
+		if err != nil {
+			return nil, err
+		}
+		listPrice := bundleListPrice(product, products)
+		sellingPrice := bundleSellingPrice(product, listPrice)
+
+		components := bundleComponents(product)
+		allocated := 0.0
+		for idx, component := range components {
+			share := 0.0
+			if idx == len(components)-1 {
+				// Last component takes the rounding difference
+				share = roundAmount(sellingPrice - allocated)
+			} else if listPrice > 0 {
+				componentPrice := toFloat(products[component.productId][FLD_PRODUCT_PRICE]) * float64(component.quantity)
+				share = roundAmount(sellingPrice * componentPrice / listPrice)
+			}
+			allocated += share
+
+			lines = append(lines, utils.Map{
+				sales_common.FLD_PRODUCT_ID: component.productId,
+				FLD_RESERVATION_QUANTITY:    component.quantity * quantity,
+				FLD_ORDER_BUNDLE_ID:         productId,
+				FLD_BUNDLE_ALLOCATED_PRICE:  roundAmount(share * float64(quantity)),
+			})
+		}
+	}
+	return lines, nil
+}
+
+// ProductBundleService - Product Bundle Service structure
+type ProductBundleService interface {
+	// SetBundle - Make the product a bundle of the components [{product_id, quantity}]
+	// with either the fixed bundle price or the discount percent on the total of the components
+	SetBundle(productId string, components []utils.Map, bundlePrice float64, discountPercent float64) (utils.Map, error)
+	// GetBundle - Get the bundle with its components, price and the stock derived from the components
+	GetBundle(productId string) (utils.Map, error)
+
+	EndService()
+}
+
+// productBundleBaseService - Product Bundle Service structure, shares the product service
+type productBundleBaseService struct {
+	*productBaseService
+}
+
+// NewProductBundleService - Construct Product Bundle
+func NewProductBundleService(props utils.Map) (ProductBundleService, error) {
+
+	log.Printf("ProductBundleService::Start ")
+	p, err := newProductBaseService(props)
+	if err != nil {
+		return nil, err
+	}
+	return &productBundleBaseService{p}, nil
+}
+
+// SetBundle - Make the product a bundle of the components [{product_id, quantity}]
+// with either the fixed bundle price or the discount percent on the total of the components
+// The bundle is changed directly like the price schedules, not through the draft
+func (p *productBundleBaseService) SetBundle(productId string, components []utils.Map, bundlePrice float64, discountPercent float64) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "13"
+
+	log.Println("ProductBundleService::SetBundle - Begin", productId)
+
+	_, err := p.daoProduct.Get(productId)
+	if err != nil {
+		return nil, err
+	}
+
+	if (bundlePrice > 0) == (discountPercent > 0) || discountPercent > 100 {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "02",
+			ErrorMsg:    "Invalid bundle price",
+			ErrorDetail: "Set either the bundle price or the discount percent up to 100"}
+		return nil, err
+	}
+
+	// Merge the quantities of the same component
+	quantities := map[string]int{}
+	componentIds := []string{}
+	for _, component := range components {
+		componentId, _ := component[sales_common.FLD_PRODUCT_ID].(string)
+		quantity := toInt(component[FLD_RESERVATION_QUANTITY])
+		if len(componentId) == 0 || quantity <= 0 {
+			err := &utils.AppError{
+				ErrorCode:   funcode + "03",
+				ErrorMsg:    "Invalid bundle component",
+				ErrorDetail: "Each component requires product_id and a positive quantity"}
+			return nil, err
+		}
+		if _, ok := quantities[componentId]; !ok {
+			componentIds = append(componentIds, componentId)
+		}
+		quantities[componentId] += quantity
+	}
+	if len(componentIds) == 0 {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "04",
+			ErrorMsg:    "Invalid bundle",
+			ErrorDetail: "Bundle requires at least one component"}
+		return nil, err
+	}
+
+	bundleItems := make([]utils.Map, 0, len(componentIds))
+	for _, componentId := range componentIds {
+		component, err := p.daoProduct.Get(componentId)
+		if err != nil {
+			return nil, err
+		}
+		if componentId == productId || isBundle(component) {
+			err := &utils.AppError{
+				ErrorCode:   funcode + "05",
+				ErrorMsg:    "Invalid bundle component",
+				ErrorDetail: fmt.Sprintf("Product %s is a bundle, bundles cannot contain bundles", componentId)}
+			return nil, err
+		}
+		bundleItems = append(bundleItems, utils.Map{
+			sales_common.FLD_PRODUCT_ID: componentId,
+			FLD_RESERVATION_QUANTITY:    quantities[componentId],
+		})
+	}
+
+	indata := utils.Map{
+		FLD_PRODUCT_IS_BUNDLE: true,
+		FLD_BUNDLE_COMPONENTS: bundleItems,
+		FLD_BUNDLE_PRICE:      bundlePrice,
+		FLD_BUNDLE_DISCOUNT:   discountPercent,
+	}
+	data, err := p.updateProduct(productId, indata)
+
+	log.Println("ProductBundleService::SetBundle - End ", err)
+	return data, err
+}
+
+// GetBundle - Get the bundle with its components, price and the stock derived from the components
+func (p *productBundleBaseService) GetBundle(productId string) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "13"
+
+	log.Println("ProductBundleService::GetBundle - Begin", productId)
+
+	bundle, err := p.daoProduct.Get(productId)
+	if err != nil {
+		return nil, err
+	}
+	if !isBundle(bundle) {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "06",
+			ErrorMsg:    "Invalid bundle",
+			ErrorDetail: "Given product is not a bundle"}
+		return nil, err
+	}
+
+	products, err := loadBundleComponents(p.daoProduct, bundle)
+	if err != nil {
+		return nil, err
+	}
+
+	components := []utils.Map{}
+	for _, component := range bundleComponents(bundle) {
+		components = append(components, utils.Map{
+			sales_common.FLD_PRODUCT_ID: component.productId,
+			FLD_RESERVATION_QUANTITY:    component.quantity,
+			FLD_BUNDLE_COMPONENT:        products[component.productId],
+		})
+	}
+
+	listPrice := bundleListPrice(bundle, products)
+	response := utils.CopyMap(bundle)
+	response[FLD_BUNDLE_COMPONENTS] = components
+	response[FLD_BUNDLE_LIST_PRICE] = listPrice
+	response[FLD_PRODUCT_PRICE] = bundleSellingPrice(bundle, listPrice)
+	response[FLD_STOCK_AVAILABLE] = bundleAvailable(bundle, products, time.Now())
+
+	log.Println("ProductBundleService::GetBundle - End ")
+	return response, nil
+}
@@ -0,0 +1,43 @@
+package sales_service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zapscloud/golib-sales-repository/sales_common"
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+func TestStoredBundleComponents(t *testing.T) {
+	bundle := roundTrip(t, utils.Map{
+		FLD_PRODUCT_IS_BUNDLE: true,
+		FLD_BUNDLE_COMPONENTS: []utils.Map{
+			{sales_common.FLD_PRODUCT_ID: "prod_a", FLD_RESERVATION_QUANTITY: 2},
+			{sales_common.FLD_PRODUCT_ID: "prod_b", FLD_RESERVATION_QUANTITY: 1},
+		},
+		FLD_BUNDLE_DISCOUNT: 10,
+	})
+
+	if !isBundle(bundle) {
+		t.Fatal("stored bundle is not a bundle")
+	}
+	components := bundleComponents(bundle)
+	if len(components) != 2 || components[0] != (bundleComponent{"prod_a", 2}) || components[1] != (bundleComponent{"prod_b", 1}) {
+		t.Fatalf("bundleComponents = %v", components)
+	}
+
+	products := map[string]utils.Map{
+		"prod_a": roundTrip(t, utils.Map{FLD_PRODUCT_PRICE: 100, FLD_PRODUCT_STOCK: 7}),
+		"prod_b": roundTrip(t, utils.Map{FLD_PRODUCT_PRICE: 50.5, FLD_PRODUCT_STOCK: 2}),
+	}
+	listPrice := bundleListPrice(bundle, products)
+	if listPrice != 250.5 {
+		t.Errorf("bundleListPrice = %g", listPrice)
+	}
+	if price := bundleSellingPrice(bundle, listPrice); price != 225.45 {
+		t.Errorf("bundleSellingPrice = %g", price)
+	}
+	if available := bundleAvailable(bundle, products, time.Now()); available != 2 {
+		t.Errorf("bundleAvailable = %d", available)
+	}
+}
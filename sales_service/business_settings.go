@@ -0,0 +1,59 @@
+package sales_service
+
+import (
+	"log"
+
+	"github.com/zapscloud/golib-platform-repository/platform_repository"
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+const (
+	// Sales module settings are kept in the business record under this field
+	FLD_BUSINESS_SALES_SETTINGS = "sales_settings"
+)
+
+// getBusinessSettings - Get the sales module settings of the business
+func getBusinessSettings(daoBusiness platform_repository.BusinessDao, businessId string) (utils.Map, error) {
+
+	business, err := daoBusiness.Get(businessId)
+	if err != nil {
+		return nil, err
+	}
+
+	settings, ok := toMap(business[FLD_BUSINESS_SALES_SETTINGS])
+	if !ok {
+		settings = utils.Map{}
+	}
+	return settings, nil
+}
+
+// getBusinessSetting - Get a sales module setting of the business, returns false when not set
+func getBusinessSetting(daoBusiness platform_repository.BusinessDao, businessId string, key string) (any, bool, error) {
+
+	settings, err := getBusinessSettings(daoBusiness, businessId)
+	if err != nil {
+		return nil, false, err
+	}
+
+	value, ok := settings[key]
+	return value, ok, nil
+}
+
+// updateBusinessSetting - Update a sales module setting of the business
+func updateBusinessSetting(daoBusiness platform_repository.BusinessDao, businessId string, key string, value any) (utils.Map, error) {
+
+	log.Println("updateBusinessSetting ", businessId, key)
+
+	settings, err := getBusinessSettings(daoBusiness, businessId)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the given setting is written, the settings updated meanwhile are kept
+	_, err = daoBusiness.Update(businessId, utils.Map{FLD_BUSINESS_SALES_SETTINGS + "." + key: value})
+	if err != nil {
+		return nil, err
+	}
+	settings[key] = value
+	return settings, nil
+}
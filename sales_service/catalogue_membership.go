@@ -0,0 +1,130 @@
+package sales_service
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zapscloud/golib-sales-repository/sales_common"
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+const (
+	// Catalogue fields for the product membership
+	FLD_CATALOGUE_PRODUCT_IDS  = "product_ids"
+	FLD_CATALOGUE_EXCLUDED_IDS = "excluded_product_ids"
+	FLD_CATALOGUE_RULES        = "membership_rules"
+	FLD_CATALOGUE_RULE_SORT    = "rule_sort_field"
+	FLD_CATALOGUE_RULE_DESC    = "rule_sort_desc"
+	FLD_CATALOGUE_STOREFRONT   = "storefront_id"
+	FLD_CATALOGUE_PUBLISH_FROM = "publish_start"
+	FLD_CATALOGUE_PUBLISH_TILL = "publish_end"
+
+	// Membership rule fields, every product matching all the given fields is included
+	FLD_RULE_INCLUDE_SUBCATEGORIES = "include_subcategories"
+
+	// Response fields of the catalogue products
+	FLD_CATALOGUE_PRODUCTS = "products"
+)
+
+// insertIds - Insert the ids at the position, ids already in the list are skipped
+// Position beyond the list or negative appends the ids at the end
+func insertIds(existing []string, ids []string, position int) []string {
+	present := map[string]bool{}
+	for _, id := range existing {
+		present[id] = true
+	}
+
+	newIds := []string{}
+	for _, id := range ids {
+		if !present[id] {
+			present[id] = true
+			newIds = append(newIds, id)
+		}
+	}
+
+	if position < 0 || position > len(existing) {
+		position = len(existing)
+	}
+
+	result := make([]string, 0, len(existing)+len(newIds))
+	result = append(result, existing[:position]...)
+	result = append(result, newIds...)
+	result = append(result, existing[position:]...)
+	return result
+}
+
+// removeIds - Remove the ids from the list
+func removeIds(existing []string, ids []string) []string {
+	removed := map[string]bool{}
+	for _, id := range ids {
+		removed[id] = true
+	}
+
+	result := []string{}
+	for _, id := range existing {
+		if !removed[id] {
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+// sameIds - Check whether both the lists have the same ids regardless of the order
+func sameIds(first []string, second []string) bool {
+	if len(first) != len(second) {
+		return false
+	}
+
+	counts := map[string]int{}
+	for _, id := range first {
+		counts[id]++
+	}
+	for _, id := range second {
+		counts[id]--
+		if counts[id] < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// isCataloguePublished - Check whether the catalogue is within its publish window
+func isCataloguePublished(catalogue utils.Map, now time.Time) bool {
+	if from, ok := toTime(catalogue[FLD_CATALOGUE_PUBLISH_FROM]); ok && now.Before(from) {
+		return false
+	}
+	if till, ok := toTime(catalogue[FLD_CATALOGUE_PUBLISH_TILL]); ok && !now.Before(till) {
+		return false
+	}
+	return true
+}
+
+// sortRuleProducts - Sort the products matched by the rules on the field, by product name when not given
+func sortRuleProducts(products []utils.Map, field string, desc bool) {
+	if len(field) == 0 {
+		field = FLD_PRODUCT_NAME
+	}
+
+	less := func(i, j int) bool {
+		first, second := products[i][field], products[j][field]
+		if firstStr, ok := first.(string); ok {
+			secondStr, _ := second.(string)
+			return strings.ToLower(firstStr) < strings.ToLower(secondStr)
+		}
+		return toFloat(first) < toFloat(second)
+	}
+
+	sort.SliceStable(products, func(i, j int) bool {
+		if desc {
+			return less(j, i)
+		}
+		return less(i, j)
+	})
+}
+
+// productIdOf - Id of the product record
+func productIdOf(product utils.Map) string {
+	productId, _ := product[sales_common.FLD_PRODUCT_ID].(string)
+	return productId
+}
@@ -0,0 +1,91 @@
+package sales_service
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zapscloud/golib-sales-repository/sales_common"
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+func TestInsertIds(t *testing.T) {
+	existing := []string{"a", "b", "c"}
+	tests := []struct {
+		ids      []string
+		position int
+		want     []string
+	}{
+		{[]string{"x"}, 1, []string{"a", "x", "b", "c"}},
+		{[]string{"x", "y"}, 0, []string{"x", "y", "a", "b", "c"}},
+		{[]string{"x"}, -1, []string{"a", "b", "c", "x"}},
+		{[]string{"x"}, 10, []string{"a", "b", "c", "x"}},
+		{[]string{"b", "x", "x"}, 0, []string{"x", "a", "b", "c"}},
+	}
+
+	for _, test := range tests {
+		if got := insertIds(existing, test.ids, test.position); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("insertIds(%v, %d) = %v, want %v", test.ids, test.position, got, test.want)
+		}
+	}
+	if !reflect.DeepEqual(existing, []string{"a", "b", "c"}) {
+		t.Errorf("insertIds changed the existing ids %v", existing)
+	}
+}
+
+func TestRemoveAndSameIds(t *testing.T) {
+	if got := removeIds([]string{"a", "b", "c"}, []string{"b", "z"}); !reflect.DeepEqual(got, []string{"a", "c"}) {
+		t.Errorf("removeIds = %v", got)
+	}
+	if !sameIds([]string{"a", "b"}, []string{"b", "a"}) {
+		t.Error("reordered ids are not the same")
+	}
+	if sameIds([]string{"a", "a"}, []string{"a", "b"}) || sameIds([]string{"a"}, []string{"a", "b"}) {
+		t.Error("different ids are the same")
+	}
+}
+
+func TestCataloguePublishWindow(t *testing.T) {
+	now := time.Date(2030, 6, 1, 12, 0, 0, 0, time.UTC)
+	catalogue := roundTrip(t, utils.Map{
+		FLD_CATALOGUE_PUBLISH_FROM: now.AddDate(0, 0, -1),
+		FLD_CATALOGUE_PUBLISH_TILL: now.AddDate(0, 0, 1),
+	})
+
+	if !isCataloguePublished(catalogue, now) {
+		t.Error("catalogue within the window is not published")
+	}
+	if isCataloguePublished(catalogue, now.AddDate(0, 0, -2)) {
+		t.Error("catalogue before the window is published")
+	}
+	if isCataloguePublished(catalogue, now.AddDate(0, 0, 1)) {
+		t.Error("catalogue at the end of the window is published")
+	}
+	if !isCataloguePublished(utils.Map{}, now) {
+		t.Error("catalogue without a window is not published")
+	}
+}
+
+func TestSortRuleProducts(t *testing.T) {
+	products := []utils.Map{
+		{sales_common.FLD_PRODUCT_ID: "p1", FLD_PRODUCT_NAME: "bed", FLD_PRODUCT_PRICE: 300},
+		{sales_common.FLD_PRODUCT_ID: "p2", FLD_PRODUCT_NAME: "Armchair", FLD_PRODUCT_PRICE: 100},
+		{sales_common.FLD_PRODUCT_ID: "p3", FLD_PRODUCT_NAME: "cot", FLD_PRODUCT_PRICE: 200},
+	}
+	ids := func() []string {
+		result := []string{}
+		for _, product := range products {
+			result = append(result, productIdOf(product))
+		}
+		return result
+	}
+
+	sortRuleProducts(products, "", false)
+	if got := ids(); !reflect.DeepEqual(got, []string{"p2", "p1", "p3"}) {
+		t.Errorf("sorted by name = %v", got)
+	}
+	sortRuleProducts(products, FLD_PRODUCT_PRICE, true)
+	if got := ids(); !reflect.DeepEqual(got, []string{"p1", "p3", "p2"}) {
+		t.Errorf("sorted by price descending = %v", got)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/zapscloud/golib-dbutils/db_common"
 	"github.com/zapscloud/golib-dbutils/db_utils"
@@ -30,6 +31,21 @@ type CatalogueService interface {
 	// Delete - Delete Service
 	Delete(catalogueId string, delete_permanent bool) error
 
+	// AddProducts - Add the products to the catalogue at the position, negative position appends at the end
+	AddProducts(catalogueId string, productIds []string, position int) (utils.Map, error)
+	// RemoveProducts - Remove the products from the catalogue, also the ones matched by the rules
+	RemoveProducts(catalogueId string, productIds []string) (utils.Map, error)
+	// ReorderProducts - Set the manual order of the products added to the catalogue
+	ReorderProducts(catalogueId string, productIds []string) (utils.Map, error)
+	// SetRules - Set the rules to include the matching products, e.g. [{"category_id": "beds", "include_subcategories": true}]
+	SetRules(catalogueId string, rules []utils.Map, sortField string, sortDesc bool) (utils.Map, error)
+	// SetPublishWindow - Set the publish start and end of the catalogue, zero time leaves it open
+	SetPublishWindow(catalogueId string, publishStart time.Time, publishEnd time.Time) (utils.Map, error)
+	// ListProducts - Get the products of the catalogue, manually added ones first then the ones matched by the rules
+	ListProducts(catalogueId string) (utils.Map, error)
+	// GetActive - Get the currently published catalogue of the storefront
+	GetActive(storefrontId string) (utils.Map, error)
+
 	EndService()
 }
 
@@ -38,6 +54,8 @@ type catalogueBaseService struct {
 	db_utils.DatabaseService
 	dbRegion     db_utils.DatabaseService
 	daoCatalogue sales_repository.CatalogueDao
+	daoProduct   sales_repository.ProductDao
+	daoCategory  sales_repository.CategoryDao
 	daoBusiness  platform_repository.BusinessDao
 	child        CatalogueService
 	businessId   string
@@ -97,6 +115,8 @@ func (p *catalogueBaseService) initializeService() {
 	log.Printf("CatalogueService:: GetBusinessDao ")
 	p.daoBusiness = platform_repository.NewBusinessDao(p.GetClient())
 	p.daoCatalogue = sales_repository.NewCatalogueDao(p.dbRegion.GetClient(), p.businessId)
+	p.daoProduct = sales_repository.NewProductDao(p.dbRegion.GetClient(), p.businessId)
+	p.daoCategory = sales_repository.NewCategoryDao(p.dbRegion.GetClient(), p.businessId)
 }
 
 // List - List All records
@@ -194,6 +214,292 @@ func (p *catalogueBaseService) Delete(catalogueId string, delete_permanent bool)
 	return nil
 }
 
+// AddProducts - Add the products to the catalogue at the position, negative position appends at the end
+func (p *catalogueBaseService) AddProducts(catalogueId string, productIds []string, position int) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "11"
+
+	log.Println("CatalogueService::AddProducts - Begin", catalogueId, productIds)
+
+	catalogue, err := p.daoCatalogue.Get(catalogueId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, productId := range productIds {
+		_, err := p.daoProduct.Get(productId)
+		if err != nil {
+			err := &utils.AppError{
+				ErrorCode:   funcode + "01",
+				ErrorMsg:    "Invalid product",
+				ErrorDetail: fmt.Sprintf("Given product %s is not exist", productId)}
+			return nil, err
+		}
+	}
+
+	indata := utils.Map{
+		FLD_CATALOGUE_PRODUCT_IDS:  insertIds(toStringSlice(catalogue[FLD_CATALOGUE_PRODUCT_IDS]), productIds, position),
+		FLD_CATALOGUE_EXCLUDED_IDS: removeIds(toStringSlice(catalogue[FLD_CATALOGUE_EXCLUDED_IDS]), productIds),
+	}
+	data, err := p.daoCatalogue.Update(catalogueId, indata)
+
+	log.Println("CatalogueService::AddProducts - End ", err)
+	return data, err
+}
+
+// RemoveProducts - Remove the products from the catalogue, also the ones matched by the rules
+func (p *catalogueBaseService) RemoveProducts(catalogueId string, productIds []string) (utils.Map, error) {
+
+	log.Println("CatalogueService::RemoveProducts - Begin", catalogueId, productIds)
+
+	catalogue, err := p.daoCatalogue.Get(catalogueId)
+	if err != nil {
+		return nil, err
+	}
+
+	// Removed products are excluded, so the rules do not add them back
+	excluded := toStringSlice(catalogue[FLD_CATALOGUE_EXCLUDED_IDS])
+	indata := utils.Map{
+		FLD_CATALOGUE_PRODUCT_IDS:  removeIds(toStringSlice(catalogue[FLD_CATALOGUE_PRODUCT_IDS]), productIds),
+		FLD_CATALOGUE_EXCLUDED_IDS: insertIds(excluded, productIds, len(excluded)),
+	}
+	data, err := p.daoCatalogue.Update(catalogueId, indata)
+
+	log.Println("CatalogueService::RemoveProducts - End ", err)
+	return data, err
+}
+
+// ReorderProducts - Set the manual order of the products added to the catalogue
+func (p *catalogueBaseService) ReorderProducts(catalogueId string, productIds []string) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "11"
+
+	log.Println("CatalogueService::ReorderProducts - Begin", catalogueId)
+
+	catalogue, err := p.daoCatalogue.Get(catalogueId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !sameIds(toStringSlice(catalogue[FLD_CATALOGUE_PRODUCT_IDS]), productIds) {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "02",
+			ErrorMsg:    "Invalid product order",
+			ErrorDetail: "Product order should have all the products of the catalogue exactly once"}
+		return nil, err
+	}
+
+	data, err := p.daoCatalogue.Update(catalogueId, utils.Map{FLD_CATALOGUE_PRODUCT_IDS: productIds})
+
+	log.Println("CatalogueService::ReorderProducts - End ", err)
+	return data, err
+}
+
+// SetRules - Set the rules to include the matching products, e.g. [{"category_id": "beds", "include_subcategories": true}]
+func (p *catalogueBaseService) SetRules(catalogueId string, rules []utils.Map, sortField string, sortDesc bool) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "11"
+
+	log.Println("CatalogueService::SetRules - Begin", catalogueId, len(rules))
+
+	_, err := p.daoCatalogue.Get(catalogueId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rule := range rules {
+		categoryId, _ := rule[sales_common.FLD_CATEGORY_ID].(string)
+		brandId, _ := rule[sales_common.FLD_BRAND_ID].(string)
+		if len(categoryId) == 0 && len(brandId) == 0 {
+			err := &utils.AppError{
+				ErrorCode:   funcode + "03",
+				ErrorMsg:    "Invalid membership rule",
+				ErrorDetail: "Each rule requires category_id or brand_id"}
+			return nil, err
+		}
+		if len(categoryId) > 0 {
+			_, err := p.daoCategory.Get(categoryId)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	indata := utils.Map{
+		FLD_CATALOGUE_RULES:     rules,
+		FLD_CATALOGUE_RULE_SORT: sortField,
+		FLD_CATALOGUE_RULE_DESC: sortDesc,
+	}
+	data, err := p.daoCatalogue.Update(catalogueId, indata)
+
+	log.Println("CatalogueService::SetRules - End ", err)
+	return data, err
+}
+
+// SetPublishWindow - Set the publish start and end of the catalogue, zero time leaves it open
+func (p *catalogueBaseService) SetPublishWindow(catalogueId string, publishStart time.Time, publishEnd time.Time) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "11"
+
+	log.Println("CatalogueService::SetPublishWindow - Begin", catalogueId, publishStart, publishEnd)
+
+	if !publishStart.IsZero() && !publishEnd.IsZero() && !publishEnd.After(publishStart) {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "04",
+			ErrorMsg:    "Invalid publish window",
+			ErrorDetail: "Publish end should be after the publish start"}
+		return nil, err
+	}
+
+	indata := utils.Map{
+		FLD_CATALOGUE_PUBLISH_FROM: nil,
+		FLD_CATALOGUE_PUBLISH_TILL: nil,
+	}
+	if !publishStart.IsZero() {
+		indata[FLD_CATALOGUE_PUBLISH_FROM] = publishStart
+	}
+	if !publishEnd.IsZero() {
+		indata[FLD_CATALOGUE_PUBLISH_TILL] = publishEnd
+	}
+	data, err := p.daoCatalogue.Update(catalogueId, indata)
+
+	log.Println("CatalogueService::SetPublishWindow - End ", err)
+	return data, err
+}
+
+// ListProducts - Get the products of the catalogue, manually added ones first then the ones matched by the rules
+func (p *catalogueBaseService) ListProducts(catalogueId string) (utils.Map, error) {
+
+	log.Println("CatalogueService::ListProducts - Begin", catalogueId)
+
+	catalogue, err := p.daoCatalogue.Get(catalogueId)
+	if err != nil {
+		return nil, err
+	}
+
+	products, err := p.catalogueProducts(catalogue)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("CatalogueService::ListProducts - End ", len(products))
+	return utils.Map{sales_common.FLD_CATALOGUE_ID: catalogueId, FLD_CATALOGUE_PRODUCTS: products}, nil
+}
+
+// GetActive - Get the currently published catalogue of the storefront
+// When the publish windows overlap, the catalogue published most recently is used
+func (p *catalogueBaseService) GetActive(storefrontId string) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "11"
+
+	log.Println("CatalogueService::GetActive - Begin", storefrontId)
+
+	listdata, err := p.daoCatalogue.List(buildFilter(utils.Map{FLD_CATALOGUE_STOREFRONT: storefrontId}), "", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var active utils.Map
+	var activeFrom time.Time
+	for _, catalogue := range listResult(listdata) {
+		if deleted, _ := catalogue[db_common.FLD_IS_DELETED].(bool); deleted || !isCataloguePublished(catalogue, now) {
+			continue
+		}
+		from, _ := toTime(catalogue[FLD_CATALOGUE_PUBLISH_FROM])
+		if active == nil || from.After(activeFrom) {
+			active = catalogue
+			activeFrom = from
+		}
+	}
+
+	if active == nil {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "05",
+			ErrorMsg:    "No active catalogue",
+			ErrorDetail: "No catalogue of the storefront is published currently"}
+		return nil, err
+	}
+
+	log.Println("CatalogueService::GetActive - End ", active[sales_common.FLD_CATALOGUE_ID])
+	return active, nil
+}
+
+// catalogueProducts - Resolve the products of the catalogue in their display order
+func (p *catalogueBaseService) catalogueProducts(catalogue utils.Map) ([]utils.Map, error) {
+
+	excluded := map[string]bool{}
+	for _, productId := range toStringSlice(catalogue[FLD_CATALOGUE_EXCLUDED_IDS]) {
+		excluded[productId] = true
+	}
+
+	products := []utils.Map{}
+	added := map[string]bool{}
+	for _, productId := range toStringSlice(catalogue[FLD_CATALOGUE_PRODUCT_IDS]) {
+		product, err := p.daoProduct.Get(productId)
+		if err != nil {
+			// Skip the products deleted after adding to the catalogue
+			continue
+		}
+		added[productId] = true
+		products = append(products, product)
+	}
+
+	ruleProducts := []utils.Map{}
+	for _, rule := range toMapSlice(catalogue[FLD_CATALOGUE_RULES]) {
+		matched, err := p.ruleProducts(rule)
+		if err != nil {
+			return nil, err
+		}
+		for _, product := range matched {
+			productId := productIdOf(product)
+			if added[productId] || excluded[productId] {
+				continue
+			}
+			added[productId] = true
+			ruleProducts = append(ruleProducts, product)
+		}
+	}
+
+	sortField, _ := catalogue[FLD_CATALOGUE_RULE_SORT].(string)
+	sortDesc, _ := catalogue[FLD_CATALOGUE_RULE_DESC].(bool)
+	sortRuleProducts(ruleProducts, sortField, sortDesc)
+
+	return append(products, ruleProducts...), nil
+}
+
+// ruleProducts - Get the products matching the membership rule, variants are listed under their parent
+func (p *catalogueBaseService) ruleProducts(rule utils.Map) ([]utils.Map, error) {
+
+	filter := utils.Map{}
+	if categoryId, _ := rule[sales_common.FLD_CATEGORY_ID].(string); len(categoryId) > 0 {
+		categoryIds := []string{categoryId}
+		if subcategories, _ := rule[FLD_RULE_INCLUDE_SUBCATEGORIES].(bool); subcategories {
+			var err error
+			categoryIds, err = categoryWithDescendantIds(p.daoCategory, categoryId)
+			if err != nil {
+				return nil, err
+			}
+		}
+		filter[sales_common.FLD_CATEGORY_ID] = utils.Map{"$in": categoryIds}
+	}
+	if brandId, _ := rule[sales_common.FLD_BRAND_ID].(string); len(brandId) > 0 {
+		filter[sales_common.FLD_BRAND_ID] = brandId
+	}
+
+	listdata, err := p.daoProduct.List(buildFilter(filter), "", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	products := []utils.Map{}
+	for _, product := range listResult(listdata) {
+		if deleted, _ := product[db_common.FLD_IS_DELETED].(bool); deleted {
+			continue
+		}
+		if parentId, _ := product[FLD_PRODUCT_PARENT_ID].(string); len(parentId) > 0 {
+			continue
+		}
+		products = append(products, product)
+	}
+	return products, nil
+}
+
 func (p *catalogueBaseService) errorReturn(err error) (CatalogueService, error) {
 	// Close the Database Connection
 	p.EndService()
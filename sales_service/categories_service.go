@@ -30,6 +30,15 @@ type CategoryService interface {
 	// Delete - Delete Service
 	Delete(categoryId string, delete_permanent bool) error
 
+	// Tree - Get the categories as nested tree
+	Tree() ([]utils.Map, error)
+	// Breadcrumb - Get the path of categories from the root to the category
+	Breadcrumb(categoryId string) ([]utils.Map, error)
+	// Move - Move the category under the new parent, empty parent moves it to the root
+	Move(categoryId string, newParentId string) (utils.Map, error)
+	// Descendants - Get all the categories below the category
+	Descendants(categoryId string) ([]utils.Map, error)
+
 	EndService()
 }
 
@@ -38,6 +47,8 @@ type categoryBaseService struct {
 	db_utils.DatabaseService
 	dbRegion    db_utils.DatabaseService
 	daoCategory sales_repository.CategoryDao
+	daoProduct  sales_repository.ProductDao
+	seoSlugs    *seoSlugs
 	daoBusiness platform_repository.BusinessDao
 	child       CategoryService
 	businessId  string
@@ -97,6 +108,8 @@ func (p *categoryBaseService) initializeService() {
 	log.Printf("CategoryService:: GetBusinessDao ")
 	p.daoBusiness = platform_repository.NewBusinessDao(p.GetClient())
 	p.daoCategory = sales_repository.NewCategoryDao(p.dbRegion.GetClient(), p.businessId)
+	p.seoSlugs = newSeoSlugs(p.dbRegion, p.businessId)
+	p.daoProduct = sales_repository.NewProductDao(p.dbRegion.GetClient(), p.businessId)
 }
 
 // List - List All records
@@ -146,10 +159,24 @@ func (p *categoryBaseService) Create(indata utils.Map) (utils.Map, error) {
 		log.Println("Unique Category ID", categoryId)
 	}
 
+	// Parent category must exist
+	if parentId, _ := indata[FLD_CATEGORY_PARENT_ID].(string); len(parentId) > 0 {
+		_, err := p.daoCategory.Get(parentId)
+		if err != nil {
+			return utils.Map{}, err
+		}
+	}
+
 	// Assign Business Id
 	indata[sales_common.FLD_BUSINESS_ID] = p.businessId
 	indata[sales_common.FLD_CATEGORY_ID] = categoryId
 
+	// Slug is generated from the name when not given
+	err := p.seoSlugs.assignOnCreate(SEO_ENTITY_CATEGORY, categoryId, indata)
+	if err != nil {
+		return utils.Map{}, err
+	}
+
 	data, err := p.daoCategory.Create(indata)
 	if err != nil {
 		return utils.Map{}, err
@@ -164,6 +191,27 @@ func (p *categoryBaseService) Update(categoryId string, indata utils.Map) (utils
 
 	log.Println("CategoryService::Update - Begin")
 
+	// Changing the parent is verified for the cycles same as Move
+	if parentVal, ok := indata[FLD_CATEGORY_PARENT_ID]; ok {
+		parentId, _ := parentVal.(string)
+		err := p.validateParent(categoryId, parentId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Changed slug keeps the old one for the redirect
+	if _, ok := indata[FLD_SEO_SLUG]; ok {
+		current, err := p.daoCategory.Get(categoryId)
+		if err != nil {
+			return nil, err
+		}
+		err = p.seoSlugs.assignOnUpdate(SEO_ENTITY_CATEGORY, current, indata)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	data, err := p.daoCategory.Update(categoryId, indata)
 
 	log.Println("CategoryService::Update - End ")
@@ -175,6 +223,11 @@ func (p *categoryBaseService) Delete(categoryId string, delete_permanent bool) e
 
 	log.Println("BrandService::Delete - Begin", categoryId)
 
+	err := p.validateDelete(categoryId)
+	if err != nil {
+		return err
+	}
+
 	if delete_permanent {
 		result, err := p.daoCategory.Delete(categoryId)
 		if err != nil {
@@ -194,6 +247,147 @@ func (p *categoryBaseService) Delete(categoryId string, delete_permanent bool) e
 	return nil
 }
 
+// Tree - Get the categories as nested tree
+func (p *categoryBaseService) Tree() ([]utils.Map, error) {
+
+	log.Println("CategoryService::Tree - Begin")
+
+	categories, err := loadCategories(p.daoCategory)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := categoryTree(categories)
+
+	log.Println("CategoryService::Tree - End ")
+	return tree, nil
+}
+
+// Breadcrumb - Get the path of categories from the root to the category
+func (p *categoryBaseService) Breadcrumb(categoryId string) ([]utils.Map, error) {
+
+	log.Println("CategoryService::Breadcrumb - Begin", categoryId)
+
+	path := []utils.Map{}
+	visited := map[string]bool{}
+	for currentId := categoryId; len(currentId) > 0 && !visited[currentId]; {
+		visited[currentId] = true
+
+		category, err := p.daoCategory.Get(currentId)
+		if err != nil {
+			return nil, err
+		}
+		path = append([]utils.Map{category}, path...)
+		currentId = categoryParentId(category)
+	}
+
+	log.Println("CategoryService::Breadcrumb - End ", len(path))
+	return path, nil
+}
+
+// Move - Move the category under the new parent, empty parent moves it to the root
+func (p *categoryBaseService) Move(categoryId string, newParentId string) (utils.Map, error) {
+
+	log.Println("CategoryService::Move - Begin", categoryId, newParentId)
+
+	_, err := p.daoCategory.Get(categoryId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.validateParent(categoryId, newParentId)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := p.daoCategory.Update(categoryId, utils.Map{FLD_CATEGORY_PARENT_ID: newParentId})
+
+	log.Println("CategoryService::Move - End ", err)
+	return data, err
+}
+
+// Descendants - Get all the categories below the category
+func (p *categoryBaseService) Descendants(categoryId string) ([]utils.Map, error) {
+
+	log.Println("CategoryService::Descendants - Begin", categoryId)
+
+	categories, err := loadCategories(p.daoCategory)
+	if err != nil {
+		return nil, err
+	}
+
+	descendants := collectDescendants(categoryId, categoryChildren(categories))
+
+	log.Println("CategoryService::Descendants - End ", len(descendants))
+	return descendants, nil
+}
+
+// validateParent - Verify the parent exists and is not the category itself or a category below it
+func (p *categoryBaseService) validateParent(categoryId string, parentId string) error {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "10"
+
+	if len(parentId) == 0 {
+		return nil
+	}
+
+	_, err := p.daoCategory.Get(parentId)
+	if err != nil {
+		return err
+	}
+
+	categories, err := loadCategories(p.daoCategory)
+	if err != nil {
+		return err
+	}
+
+	invalid := parentId == categoryId
+	for _, category := range collectDescendants(categoryId, categoryChildren(categories)) {
+		if category[sales_common.FLD_CATEGORY_ID] == parentId {
+			invalid = true
+			break
+		}
+	}
+	if invalid {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "01",
+			ErrorMsg:    "Invalid parent category",
+			ErrorDetail: "Category cannot be moved under itself or its subcategory"}
+		return err
+	}
+	return nil
+}
+
+// validateDelete - Verify the category has no subcategories and products, deleted products are not counted
+func (p *categoryBaseService) validateDelete(categoryId string) error {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "10"
+
+	categories, err := loadCategories(p.daoCategory)
+	if err != nil {
+		return err
+	}
+
+	if len(categoryChildren(categories)[categoryId]) > 0 {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "02",
+			ErrorMsg:    "Category has subcategories",
+			ErrorDetail: "Move or delete the subcategories before deleting the category"}
+		return err
+	}
+
+	hasProducts, err := recordExists(p.daoProduct, utils.Map{sales_common.FLD_CATEGORY_ID: categoryId})
+	if err != nil {
+		return err
+	}
+	if hasProducts {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "03",
+			ErrorMsg:    "Category has products",
+			ErrorDetail: "Move the products to another category before deleting the category"}
+		return err
+	}
+	return nil
+}
+
 func (p *categoryBaseService) errorReturn(err error) (CategoryService, error) {
 	// Close the Database Connection
 	p.EndService()
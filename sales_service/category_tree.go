@@ -0,0 +1,138 @@
+package sales_service
+
+import (
+	"github.com/zapscloud/golib-dbutils/db_common"
+	"github.com/zapscloud/golib-sales-repository/sales_common"
+	"github.com/zapscloud/golib-sales-repository/sales_repository"
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+const (
+	// Category fields for the hierarchy, root categories have no parent
+	FLD_CATEGORY_PARENT_ID = "parent_category_id"
+	FLD_CATEGORY_NAME      = "category_name"
+	FLD_CATEGORY_CHILDREN  = "children"
+)
+
+// loadCategories - Get all the categories of the business, excluding the deleted ones
+func loadCategories(daoCategory sales_repository.CategoryDao) ([]utils.Map, error) {
+	listdata, err := daoCategory.List("", "", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	categories := []utils.Map{}
+	for _, category := range listResult(listdata) {
+		if deleted, _ := category[db_common.FLD_IS_DELETED].(bool); deleted {
+			continue
+		}
+		categories = append(categories, category)
+	}
+	return categories, nil
+}
+
+// categoryParentId - Parent of the category, empty for the root category
+func categoryParentId(category utils.Map) string {
+	parentId, _ := category[FLD_CATEGORY_PARENT_ID].(string)
+	return parentId
+}
+
+// categoryChildren - Index the categories by their parent id
+func categoryChildren(categories []utils.Map) map[string][]utils.Map {
+	children := map[string][]utils.Map{}
+	for _, category := range categories {
+		parentId := categoryParentId(category)
+		children[parentId] = append(children[parentId], category)
+	}
+	return children
+}
+
+// buildCategoryTree - Build the nested tree of the children of the parent
+func buildCategoryTree(parentId string, children map[string][]utils.Map, visited map[string]bool) []utils.Map {
+	nodes := []utils.Map{}
+	for _, category := range children[parentId] {
+		categoryId, _ := category[sales_common.FLD_CATEGORY_ID].(string)
+		if visited[categoryId] {
+			continue
+		}
+		visited[categoryId] = true
+
+		node := utils.CopyMap(category)
+		node[FLD_CATEGORY_CHILDREN] = buildCategoryTree(categoryId, children, visited)
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// categoryTree - Build the nested tree of the categories
+// Categories with the parent deleted or missing are placed at the root, as are the categories of a parent cycle
+func categoryTree(categories []utils.Map) []utils.Map {
+	categoryIds := map[string]bool{}
+	for _, category := range categories {
+		categoryId, _ := category[sales_common.FLD_CATEGORY_ID].(string)
+		categoryIds[categoryId] = true
+	}
+
+	children := map[string][]utils.Map{}
+	for _, category := range categories {
+		parentId := categoryParentId(category)
+		if !categoryIds[parentId] {
+			parentId = ""
+		}
+		children[parentId] = append(children[parentId], category)
+	}
+
+	visited := map[string]bool{}
+	tree := buildCategoryTree("", children, visited)
+
+	// Categories of a parent cycle are not reached from the root
+	for _, category := range categories {
+		categoryId, _ := category[sales_common.FLD_CATEGORY_ID].(string)
+		if visited[categoryId] {
+			continue
+		}
+		visited[categoryId] = true
+
+		node := utils.CopyMap(category)
+		node[FLD_CATEGORY_CHILDREN] = buildCategoryTree(categoryId, children, visited)
+		tree = append(tree, node)
+	}
+	return tree
+}
+
+// collectDescendants - Get all the categories below the category, nearest level first
+func collectDescendants(categoryId string, children map[string][]utils.Map) []utils.Map {
+	descendants := []utils.Map{}
+	visited := map[string]bool{categoryId: true}
+	queue := []string{categoryId}
+
+	for len(queue) > 0 {
+		parentId := queue[0]
+		queue = queue[1:]
+		for _, category := range children[parentId] {
+			childId, _ := category[sales_common.FLD_CATEGORY_ID].(string)
+			if visited[childId] {
+				continue
+			}
+			visited[childId] = true
+			descendants = append(descendants, category)
+			queue = append(queue, childId)
+		}
+	}
+	return descendants
+}
+
+// categoryWithDescendantIds - Get the id of the category and all the categories below it
+func categoryWithDescendantIds(daoCategory sales_repository.CategoryDao, categoryId string) ([]string, error) {
+	categories, err := loadCategories(daoCategory)
+	if err != nil {
+		return nil, err
+	}
+
+	categoryIds := []string{categoryId}
+	for _, category := range collectDescendants(categoryId, categoryChildren(categories)) {
+		childId, _ := category[sales_common.FLD_CATEGORY_ID].(string)
+		categoryIds = append(categoryIds, childId)
+	}
+	return categoryIds, nil
+}
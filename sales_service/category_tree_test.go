@@ -0,0 +1,40 @@
+package sales_service
+
+import (
+	"testing"
+
+	"github.com/zapscloud/golib-sales-repository/sales_common"
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+func TestCategoryTreeOrphans(t *testing.T) {
+	categories := []utils.Map{
+		{sales_common.FLD_CATEGORY_ID: "root"},
+		{sales_common.FLD_CATEGORY_ID: "child", FLD_CATEGORY_PARENT_ID: "root"},
+		{sales_common.FLD_CATEGORY_ID: "orphan", FLD_CATEGORY_PARENT_ID: "deleted"},
+		{sales_common.FLD_CATEGORY_ID: "cycle1", FLD_CATEGORY_PARENT_ID: "cycle2"},
+		{sales_common.FLD_CATEGORY_ID: "cycle2", FLD_CATEGORY_PARENT_ID: "cycle1"},
+	}
+
+	tree := categoryTree(categories)
+	roots := []string{}
+	count := 0
+	var walk func(nodes []utils.Map)
+	walk = func(nodes []utils.Map) {
+		for _, node := range nodes {
+			count++
+			walk(toMapSlice(node[FLD_CATEGORY_CHILDREN]))
+		}
+	}
+	for _, node := range tree {
+		roots = append(roots, node[sales_common.FLD_CATEGORY_ID].(string))
+	}
+	walk(tree)
+
+	if len(roots) != 3 || roots[0] != "root" || roots[1] != "orphan" || roots[2] != "cycle1" {
+		t.Errorf("roots = %v", roots)
+	}
+	if count != len(categories) {
+		t.Errorf("tree has %d of %d categories", count, len(categories))
+	}
+}
@@ -1,9 +1,13 @@
 package sales_service
 
 import (
+	"crypto/rand"
 	"fmt"
 	"log"
+	"math/big"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/zapscloud/golib-dbutils/db_common"
 	"github.com/zapscloud/golib-dbutils/db_utils"
@@ -14,6 +18,40 @@ import (
 	"github.com/zapscloud/golib-utils/utils"
 )
 
+const (
+	FLD_COUPON_CODE          = "coupon_code"
+	FLD_COUPON_PARENT_ID     = "parent_coupon_id"
+	FLD_COUPON_BATCH_ID      = "coupon_batch_id"
+	FLD_COUPON_IS_SINGLE_USE = "is_single_use"
+	FLD_COUPON_IS_REDEEMED   = "is_redeemed"
+	FLD_COUPON_IS_VOIDED     = "is_voided"
+	FLD_COUPON_MIN_ORDER     = "min_order_amount"
+	// Orders the coupon is redeemed on, keyed by the order id
+	FLD_COUPON_REDEMPTIONS    = "redemptions"
+	FLD_COUPON_REDEEMED_ORDER = "redeemed_order_id"
+
+	// Coupon codes given on the order
+	FLD_ORDER_COUPON_CODES = "coupon_codes"
+
+	// Coupon batch response fields
+	FLD_COUPON_BATCH_COUNT   = "count"
+	FLD_COUPON_BATCH_CODES   = "codes"
+	FLD_COUPON_BATCH_VOIDED  = "voided"
+	FLD_COUPON_BATCH_SKIPPED = "skipped"
+
+	// Characters used for the generated codes, 0/O, 1/I/L and 2/Z/5/S are left out
+	// since they are easily confused when printed or read aloud
+	COUPON_CODE_CHARSET = "ABCDEFGHJKMNPQRTUVWXY346789"
+	// Placeholder character in the pattern replaced by a random character
+	COUPON_CODE_PLACEHOLDER = 'X'
+	// Characters allowed in the pattern, besides the code characters
+	COUPON_PATTERN_CHARSET = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-"
+	// Maximum length of the pattern
+	COUPON_PATTERN_MAX_LENGTH = 32
+	// Maximum codes allowed in a single batch
+	COUPON_BATCH_MAX_COUNT = 50000
+)
+
 type CouponService interface {
 	// List - List All records
 	List(filter string, sort string, skip int64, limit int64) (utils.Map, error)
@@ -28,6 +66,15 @@ type CouponService interface {
 	// Delete - Delete Service
 	Delete(couponId string, delete_permanent bool) error
 
+	// GenerateBatch - Generate single-use coupon codes from the template coupon
+	GenerateBatch(templateId string, count int, pattern string) (utils.Map, error)
+	// ExportBatch - List all the coupon codes in the batch
+	ExportBatch(batchId string) (utils.Map, error)
+	// VoidBatch - Void all the unredeemed coupon codes in the batch
+	VoidBatch(batchId string) (utils.Map, error)
+	// Redeem - Redeem the coupon code on the order, single-use codes are redeemed only once
+	Redeem(couponCode string, orderId string) (utils.Map, error)
+
 	EndService()
 }
 
@@ -190,6 +237,368 @@ func (p *couponBaseService) Delete(couponId string, delete_permanent bool) error
 	return nil
 }
 
+// GenerateBatch - Generate single-use coupon codes from the template coupon
+func (p *couponBaseService) GenerateBatch(templateId string, count int, pattern string) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "02"
+
+	log.Println("CouponService::GenerateBatch - Begin", templateId, count, pattern)
+
+	pattern = strings.ToUpper(strings.TrimSpace(pattern))
+	err := validateCouponPattern(pattern, count)
+	if err != nil {
+		return nil, err
+	}
+
+	template, err := p.daoCoupon.Get(templateId)
+	if err != nil {
+		return nil, err
+	}
+
+	batchId := utils.GenerateUniqueId("cpbt")
+	codes := make([]string, 0, count)
+	generated := map[string]bool{}
+	maxAttempts := count * 10
+
+	for attempt := 0; len(codes) < count; attempt++ {
+		if attempt >= maxAttempts {
+			err := &utils.AppError{
+				ErrorCode:   funcode + "01",
+				ErrorMsg:    "Unable to generate unique codes",
+				ErrorDetail: "Too many duplicate codes, use a pattern with more placeholders"}
+			return utils.Map{FLD_COUPON_BATCH_ID: batchId, FLD_COUPON_BATCH_CODES: codes}, err
+		}
+
+		code, err := generateCouponCode(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		// Skip the codes already generated in this batch or exist already
+		if generated[code] {
+			continue
+		}
+		exists, err := recordExists(p.daoCoupon, utils.Map{FLD_COUPON_CODE: code})
+		if err != nil {
+			return utils.Map{FLD_COUPON_BATCH_ID: batchId, FLD_COUPON_BATCH_CODES: codes}, err
+		}
+		if exists {
+			continue
+		}
+		generated[code] = true
+
+		indata := utils.CopyMap(template)
+		delete(indata, db_common.FLD_DEFAULT_ID)
+		delete(indata, db_common.FLD_CREATED_AT)
+		delete(indata, db_common.FLD_UPDATED_AT)
+		delete(indata, sales_common.FLD_COUPON_ID)
+
+		indata[FLD_COUPON_CODE] = code
+		indata[FLD_COUPON_PARENT_ID] = templateId
+		indata[FLD_COUPON_BATCH_ID] = batchId
+		indata[FLD_COUPON_IS_SINGLE_USE] = true
+		indata[FLD_COUPON_IS_REDEEMED] = false
+		indata[FLD_COUPON_IS_VOIDED] = false
+
+		_, err = p.Create(indata)
+		if err != nil {
+			return utils.Map{FLD_COUPON_BATCH_ID: batchId, FLD_COUPON_BATCH_CODES: codes}, err
+		}
+		codes = append(codes, code)
+	}
+
+	response := utils.Map{
+		FLD_COUPON_BATCH_ID:    batchId,
+		FLD_COUPON_PARENT_ID:   templateId,
+		FLD_COUPON_BATCH_COUNT: len(codes),
+		FLD_COUPON_BATCH_CODES: codes,
+	}
+
+	log.Println("CouponService::GenerateBatch - End ", batchId, len(codes))
+	return response, nil
+}
+
+// ExportBatch - List all the coupon codes in the batch
+func (p *couponBaseService) ExportBatch(batchId string) (utils.Map, error) {
+
+	log.Println("CouponService::ExportBatch - Begin", batchId)
+
+	filter := buildFilter(utils.Map{FLD_COUPON_BATCH_ID: batchId})
+	sort := buildFilter(utils.Map{FLD_COUPON_CODE: 1})
+
+	listdata, err := p.daoCoupon.List(filter, sort, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("CouponService::ExportBatch - End ")
+	return listdata, nil
+}
+
+// VoidBatch - Void all the unredeemed coupon codes in the batch
+func (p *couponBaseService) VoidBatch(batchId string) (utils.Map, error) {
+
+	log.Println("CouponService::VoidBatch - Begin", batchId)
+
+	listdata, err := p.ExportBatch(batchId)
+	if err != nil {
+		return nil, err
+	}
+
+	voided := 0
+	skipped := 0
+	for _, record := range listResult(listdata) {
+		// Redeemed codes are left as they are to keep the order history intact,
+		// the codes voided already are not voided again
+		isRedeemed, _ := record[FLD_COUPON_IS_REDEEMED].(bool)
+		isVoided, _ := record[FLD_COUPON_IS_VOIDED].(bool)
+		if isRedeemed || isVoided {
+			skipped++
+			continue
+		}
+
+		couponId, _ := record[sales_common.FLD_COUPON_ID].(string)
+		_, err := p.daoCoupon.Update(couponId, utils.Map{FLD_COUPON_IS_VOIDED: true})
+		if err != nil {
+			return nil, err
+		}
+		voided++
+	}
+
+	response := utils.Map{
+		FLD_COUPON_BATCH_ID:      batchId,
+		FLD_COUPON_BATCH_VOIDED:  voided,
+		FLD_COUPON_BATCH_SKIPPED: skipped,
+	}
+
+	log.Println("CouponService::VoidBatch - End ", response)
+	return response, nil
+}
+
+// Redeem - Redeem the coupon code on the order, single-use codes are redeemed only once
+func (p *couponBaseService) Redeem(couponCode string, orderId string) (utils.Map, error) {
+
+	log.Println("CouponService::Redeem - Begin", couponCode, orderId)
+
+	data, err := RedeemCoupon(p.daoCoupon, couponCode, orderId)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("CouponService::Redeem - End ")
+	return data, nil
+}
+
+// RedeemCoupon - Record the redemption of the coupon code on the order, returns the coupon
+// A single-use code is redeemed only when no other order has redeemed it. The redemption of the order
+// is written first and verified after it, so of the concurrent orders at most one redeems the code;
+// the redemption is removed when another order has redeemed it too. Redeeming again on the same order does nothing.
+func RedeemCoupon(daoCoupon sales_repository.CouponDao, couponCode string, orderId string) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "02"
+
+	couponCode = strings.ToUpper(strings.TrimSpace(couponCode))
+	coupons, err := findActive(daoCoupon, utils.Map{FLD_COUPON_CODE: couponCode}, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(coupons) == 0 {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "06",
+			ErrorMsg:    "Coupon not found",
+			ErrorDetail: fmt.Sprintf("Coupon code %s is not found", couponCode)}
+		return nil, err
+	}
+	coupon := coupons[0]
+	couponId, _ := coupon[sales_common.FLD_COUPON_ID].(string)
+
+	if voided, _ := coupon[FLD_COUPON_IS_VOIDED].(bool); voided {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "07",
+			ErrorMsg:    "Coupon is voided",
+			ErrorDetail: fmt.Sprintf("Coupon code %s is voided", couponCode)}
+		return nil, err
+	}
+	redeemedBy := func(coupon utils.Map) []string {
+		others := []string{}
+		for _, redeemedOrderId := range couponRedemptions(coupon) {
+			if redeemedOrderId != orderId {
+				others = append(others, redeemedOrderId)
+			}
+		}
+		return others
+	}
+	singleUse, _ := coupon[FLD_COUPON_IS_SINGLE_USE].(bool)
+	redeemedErr := &utils.AppError{
+		ErrorCode:   funcode + "08",
+		ErrorMsg:    "Coupon is already redeemed",
+		ErrorDetail: fmt.Sprintf("Coupon code %s is already redeemed on another order", couponCode)}
+	if singleUse && len(redeemedBy(coupon)) > 0 {
+		return nil, redeemedErr
+	}
+
+	redemptionKey := FLD_COUPON_REDEMPTIONS + "." + orderId
+	_, err = daoCoupon.Update(couponId, utils.Map{redemptionKey: time.Now()})
+	if err != nil {
+		return nil, err
+	}
+	if !singleUse {
+		return coupon, nil
+	}
+
+	latest, err := daoCoupon.Get(couponId)
+	if err != nil {
+		return nil, err
+	}
+	if len(redeemedBy(latest)) > 0 {
+		_, err = daoCoupon.Update(couponId, utils.Map{redemptionKey: nil})
+		if err != nil {
+			return nil, err
+		}
+		return nil, redeemedErr
+	}
+
+	_, err = daoCoupon.Update(couponId, utils.Map{FLD_COUPON_IS_REDEEMED: true, FLD_COUPON_REDEEMED_ORDER: orderId})
+	if err != nil {
+		return nil, err
+	}
+	latest[FLD_COUPON_IS_REDEEMED] = true
+	latest[FLD_COUPON_REDEEMED_ORDER] = orderId
+	return latest, nil
+}
+
+// ReleaseCoupon - Remove the redemption of the coupon code on the order,
+// used when the order the code is redeemed on is not created
+func ReleaseCoupon(daoCoupon sales_repository.CouponDao, couponCode string, orderId string) error {
+	couponCode = strings.ToUpper(strings.TrimSpace(couponCode))
+	coupons, err := findActive(daoCoupon, utils.Map{FLD_COUPON_CODE: couponCode}, 1)
+	if err != nil || len(coupons) == 0 {
+		return err
+	}
+	coupon := coupons[0]
+	couponId, _ := coupon[sales_common.FLD_COUPON_ID].(string)
+
+	indata := utils.Map{FLD_COUPON_REDEMPTIONS + "." + orderId: nil}
+	if redeemedOrderId, _ := coupon[FLD_COUPON_REDEEMED_ORDER].(string); redeemedOrderId == orderId {
+		indata[FLD_COUPON_IS_REDEEMED] = false
+		indata[FLD_COUPON_REDEEMED_ORDER] = ""
+	}
+	_, err = daoCoupon.Update(couponId, indata)
+	return err
+}
+
+// RedeemOrderCoupons - Redeem the coupon codes given on the order, the codes redeemed
+// are released again when one of the codes cannot be redeemed
+func RedeemOrderCoupons(daoCoupon sales_repository.CouponDao, order utils.Map) error {
+	orderId, _ := order[sales_common.FLD_CUSTOMER_ORDER_ID].(string)
+
+	redeemed := []string{}
+	for _, couponCode := range toStringSlice(order[FLD_ORDER_COUPON_CODES]) {
+		_, err := RedeemCoupon(daoCoupon, couponCode, orderId)
+		if err != nil {
+			for _, redeemedCode := range redeemed {
+				releaseErr := ReleaseCoupon(daoCoupon, redeemedCode, orderId)
+				if releaseErr != nil {
+					log.Println("RedeemOrderCoupons - Release coupon failed", redeemedCode, orderId, releaseErr)
+				}
+			}
+			return err
+		}
+		redeemed = append(redeemed, couponCode)
+	}
+	return nil
+}
+
+// ReleaseOrderCoupons - Release the coupon codes redeemed on the order,
+// used when the order the codes are redeemed on is not created
+func ReleaseOrderCoupons(daoCoupon sales_repository.CouponDao, order utils.Map) error {
+	orderId, _ := order[sales_common.FLD_CUSTOMER_ORDER_ID].(string)
+
+	for _, couponCode := range toStringSlice(order[FLD_ORDER_COUPON_CODES]) {
+		err := ReleaseCoupon(daoCoupon, couponCode, orderId)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// couponRedemptions - Ids of the orders the coupon is redeemed on, the removed redemptions are left out
+func couponRedemptions(coupon utils.Map) []string {
+	redemptions, _ := toMap(coupon[FLD_COUPON_REDEMPTIONS])
+	orderIds := []string{}
+	for orderId, redeemedAt := range redemptions {
+		if redeemedAt != nil {
+			orderIds = append(orderIds, orderId)
+		}
+	}
+	sort.Strings(orderIds)
+	return orderIds
+}
+
+// validateCouponPattern - Verify the pattern can produce the requested count of codes
+func validateCouponPattern(pattern string, count int) error {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "02"
+
+	if count <= 0 || count > COUPON_BATCH_MAX_COUNT {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "02",
+			ErrorMsg:    "Invalid count",
+			ErrorDetail: fmt.Sprintf("Count should be between 1 and %d", COUPON_BATCH_MAX_COUNT)}
+		return err
+	}
+
+	invalidChar := strings.IndexFunc(pattern, func(ch rune) bool {
+		return !strings.ContainsRune(COUPON_PATTERN_CHARSET, ch)
+	})
+	if len(pattern) > COUPON_PATTERN_MAX_LENGTH || invalidChar >= 0 {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "05",
+			ErrorMsg:    "Invalid pattern",
+			ErrorDetail: fmt.Sprintf("Pattern should have at most %d letters, digits or '-'", COUPON_PATTERN_MAX_LENGTH)}
+		return err
+	}
+
+	placeholders := strings.Count(pattern, string(COUPON_CODE_PLACEHOLDER))
+	if placeholders == 0 {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "03",
+			ErrorMsg:    "Invalid pattern",
+			ErrorDetail: "Pattern should contain at least one X placeholder, e.g. SALE-XXXX-XXXX"}
+		return err
+	}
+
+	// Keep the possible combinations well above the count, otherwise codes are easily guessed
+	combinations := new(big.Int).Exp(big.NewInt(int64(len(COUPON_CODE_CHARSET))), big.NewInt(int64(placeholders)), nil)
+	if combinations.Cmp(big.NewInt(int64(count)*100)) < 0 {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "04",
+			ErrorMsg:    "Pattern too short",
+			ErrorDetail: fmt.Sprintf("Pattern %s cannot produce %d codes safely, add more X placeholders", pattern, count)}
+		return err
+	}
+
+	return nil
+}
+
+// generateCouponCode - Replace each placeholder in the pattern with a random character
+func generateCouponCode(pattern string) (string, error) {
+	var code strings.Builder
+	max := big.NewInt(int64(len(COUPON_CODE_CHARSET)))
+
+	for _, ch := range pattern {
+		if ch != COUPON_CODE_PLACEHOLDER {
+			code.WriteRune(ch)
+			continue
+		}
+		idx, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code.WriteByte(COUPON_CODE_CHARSET[idx.Int64()])
+	}
+
+	return code.String(), nil
+}
+
 func (p *couponBaseService) errorReturn(err error) (CouponService, error) {
 	// Close the Database Connection
 	p.EndService()
@@ -0,0 +1,42 @@
+package sales_service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+func TestValidateCouponPattern(t *testing.T) {
+	valid := []string{"SALE-XXXX-XXXX", "XXXXXXXX"}
+	for _, pattern := range valid {
+		if err := validateCouponPattern(pattern, 100); err != nil {
+			t.Errorf("pattern %q: %v", pattern, err)
+		}
+	}
+
+	invalid := []string{`XXXX","$ne":"`, "XXXX XXXX", "xxxx-XXXXXXXX", "SALE-XXXXXXXXXXXXXXXXXXXXXXXXXXXXXX"}
+	for _, pattern := range invalid {
+		if err := validateCouponPattern(pattern, 100); err == nil {
+			t.Errorf("pattern %q is accepted", pattern)
+		}
+	}
+}
+
+func TestCouponRedemptionsSkipRemoved(t *testing.T) {
+	stored := roundTrip(t, utils.Map{
+		FLD_COUPON_REDEMPTIONS: utils.Map{
+			"order_b": time.Now(),
+			"order_a": time.Now(),
+			"order_c": nil,
+		},
+	})
+
+	orderIds := couponRedemptions(stored)
+	if len(orderIds) != 2 || orderIds[0] != "order_a" || orderIds[1] != "order_b" {
+		t.Errorf("couponRedemptions = %v", orderIds)
+	}
+	if orderIds := couponRedemptions(utils.Map{}); len(orderIds) != 0 {
+		t.Errorf("couponRedemptions of unredeemed coupon = %v", orderIds)
+	}
+}
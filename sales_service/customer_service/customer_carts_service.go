@@ -12,6 +12,7 @@ import (
 	"github.com/zapscloud/golib-sales-repository/sales_common"
 	"github.com/zapscloud/golib-sales-repository/sales_repository"
 	"github.com/zapscloud/golib-sales-repository/sales_repository/customer_repository"
+	"github.com/zapscloud/golib-sales-service/sales_service"
 	"github.com/zapscloud/golib-utils/utils"
 )
 
@@ -38,6 +39,7 @@ type customerCartBaseService struct {
 	daoCustomerCart customer_repository.CustomerCartDao
 	daoBusiness     platform_repository.BusinessDao
 	daoCustomer     sales_repository.CustomerDao
+	priceResolver   *sales_service.PriceResolver
 
 	child      CustomerCartService
 	businessId string
@@ -119,6 +121,7 @@ func (p *customerCartBaseService) initializeService() {
 	p.daoBusiness = platform_repository.NewBusinessDao(p.GetClient())
 	p.daoCustomer = sales_repository.NewCustomerDao(p.dbRegion.GetClient(), p.businessId)
 	p.daoCustomerCart = customer_repository.NewCustomerCartDao(p.dbRegion.GetClient(), p.businessId, p.customerId)
+	p.priceResolver = sales_service.NewPriceResolver(p.dbRegion.GetClient(), p.businessId)
 }
 
 // List - List All records
@@ -172,6 +175,11 @@ func (p *customerCartBaseService) Create(indata utils.Map) (utils.Map, error) {
 	indata[sales_common.FLD_CUSTOMER_ID] = p.customerId
 	indata[sales_common.FLD_CART_ID] = cartId
 
+	err := p.priceItems(indata)
+	if err != nil {
+		return utils.Map{}, err
+	}
+
 	data, err := p.daoCustomerCart.Create(indata)
 	if err != nil {
 		return utils.Map{}, err
@@ -191,6 +199,11 @@ func (p *customerCartBaseService) Update(cartId string, indata utils.Map) (utils
 	delete(indata, sales_common.FLD_CUSTOMER_ID)
 	delete(indata, sales_common.FLD_CART_ID)
 
+	err := p.priceItems(indata)
+	if err != nil {
+		return utils.Map{}, err
+	}
+
 	data, err := p.daoCustomerCart.Update(cartId, indata)
 
 	log.Println("CustomerCartService::Update - End ")
@@ -221,6 +234,23 @@ func (p *customerCartBaseService) Delete(cartId string, delete_permanent bool) e
 	return nil
 }
 
+// priceItems - Price the cart items for the customer at the cart location and set the cart total, when the items are given
+// The items not available at the location stay in the cart marked as not available
+func (p *customerCartBaseService) priceItems(indata utils.Map) error {
+	items, ok := indata[sales_service.FLD_CART_ITEMS]
+	if !ok {
+		return nil
+	}
+
+	priced, total, err := p.priceResolver.PriceItems(items, p.customerId, indata[sales_service.FLD_SHOPPER_LOCATION])
+	if err != nil {
+		return err
+	}
+	indata[sales_service.FLD_CART_ITEMS] = priced
+	indata[sales_service.FLD_CART_TOTAL] = total
+	return nil
+}
+
 func (p *customerCartBaseService) errorReturn(err error) (CustomerCartService, error) {
 	// Close the Database Connection
 	p.EndService()
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/zapscloud/golib-dbutils/db_common"
 	"github.com/zapscloud/golib-dbutils/db_utils"
@@ -12,6 +13,7 @@ import (
 	"github.com/zapscloud/golib-sales-repository/sales_common"
 	"github.com/zapscloud/golib-sales-repository/sales_repository"
 	"github.com/zapscloud/golib-sales-repository/sales_repository/customer_repository"
+	"github.com/zapscloud/golib-sales-service/sales_service"
 	"github.com/zapscloud/golib-utils/utils"
 )
 
@@ -38,6 +40,11 @@ type customerOrderBaseService struct {
 	daoCustomerOrder customer_repository.CustomerOrderDao
 	daoBusiness      platform_repository.BusinessDao
 	daoCustomer      sales_repository.CustomerDao
+	daoDealer        sales_repository.DealerDao
+	daoProduct       sales_repository.ProductDao
+	daoCoupon        sales_repository.CouponDao
+	priceResolver    *sales_service.PriceResolver
+	promotions       *sales_service.PromotionResolver
 
 	child      CustomerOrderService
 	businessId string
@@ -118,6 +125,11 @@ func (p *customerOrderBaseService) initializeService() {
 	log.Printf("customerOrderBaseService:: GetBusinessDao ")
 	p.daoBusiness = platform_repository.NewBusinessDao(p.GetClient())
 	p.daoCustomer = sales_repository.NewCustomerDao(p.dbRegion.GetClient(), p.businessId)
+	p.daoDealer = sales_repository.NewDealerDao(p.dbRegion.GetClient(), p.businessId)
+	p.daoProduct = sales_repository.NewProductDao(p.dbRegion.GetClient(), p.businessId)
+	p.daoCoupon = sales_repository.NewCouponDao(p.dbRegion.GetClient(), p.businessId)
+	p.priceResolver = sales_service.NewPriceResolver(p.dbRegion.GetClient(), p.businessId)
+	p.promotions = sales_service.NewPromotionResolver(p.GetClient(), p.dbRegion.GetClient(), p.businessId)
 	p.daoCustomerOrder = customer_repository.NewCustomerOrderDao(p.GetClient(), p.businessId, p.customerId)
 }
 
@@ -172,11 +184,80 @@ func (p *customerOrderBaseService) Create(indata utils.Map) (utils.Map, error) {
 	indata[sales_common.FLD_CUSTOMER_ID] = p.customerId
 	indata[sales_common.FLD_CUSTOMER_ORDER_ID] = custOrderId
 
+	// Items are priced for the customer at the delivery location, the order total is the total of the priced items
+	// The order is rejected when any item cannot be delivered to the location
+	delete(indata, sales_service.FLD_ORDER_TOTAL)
+	delete(indata, sales_service.FLD_ORDER_PROMOTIONS)
+	delete(indata, sales_service.FLD_ORDER_DISCOUNT)
+	if items, ok := indata[sales_service.FLD_ORDER_ITEMS]; ok {
+		orderItems, total, err := p.priceResolver.PriceItems(items, p.customerId, indata[sales_service.FLD_SHOPPER_LOCATION])
+		if err != nil {
+			return utils.Map{}, err
+		}
+		err = sales_service.CheckItemsAvailable(orderItems)
+		if err != nil {
+			return utils.Map{}, err
+		}
+		indata[sales_service.FLD_ORDER_ITEMS] = orderItems
+		indata[sales_service.FLD_ORDER_TOTAL] = total
+
+		// Offers and coupon codes are applied as per the stacking policy, the total is after the discount
+		err = p.promotions.ApplyToOrder(indata)
+		if err != nil {
+			return utils.Map{}, err
+		}
+
+		// Bundles are exploded into their component lines for the fulfillment
+		lines, err := sales_service.ExplodeBundleLines(p.daoProduct, orderItems)
+		if err != nil {
+			return utils.Map{}, err
+		}
+		indata[sales_service.FLD_ORDER_FULFILLMENT_LINES] = lines
+	} else {
+		// Coupon codes are applied only on the items of the order
+		delete(indata, sales_service.FLD_ORDER_COUPON_CODES)
+	}
+
+	// Orders of the dealers with a credit limit are charged to the credit before the order is created,
+	// blocked when the credit limit is exceeded
+	dealer, err := p.getDealer()
+	if err != nil {
+		return utils.Map{}, err
+	}
+	if dealer != nil {
+		err = sales_service.ApplyDealerCreditTerms(p.daoDealer, dealer, indata)
+		if err != nil {
+			return utils.Map{}, err
+		}
+	}
+
+	// Coupon codes given on the order are redeemed, a single-use code already redeemed blocks the order
+	err = sales_service.RedeemOrderCoupons(p.daoCoupon, indata)
+	if err != nil {
+		p.releaseOrder(indata, false)
+		return utils.Map{}, err
+	}
+
 	data, err := p.daoCustomerOrder.Create(indata)
 	if err != nil {
+		p.releaseOrder(indata, true)
 		return utils.Map{}, err
 	}
 
+	// Stock reserved at the checkout is deducted once the order is created,
+	// the order is removed when the reservation is expired or released meanwhile
+	if reservationId, _ := indata[sales_service.FLD_RESERVATION_ID].(string); len(reservationId) > 0 {
+		_, err = sales_service.CommitReservation(p.daoProduct, reservationId)
+		if err != nil {
+			_, deleteErr := p.daoCustomerOrder.Delete(custOrderId)
+			if deleteErr != nil {
+				log.Println("customerOrderBaseService::Create - Remove order failed", custOrderId, deleteErr)
+			}
+			p.releaseOrder(indata, true)
+			return utils.Map{}, err
+		}
+	}
+
 	log.Println("customerOrderBaseService::Create - End ")
 	return data, nil
 }
@@ -191,7 +272,34 @@ func (p *customerOrderBaseService) Update(custOrderId string, indata utils.Map)
 	delete(indata, sales_common.FLD_CUSTOMER_ID)
 	delete(indata, sales_common.FLD_CUSTOMER_ORDER_ID)
 
+	var order utils.Map
+	status, _ := indata[sales_service.FLD_ORDER_STATUS].(string)
+	if status == sales_service.ORDER_STATUS_DELIVERED || status == sales_service.ORDER_STATUS_CANCELLED {
+		stored, err := p.daoCustomerOrder.Get(custOrderId)
+		if err != nil {
+			return utils.Map{}, err
+		}
+		order = stored
+	}
+
+	// The delivered time starts the return window of the referral rewards, it is set once
+	if _, ok := indata[sales_service.FLD_ORDER_DELIVERED_AT]; status == sales_service.ORDER_STATUS_DELIVERED && !ok {
+		if _, delivered := order[sales_service.FLD_ORDER_DELIVERED_AT]; !delivered {
+			indata[sales_service.FLD_ORDER_DELIVERED_AT] = time.Now()
+		}
+	}
+
 	data, err := p.daoCustomerOrder.Update(custOrderId, indata)
+	if err != nil {
+		return data, err
+	}
+
+	// Stock of the cancelled order is released, once when the order is cancelled
+	if previous, _ := order[sales_service.FLD_ORDER_STATUS].(string); status == sales_service.ORDER_STATUS_CANCELLED && previous != status {
+		if reservationId, _ := order[sales_service.FLD_RESERVATION_ID].(string); len(reservationId) > 0 {
+			_, err = sales_service.ReleaseReservation(p.daoProduct, reservationId)
+		}
+	}
 
 	log.Println("customerOrderService::Update - End ")
 	return data, err
@@ -221,6 +329,51 @@ func (p *customerOrderBaseService) Delete(custOrderId string, delete_permanent b
 	return nil
 }
 
+// releaseOrder - Release the dealer credit charged, the coupon codes redeemed and the stock reserved
+// for the order not created
+func (p *customerOrderBaseService) releaseOrder(order utils.Map, couponsRedeemed bool) {
+	custOrderId, _ := order[sales_common.FLD_CUSTOMER_ORDER_ID].(string)
+
+	if reservationId, _ := order[sales_service.FLD_RESERVATION_ID].(string); len(reservationId) > 0 {
+		_, err := sales_service.ReleaseReservation(p.daoProduct, reservationId)
+		if err != nil {
+			log.Println("customerOrderBaseService::Create - Release reservation failed", custOrderId, err)
+		}
+	}
+
+	if onCredit, _ := order[sales_service.FLD_ORDER_ON_CREDIT].(bool); onCredit {
+		dealerId, _ := order[sales_common.FLD_DEALER_ID].(string)
+		err := sales_service.ReleaseDealerCredit(p.daoDealer, dealerId, custOrderId)
+		if err != nil {
+			log.Println("customerOrderBaseService::Create - Release dealer credit failed", custOrderId, err)
+		}
+	}
+	if couponsRedeemed {
+		err := sales_service.ReleaseOrderCoupons(p.daoCoupon, order)
+		if err != nil {
+			log.Println("customerOrderBaseService::Create - Release coupons failed", custOrderId, err)
+		}
+	}
+}
+
+// getDealer - Get the dealer of the customer, nil when the customer is not a dealer
+func (p *customerOrderBaseService) getDealer() (utils.Map, error) {
+	if len(p.customerId) == 0 {
+		return nil, nil
+	}
+
+	customer, err := p.daoCustomer.Get(p.customerId)
+	if err != nil {
+		return nil, err
+	}
+
+	dealerId, _ := customer[sales_common.FLD_DEALER_ID].(string)
+	if len(dealerId) == 0 {
+		return nil, nil
+	}
+	return p.daoDealer.Get(dealerId)
+}
+
 func (p *customerOrderBaseService) errorReturn(err error) (CustomerOrderService, error) {
 	// Close the Database Connection
 	p.EndService()
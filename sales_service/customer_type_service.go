@@ -28,6 +28,11 @@ type CustomerTypeService interface {
 	// Delete - Delete Service
 	Delete(CustomerTypeId string, delete_permanent bool) error
 
+	// GetPriceList - Get the price list attached to the customer type
+	GetPriceList(CustomerTypeId string) (utils.Map, error)
+	// SetPriceList - Attach the price list to the customer type
+	SetPriceList(CustomerTypeId string, priceList utils.Map) (utils.Map, error)
+
 	EndService()
 }
 
@@ -196,6 +201,46 @@ func (p *CustomerTypeBaseService) Delete(CustomerTypeId string, delete_permanent
 	return nil
 }
 
+// GetPriceList - Get the price list attached to the customer type
+func (p *CustomerTypeBaseService) GetPriceList(CustomerTypeId string) (utils.Map, error) {
+
+	log.Println("CustomerTypeService::GetPriceList - Begin", CustomerTypeId)
+
+	data, err := p.daoCustomerType.Get(CustomerTypeId)
+	if err != nil {
+		return nil, err
+	}
+
+	priceList, ok := toMap(data[FLD_PRICE_LIST])
+	if !ok {
+		priceList = utils.Map{}
+	}
+
+	log.Println("CustomerTypeService::GetPriceList - End ")
+	return priceList, nil
+}
+
+// SetPriceList - Attach the price list to the customer type
+func (p *CustomerTypeBaseService) SetPriceList(CustomerTypeId string, priceList utils.Map) (utils.Map, error) {
+
+	log.Println("CustomerTypeService::SetPriceList - Begin", CustomerTypeId)
+
+	err := validatePriceList(priceList)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = p.daoCustomerType.Get(CustomerTypeId)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := p.daoCustomerType.Update(CustomerTypeId, utils.Map{FLD_PRICE_LIST: priceList})
+
+	log.Println("CustomerTypeService::SetPriceList - End ")
+	return data, err
+}
+
 func (p *CustomerTypeBaseService) errorReturn(err error) (CustomerTypeService, error) {
 	// Close the Database Connection
 	p.EndService()
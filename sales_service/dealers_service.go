@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/zapscloud/golib-dbutils/db_common"
 	"github.com/zapscloud/golib-dbutils/db_utils"
@@ -14,6 +15,28 @@ import (
 	"github.com/zapscloud/golib-utils/utils"
 )
 
+const (
+	// Dealer fields for the B2B terms
+	FLD_DEALER_CONTRACT_PRICES  = "contract_prices"
+	FLD_DEALER_QUANTITY_BREAKS  = "quantity_breaks"
+	FLD_DEALER_BREAK_MIN_QTY    = "min_quantity"
+	FLD_DEALER_CONTRACT_PRICE   = "price"
+	FLD_DEALER_CREDIT_LIMIT     = "credit_limit"
+	FLD_DEALER_OUTSTANDING      = "outstanding_balance"
+	FLD_DEALER_AVAILABLE_CREDIT = "available_credit"
+	FLD_DEALER_PAYMENT_TERMS    = "payment_terms_days"
+	// Credit charges and payments of the dealer keyed by the order id and the payment id {id: amount},
+	// the outstanding balance is the total of the charges less the payments
+	FLD_DEALER_CREDIT_CHARGES  = "credit_charges"
+	FLD_DEALER_CREDIT_PAYMENTS = "credit_payments"
+	FLD_DEALER_PAYMENT_ID      = "payment_id"
+
+	// Order fields used for the dealer credit
+	FLD_ORDER_TOTAL       = "order_total"
+	FLD_ORDER_PAYMENT_DUE = "payment_due_date"
+	FLD_ORDER_ON_CREDIT   = "is_on_credit"
+)
+
 type DealerService interface {
 	// List - List All records
 	List(filter string, sort string, skip int64, limit int64) (utils.Map, error)
@@ -28,6 +51,15 @@ type DealerService interface {
 	// Delete - Delete Service
 	Delete(dealerId string, delete_permanent bool) error
 
+	// SetTerms - Set the contract prices, quantity breaks, credit limit and payment terms of the dealer
+	SetTerms(dealerId string, terms utils.Map) (utils.Map, error)
+	// GetCredit - Get the credit limit, outstanding balance and available credit of the dealer
+	GetCredit(dealerId string) (utils.Map, error)
+	// CheckCredit - Verify the order amount is within the available credit of the dealer
+	CheckCredit(dealerId string, orderAmount float64) (utils.Map, error)
+	// RecordPayment - Reduce the outstanding balance of the dealer by the payment
+	RecordPayment(dealerId string, amount float64) (utils.Map, error)
+
 	EndService()
 }
 
@@ -190,6 +222,286 @@ func (p *dealerBaseService) Delete(dealerId string, delete_permanent bool) error
 	return nil
 }
 
+// SetTerms - Set the contract prices, quantity breaks, credit limit and payment terms of the dealer
+func (p *dealerBaseService) SetTerms(dealerId string, terms utils.Map) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "07"
+
+	log.Println("DealerService::SetTerms - Begin", dealerId)
+
+	_, err := p.daoDealer.Get(dealerId)
+	if err != nil {
+		return nil, err
+	}
+
+	indata := utils.Map{}
+	if value, ok := terms[FLD_DEALER_CONTRACT_PRICES]; ok {
+		for _, contract := range toMapSlice(value) {
+			productId, _ := contract[sales_common.FLD_PRODUCT_ID].(string)
+			if len(productId) == 0 || toFloat(contract[FLD_DEALER_CONTRACT_PRICE]) < 0 {
+				err := &utils.AppError{
+					ErrorCode:   funcode + "01",
+					ErrorMsg:    "Invalid contract price",
+					ErrorDetail: "Contract price should have product_id and a price not less than zero"}
+				return nil, err
+			}
+		}
+		indata[FLD_DEALER_CONTRACT_PRICES] = value
+	}
+	if value, ok := terms[FLD_DEALER_CREDIT_LIMIT]; ok {
+		if toFloat(value) < 0 {
+			err := &utils.AppError{
+				ErrorCode:   funcode + "02",
+				ErrorMsg:    "Invalid credit limit",
+				ErrorDetail: "Credit limit should not be less than zero"}
+			return nil, err
+		}
+		indata[FLD_DEALER_CREDIT_LIMIT] = toFloat(value)
+	}
+	if value, ok := terms[FLD_DEALER_PAYMENT_TERMS]; ok {
+		indata[FLD_DEALER_PAYMENT_TERMS] = toInt(value)
+	}
+
+	data, err := p.daoDealer.Update(dealerId, indata)
+
+	log.Println("DealerService::SetTerms - End ", err)
+	return data, err
+}
+
+// GetCredit - Get the credit limit, outstanding balance and available credit of the dealer
+func (p *dealerBaseService) GetCredit(dealerId string) (utils.Map, error) {
+
+	log.Println("DealerService::GetCredit - Begin", dealerId)
+
+	dealer, err := p.daoDealer.Get(dealerId)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("DealerService::GetCredit - End ")
+	return dealerCredit(dealer), nil
+}
+
+// CheckCredit - Verify the order amount is within the available credit of the dealer
+func (p *dealerBaseService) CheckCredit(dealerId string, orderAmount float64) (utils.Map, error) {
+
+	log.Println("DealerService::CheckCredit - Begin", dealerId, orderAmount)
+
+	dealer, err := p.daoDealer.Get(dealerId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ValidateDealerCredit(dealer, orderAmount)
+
+	log.Println("DealerService::CheckCredit - End ", err)
+	return dealerCredit(dealer), err
+}
+
+// RecordPayment - Reduce the outstanding balance of the dealer by the payment
+// The payment cannot be more than the outstanding balance
+func (p *dealerBaseService) RecordPayment(dealerId string, amount float64) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "07"
+
+	log.Println("DealerService::RecordPayment - Begin", dealerId, amount)
+
+	if amount <= 0 {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "03",
+			ErrorMsg:    "Invalid payment amount",
+			ErrorDetail: "Payment amount should be greater than zero"}
+		return nil, err
+	}
+
+	dealer, err := p.daoDealer.Get(dealerId)
+	if err != nil {
+		return nil, err
+	}
+
+	exceeded := func(outstanding float64) error {
+		return &utils.AppError{
+			ErrorCode:   funcode + "05",
+			ErrorMsg:    "Payment exceeds outstanding",
+			ErrorDetail: fmt.Sprintf("Payment amount %.2f is more than the outstanding balance %.2f", amount, outstanding)}
+	}
+	if outstanding := dealerOutstanding(dealer); amount > outstanding+creditEpsilon {
+		return nil, exceeded(outstanding)
+	}
+
+	// The payment is written first and the balance verified after it,
+	// so the concurrent payments together never take the balance below zero
+	paymentKey := FLD_DEALER_CREDIT_PAYMENTS + "." + utils.GenerateUniqueId("dpay")
+	_, err = p.daoDealer.Update(dealerId, utils.Map{paymentKey: amount})
+	if err != nil {
+		return nil, err
+	}
+	dealer, err = refreshOutstanding(p.daoDealer, dealerId)
+	if err != nil {
+		return nil, err
+	}
+	if outstanding := dealerOutstanding(dealer); outstanding < -creditEpsilon {
+		_, err = p.daoDealer.Update(dealerId, utils.Map{paymentKey: 0})
+		if err != nil {
+			return nil, err
+		}
+		_, err = refreshOutstanding(p.daoDealer, dealerId)
+		if err != nil {
+			return nil, err
+		}
+		return nil, exceeded(outstanding + amount)
+	}
+
+	log.Println("DealerService::RecordPayment - End ", dealer[FLD_DEALER_OUTSTANDING])
+	return dealerCredit(dealer), nil
+}
+
+// creditEpsilon - Tolerance for the rounding of the amounts
+const creditEpsilon = 0.005
+
+// ValidateDealerCredit - Verify the outstanding balance plus the order amount is within the credit limit
+// Dealers without a credit limit are not allowed to buy on credit
+func ValidateDealerCredit(dealer utils.Map, orderAmount float64) error {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "07"
+
+	credit := dealerCredit(dealer)
+	if orderAmount > toFloat(credit[FLD_DEALER_AVAILABLE_CREDIT])+creditEpsilon {
+		err := &utils.AppError{
+			ErrorCode: funcode + "04",
+			ErrorMsg:  "Credit limit exceeded",
+			ErrorDetail: fmt.Sprintf("Order amount %.2f exceeds the available credit %.2f (limit %.2f, outstanding %.2f)",
+				orderAmount, credit[FLD_DEALER_AVAILABLE_CREDIT], credit[FLD_DEALER_CREDIT_LIMIT], credit[FLD_DEALER_OUTSTANDING])}
+		return err
+	}
+	return nil
+}
+
+// hasDealerCredit - Dealers with a credit limit buy on credit, the others pay for their orders as usual
+func hasDealerCredit(dealer utils.Map) bool {
+	return toFloat(dealer[FLD_DEALER_CREDIT_LIMIT]) > 0
+}
+
+// ApplyDealerCreditTerms - Charge the order total to the credit of the dealer and set the payment due date
+// The order total must be computed from the priced items. The charge is written first and the limit
+// verified after it, so the concurrent orders together never exceed the limit; the charge is removed
+// when the limit is exceeded. Dealers without a credit limit are not on credit and nothing is charged.
+func ApplyDealerCreditTerms(daoDealer sales_repository.DealerDao, dealer utils.Map, order utils.Map) error {
+	if !hasDealerCredit(dealer) {
+		return nil
+	}
+
+	dealerId, _ := dealer[sales_common.FLD_DEALER_ID].(string)
+	orderId, _ := order[sales_common.FLD_CUSTOMER_ORDER_ID].(string)
+	total := toFloat(order[FLD_ORDER_TOTAL])
+
+	err := ValidateDealerCredit(dealer, total)
+	if err != nil {
+		return err
+	}
+
+	_, err = daoDealer.Update(dealerId, utils.Map{FLD_DEALER_CREDIT_CHARGES + "." + orderId: total})
+	if err != nil {
+		return err
+	}
+	latest, err := refreshOutstanding(daoDealer, dealerId)
+	if err != nil {
+		return err
+	}
+	if dealerOutstanding(latest) > toFloat(latest[FLD_DEALER_CREDIT_LIMIT])+creditEpsilon {
+		// Validate again without this order for the error details
+		latest[FLD_DEALER_OUTSTANDING] = dealerOutstanding(latest) - total
+		creditErr := ValidateDealerCredit(latest, total)
+		err = ReleaseDealerCredit(daoDealer, dealerId, orderId)
+		if err != nil {
+			return err
+		}
+		return creditErr
+	}
+
+	order[sales_common.FLD_DEALER_ID] = dealerId
+	order[FLD_ORDER_ON_CREDIT] = true
+	order[FLD_ORDER_PAYMENT_DUE] = time.Now().AddDate(0, 0, toInt(dealer[FLD_DEALER_PAYMENT_TERMS]))
+	return nil
+}
+
+// ReleaseDealerCredit - Remove the charge of the order from the credit of the dealer,
+// used when the order charged is not created
+func ReleaseDealerCredit(daoDealer sales_repository.DealerDao, dealerId string, orderId string) error {
+	_, err := daoDealer.Update(dealerId, utils.Map{FLD_DEALER_CREDIT_CHARGES + "." + orderId: 0})
+	if err != nil {
+		return err
+	}
+	_, err = refreshOutstanding(daoDealer, dealerId)
+	return err
+}
+
+// refreshOutstanding - Save the outstanding balance computed from the charges and payments, returns the dealer
+func refreshOutstanding(daoDealer sales_repository.DealerDao, dealerId string) (utils.Map, error) {
+	dealer, err := daoDealer.Get(dealerId)
+	if err != nil {
+		return nil, err
+	}
+	outstanding := roundAmount(dealerOutstanding(dealer))
+	_, err = daoDealer.Update(dealerId, utils.Map{FLD_DEALER_OUTSTANDING: outstanding})
+	if err != nil {
+		return nil, err
+	}
+	dealer[FLD_DEALER_OUTSTANDING] = outstanding
+	return dealer, nil
+}
+
+// dealerOutstanding - Outstanding balance of the dealer, the charges less the payments
+func dealerOutstanding(dealer utils.Map) float64 {
+	outstanding := 0.0
+	charges, _ := toMap(dealer[FLD_DEALER_CREDIT_CHARGES])
+	for _, amount := range charges {
+		outstanding += toFloat(amount)
+	}
+	payments, _ := toMap(dealer[FLD_DEALER_CREDIT_PAYMENTS])
+	for _, amount := range payments {
+		outstanding -= toFloat(amount)
+	}
+	return outstanding
+}
+
+// dealerCredit - Credit details of the dealer
+func dealerCredit(dealer utils.Map) utils.Map {
+	limit := toFloat(dealer[FLD_DEALER_CREDIT_LIMIT])
+	outstanding := toFloat(dealer[FLD_DEALER_OUTSTANDING])
+	available := limit - outstanding
+	if available < 0 {
+		available = 0
+	}
+
+	return utils.Map{
+		sales_common.FLD_DEALER_ID:  dealer[sales_common.FLD_DEALER_ID],
+		FLD_DEALER_CREDIT_LIMIT:     roundAmount(limit),
+		FLD_DEALER_OUTSTANDING:      roundAmount(outstanding),
+		FLD_DEALER_AVAILABLE_CREDIT: roundAmount(available),
+		FLD_DEALER_PAYMENT_TERMS:    toInt(dealer[FLD_DEALER_PAYMENT_TERMS]),
+	}
+}
+
+// dealerContractPrice - Contract price of the product for the dealer and quantity
+// The highest quantity break reached overrides the contract price
+func dealerContractPrice(dealer utils.Map, productId string, qty int) (float64, int, bool) {
+	for _, contract := range toMapSlice(dealer[FLD_DEALER_CONTRACT_PRICES]) {
+		if contract[sales_common.FLD_PRODUCT_ID] != productId {
+			continue
+		}
+
+		price := toFloat(contract[FLD_DEALER_CONTRACT_PRICE])
+		breakQty := 0
+		for _, qtyBreak := range toMapSlice(contract[FLD_DEALER_QUANTITY_BREAKS]) {
+			minQty := toInt(qtyBreak[FLD_DEALER_BREAK_MIN_QTY])
+			if qty >= minQty && minQty > breakQty {
+				breakQty = minQty
+				price = toFloat(qtyBreak[FLD_DEALER_CONTRACT_PRICE])
+			}
+		}
+		return price, breakQty, true
+	}
+	return 0, 0, false
+}
+
 func (p *dealerBaseService) errorReturn(err error) (DealerService, error) {
 	// Close the Database Connection
 	p.EndService()
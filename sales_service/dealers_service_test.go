@@ -0,0 +1,26 @@
+package sales_service
+
+import (
+	"math"
+	"testing"
+
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+func TestStoredDealerOutstanding(t *testing.T) {
+	dealer := roundTrip(t, utils.Map{
+		FLD_DEALER_CREDIT_LIMIT:    1000,
+		FLD_DEALER_CREDIT_CHARGES:  utils.Map{"order1": 400.5, "order2": 300, "order3": 0},
+		FLD_DEALER_CREDIT_PAYMENTS: utils.Map{"dpay1": 200},
+	})
+
+	if outstanding := dealerOutstanding(dealer); math.Abs(outstanding-500.5) > 1e-9 {
+		t.Errorf("dealerOutstanding = %g", outstanding)
+	}
+	if !hasDealerCredit(dealer) {
+		t.Error("dealer with a credit limit is not on credit")
+	}
+	if hasDealerCredit(utils.Map{}) {
+		t.Error("dealer without a credit limit is on credit")
+	}
+}
@@ -0,0 +1,1088 @@
+package sales_service
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zapscloud/golib-dbutils/db_utils"
+	"github.com/zapscloud/golib-platform-repository/platform_repository"
+	"github.com/zapscloud/golib-platform-service/platform_service"
+	"github.com/zapscloud/golib-sales-repository/sales_common"
+	"github.com/zapscloud/golib-sales-repository/sales_repository"
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+const (
+	// Product fields for the inventory, the on hand and reserved quantities are kept for the listing
+	// in FLD_PRODUCT_STOCK and FLD_STOCK_RESERVED, the inventory computes them from the fields below
+	FLD_STOCK_RESERVED  = "stock_reserved"
+	FLD_STOCK_AVAILABLE = "stock_available"
+	// On hand quantity at the last stock count and the quantities committed after it {reservation_id: quantity}
+	FLD_STOCK_COUNTED = "stock_counted"
+	FLD_STOCK_COMMITS = "stock_commits"
+	// Quantities committed at the locations after their last stock count {location_id: {reservation_id: quantity}}
+	FLD_LOCATION_COMMITS = "location_commits"
+	// Reservations by their expiry day {yyyymmdd: {reservation_id: reservation}}, released reservations are left empty
+	FLD_STOCK_RESERVATIONS = "stock_reservations"
+
+	// Stock reservation fields
+	FLD_RESERVATION_ID           = "reservation_id"
+	FLD_RESERVATION_REFERENCE_ID = "reference_id"
+	FLD_RESERVATION_QUANTITY     = "quantity"
+	FLD_RESERVATION_EXPIRES_AT   = "expires_at"
+	FLD_RESERVATION_LOCATIONS    = "location_quantities"
+	FLD_RESERVATION_ITEMS        = "items"
+	FLD_RESERVATION_RELEASED     = "released_count"
+
+	// Reservation is held for these minutes when not specified
+	RESERVATION_DEFAULT_TTL_MINUTES = 15
+)
+
+// InventoryService - Product stock and reservation service
+type InventoryService interface {
+	// GetStock - Get the on hand, reserved and available quantity of the product
+	GetStock(productId string) (utils.Map, error)
+	// SetStock - Set the on hand quantity of the product without stock locations
+	SetStock(productId string, onHand int) (utils.Map, error)
+	// Reserve - Reserve the stock of the items for the checkout, items are [{product_id, quantity, product_unit_id, location_id}]
+	// Bundles are reserved as their components
+	Reserve(referenceId string, items []utils.Map, ttlMinutes int) (utils.Map, error)
+	// Commit - Deduct the reserved stock on order placement
+	Commit(reservationId string) (utils.Map, error)
+	// Release - Release the reserved stock on cancellation, the stock committed already is returned
+	Release(reservationId string) (utils.Map, error)
+	// ReleaseExpired - Release the reservations past their expiry time
+	ReleaseExpired() (utils.Map, error)
+
+	// GetLocations - Get the stock locations of the business
+	GetLocations() ([]utils.Map, error)
+	// SetLocations - Set the stock locations of the business with the regions they serve
+	SetLocations(locations []utils.Map) ([]utils.Map, error)
+	// SetLocationStock - Set the on hand quantity of the product at the location
+	SetLocationStock(productId string, locationId string, onHand int) (utils.Map, error)
+	// SelectSources - Pick the locations to ship the order items from
+	SelectSources(order utils.Map) (utils.Map, error)
+
+	EndService()
+}
+
+type inventoryBaseService struct {
+	db_utils.DatabaseService
+	dbRegion      db_utils.DatabaseService
+	daoProduct    sales_repository.ProductDao
+	daoRegion     sales_repository.RegionDao
+	daoBusiness   platform_repository.BusinessDao
+	unitConverter *unitConverter
+	child         InventoryService
+	businessId    string
+}
+
+// NewInventoryService - Construct Inventory
+func NewInventoryService(props utils.Map) (InventoryService, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "01"
+
+	log.Printf("InventoryService::Start ")
+	// Verify whether the business id data passed
+	businessId, err := utils.GetMemberDataStr(props, sales_common.FLD_BUSINESS_ID)
+	if err != nil {
+		return nil, err
+	}
+
+	p := inventoryBaseService{}
+	// Open Database Service
+	err = p.OpenDatabaseService(props)
+	if err != nil {
+		return nil, err
+	}
+
+	// Open RegionDB Service
+	p.dbRegion, err = platform_service.OpenRegionDatabaseService(props)
+	if err != nil {
+		p.CloseDatabaseService()
+		return nil, err
+	}
+
+	// Assign the BusinessId
+	p.businessId = businessId
+	p.initializeService()
+
+	_, err = p.daoBusiness.Get(businessId)
+	if err != nil {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "01",
+			ErrorMsg:    "Invalid BusinessId",
+			ErrorDetail: "Given BusinessId is not exist"}
+		return p.errorReturn(err)
+	}
+
+	p.child = &p
+
+	return &p, err
+}
+
+// EndService - Close all the services
+func (p *inventoryBaseService) EndService() {
+	log.Printf("EndService ")
+	p.CloseDatabaseService()
+	p.dbRegion.CloseDatabaseService()
+}
+
+func (p *inventoryBaseService) initializeService() {
+	log.Printf("InventoryService:: GetBusinessDao ")
+	p.daoBusiness = platform_repository.NewBusinessDao(p.GetClient())
+	p.daoProduct = sales_repository.NewProductDao(p.dbRegion.GetClient(), p.businessId)
+	p.daoRegion = sales_repository.NewRegionDao(p.dbRegion.GetClient(), p.businessId)
+	p.unitConverter = &unitConverter{daoProductUnit: sales_repository.NewProduct_unitDao(p.dbRegion.GetClient(), p.businessId)}
+}
+
+// GetStock - Get the on hand, reserved and available quantity of the product
+func (p *inventoryBaseService) GetStock(productId string) (utils.Map, error) {
+
+	log.Println("InventoryService::GetStock - Begin", productId)
+
+	product, err := p.daoProduct.Get(productId)
+	if err != nil {
+		return nil, err
+	}
+
+	// Stock of the bundle is derived from its components
+	if isBundle(product) {
+		products, err := loadBundleComponents(p.daoProduct, product)
+		if err != nil {
+			return nil, err
+		}
+		available := bundleAvailable(product, products, time.Now())
+		return utils.Map{sales_common.FLD_PRODUCT_ID: productId, FLD_STOCK_AVAILABLE: available}, nil
+	}
+
+	reservations, _ := activeReservations(product, time.Now())
+	response := stockInfo(productId, productOnHand(product), reservations)
+
+	log.Println("InventoryService::GetStock - End ", response)
+	return response, nil
+}
+
+// SetStock - Set the on hand quantity of the product as counted, the committed quantities start again from the count
+// Stock of the product with stock locations is the total of its locations, it is set by the location
+func (p *inventoryBaseService) SetStock(productId string, onHand int) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "09"
+
+	log.Println("InventoryService::SetStock - Begin", productId, onHand)
+
+	product, err := p.daoProduct.Get(productId)
+	if err != nil {
+		return nil, err
+	}
+	if hasLocationStock(product) {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "13",
+			ErrorMsg:    "Stock is kept by location",
+			ErrorDetail: fmt.Sprintf("Stock of product %s is the total of its locations, set the stock of the locations", productId)}
+		return nil, err
+	}
+
+	reservations, _ := activeReservations(product, time.Now())
+	reserved := reservedQuantity(reservations)
+	if onHand < reserved {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "01",
+			ErrorMsg:    "Invalid stock quantity",
+			ErrorDetail: fmt.Sprintf("On hand quantity cannot be less than the reserved quantity %d", reserved)}
+		return nil, err
+	}
+
+	_, err = p.daoProduct.Update(productId, utils.Map{
+		FLD_STOCK_COUNTED:  onHand,
+		FLD_STOCK_COMMITS:  utils.Map{},
+		FLD_PRODUCT_STOCK:  onHand,
+		FLD_STOCK_RESERVED: reserved,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("InventoryService::SetStock - End ")
+	return stockInfo(productId, onHand, reservations), nil
+}
+
+// Reserve - Reserve the stock of the items for the checkout, items are [{product_id, quantity, product_unit_id, location_id}]
+// product_unit_id is optional, the quantity is converted to the base unit of the product
+// location_id is optional, when given the stock of the location is reserved as well
+// The reservation is written first and the stock verified after it, so the concurrent reservations
+// never oversell the stock; the reservation is removed again when any item is short.
+func (p *inventoryBaseService) Reserve(referenceId string, items []utils.Map, ttlMinutes int) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "09"
+
+	log.Println("InventoryService::Reserve - Begin", referenceId)
+
+	// Quantities in other units are reserved in the base unit of the product
+	items, err := p.baseUnitItems(items)
+	if err != nil {
+		return nil, err
+	}
+
+	// Bundles reserve the stock of their components
+	items, err = expandBundleItems(p.daoProduct, items)
+	if err != nil {
+		return nil, err
+	}
+
+	// Merge the quantities of the same product and location
+	quantities := map[string]map[string]int{}
+	for _, item := range items {
+		productId, _ := item[sales_common.FLD_PRODUCT_ID].(string)
+		locationId, _ := item[FLD_LOCATION_ID].(string)
+		quantity := toInt(item[FLD_RESERVATION_QUANTITY])
+		if len(productId) == 0 || quantity <= 0 {
+			err := &utils.AppError{
+				ErrorCode:   funcode + "02",
+				ErrorMsg:    "Invalid reservation item",
+				ErrorDetail: "Each item requires product_id and a positive quantity"}
+			return nil, err
+		}
+		if quantities[productId] == nil {
+			quantities[productId] = map[string]int{}
+		}
+		quantities[productId][locationId] += quantity
+	}
+	if len(quantities) == 0 {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "03",
+			ErrorMsg:    "Invalid reservation",
+			ErrorDetail: "No items to reserve"}
+		return nil, err
+	}
+
+	if ttlMinutes <= 0 {
+		ttlMinutes = RESERVATION_DEFAULT_TTL_MINUTES
+	}
+
+	productIds := make([]string, 0, len(quantities))
+	for productId := range quantities {
+		productIds = append(productIds, productId)
+	}
+	sort.Strings(productIds)
+
+	now := time.Now()
+	expiresAt := now.Add(time.Duration(ttlMinutes) * time.Minute)
+	reservationId := reservationDay(expiresAt) + "_" + utils.GenerateUniqueId("resv")
+	reserved := []string{}
+	reservedItems := []utils.Map{}
+	for _, productId := range productIds {
+		total := 0
+		locationQuantities := utils.Map{}
+		for locationId, quantity := range quantities[productId] {
+			total += quantity
+			item := utils.Map{sales_common.FLD_PRODUCT_ID: productId, FLD_RESERVATION_QUANTITY: quantity}
+			if len(locationId) > 0 {
+				locationQuantities[locationId] = quantity
+				item[FLD_LOCATION_ID] = locationId
+			}
+			reservedItems = append(reservedItems, item)
+		}
+
+		reservation := utils.Map{
+			FLD_RESERVATION_ID:           reservationId,
+			FLD_RESERVATION_REFERENCE_ID: referenceId,
+			FLD_RESERVATION_QUANTITY:     total,
+			FLD_RESERVATION_LOCATIONS:    locationQuantities,
+			FLD_RESERVATION_EXPIRES_AT:   expiresAt,
+		}
+		_, err := p.daoProduct.Update(productId, utils.Map{reservationField(reservationId): reservation})
+		if err != nil {
+			return nil, p.undoReserve(funcode, reservationId, reserved, err)
+		}
+		reserved = append(reserved, productId)
+
+		// Stock is verified with all the reservations held, including the ones made meanwhile
+		product, err := p.daoProduct.Get(productId)
+		if err != nil {
+			return nil, p.undoReserve(funcode, reservationId, reserved, err)
+		}
+		reservations, _ := activeReservations(product, now)
+		err = checkReservedStock(funcode, product, reservations, quantities[productId])
+		if err != nil {
+			return nil, p.undoReserve(funcode, reservationId, reserved, err)
+		}
+		err = saveStock(p.daoProduct, product, reservations)
+		if err != nil {
+			return nil, p.undoReserve(funcode, reservationId, reserved, err)
+		}
+	}
+
+	response := utils.Map{
+		FLD_RESERVATION_ID:           reservationId,
+		FLD_RESERVATION_REFERENCE_ID: referenceId,
+		FLD_RESERVATION_EXPIRES_AT:   expiresAt,
+		FLD_RESERVATION_ITEMS:        reservedItems,
+	}
+
+	log.Println("InventoryService::Reserve - End ", reservationId)
+	return response, nil
+}
+
+// baseUnitItems - Convert the quantity of the items given in another unit to the base unit of the product
+// Stock is kept in whole base units, so a part of the base unit reserves the whole unit
+func (p *inventoryBaseService) baseUnitItems(items []utils.Map) ([]utils.Map, error) {
+
+	converted := make([]utils.Map, 0, len(items))
+	for _, item := range items {
+		unitId, _ := item[sales_common.FLD_PRODUCT_UNIT_ID].(string)
+		if len(unitId) == 0 {
+			converted = append(converted, item)
+			continue
+		}
+
+		product, err := p.daoProduct.Get(productIdOf(item))
+		if err != nil {
+			return nil, err
+		}
+		baseQuantity, _, err := p.unitConverter.toBaseQuantity(product, toFloat(item[FLD_RESERVATION_QUANTITY]), unitId)
+		if err != nil {
+			return nil, err
+		}
+
+		item = utils.CopyMap(item)
+		delete(item, sales_common.FLD_PRODUCT_UNIT_ID)
+		item[FLD_RESERVATION_QUANTITY] = int(math.Ceil(baseQuantity - unitEpsilon))
+		converted = append(converted, item)
+	}
+	return converted, nil
+}
+
+// Commit - Deduct the reserved stock on order placement
+// Commit of the reservation already committed for some of its products is completed for the others,
+// so the failed commit can be run again
+func (p *inventoryBaseService) Commit(reservationId string) (utils.Map, error) {
+
+	log.Println("InventoryService::Commit - Begin", reservationId)
+
+	response, err := CommitReservation(p.daoProduct, reservationId)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("InventoryService::Commit - End ", reservationId)
+	return response, nil
+}
+
+// Release - Release the reserved stock on cancellation, the stock committed already is returned
+func (p *inventoryBaseService) Release(reservationId string) (utils.Map, error) {
+
+	log.Println("InventoryService::Release - Begin", reservationId)
+
+	response, err := ReleaseReservation(p.daoProduct, reservationId)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("InventoryService::Release - End ", reservationId)
+	return response, nil
+}
+
+// ReleaseExpired - Remove the reservations of the past days, run by the scheduler
+// Expired reservations of the day are not counted already, they are removed with their day
+func (p *inventoryBaseService) ReleaseExpired() (utils.Map, error) {
+
+	log.Println("InventoryService::ReleaseExpired - Begin")
+
+	filter := buildFilter(utils.Map{FLD_STOCK_RESERVATIONS: utils.Map{"$exists": true, "$ne": utils.Map{}}})
+	listdata, err := p.daoProduct.List(filter, "", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	released := 0
+	for _, item := range listResult(listdata) {
+		productId, _ := item[sales_common.FLD_PRODUCT_ID].(string)
+		count, err := p.releaseExpiredOf(productId)
+		if err != nil {
+			return utils.Map{FLD_RESERVATION_RELEASED: released}, err
+		}
+		released += count
+	}
+
+	log.Println("InventoryService::ReleaseExpired - End ", released)
+	return utils.Map{FLD_RESERVATION_RELEASED: released}, nil
+}
+
+// GetLocations - Get the stock locations of the business
+func (p *inventoryBaseService) GetLocations() ([]utils.Map, error) {
+
+	log.Println("InventoryService::GetLocations - Begin")
+
+	locations, _, err := getBusinessSetting(p.daoBusiness, p.businessId, FLD_STOCK_LOCATIONS)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("InventoryService::GetLocations - End ")
+	return toMapSlice(locations), nil
+}
+
+// SetLocations - Set the stock locations of the business with the regions they serve
+func (p *inventoryBaseService) SetLocations(locations []utils.Map) ([]utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "09"
+
+	log.Println("InventoryService::SetLocations - Begin", len(locations))
+
+	locationIds := map[string]bool{}
+	for _, location := range locations {
+		locationId, _ := location[FLD_LOCATION_ID].(string)
+		if len(locationId) == 0 || locationIds[locationId] {
+			err := &utils.AppError{
+				ErrorCode:   funcode + "07",
+				ErrorMsg:    "Invalid location",
+				ErrorDetail: "Each location requires a unique location_id"}
+			return nil, err
+		}
+		locationIds[locationId] = true
+
+		locationType, _ := location[FLD_LOCATION_TYPE].(string)
+		if locationType != LOCATION_TYPE_WAREHOUSE && locationType != LOCATION_TYPE_DEALER_STORE {
+			err := &utils.AppError{
+				ErrorCode:   funcode + "08",
+				ErrorMsg:    "Invalid location type",
+				ErrorDetail: fmt.Sprintf("Location type of %s should be %s or %s", locationId, LOCATION_TYPE_WAREHOUSE, LOCATION_TYPE_DEALER_STORE)}
+			return nil, err
+		}
+
+		for _, regionId := range toStringSlice(location[FLD_LOCATION_REGION_IDS]) {
+			_, err := p.daoRegion.Get(regionId)
+			if err != nil {
+				err := &utils.AppError{
+					ErrorCode:   funcode + "09",
+					ErrorMsg:    "Invalid region",
+					ErrorDetail: fmt.Sprintf("Region %s of location %s is not exist", regionId, locationId)}
+				return nil, err
+			}
+		}
+	}
+
+	_, err := updateBusinessSetting(p.daoBusiness, p.businessId, FLD_STOCK_LOCATIONS, locations)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("InventoryService::SetLocations - End ")
+	return locations, nil
+}
+
+// SetLocationStock - Set the on hand quantity of the product at the location as counted
+// The on hand quantity of the product is kept as the total of all the locations
+func (p *inventoryBaseService) SetLocationStock(productId string, locationId string, onHand int) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "09"
+
+	log.Println("InventoryService::SetLocationStock - Begin", productId, locationId, onHand)
+
+	locations, err := p.GetLocations()
+	if err != nil {
+		return nil, err
+	}
+	found := false
+	for _, location := range locations {
+		if location[FLD_LOCATION_ID] == locationId {
+			found = true
+			break
+		}
+	}
+	if !found {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "10",
+			ErrorMsg:    "Invalid location",
+			ErrorDetail: "Given location_id is not exist"}
+		return nil, err
+	}
+
+	product, err := p.daoProduct.Get(productId)
+	if err != nil {
+		return nil, err
+	}
+
+	reservations, _ := activeReservations(product, time.Now())
+	reserved := reservedAtLocation(reservations, locationId)
+	if onHand < reserved {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "01",
+			ErrorMsg:    "Invalid stock quantity",
+			ErrorDetail: fmt.Sprintf("On hand quantity cannot be less than the reserved quantity %d", reserved)}
+		return nil, err
+	}
+
+	// Quantities committed without a location are counted in the locations from now
+	_, err = p.daoProduct.Update(productId, utils.Map{
+		FLD_PRODUCT_LOCATION_STOCK + "." + locationId: onHand,
+		FLD_LOCATION_COMMITS + "." + locationId:       utils.Map{},
+		FLD_STOCK_COMMITS:                             utils.Map{},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	product, err = p.daoProduct.Get(productId)
+	if err != nil {
+		return nil, err
+	}
+	reservations, _ = activeReservations(product, time.Now())
+	err = saveStock(p.daoProduct, product, reservations)
+	if err != nil {
+		return nil, err
+	}
+
+	locationStock := utils.Map{}
+	stock, _ := toMap(product[FLD_PRODUCT_LOCATION_STOCK])
+	for stockLocationId := range stock {
+		locationStock[stockLocationId] = locationOnHand(product, stockLocationId)
+	}
+	response := stockInfo(productId, productOnHand(product), reservations)
+	response[FLD_PRODUCT_LOCATION_STOCK] = locationStock
+
+	log.Println("InventoryService::SetLocationStock - End ")
+	return response, nil
+}
+
+// SelectSources - Pick the locations to ship the order items from
+// order holds items [{product_id, quantity}], region_id, latitude, longitude and allow_split
+// Bundles are shipped as their components
+func (p *inventoryBaseService) SelectSources(order utils.Map) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "09"
+
+	log.Println("InventoryService::SelectSources - Begin")
+
+	items, err := expandBundleItems(p.daoProduct, toMapSlice(order[FLD_RESERVATION_ITEMS]))
+	if err != nil {
+		return nil, err
+	}
+
+	quantities := map[string]int{}
+	for _, item := range items {
+		productId, _ := item[sales_common.FLD_PRODUCT_ID].(string)
+		quantity := toInt(item[FLD_RESERVATION_QUANTITY])
+		if len(productId) == 0 || quantity <= 0 {
+			err := &utils.AppError{
+				ErrorCode:   funcode + "02",
+				ErrorMsg:    "Invalid order item",
+				ErrorDetail: "Each item requires product_id and a positive quantity"}
+			return nil, err
+		}
+		quantities[productId] += quantity
+	}
+
+	locations, err := p.GetLocations()
+	if err != nil {
+		return nil, err
+	}
+
+	regionId, _ := order[sales_common.FLD_REGION_ID].(string)
+	sources := []*sourceLocation{}
+	sourceIdx := map[string]*sourceLocation{}
+	for _, location := range locations {
+		if !servesRegion(location, regionId) {
+			continue
+		}
+		source := &sourceLocation{location: location, stock: map[string]int{}}
+		source.distance, source.hasDist = locationDistance(location, order[FLD_LOCATION_LATITUDE], order[FLD_LOCATION_LONGITUDE])
+		sources = append(sources, source)
+		sourceIdx[source.locationId()] = source
+	}
+
+	// Available stock of the items at each location
+	now := time.Now()
+	for productId := range quantities {
+		product, err := p.daoProduct.Get(productId)
+		if err != nil {
+			return nil, err
+		}
+
+		reservations, _ := activeReservations(product, now)
+		locationStock, _ := toMap(product[FLD_PRODUCT_LOCATION_STOCK])
+		for locationId := range locationStock {
+			if source, ok := sourceIdx[locationId]; ok {
+				source.stock[productId] = locationOnHand(product, locationId) - reservedAtLocation(reservations, locationId)
+			}
+		}
+	}
+
+	allowSplit, _ := order[FLD_SOURCING_ALLOW_SPLIT].(bool)
+	picked, allocations, shortage := selectSources(sources, quantities, allowSplit)
+	if len(shortage) > 0 {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "11",
+			ErrorMsg:    "Insufficient stock",
+			ErrorDetail: "No location or combination of locations serving the region has the stock for the order"}
+		return utils.Map{FLD_SOURCING_SHORTAGE: shipmentItems(shortage)}, err
+	}
+
+	shipments := make([]utils.Map, 0, len(picked))
+	for _, source := range picked {
+		shipment := utils.Map{
+			FLD_LOCATION_ID:       source.locationId(),
+			FLD_LOCATION_NAME:     source.location[FLD_LOCATION_NAME],
+			FLD_LOCATION_TYPE:     source.location[FLD_LOCATION_TYPE],
+			FLD_RESERVATION_ITEMS: shipmentItems(allocations[source.locationId()]),
+		}
+		if source.hasDist {
+			shipment[FLD_SOURCING_DISTANCE_KM] = roundAmount(source.distance)
+		}
+		shipments = append(shipments, shipment)
+	}
+
+	response := utils.Map{
+		FLD_SOURCING_SHIPMENTS: shipments,
+		FLD_SOURCING_IS_SPLIT:  len(shipments) > 1,
+	}
+
+	log.Println("InventoryService::SelectSources - End ", len(shipments))
+	return response, nil
+}
+
+// releaseExpiredOf - Remove the reservations of the product expiring on the past days
+// No reservation is made for the past days, so their whole day is removed at once
+func (p *inventoryBaseService) releaseExpiredOf(productId string) (int, error) {
+
+	product, err := p.daoProduct.Get(productId)
+	if err != nil {
+		return 0, err
+	}
+
+	today := reservationDay(time.Now())
+	released := 0
+	indata := utils.Map{}
+	days, _ := toMap(product[FLD_STOCK_RESERVATIONS])
+	for day, value := range days {
+		reservations, ok := toMap(value)
+		if !ok || day >= today {
+			continue
+		}
+		for _, reservation := range reservations {
+			if _, ok := toMap(reservation); ok {
+				released++
+			}
+		}
+		indata[FLD_STOCK_RESERVATIONS+"."+day] = nil
+	}
+	if len(indata) == 0 {
+		return 0, nil
+	}
+
+	_, err = p.daoProduct.Update(productId, indata)
+	if err != nil {
+		return 0, err
+	}
+	return released, refreshStock(p.daoProduct, productId)
+}
+
+// CommitReservation - Deduct the reserved stock of the reservation, used by the inventory and on order placement
+// Commit of the reservation already committed for some of its products is completed for the others
+func CommitReservation(daoProduct sales_repository.ProductDao, reservationId string) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "09"
+
+	productIds, err := reservedProducts(daoProduct, funcode, reservationId, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// Verify the reservation is still held for all the items before deducting any of them
+	now := time.Now()
+	products := map[string]utils.Map{}
+	quantities := map[string]int{}
+	locations := map[string]map[string]int{}
+	for _, productId := range productIds {
+		product, err := daoProduct.Get(productId)
+		if err != nil {
+			return nil, err
+		}
+
+		reservations, _ := activeReservations(product, now)
+		_, quantity, byLocation, found := removeReservation(reservations, reservationId)
+		if !found {
+			if committed, ok := committedReservation(product, reservationId); ok {
+				quantities[productId] = committed
+				continue
+			}
+			err := &utils.AppError{
+				ErrorCode:   funcode + "06",
+				ErrorMsg:    "Reservation expired",
+				ErrorDetail: fmt.Sprintf("Reservation of product %s is expired or released, reserve the stock again", productId)}
+			return nil, err
+		}
+		products[productId] = product
+		quantities[productId] = quantity
+		locations[productId] = byLocation
+	}
+
+	for _, productId := range productIds {
+		product, ok := products[productId]
+		if !ok {
+			continue
+		}
+
+		// Reservation is turned into the commit in one update, the stock is deducted from the count,
+		// the quantities of the locations from the count of the location
+		unlocated := quantities[productId]
+		indata := utils.Map{reservationField(reservationId): nil}
+		for locationId, quantity := range locations[productId] {
+			indata[FLD_LOCATION_COMMITS+"."+locationId+"."+reservationId] = quantity
+			unlocated -= quantity
+		}
+		indata[FLD_STOCK_COMMITS+"."+reservationId] = unlocated
+		if _, ok := product[FLD_STOCK_COUNTED]; !ok {
+			indata[FLD_STOCK_COUNTED] = toInt(product[FLD_PRODUCT_STOCK])
+		}
+		_, err := daoProduct.Update(productId, indata)
+		if err != nil {
+			return nil, err
+		}
+		err = refreshStock(daoProduct, productId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return utils.Map{
+		FLD_RESERVATION_ID:    reservationId,
+		FLD_RESERVATION_ITEMS: quantities,
+	}, nil
+}
+
+// ReleaseReservation - Release the reserved stock of the reservation, used by the inventory,
+// when the order is not created and when the order is cancelled. The stock committed already
+// for the cancelled order is returned, the commit is removed from the on hand quantity
+func ReleaseReservation(daoProduct sales_repository.ProductDao, reservationId string) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "09"
+
+	productIds, err := reservedProducts(daoProduct, funcode, reservationId, true)
+	if err != nil {
+		return nil, err
+	}
+
+	quantities := map[string]int{}
+	for _, productId := range productIds {
+		product, err := daoProduct.Get(productId)
+		if err != nil {
+			return nil, err
+		}
+
+		reservations, _ := activeReservations(product, time.Now())
+		_, quantity, _, found := removeReservation(reservations, reservationId)
+		indata := utils.Map{reservationField(reservationId): nil}
+		if !found {
+			quantity, _ = committedReservation(product, reservationId)
+			if quantity == 0 {
+				continue
+			}
+			indata = utils.Map{FLD_STOCK_COMMITS + "." + reservationId: nil}
+			locationCommits, _ := toMap(product[FLD_LOCATION_COMMITS])
+			for locationId, value := range locationCommits {
+				if commits, _ := toMap(value); commits[reservationId] != nil {
+					indata[FLD_LOCATION_COMMITS+"."+locationId+"."+reservationId] = nil
+				}
+			}
+		}
+
+		_, err = daoProduct.Update(productId, indata)
+		if err != nil {
+			return nil, err
+		}
+		err = refreshStock(daoProduct, productId)
+		if err != nil {
+			return nil, err
+		}
+		quantities[productId] = quantity
+	}
+
+	return utils.Map{
+		FLD_RESERVATION_ID:    reservationId,
+		FLD_RESERVATION_ITEMS: quantities,
+	}, nil
+}
+
+// reservedProducts - Get the products having the reservation, with the products it is committed for when committed
+func reservedProducts(daoProduct sales_repository.ProductDao, funcode string, reservationId string, committed bool) ([]string, error) {
+	notExist := &utils.AppError{
+		ErrorCode:   funcode + "05",
+		ErrorMsg:    "Invalid reservation",
+		ErrorDetail: "Given reservation_id is not exist"}
+
+	if _, ok := reservationIdDay(reservationId); !ok {
+		return nil, notExist
+	}
+
+	filter := utils.Map{reservationField(reservationId) + "." + FLD_RESERVATION_ID: reservationId}
+	if committed {
+		filter = utils.Map{"$or": []utils.Map{
+			filter,
+			{FLD_STOCK_COMMITS + "." + reservationId: utils.Map{"$exists": true}},
+		}}
+	}
+	listdata, err := daoProduct.List(buildFilter(filter), "", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	productIds := []string{}
+	for _, item := range listResult(listdata) {
+		if productId, ok := item[sales_common.FLD_PRODUCT_ID].(string); ok {
+			productIds = append(productIds, productId)
+		}
+	}
+	sort.Strings(productIds)
+
+	if len(productIds) == 0 {
+		return nil, notExist
+	}
+	return productIds, nil
+}
+
+// undoReserve - Remove the reservation from the products reserved already, returns the cause
+// The error names the products the reservation could not be removed from, held until it expires
+func (p *inventoryBaseService) undoReserve(funcode string, reservationId string, productIds []string, cause error) error {
+	failed := []string{}
+	for _, productId := range productIds {
+		_, err := p.daoProduct.Update(productId, utils.Map{reservationField(reservationId): nil})
+		if err == nil {
+			err = refreshStock(p.daoProduct, productId)
+		}
+		if err != nil {
+			log.Println("InventoryService::Reserve - Undo failed", reservationId, productId, err)
+			failed = append(failed, productId)
+		}
+	}
+	if len(failed) == 0 {
+		return cause
+	}
+
+	return &utils.AppError{
+		ErrorCode:   funcode + "12",
+		ErrorMsg:    "Reservation failed",
+		ErrorDetail: fmt.Sprintf("%v; reservation %s of products %v could not be removed, it is held until it expires", cause, reservationId, failed)}
+}
+
+// refreshStock - Save the on hand and reserved quantity of the product computed from its reservations and commits
+func refreshStock(daoProduct sales_repository.ProductDao, productId string) error {
+	product, err := daoProduct.Get(productId)
+	if err != nil {
+		return err
+	}
+	reservations, _ := activeReservations(product, time.Now())
+	return saveStock(daoProduct, product, reservations)
+}
+
+// saveStock - Save the on hand and reserved quantity of the product
+// These are kept for the listing, the inventory always computes them from the reservations and commits
+func saveStock(daoProduct sales_repository.ProductDao, product utils.Map, reservations []utils.Map) error {
+	_, err := daoProduct.Update(productIdOf(product), utils.Map{
+		FLD_PRODUCT_STOCK:  productOnHand(product),
+		FLD_STOCK_RESERVED: reservedQuantity(reservations),
+	})
+	return err
+}
+
+func (p *inventoryBaseService) errorReturn(err error) (InventoryService, error) {
+	// Close the Database Connection
+	p.EndService()
+	return nil, err
+}
+
+// reservationDay - Day the reservations expiring at the time are kept under
+func reservationDay(expiresAt time.Time) string {
+	return expiresAt.UTC().Format("20060102")
+}
+
+// reservationIdDay - Day of the reservation, the reservation id starts with it
+func reservationIdDay(reservationId string) (string, bool) {
+	day, _, ok := strings.Cut(reservationId, "_")
+	if _, err := time.Parse("20060102", day); !ok || err != nil {
+		return "", false
+	}
+	return day, true
+}
+
+// reservationField - Product field holding the reservation
+func reservationField(reservationId string) string {
+	day, _ := reservationIdDay(reservationId)
+	return FLD_STOCK_RESERVATIONS + "." + day + "." + reservationId
+}
+
+// activeReservations - Get the reservations of the product not expired yet and the count of expired ones
+func activeReservations(product utils.Map, now time.Time) ([]utils.Map, int) {
+	active := []utils.Map{}
+	expired := 0
+
+	days, _ := toMap(product[FLD_STOCK_RESERVATIONS])
+	for _, day := range sortedKeys(days) {
+		reservations, _ := toMap(days[day])
+		for _, reservationId := range sortedKeys(reservations) {
+			// Released reservations are left empty
+			reservation, ok := toMap(reservations[reservationId])
+			if !ok {
+				continue
+			}
+			expiresAt, ok := toTime(reservation[FLD_RESERVATION_EXPIRES_AT])
+			if ok && !now.Before(expiresAt) {
+				expired++
+				continue
+			}
+			active = append(active, reservation)
+		}
+	}
+	return active, expired
+}
+
+// sortedKeys - Keys of the map in sorted order
+func sortedKeys(values utils.Map) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// removeReservation - Remove the reservation from the list
+// Returns the remaining ones, the reserved quantity and the quantity reserved per location
+func removeReservation(reservations []utils.Map, reservationId string) ([]utils.Map, int, map[string]int, bool) {
+	remaining := []utils.Map{}
+	quantity := 0
+	byLocation := map[string]int{}
+	found := false
+	for _, reservation := range reservations {
+		if reservation[FLD_RESERVATION_ID] == reservationId {
+			quantity += toInt(reservation[FLD_RESERVATION_QUANTITY])
+			locationQuantities, _ := toMap(reservation[FLD_RESERVATION_LOCATIONS])
+			for locationId, locationQuantity := range locationQuantities {
+				byLocation[locationId] += toInt(locationQuantity)
+			}
+			found = true
+			continue
+		}
+		remaining = append(remaining, reservation)
+	}
+	return remaining, quantity, byLocation, found
+}
+
+// reservedQuantity - Total quantity held by the reservations
+func reservedQuantity(reservations []utils.Map) int {
+	reserved := 0
+	for _, reservation := range reservations {
+		reserved += toInt(reservation[FLD_RESERVATION_QUANTITY])
+	}
+	return reserved
+}
+
+// reservedAtLocation - Quantity held by the reservations at the location
+func reservedAtLocation(reservations []utils.Map, locationId string) int {
+	reserved := 0
+	for _, reservation := range reservations {
+		locationQuantities, _ := toMap(reservation[FLD_RESERVATION_LOCATIONS])
+		reserved += toInt(locationQuantities[locationId])
+	}
+	return reserved
+}
+
+// committedQuantity - Total quantity of the commits {reservation_id: quantity}
+func committedQuantity(value any) int {
+	commits, _ := toMap(value)
+	committed := 0
+	for _, quantity := range commits {
+		committed += toInt(quantity)
+	}
+	return committed
+}
+
+// hasLocationStock - Check whether the stock of the product is kept by location
+func hasLocationStock(product utils.Map) bool {
+	locationStock, _ := toMap(product[FLD_PRODUCT_LOCATION_STOCK])
+	return len(locationStock) > 0
+}
+
+// committedReservation - Quantity committed for the reservation, at the locations and without a location
+func committedReservation(product utils.Map, reservationId string) (int, bool) {
+	commits, _ := toMap(product[FLD_STOCK_COMMITS])
+	unlocated, found := commits[reservationId]
+	committed := toInt(unlocated)
+
+	locationCommits, _ := toMap(product[FLD_LOCATION_COMMITS])
+	for _, value := range locationCommits {
+		commits, _ := toMap(value)
+		if quantity, ok := commits[reservationId]; ok {
+			committed += toInt(quantity)
+			found = true
+		}
+	}
+	return committed, found
+}
+
+// productOnHand - On hand quantity of the product, the count less the quantity committed after it
+// Product with stock locations has the total of its locations, less the quantity committed without a location.
+// Product never counted has the stock it was created with as the count.
+func productOnHand(product utils.Map) int {
+	if hasLocationStock(product) {
+		onHand := 0
+		locationStock, _ := toMap(product[FLD_PRODUCT_LOCATION_STOCK])
+		for locationId := range locationStock {
+			onHand += locationOnHand(product, locationId)
+		}
+		return onHand - committedQuantity(product[FLD_STOCK_COMMITS])
+	}
+
+	counted, ok := product[FLD_STOCK_COUNTED]
+	if !ok {
+		counted = product[FLD_PRODUCT_STOCK]
+	}
+	return toInt(counted) - committedQuantity(product[FLD_STOCK_COMMITS])
+}
+
+// locationOnHand - On hand quantity of the product at the location, the count less the quantity committed after it
+func locationOnHand(product utils.Map, locationId string) int {
+	locationStock, _ := toMap(product[FLD_PRODUCT_LOCATION_STOCK])
+	locationCommits, _ := toMap(product[FLD_LOCATION_COMMITS])
+	return toInt(locationStock[locationId]) - committedQuantity(locationCommits[locationId])
+}
+
+// checkReservedStock - Verify the reservations held, including the requested quantities, are within the stock
+func checkReservedStock(funcode string, product utils.Map, reservations []utils.Map, quantities map[string]int) error {
+	productId := productIdOf(product)
+
+	requested := 0
+	for locationId, quantity := range quantities {
+		requested += quantity
+		if len(locationId) == 0 {
+			continue
+		}
+
+		available := locationOnHand(product, locationId) - reservedAtLocation(reservations, locationId) + quantity
+		if available < quantity {
+			err := &utils.AppError{
+				ErrorCode:   funcode + "04",
+				ErrorMsg:    "Insufficient stock",
+				ErrorDetail: fmt.Sprintf("Product %s has %d available at %s, %d requested", productId, available, locationId, quantity)}
+			return err
+		}
+	}
+
+	available := productOnHand(product) - reservedQuantity(reservations) + requested
+	if available < requested {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "04",
+			ErrorMsg:    "Insufficient stock",
+			ErrorDetail: fmt.Sprintf("Product %s has %d available, %d requested", productId, available, requested)}
+		return err
+	}
+	return nil
+}
+
+// stockInfo - Build the stock response of the product
+func stockInfo(productId string, onHand int, reservations []utils.Map) utils.Map {
+	reserved := reservedQuantity(reservations)
+	return utils.Map{
+		sales_common.FLD_PRODUCT_ID: productId,
+		FLD_PRODUCT_STOCK:           onHand,
+		FLD_STOCK_RESERVED:          reserved,
+		FLD_STOCK_AVAILABLE:         onHand - reserved,
+	}
+}
@@ -0,0 +1,82 @@
+package sales_service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+func TestStoredReservations(t *testing.T) {
+	now := time.Date(2030, 1, 2, 10, 0, 0, 0, time.UTC)
+	active := reservationDay(now) + "_resv_active"
+	expired := reservationDay(now) + "_resv_expired"
+	product := roundTrip(t, utils.Map{
+		FLD_PRODUCT_STOCK:          100,
+		FLD_STOCK_COUNTED:          20,
+		FLD_STOCK_COMMITS:          utils.Map{"resv_old": 5},
+		FLD_PRODUCT_LOCATION_STOCK: utils.Map{"wh1": 12},
+		FLD_LOCATION_COMMITS:       utils.Map{"wh1": utils.Map{"resv_old": 5}},
+		FLD_STOCK_RESERVATIONS: utils.Map{reservationDay(now): utils.Map{
+			active: utils.Map{
+				FLD_RESERVATION_ID:         active,
+				FLD_RESERVATION_QUANTITY:   4,
+				FLD_RESERVATION_LOCATIONS:  utils.Map{"wh1": 3},
+				FLD_RESERVATION_EXPIRES_AT: now.Add(time.Minute),
+			},
+			expired: utils.Map{
+				FLD_RESERVATION_ID:         expired,
+				FLD_RESERVATION_QUANTITY:   9,
+				FLD_RESERVATION_EXPIRES_AT: now.Add(-time.Minute),
+			},
+			"20300102_resv_released": nil,
+		}},
+	})
+
+	reservations, expiredCount := activeReservations(product, now)
+	if len(reservations) != 1 || expiredCount != 1 {
+		t.Fatalf("activeReservations = %v, %d", reservations, expiredCount)
+	}
+	if reserved := reservedAtLocation(reservations, "wh1"); reserved != 3 {
+		t.Errorf("reservedAtLocation = %d", reserved)
+	}
+	// Stock of the locations less the quantity committed without a location
+	if onHand := productOnHand(product); onHand != 2 {
+		t.Errorf("productOnHand = %d", onHand)
+	}
+	if onHand := locationOnHand(product, "wh1"); onHand != 7 {
+		t.Errorf("locationOnHand = %d", onHand)
+	}
+
+	// 7 on hand at wh1 and 3 reserved there, including the 3 requested
+	if err := checkReservedStock("test", product, reservations, map[string]int{"wh1": 3}); err == nil {
+		t.Error("reservation over the product stock is accepted")
+	}
+	delete(product, FLD_STOCK_COMMITS)
+	if err := checkReservedStock("test", product, reservations, map[string]int{"wh1": 3}); err != nil {
+		t.Errorf("checkReservedStock = %v", err)
+	}
+	more := append(reservations, utils.Map{FLD_RESERVATION_QUANTITY: 5, FLD_RESERVATION_LOCATIONS: utils.Map{"wh1": 5}})
+	if err := checkReservedStock("test", product, more, map[string]int{"wh1": 5}); err == nil {
+		t.Error("reservation over the location stock is accepted")
+	}
+}
+
+func TestProductOnHand(t *testing.T) {
+	product := roundTrip(t, utils.Map{FLD_PRODUCT_STOCK: 100, FLD_STOCK_COUNTED: 20, FLD_STOCK_COMMITS: utils.Map{"resv_old": 5}})
+	if onHand := productOnHand(product); onHand != 15 {
+		t.Errorf("productOnHand = %d", onHand)
+	}
+	if onHand := productOnHand(utils.Map{FLD_PRODUCT_STOCK: 100}); onHand != 100 {
+		t.Errorf("productOnHand of the product never counted = %d", onHand)
+	}
+}
+
+func TestReservationIdDay(t *testing.T) {
+	if day, ok := reservationIdDay("20300102_resv_x"); !ok || day != "20300102" {
+		t.Errorf("reservationIdDay = %s, %v", day, ok)
+	}
+	if _, ok := reservationIdDay("resv_x"); ok {
+		t.Error("reservation id without the day is accepted")
+	}
+}
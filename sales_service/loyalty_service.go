@@ -0,0 +1,667 @@
+package sales_service
+
+import (
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/zapscloud/golib-dbutils/db_common"
+	"github.com/zapscloud/golib-dbutils/db_utils"
+	"github.com/zapscloud/golib-platform-repository/platform_repository"
+	"github.com/zapscloud/golib-platform-service/platform_service"
+	"github.com/zapscloud/golib-sales-repository/sales_common"
+	"github.com/zapscloud/golib-sales-repository/sales_repository"
+	"github.com/zapscloud/golib-sales-repository/sales_repository/customer_repository"
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+const (
+	// Customer fields for the loyalty points
+	FLD_LOYALTY_POINTS          = "loyalty_points"
+	FLD_LOYALTY_LIFETIME_POINTS = "loyalty_lifetime_points"
+	FLD_LOYALTY_TIER            = "loyalty_tier"
+	// Transactions are keyed by the transaction id {transaction_id: transaction}
+	FLD_LOYALTY_TRANSACTIONS = "loyalty_transactions"
+
+	// Loyalty transaction fields
+	FLD_LOYALTY_TRANSACTION_ID   = "transaction_id"
+	FLD_LOYALTY_TRANSACTION_TYPE = "transaction_type"
+	FLD_LOYALTY_TXN_POINTS       = "points"
+	FLD_LOYALTY_TXN_REMAINING    = "remaining_points"
+	FLD_LOYALTY_TXN_VALUE        = "value"
+	FLD_LOYALTY_TXN_EXPIRES_AT   = "expires_at"
+	FLD_LOYALTY_TXN_DESCRIPTION  = "description"
+
+	// Business setting for the loyalty program
+	FLD_LOYALTY_PROGRAM            = "loyalty_program"
+	FLD_LOYALTY_EARN_RATE          = "earn_rate"
+	FLD_LOYALTY_BONUS_CATEGORIES   = "bonus_categories"
+	FLD_LOYALTY_POINT_VALUE        = "point_value"
+	FLD_LOYALTY_MIN_REDEEM_POINTS  = "min_redeem_points"
+	FLD_LOYALTY_MAX_REDEEM_PERCENT = "max_redeem_percent"
+	FLD_LOYALTY_EXPIRY_DAYS        = "expiry_days"
+	FLD_LOYALTY_TIERS              = "tiers"
+	FLD_LOYALTY_TIER_NAME          = "tier_name"
+	FLD_LOYALTY_TIER_MIN_POINTS    = "min_points"
+	FLD_LOYALTY_TIER_MULTIPLIER    = "multiplier"
+
+	// Statement response fields
+	FLD_LOYALTY_EXPIRING_POINTS = "expiring_points"
+	FLD_LOYALTY_NEXT_EXPIRY     = "next_expiry"
+	FLD_LOYALTY_UPDATED_COUNT   = "updated_customers"
+
+	LOYALTY_TXN_EARN   = "earn"
+	LOYALTY_TXN_REDEEM = "redeem"
+	LOYALTY_TXN_EXPIRE = "expire"
+
+	// Points expiring within these days are shown in the statement
+	LOYALTY_EXPIRING_WITHIN_DAYS = 30
+)
+
+// LoyaltyService - Loyalty points earn and burn program
+type LoyaltyService interface {
+	// GetProgram - Get the loyalty program settings of the business
+	GetProgram() (utils.Map, error)
+	// SetProgram - Set the loyalty program settings of the business
+	SetProgram(program utils.Map) (utils.Map, error)
+	// Earn - Credit the points for the order to the customer, computed from the stored order
+	Earn(customerId string, orderId string) (utils.Map, error)
+	// Redeem - Redeem the points against the order, returns the value of the points
+	Redeem(customerId string, orderId string, points int) (utils.Map, error)
+	// Statement - Get the points statement of the customer
+	Statement(customerId string) (utils.Map, error)
+	// ExpirePoints - Expire the points past their expiry date for all the customers
+	ExpirePoints() (utils.Map, error)
+
+	EndService()
+}
+
+type loyaltyBaseService struct {
+	db_utils.DatabaseService
+	dbRegion      db_utils.DatabaseService
+	daoCustomer   sales_repository.CustomerDao
+	priceResolver *PriceResolver
+	daoBusiness   platform_repository.BusinessDao
+	child         LoyaltyService
+	businessId    string
+}
+
+// NewLoyaltyService - Construct Loyalty
+func NewLoyaltyService(props utils.Map) (LoyaltyService, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "01"
+
+	log.Printf("LoyaltyService::Start ")
+	// Verify whether the business id data passed
+	businessId, err := utils.GetMemberDataStr(props, sales_common.FLD_BUSINESS_ID)
+	if err != nil {
+		return nil, err
+	}
+
+	p := loyaltyBaseService{}
+	// Open Database Service
+	err = p.OpenDatabaseService(props)
+	if err != nil {
+		return nil, err
+	}
+
+	// Open RegionDB Service
+	p.dbRegion, err = platform_service.OpenRegionDatabaseService(props)
+	if err != nil {
+		p.CloseDatabaseService()
+		return nil, err
+	}
+
+	// Assign the BusinessId
+	p.businessId = businessId
+	p.initializeService()
+
+	_, err = p.daoBusiness.Get(businessId)
+	if err != nil {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "01",
+			ErrorMsg:    "Invalid BusinessId",
+			ErrorDetail: "Given BusinessId is not exist"}
+		return p.errorReturn(err)
+	}
+
+	p.child = &p
+
+	return &p, err
+}
+
+// EndService - Close all the services
+func (p *loyaltyBaseService) EndService() {
+	log.Printf("EndService ")
+	p.CloseDatabaseService()
+	p.dbRegion.CloseDatabaseService()
+}
+
+func (p *loyaltyBaseService) initializeService() {
+	log.Printf("LoyaltyService:: GetBusinessDao ")
+	p.daoBusiness = platform_repository.NewBusinessDao(p.GetClient())
+	p.daoCustomer = sales_repository.NewCustomerDao(p.dbRegion.GetClient(), p.businessId)
+	p.priceResolver = NewPriceResolver(p.dbRegion.GetClient(), p.businessId)
+}
+
+// GetProgram - Get the loyalty program settings of the business
+func (p *loyaltyBaseService) GetProgram() (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "05"
+
+	value, _, err := getBusinessSetting(p.daoBusiness, p.businessId, FLD_LOYALTY_PROGRAM)
+	if err != nil {
+		return nil, err
+	}
+
+	program, ok := toMap(value)
+	if !ok {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "01",
+			ErrorMsg:    "Loyalty program not configured",
+			ErrorDetail: "Loyalty program settings are not configured for the business"}
+		return nil, err
+	}
+	return program, nil
+}
+
+// SetProgram - Set the loyalty program settings of the business
+func (p *loyaltyBaseService) SetProgram(program utils.Map) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "05"
+
+	log.Println("LoyaltyService::SetProgram - Begin")
+
+	if toFloat(program[FLD_LOYALTY_EARN_RATE]) <= 0 || toFloat(program[FLD_LOYALTY_POINT_VALUE]) <= 0 {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "02",
+			ErrorMsg:    "Invalid loyalty program",
+			ErrorDetail: "earn_rate and point_value should be greater than zero"}
+		return nil, err
+	}
+
+	data, err := updateBusinessSetting(p.daoBusiness, p.businessId, FLD_LOYALTY_PROGRAM, program)
+
+	log.Println("LoyaltyService::SetProgram - End", err)
+	return data, err
+}
+
+// Earn - Credit the points for the delivered order to the customer, computed from the stored order
+// The items and the prices are taken from the order and the products, never from the caller
+func (p *loyaltyBaseService) Earn(customerId string, orderId string) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "05"
+
+	log.Println("LoyaltyService::Earn - Begin", customerId, orderId)
+
+	program, err := p.GetProgram()
+	if err != nil {
+		return nil, err
+	}
+
+	customer, err := p.daoCustomer.Get(customerId)
+	if err != nil {
+		return nil, err
+	}
+
+	// Transaction id of the order, the concurrent calls for the order write the same transaction
+	txnId := LOYALTY_TXN_EARN + "_" + orderId
+	now := time.Now()
+	transactions, changed := expireTransactions(loyaltyTransactions(customer), now)
+	if orderTransaction(transactions, LOYALTY_TXN_EARN, orderId) != nil {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "03",
+			ErrorMsg:    "Points already earned",
+			ErrorDetail: "Points are already credited for the given order"}
+		return nil, err
+	}
+
+	daoOrder := customer_repository.NewCustomerOrderDao(p.GetClient(), p.businessId, customerId)
+	order, err := daoOrder.Get(orderId)
+	if err != nil {
+		return nil, err
+	}
+	// Points are credited once the order is delivered, so the cancelled orders never earn
+	if status, _ := order[FLD_ORDER_STATUS].(string); status != ORDER_STATUS_DELIVERED {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "07",
+			ErrorMsg:    "Order not eligible",
+			ErrorDetail: "Points are credited only for the delivered orders"}
+		return nil, err
+	}
+
+	lines, err := loadCartLines(p.priceResolver, utils.Map{
+		FLD_CART_ITEMS:               order[FLD_ORDER_ITEMS],
+		sales_common.FLD_CUSTOMER_ID: customerId,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tier := loyaltyTier(program, lifetimePoints(transactions))
+	points := earnedPoints(program, tier, lines)
+	if points <= 0 {
+		return utils.Map{FLD_LOYALTY_TXN_POINTS: 0}, nil
+	}
+
+	txn := utils.Map{
+		FLD_LOYALTY_TRANSACTION_ID:         txnId,
+		FLD_LOYALTY_TRANSACTION_TYPE:       LOYALTY_TXN_EARN,
+		FLD_LOYALTY_TXN_POINTS:             points,
+		FLD_LOYALTY_TXN_REMAINING:          points,
+		sales_common.FLD_CUSTOMER_ORDER_ID: orderId,
+		db_common.FLD_CREATED_AT:           now,
+	}
+	if expiryDays := toInt(program[FLD_LOYALTY_EXPIRY_DAYS]); expiryDays > 0 {
+		txn[FLD_LOYALTY_TXN_EXPIRES_AT] = now.AddDate(0, 0, expiryDays)
+	}
+	transactions = append(transactions, txn)
+	changed = append(changed, txn)
+
+	lifetime := lifetimePoints(transactions)
+	indata := transactionUpdates(customer, transactions, changed)
+	indata[FLD_LOYALTY_LIFETIME_POINTS] = lifetime
+	indata[FLD_LOYALTY_TIER] = loyaltyTier(program, lifetime)[FLD_LOYALTY_TIER_NAME]
+	_, err = p.daoCustomer.Update(customerId, indata)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("LoyaltyService::Earn - End ", points)
+	return txn, nil
+}
+
+// Redeem - Redeem the points against the order, returns the value of the points
+// Points expiring first are used first, the points are redeemed once for the order.
+// The order amount is taken from the stored order, never from the caller
+func (p *loyaltyBaseService) Redeem(customerId string, orderId string, points int) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "05"
+
+	log.Println("LoyaltyService::Redeem - Begin", customerId, orderId, points)
+
+	program, err := p.GetProgram()
+	if err != nil {
+		return nil, err
+	}
+
+	daoOrder := customer_repository.NewCustomerOrderDao(p.GetClient(), p.businessId, customerId)
+	order, err := daoOrder.Get(orderId)
+	if err != nil {
+		return nil, err
+	}
+	if status, _ := order[FLD_ORDER_STATUS].(string); status == ORDER_STATUS_CANCELLED || status == ORDER_STATUS_RETURNED {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "07",
+			ErrorMsg:    "Order not eligible",
+			ErrorDetail: "Points are not redeemed for the cancelled or returned orders"}
+		return nil, err
+	}
+	orderAmount := toFloat(order[FLD_ORDER_TOTAL])
+
+	customer, err := p.daoCustomer.Get(customerId)
+	if err != nil {
+		return nil, err
+	}
+
+	txnId := LOYALTY_TXN_REDEEM + "_" + orderId
+	now := time.Now()
+	transactions, changed := expireTransactions(loyaltyTransactions(customer), now)
+	if orderTransaction(transactions, LOYALTY_TXN_REDEEM, orderId) != nil {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "08",
+			ErrorMsg:    "Points already redeemed",
+			ErrorDetail: "Points are already redeemed for the given order"}
+		return nil, err
+	}
+	balance := pointsBalance(transactions)
+
+	insufficient := &utils.AppError{
+		ErrorCode:   funcode + "04",
+		ErrorMsg:    "Insufficient points",
+		ErrorDetail: "Points to redeem should be between 1 and the available balance"}
+	if points <= 0 || points > balance {
+		return nil, insufficient
+	}
+	if minPoints := toInt(program[FLD_LOYALTY_MIN_REDEEM_POINTS]); points < minPoints {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "05",
+			ErrorMsg:    "Below minimum redemption",
+			ErrorDetail: "Points to redeem is below the minimum allowed"}
+		return nil, err
+	}
+
+	pointValue := toFloat(program[FLD_LOYALTY_POINT_VALUE])
+	value := roundAmount(float64(points) * pointValue)
+	if maxPercent := toFloat(program[FLD_LOYALTY_MAX_REDEEM_PERCENT]); maxPercent > 0 && value > orderAmount*maxPercent/100 {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "06",
+			ErrorMsg:    "Redemption limit exceeded",
+			ErrorDetail: "Value of the points exceeds the allowed percentage of the order"}
+		return nil, err
+	}
+	if value > orderAmount {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "06",
+			ErrorMsg:    "Redemption limit exceeded",
+			ErrorDetail: "Value of the points exceeds the order amount"}
+		return nil, err
+	}
+
+	// Consume the earned points, the earliest expiry first
+	lots := []utils.Map{}
+	for _, txn := range transactions {
+		if txn[FLD_LOYALTY_TRANSACTION_TYPE] == LOYALTY_TXN_EARN && toInt(txn[FLD_LOYALTY_TXN_REMAINING]) > 0 {
+			lots = append(lots, txn)
+		}
+	}
+	sort.SliceStable(lots, func(i, j int) bool {
+		expiryI, okI := toTime(lots[i][FLD_LOYALTY_TXN_EXPIRES_AT])
+		expiryJ, okJ := toTime(lots[j][FLD_LOYALTY_TXN_EXPIRES_AT])
+		if okI != okJ {
+			return okI
+		}
+		return expiryI.Before(expiryJ)
+	})
+	pending := points
+	usedLots := map[string]int{}
+	for _, lot := range lots {
+		used := int(math.Min(float64(pending), float64(toInt(lot[FLD_LOYALTY_TXN_REMAINING]))))
+		lot[FLD_LOYALTY_TXN_REMAINING] = toInt(lot[FLD_LOYALTY_TXN_REMAINING]) - used
+		lotId, _ := lot[FLD_LOYALTY_TRANSACTION_ID].(string)
+		usedLots[lotId] = used
+		changed = append(changed, lot)
+		pending -= used
+		if pending == 0 {
+			break
+		}
+	}
+
+	txn := utils.Map{
+		FLD_LOYALTY_TRANSACTION_ID:         txnId,
+		FLD_LOYALTY_TRANSACTION_TYPE:       LOYALTY_TXN_REDEEM,
+		FLD_LOYALTY_TXN_POINTS:             -points,
+		FLD_LOYALTY_TXN_VALUE:              value,
+		sales_common.FLD_CUSTOMER_ORDER_ID: orderId,
+		db_common.FLD_CREATED_AT:           now,
+	}
+	transactions = append(transactions, txn)
+	changed = append(changed, txn)
+
+	// The redemption is written first and the balance verified after it,
+	// so the concurrent redemptions together never take the balance below zero
+	_, err = p.daoCustomer.Update(customerId, transactionUpdates(customer, transactions, changed))
+	if err != nil {
+		return nil, err
+	}
+	latest, err := p.daoCustomer.Get(customerId)
+	if err != nil {
+		return nil, err
+	}
+	if pointsBalance(loyaltyTransactions(latest)) < 0 {
+		err = p.revertRedemption(customerId, txnId, usedLots)
+		if err != nil {
+			return nil, err
+		}
+		return nil, insufficient
+	}
+
+	log.Println("LoyaltyService::Redeem - End ", value)
+	return txn, nil
+}
+
+// revertRedemption - Remove the redeem transaction and give back the points used from the earned lots
+func (p *loyaltyBaseService) revertRedemption(customerId string, txnId string, usedLots map[string]int) error {
+	customer, err := p.daoCustomer.Get(customerId)
+	if err != nil {
+		return err
+	}
+
+	indata := utils.Map{FLD_LOYALTY_TRANSACTIONS + "." + txnId: nil}
+	transactions := []utils.Map{}
+	for _, txn := range loyaltyTransactions(customer) {
+		id, _ := txn[FLD_LOYALTY_TRANSACTION_ID].(string)
+		if id == txnId {
+			continue
+		}
+		if used, ok := usedLots[id]; ok {
+			txn[FLD_LOYALTY_TXN_REMAINING] = toInt(txn[FLD_LOYALTY_TXN_REMAINING]) + used
+			indata[FLD_LOYALTY_TRANSACTIONS+"."+id] = txn
+		}
+		transactions = append(transactions, txn)
+	}
+	indata[FLD_LOYALTY_POINTS] = pointsBalance(transactions)
+
+	_, err = p.daoCustomer.Update(customerId, indata)
+	return err
+}
+
+// Statement - Get the points statement of the customer
+func (p *loyaltyBaseService) Statement(customerId string) (utils.Map, error) {
+
+	log.Println("LoyaltyService::Statement - Begin", customerId)
+
+	customer, err := p.daoCustomer.Get(customerId)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	transactions, _ := expireTransactions(loyaltyTransactions(customer), now)
+
+	// Points expiring soon
+	expiring := 0
+	var nextExpiry *time.Time
+	for _, txn := range transactions {
+		expiresAt, ok := toTime(txn[FLD_LOYALTY_TXN_EXPIRES_AT])
+		remaining := toInt(txn[FLD_LOYALTY_TXN_REMAINING])
+		if !ok || remaining <= 0 {
+			continue
+		}
+		if expiresAt.Before(now.AddDate(0, 0, LOYALTY_EXPIRING_WITHIN_DAYS)) {
+			expiring += remaining
+		}
+		if nextExpiry == nil || expiresAt.Before(*nextExpiry) {
+			nextExpiry = &expiresAt
+		}
+	}
+
+	// Latest transactions first
+	statement := make([]utils.Map, len(transactions))
+	copy(statement, transactions)
+	sort.SliceStable(statement, func(i, j int) bool {
+		createdI, _ := toTime(statement[i][db_common.FLD_CREATED_AT])
+		createdJ, _ := toTime(statement[j][db_common.FLD_CREATED_AT])
+		return createdI.After(createdJ)
+	})
+
+	response := utils.Map{
+		sales_common.FLD_CUSTOMER_ID: customerId,
+		FLD_LOYALTY_POINTS:           pointsBalance(transactions),
+		FLD_LOYALTY_LIFETIME_POINTS:  lifetimePoints(transactions),
+		FLD_LOYALTY_TIER:             customer[FLD_LOYALTY_TIER],
+		FLD_LOYALTY_EXPIRING_POINTS:  expiring,
+		FLD_LOYALTY_TRANSACTIONS:     statement,
+	}
+	if nextExpiry != nil {
+		response[FLD_LOYALTY_NEXT_EXPIRY] = *nextExpiry
+	}
+
+	log.Println("LoyaltyService::Statement - End ")
+	return response, nil
+}
+
+// ExpirePoints - Expire the points past their expiry date for all the customers
+// It is meant to be called periodically by a batch job
+func (p *loyaltyBaseService) ExpirePoints() (utils.Map, error) {
+
+	log.Println("LoyaltyService::ExpirePoints - Begin")
+
+	filter := buildFilter(utils.Map{FLD_LOYALTY_POINTS: utils.Map{db_common.MONGODB_CONDITION_GT: 0}})
+	listdata, err := p.daoCustomer.List(filter, "", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	updated := 0
+	for _, customer := range listResult(listdata) {
+		customerId, _ := customer[sales_common.FLD_CUSTOMER_ID].(string)
+		transactions, changed := expireTransactions(loyaltyTransactions(customer), now)
+		if len(changed) == 0 {
+			continue
+		}
+
+		_, err = p.daoCustomer.Update(customerId, transactionUpdates(customer, transactions, changed))
+		if err != nil {
+			return nil, err
+		}
+		updated++
+	}
+
+	log.Println("LoyaltyService::ExpirePoints - End ", updated)
+	return utils.Map{FLD_LOYALTY_UPDATED_COUNT: updated}, nil
+}
+
+// earnedPoints - Points for the cart lines as per the earn rate, bonus categories and tier
+func earnedPoints(program utils.Map, tier utils.Map, lines []cartLine) int {
+	earnRate := toFloat(program[FLD_LOYALTY_EARN_RATE])
+	bonusCategories, _ := toMap(program[FLD_LOYALTY_BONUS_CATEGORIES])
+
+	multiplier := 1.0
+	if tierMultiplier := toFloat(tier[FLD_LOYALTY_TIER_MULTIPLIER]); tierMultiplier > 0 {
+		multiplier = tierMultiplier
+	}
+
+	points := 0.0
+	for _, line := range lines {
+		linePoints := line.amount() * earnRate
+		if bonus := toFloat(bonusCategories[line.categoryId]); bonus > 0 {
+			linePoints *= bonus
+		}
+		points += linePoints
+	}
+	return int(math.Floor(points * multiplier))
+}
+
+// loyaltyTier - Highest tier reached with the lifetime points
+func loyaltyTier(program utils.Map, lifetimePoints int) utils.Map {
+	selected := utils.Map{}
+	minPoints := -1
+	for _, tier := range toMapSlice(program[FLD_LOYALTY_TIERS]) {
+		tierPoints := toInt(tier[FLD_LOYALTY_TIER_MIN_POINTS])
+		if lifetimePoints >= tierPoints && tierPoints > minPoints {
+			selected = tier
+			minPoints = tierPoints
+		}
+	}
+	return selected
+}
+
+// expireTransactions - Add the expire transactions for the earned points past the expiry
+// Returns all the transactions and the changed ones, the expired lots and the new expire transactions
+func expireTransactions(transactions []utils.Map, now time.Time) ([]utils.Map, []utils.Map) {
+	changed := []utils.Map{}
+	expired := []utils.Map{}
+	for _, txn := range transactions {
+		expiresAt, ok := toTime(txn[FLD_LOYALTY_TXN_EXPIRES_AT])
+		remaining := toInt(txn[FLD_LOYALTY_TXN_REMAINING])
+		if txn[FLD_LOYALTY_TRANSACTION_TYPE] != LOYALTY_TXN_EARN || !ok || remaining <= 0 || now.Before(expiresAt) {
+			continue
+		}
+
+		earnTxnId, _ := txn[FLD_LOYALTY_TRANSACTION_ID].(string)
+		txn[FLD_LOYALTY_TXN_REMAINING] = 0
+		changed = append(changed, txn)
+		expired = append(expired, utils.Map{
+			FLD_LOYALTY_TRANSACTION_ID:   LOYALTY_TXN_EXPIRE + "_" + earnTxnId,
+			FLD_LOYALTY_TRANSACTION_TYPE: LOYALTY_TXN_EXPIRE,
+			FLD_LOYALTY_TXN_POINTS:       -remaining,
+			FLD_LOYALTY_TXN_DESCRIPTION:  "Points expired from " + earnTxnId,
+			db_common.FLD_CREATED_AT:     now,
+		})
+	}
+	return append(transactions, expired...), append(changed, expired...)
+}
+
+// loyaltyTransactions - Transactions of the customer in the order they are created
+// Older records have the transactions as a list, they are read as well
+func loyaltyTransactions(customer utils.Map) []utils.Map {
+	stored, keyed := toMap(customer[FLD_LOYALTY_TRANSACTIONS])
+	if !keyed {
+		return toMapSlice(customer[FLD_LOYALTY_TRANSACTIONS])
+	}
+
+	transactions := make([]utils.Map, 0, len(stored))
+	for _, value := range stored {
+		if txn, ok := toMap(value); ok {
+			transactions = append(transactions, txn)
+		}
+	}
+	sort.Slice(transactions, func(i, j int) bool {
+		createdI, _ := toTime(transactions[i][db_common.FLD_CREATED_AT])
+		createdJ, _ := toTime(transactions[j][db_common.FLD_CREATED_AT])
+		if !createdI.Equal(createdJ) {
+			return createdI.Before(createdJ)
+		}
+		idI, _ := transactions[i][FLD_LOYALTY_TRANSACTION_ID].(string)
+		idJ, _ := transactions[j][FLD_LOYALTY_TRANSACTION_ID].(string)
+		return idI < idJ
+	})
+	return transactions
+}
+
+// transactionUpdates - Fields to update for the changed transactions along with the balance
+// Each transaction is set under its own key, so the transactions written by a concurrent call are kept.
+// Older records having the transactions as a list are saved with all the transactions keyed.
+func transactionUpdates(customer utils.Map, transactions []utils.Map, changed []utils.Map) utils.Map {
+	indata := utils.Map{FLD_LOYALTY_POINTS: pointsBalance(transactions)}
+
+	if _, keyed := toMap(customer[FLD_LOYALTY_TRANSACTIONS]); keyed || customer[FLD_LOYALTY_TRANSACTIONS] == nil {
+		for _, txn := range changed {
+			txnId, _ := txn[FLD_LOYALTY_TRANSACTION_ID].(string)
+			indata[FLD_LOYALTY_TRANSACTIONS+"."+txnId] = txn
+		}
+		return indata
+	}
+
+	all := utils.Map{}
+	for _, txn := range transactions {
+		txnId, _ := txn[FLD_LOYALTY_TRANSACTION_ID].(string)
+		all[txnId] = txn
+	}
+	indata[FLD_LOYALTY_TRANSACTIONS] = all
+	return indata
+}
+
+// orderTransaction - Find the transaction of the type for the order, nil when not found
+func orderTransaction(transactions []utils.Map, txnType string, orderId string) utils.Map {
+	for _, txn := range transactions {
+		if txn[FLD_LOYALTY_TRANSACTION_TYPE] == txnType && txn[sales_common.FLD_CUSTOMER_ORDER_ID] == orderId {
+			return txn
+		}
+	}
+	return nil
+}
+
+// lifetimePoints - Total of the earned points
+func lifetimePoints(transactions []utils.Map) int {
+	lifetime := 0
+	for _, txn := range transactions {
+		if txn[FLD_LOYALTY_TRANSACTION_TYPE] == LOYALTY_TXN_EARN {
+			lifetime += toInt(txn[FLD_LOYALTY_TXN_POINTS])
+		}
+	}
+	return lifetime
+}
+
+// pointsBalance - Sum of the points of all the transactions
+func pointsBalance(transactions []utils.Map) int {
+	balance := 0
+	for _, txn := range transactions {
+		balance += toInt(txn[FLD_LOYALTY_TXN_POINTS])
+	}
+	return balance
+}
+
+func (p *loyaltyBaseService) errorReturn(err error) (LoyaltyService, error) {
+	// Close the Database Connection
+	p.EndService()
+	return nil, err
+}
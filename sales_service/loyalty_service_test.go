@@ -0,0 +1,64 @@
+package sales_service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zapscloud/golib-dbutils/db_common"
+	"github.com/zapscloud/golib-sales-repository/sales_common"
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+// storeTransactions - Apply the update of the transactions on the customer and read it back
+func storeTransactions(t *testing.T, customer utils.Map, indata utils.Map) utils.Map {
+	t.Helper()
+
+	customer = utils.CopyMap(customer)
+	for key, value := range indata {
+		if txnId := strings.TrimPrefix(key, FLD_LOYALTY_TRANSACTIONS+"."); txnId != key {
+			stored, _ := toMap(customer[FLD_LOYALTY_TRANSACTIONS])
+			if stored == nil {
+				stored = utils.Map{}
+			}
+			stored[txnId] = value
+			customer[FLD_LOYALTY_TRANSACTIONS] = stored
+			continue
+		}
+		customer[key] = value
+	}
+	return roundTrip(t, customer)
+}
+
+func TestStoredLoyaltyTransactions(t *testing.T) {
+	now := time.Now()
+	earn := utils.Map{
+		FLD_LOYALTY_TRANSACTION_ID:         "earn_order1",
+		FLD_LOYALTY_TRANSACTION_TYPE:       LOYALTY_TXN_EARN,
+		FLD_LOYALTY_TXN_POINTS:             100,
+		FLD_LOYALTY_TXN_REMAINING:          100,
+		FLD_LOYALTY_TXN_EXPIRES_AT:         now.Add(time.Hour),
+		sales_common.FLD_CUSTOMER_ORDER_ID: "order1",
+		db_common.FLD_CREATED_AT:           now,
+	}
+	customer := storeTransactions(t, utils.Map{}, transactionUpdates(utils.Map{}, []utils.Map{earn}, []utils.Map{earn}))
+
+	transactions := loyaltyTransactions(customer)
+	if len(transactions) != 1 || pointsBalance(transactions) != 100 {
+		t.Fatalf("transactions = %v", transactions)
+	}
+	if orderTransaction(transactions, LOYALTY_TXN_EARN, "order1") == nil {
+		t.Error("earn transaction of the order is not found")
+	}
+
+	// Stored expiry date is read, the points expire after it
+	transactions, changed := expireTransactions(transactions, now.Add(2*time.Hour))
+	if len(changed) != 2 || pointsBalance(transactions) != 0 {
+		t.Errorf("expired = %v, balance %d", changed, pointsBalance(transactions))
+	}
+
+	customer = storeTransactions(t, customer, transactionUpdates(customer, transactions, changed))
+	if transactions = loyaltyTransactions(customer); len(transactions) != 2 || pointsBalance(transactions) != 0 {
+		t.Errorf("transactions after expiry = %v", transactions)
+	}
+}
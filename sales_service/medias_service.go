@@ -28,6 +28,9 @@ type MediaService interface {
 	// Delete - Delete Service
 	Delete(mediaId string, delete_permanent bool) error
 
+	// GetUsage - List the products, banners and blogs using the media
+	GetUsage(mediaId string) (utils.Map, error)
+
 	EndService()
 }
 
@@ -35,6 +38,9 @@ type mediaBaseService struct {
 	db_utils.DatabaseService
 	dbRegion    db_utils.DatabaseService
 	daoMedia    sales_repository.MediaDao
+	daoProduct  sales_repository.ProductDao
+	daoBanner   sales_repository.BannerDao
+	daoBlog     sales_repository.BlogDao
 	daoBusiness platform_repository.BusinessDao
 	child       MediaService
 	businessId  string
@@ -94,6 +100,9 @@ func (p *mediaBaseService) initializeService() {
 	log.Printf("MediaService:: GetBusinessDao ")
 	p.daoBusiness = platform_repository.NewBusinessDao(p.GetClient())
 	p.daoMedia = sales_repository.NewMediaDao(p.dbRegion.GetClient(), p.businessId)
+	p.daoProduct = sales_repository.NewProductDao(p.dbRegion.GetClient(), p.businessId)
+	p.daoBanner = sales_repository.NewBannerDao(p.dbRegion.GetClient(), p.businessId)
+	p.daoBlog = sales_repository.NewBlogDao(p.dbRegion.GetClient(), p.businessId)
 }
 
 // List - List All records
@@ -167,10 +176,21 @@ func (p *mediaBaseService) Update(mediaId string, indata utils.Map) (utils.Map,
 }
 
 // Delete - Delete Service
+// Media used by any product, banner or blog cannot be deleted
 func (p *mediaBaseService) Delete(mediaId string, delete_permanent bool) error {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "21"
 
 	log.Println("MediaService::Delete - Begin", mediaId)
 
+	usage, err := mediaUsage(p.daoProduct, p.daoBanner, p.daoBlog, mediaId)
+	if err != nil {
+		return err
+	}
+	err = mediaInUseError(funcode, mediaId, usage)
+	if err != nil {
+		return err
+	}
+
 	if delete_permanent {
 		result, err := p.daoMedia.Delete(mediaId)
 		if err != nil {
@@ -190,6 +210,29 @@ func (p *mediaBaseService) Delete(mediaId string, delete_permanent bool) error {
 	return nil
 }
 
+// GetUsage - List the products, banners and blogs using the media
+func (p *mediaBaseService) GetUsage(mediaId string) (utils.Map, error) {
+
+	log.Println("MediaService::GetUsage - Begin", mediaId)
+
+	_, err := p.daoMedia.Get(mediaId)
+	if err != nil {
+		return nil, err
+	}
+
+	usage, err := mediaUsage(p.daoProduct, p.daoBanner, p.daoBlog, mediaId)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("MediaService::GetUsage - End ", len(usage))
+	return utils.Map{
+		sales_common.FLD_MEDIA_ID: mediaId,
+		FLD_MEDIA_IN_USE:          len(usage) > 0,
+		FLD_MEDIA_USAGE:           usage,
+	}, nil
+}
+
 func (p *mediaBaseService) errorReturn(err error) (MediaService, error) {
 	// Close the Database Connection
 	p.EndService()
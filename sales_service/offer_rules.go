@@ -0,0 +1,466 @@
+package sales_service
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/zapscloud/golib-dbutils/db_common"
+	"github.com/zapscloud/golib-sales-repository/sales_common"
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+// Offer rule types
+const (
+	OFFER_TYPE_BUY_X_GET_Y     = "buy_x_get_y"
+	OFFER_TYPE_QUANTITY_TIER   = "quantity_tier"
+	OFFER_TYPE_SPEND_THRESHOLD = "spend_threshold"
+	OFFER_TYPE_BUNDLE_PRICE    = "bundle_price"
+
+	DISCOUNT_TYPE_PERCENT = "percent"
+	DISCOUNT_TYPE_FIXED   = "fixed"
+)
+
+// Offer definition fields
+const (
+	FLD_OFFER_TYPE             = "offer_type"
+	FLD_OFFER_NAME             = "offer_name"
+	FLD_OFFER_PRIORITY         = "priority"
+	FLD_OFFER_VALID_FROM       = "valid_from"
+	FLD_OFFER_VALID_TILL       = "valid_till"
+	FLD_OFFER_PRODUCT_IDS      = "product_ids"
+	FLD_OFFER_CATEGORY_IDS     = "category_ids"
+	FLD_OFFER_BRAND_IDS        = "brand_ids"
+	FLD_OFFER_BUY_QUANTITY     = "buy_quantity"
+	FLD_OFFER_GET_QUANTITY     = "get_quantity"
+	FLD_OFFER_GET_PERCENT      = "get_discount_percent"
+	FLD_OFFER_TIERS            = "tiers"
+	FLD_OFFER_TIER_MIN_QTY     = "min_quantity"
+	FLD_OFFER_MIN_SPEND        = "min_spend"
+	FLD_OFFER_MAX_DISCOUNT     = "max_discount"
+	FLD_OFFER_DISCOUNT_TYPE    = "discount_type"
+	FLD_OFFER_DISCOUNT_VALUE   = "discount_value"
+	FLD_OFFER_DISCOUNT_PERCENT = "discount_percent"
+	FLD_OFFER_BUNDLE_QUANTITY  = "bundle_quantity"
+	FLD_OFFER_BUNDLE_PRICE     = "bundle_price"
+)
+
+// Cart and evaluation result fields
+const (
+	FLD_CART_ITEMS         = "items"
+	FLD_CART_ITEM_QUANTITY = "quantity"
+	FLD_CART_ITEM_PRICE    = "price"
+	FLD_CART_SUBTOTAL      = "subtotal"
+
+	FLD_DISCOUNTS         = "discounts"
+	FLD_DISCOUNT_AMOUNT   = "amount"
+	FLD_DISCOUNT_LINES    = "lines"
+	FLD_DISCOUNT_EXPLAIN  = "explanation"
+	FLD_TOTAL_DISCOUNT    = "total_discount"
+	FLD_DISCOUNT_QUANTITY = "quantity"
+)
+
+// cartLine - Cart item used by the offer rules
+type cartLine struct {
+	productId  string
+	categoryId string
+	brandId    string
+	quantity   int
+	unitPrice  float64
+}
+
+func (line cartLine) amount() float64 {
+	return float64(line.quantity) * line.unitPrice
+}
+
+// lineDiscount - Discount applied on a cart line
+type lineDiscount struct {
+	productId   string
+	quantity    int
+	amount      float64
+	explanation string
+}
+
+// offerDiscount - Discount computed for an offer or a coupon
+type offerDiscount struct {
+	promotionType string
+	offerId       string
+	offerType     string
+	couponCode    string
+	priority      int
+	stackable     bool
+	amount        float64
+	explanation   string
+	lines         []lineDiscount
+}
+
+func (d offerDiscount) toMap() utils.Map {
+	lines := []utils.Map{}
+	for _, line := range d.lines {
+		lines = append(lines, utils.Map{
+			sales_common.FLD_PRODUCT_ID: line.productId,
+			FLD_DISCOUNT_QUANTITY:       line.quantity,
+			FLD_DISCOUNT_AMOUNT:         roundAmount(line.amount),
+			FLD_DISCOUNT_EXPLAIN:        line.explanation,
+		})
+	}
+
+	data := utils.Map{
+		FLD_DISCOUNT_AMOUNT:  roundAmount(d.amount),
+		FLD_DISCOUNT_EXPLAIN: d.explanation,
+		FLD_DISCOUNT_LINES:   lines,
+	}
+	if d.promotionType == PROMOTION_TYPE_COUPON {
+		data[FLD_PROMOTION_TYPE] = PROMOTION_TYPE_COUPON
+		data[sales_common.FLD_COUPON_ID] = d.offerId
+		data[FLD_COUPON_CODE] = d.couponCode
+	} else {
+		data[FLD_PROMOTION_TYPE] = PROMOTION_TYPE_OFFER
+		data[sales_common.FLD_OFFER_ID] = d.offerId
+		data[FLD_OFFER_TYPE] = d.offerType
+	}
+	return data
+}
+
+// parseCartLines - Read the product and the quantity of the cart items from the cart data
+func parseCartLines(cart utils.Map) []cartLine {
+	lines := []cartLine{}
+	for _, item := range toMapSlice(cart[FLD_CART_ITEMS]) {
+		line := cartLine{quantity: toInt(item[FLD_CART_ITEM_QUANTITY])}
+		line.productId, _ = item[sales_common.FLD_PRODUCT_ID].(string)
+		if line.quantity > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// cartSubtotal - Total amount of the cart lines before discounts
+func cartSubtotal(lines []cartLine) float64 {
+	subtotal := 0.0
+	for _, line := range lines {
+		subtotal += line.amount()
+	}
+	return subtotal
+}
+
+// isOfferActive - Verify the offer is active on the given time
+func isOfferActive(offer utils.Map, now time.Time) bool {
+	if deleted, _ := offer[db_common.FLD_IS_DELETED].(bool); deleted {
+		return false
+	}
+	if active, ok := offer[db_common.FLD_IS_ACTIVE].(bool); ok && !active {
+		return false
+	}
+	if from, ok := toTime(offer[FLD_OFFER_VALID_FROM]); ok && now.Before(from) {
+		return false
+	}
+	if till, ok := toTime(offer[FLD_OFFER_VALID_TILL]); ok && now.After(till) {
+		return false
+	}
+	return true
+}
+
+// inOfferScope - Verify the cart line is in scope of the offer
+// Each given scope (products, categories, brands) must match when they are set
+func inOfferScope(offer utils.Map, line cartLine) bool {
+	matches := func(fld string, value string) bool {
+		ids := toStringSlice(offer[fld])
+		if len(ids) == 0 {
+			return true
+		}
+		for _, id := range ids {
+			if id == value {
+				return true
+			}
+		}
+		return false
+	}
+
+	return matches(FLD_OFFER_PRODUCT_IDS, line.productId) &&
+		matches(FLD_OFFER_CATEGORY_IDS, line.categoryId) &&
+		matches(FLD_OFFER_BRAND_IDS, line.brandId)
+}
+
+// evaluateOffer - Compute the discount of the offer on the cart lines
+// Returns false when the offer is not applicable to the cart
+func evaluateOffer(offer utils.Map, lines []cartLine) (offerDiscount, bool) {
+	discount := offerDiscount{
+		promotionType: PROMOTION_TYPE_OFFER,
+		priority:      toInt(offer[FLD_OFFER_PRIORITY]),
+		stackable:     isStackable(offer),
+	}
+	discount.offerId, _ = offer[sales_common.FLD_OFFER_ID].(string)
+	discount.offerType, _ = offer[FLD_OFFER_TYPE].(string)
+
+	scoped := []cartLine{}
+	for _, line := range lines {
+		if inOfferScope(offer, line) {
+			scoped = append(scoped, line)
+		}
+	}
+	if len(scoped) == 0 {
+		return discount, false
+	}
+
+	switch discount.offerType {
+	case OFFER_TYPE_BUY_X_GET_Y:
+		discount.lines, discount.explanation = evaluateBuyXGetY(offer, scoped)
+	case OFFER_TYPE_QUANTITY_TIER:
+		discount.lines, discount.explanation = evaluateQuantityTier(offer, scoped)
+	case OFFER_TYPE_SPEND_THRESHOLD:
+		discount.lines, discount.explanation = evaluateSpendThreshold(offer, scoped)
+	case OFFER_TYPE_BUNDLE_PRICE:
+		discount.lines, discount.explanation = evaluateBundlePrice(offer, scoped)
+	default:
+		return discount, false
+	}
+
+	for _, line := range discount.lines {
+		discount.amount += line.amount
+	}
+	discount.amount = roundAmount(discount.amount)
+
+	return discount, discount.amount > 0
+}
+
+// isStackable - Verify the promotion can be combined with other promotions, true by default
+func isStackable(promotion utils.Map) bool {
+	stackable, ok := promotion[FLD_PROMOTION_IS_STACKABLE].(bool)
+	return !ok || stackable
+}
+
+// linesByPrice - Copy of the cart lines sorted by the unit price, highest first
+func linesByPrice(lines []cartLine) []cartLine {
+	sorted := make([]cartLine, 0, len(lines))
+	for _, line := range lines {
+		if line.quantity > 0 {
+			sorted = append(sorted, line)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].unitPrice > sorted[j].unitPrice
+	})
+	return sorted
+}
+
+// unitCount - Total quantity of the cart lines
+func unitCount(lines []cartLine) int {
+	count := 0
+	for _, line := range lines {
+		count += line.quantity
+	}
+	return count
+}
+
+// takeUnits - Lines holding the units from the position skip to skip+count of the sorted lines
+// The quantity of each returned line is the number of its units taken
+func takeUnits(sorted []cartLine, skip int, count int) []cartLine {
+	taken := []cartLine{}
+	for _, line := range sorted {
+		if count <= 0 {
+			break
+		}
+		if skip >= line.quantity {
+			skip -= line.quantity
+			continue
+		}
+		qty := line.quantity - skip
+		if qty > count {
+			qty = count
+		}
+		line.quantity = qty
+		taken = append(taken, line)
+		count -= qty
+		skip = 0
+	}
+	return taken
+}
+
+// groupLineDiscounts - Merge the discounts of the units into one per product
+func groupLineDiscounts(units []lineDiscount) []lineDiscount {
+	index := map[string]int{}
+	result := []lineDiscount{}
+	for _, unit := range units {
+		if idx, ok := index[unit.productId]; ok {
+			result[idx].quantity += unit.quantity
+			result[idx].amount += unit.amount
+			continue
+		}
+		index[unit.productId] = len(result)
+		result = append(result, unit)
+	}
+	return result
+}
+
+// evaluateBuyXGetY - Buy X units and get Y units at a discount (free by default)
+// The cheapest units in each group get the discount
+func evaluateBuyXGetY(offer utils.Map, lines []cartLine) ([]lineDiscount, string) {
+	buyQty := toInt(offer[FLD_OFFER_BUY_QUANTITY])
+	getQty := toInt(offer[FLD_OFFER_GET_QUANTITY])
+	percent := 100.0
+	if _, ok := offer[FLD_OFFER_GET_PERCENT]; ok {
+		percent = toFloat(offer[FLD_OFFER_GET_PERCENT])
+	}
+	if buyQty <= 0 || getQty <= 0 || percent <= 0 {
+		return nil, ""
+	}
+
+	sorted := linesByPrice(lines)
+	total := unitCount(sorted)
+	groups := total / (buyQty + getQty)
+	if groups == 0 {
+		return nil, ""
+	}
+
+	// Lines are sorted highest price first, so the discounted units are at the end
+	discounted := []lineDiscount{}
+	for _, line := range takeUnits(sorted, total-groups*getQty, groups*getQty) {
+		discounted = append(discounted, lineDiscount{
+			productId:   line.productId,
+			quantity:    line.quantity,
+			amount:      line.amount() * percent / 100,
+			explanation: fmt.Sprintf("Buy %d get %d at %g%% off", buyQty, getQty, percent),
+		})
+	}
+
+	explanation := fmt.Sprintf("Buy %d get %d at %g%% off, applied %d time(s)", buyQty, getQty, percent, groups)
+	return groupLineDiscounts(discounted), explanation
+}
+
+// evaluateQuantityTier - Percentage off based on the total quantity of the scoped items
+func evaluateQuantityTier(offer utils.Map, lines []cartLine) ([]lineDiscount, string) {
+	quantity := 0
+	for _, line := range lines {
+		quantity += line.quantity
+	}
+
+	// Pick the highest tier reached
+	minQty, percent := 0, 0.0
+	for _, tier := range toMapSlice(offer[FLD_OFFER_TIERS]) {
+		tierQty := toInt(tier[FLD_OFFER_TIER_MIN_QTY])
+		if quantity >= tierQty && tierQty >= minQty {
+			minQty = tierQty
+			percent = toFloat(tier[FLD_OFFER_DISCOUNT_PERCENT])
+		}
+	}
+	if percent <= 0 {
+		return nil, ""
+	}
+
+	explanation := fmt.Sprintf("%g%% off for buying %d or more (bought %d)", percent, minQty, quantity)
+	discounted := []lineDiscount{}
+	for _, line := range lines {
+		discounted = append(discounted, lineDiscount{
+			productId:   line.productId,
+			quantity:    line.quantity,
+			amount:      line.amount() * percent / 100,
+			explanation: explanation,
+		})
+	}
+	return discounted, explanation
+}
+
+// evaluateSpendThreshold - Percentage or fixed discount when the scoped items reach the spend
+func evaluateSpendThreshold(offer utils.Map, lines []cartLine) ([]lineDiscount, string) {
+	return spendDiscount(offer, lines, toFloat(offer[FLD_OFFER_MIN_SPEND]))
+}
+
+// spendDiscount - Percentage or fixed discount on the lines when they reach the minimum spend
+// Fixed discounts are spread over the lines in proportion to their amount
+func spendDiscount(offer utils.Map, lines []cartLine, minSpend float64) ([]lineDiscount, string) {
+	spend := cartSubtotal(lines)
+	if spend <= 0 || spend < minSpend {
+		return nil, ""
+	}
+
+	discountType, _ := offer[FLD_OFFER_DISCOUNT_TYPE].(string)
+	value := toFloat(offer[FLD_OFFER_DISCOUNT_VALUE])
+
+	total := 0.0
+	explanation := ""
+	switch discountType {
+	case DISCOUNT_TYPE_FIXED:
+		total = value
+		explanation = fmt.Sprintf("%g off", value)
+	default:
+		total = spend * value / 100
+		explanation = fmt.Sprintf("%g%% off", value)
+	}
+	if minSpend > 0 {
+		explanation += fmt.Sprintf(" on spending %g or more", minSpend)
+	}
+
+	maxDiscount := toFloat(offer[FLD_OFFER_MAX_DISCOUNT])
+	if maxDiscount > 0 && total > maxDiscount {
+		total = maxDiscount
+		explanation += fmt.Sprintf(", limited to %g", maxDiscount)
+	}
+	if total > spend {
+		total = spend
+	}
+	if total <= 0 {
+		return nil, ""
+	}
+
+	discounted := []lineDiscount{}
+	for _, line := range lines {
+		discounted = append(discounted, lineDiscount{
+			productId:   line.productId,
+			quantity:    line.quantity,
+			amount:      total * line.amount() / spend,
+			explanation: explanation,
+		})
+	}
+	return discounted, explanation
+}
+
+// evaluateBundlePrice - Any N of the scoped units for the fixed bundle price
+// The highest priced units are bundled first to give the best price to the customer
+func evaluateBundlePrice(offer utils.Map, lines []cartLine) ([]lineDiscount, string) {
+	bundleQty := toInt(offer[FLD_OFFER_BUNDLE_QUANTITY])
+	bundlePrice := toFloat(offer[FLD_OFFER_BUNDLE_PRICE])
+	if bundleQty <= 0 || bundlePrice < 0 {
+		return nil, ""
+	}
+
+	sorted := linesByPrice(lines)
+	groups := unitCount(sorted) / bundleQty
+	explanation := fmt.Sprintf("Any %d for %g", bundleQty, bundlePrice)
+
+	discounted := []lineDiscount{}
+	applied := 0
+	position := 0
+	for applied < groups {
+		bundle := takeUnits(sorted, position, bundleQty)
+		regular := cartSubtotal(bundle)
+		if regular <= bundlePrice {
+			break
+		}
+
+		// Bundles taken from a single line are the same, they are applied together
+		repeat := 1
+		if len(bundle) == 1 {
+			remaining := takeUnits(sorted, position, (groups-applied)*bundleQty)[0]
+			repeat = remaining.quantity / bundleQty
+		}
+
+		// Spread the saving over the units in proportion to their price
+		saving := regular - bundlePrice
+		for _, line := range bundle {
+			discounted = append(discounted, lineDiscount{
+				productId:   line.productId,
+				quantity:    line.quantity * repeat,
+				amount:      saving * line.amount() / regular * float64(repeat),
+				explanation: explanation,
+			})
+		}
+		applied += repeat
+		position += bundleQty * repeat
+	}
+	if applied == 0 {
+		return nil, ""
+	}
+
+	return groupLineDiscounts(discounted), fmt.Sprintf("%s, applied %d time(s)", explanation, applied)
+}
@@ -0,0 +1,54 @@
+package sales_service
+
+import (
+	"math"
+	"testing"
+
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+func totalLineDiscount(lines []lineDiscount) (int, float64) {
+	quantity, amount := 0, 0.0
+	for _, line := range lines {
+		quantity += line.quantity
+		amount += line.amount
+	}
+	return quantity, amount
+}
+
+func TestBuyXGetYLargeQuantity(t *testing.T) {
+	offer := utils.Map{FLD_OFFER_BUY_QUANTITY: 2, FLD_OFFER_GET_QUANTITY: 1}
+	lines := []cartLine{
+		{productId: "cheap", quantity: 1000000000, unitPrice: 1},
+		{productId: "dear", quantity: 3, unitPrice: 10},
+	}
+
+	discounts, _ := evaluateBuyXGetY(offer, lines)
+	quantity, amount := totalLineDiscount(discounts)
+	if quantity != 333333334 || amount != 333333334 {
+		t.Errorf("discount = %d units, %g", quantity, amount)
+	}
+}
+
+func TestBundlePriceGroups(t *testing.T) {
+	offer := utils.Map{FLD_OFFER_BUNDLE_QUANTITY: 3, FLD_OFFER_BUNDLE_PRICE: 20}
+	lines := []cartLine{
+		{productId: "a", quantity: 4, unitPrice: 10},
+		{productId: "b", quantity: 1000000, unitPrice: 5},
+	}
+
+	// Bundles: (10,10,10) saves 10, (10,5,5) saves 0 and stops the bundling
+	discounts, _ := evaluateBundlePrice(offer, lines)
+	quantity, amount := totalLineDiscount(discounts)
+	if quantity != 3 || math.Abs(amount-10) > 1e-9 {
+		t.Errorf("discount = %d units, %g", quantity, amount)
+	}
+
+	lines[1].unitPrice = 9
+	discounts, _ = evaluateBundlePrice(offer, lines)
+	quantity, amount = totalLineDiscount(discounts)
+	// 1 bundle of a saves 10, 1 mixed bundle (10,9,9) saves 8, 333332 bundles of b save 7 each
+	if quantity != 1000002 || math.Abs(amount-(10+8+333332*7)) > 1e-6 {
+		t.Errorf("discount = %d units, %g", quantity, amount)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/zapscloud/golib-dbutils/db_common"
 	"github.com/zapscloud/golib-dbutils/db_utils"
@@ -28,16 +29,20 @@ type OfferService interface {
 	// Delete - Delete Service
 	Delete(offerId string, delete_permanent bool) error
 
+	// Evaluate - Compute the discounts of the active offers applicable to the cart, priced for the customer_id of the cart
+	Evaluate(cart utils.Map) (utils.Map, error)
+
 	EndService()
 }
 
 type offerBaseService struct {
 	db_utils.DatabaseService
-	dbRegion    db_utils.DatabaseService
-	daoOffer    sales_repository.OfferDao
-	daoBusiness platform_repository.BusinessDao
-	child       OfferService
-	businessId  string
+	dbRegion      db_utils.DatabaseService
+	daoOffer      sales_repository.OfferDao
+	priceResolver *PriceResolver
+	daoBusiness   platform_repository.BusinessDao
+	child         OfferService
+	businessId    string
 }
 
 // NewOfferService - Construct Offer
@@ -94,6 +99,7 @@ func (p *offerBaseService) initializeService() {
 	log.Printf("OfferService:: GetBusinessDao ")
 	p.daoBusiness = platform_repository.NewBusinessDao(p.GetClient())
 	p.daoOffer = sales_repository.NewOfferDao(p.dbRegion.GetClient(), p.businessId)
+	p.priceResolver = NewPriceResolver(p.dbRegion.GetClient(), p.businessId)
 }
 
 // List - List All records
@@ -190,6 +196,91 @@ func (p *offerBaseService) Delete(offerId string, delete_permanent bool) error {
 	return nil
 }
 
+// Evaluate - Compute the discounts of the active offers applicable to the cart, priced for the customer_id of the cart
+func (p *offerBaseService) Evaluate(cart utils.Map) (utils.Map, error) {
+
+	log.Println("OfferService::Evaluate - Begin")
+
+	lines, err := loadCartLines(p.priceResolver, cart)
+	if err != nil {
+		return nil, err
+	}
+
+	discounts, err := evaluateActiveOffers(p.daoOffer, lines, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	subtotal := cartSubtotal(lines)
+	totalDiscount := 0.0
+	listDiscounts := []utils.Map{}
+	for _, discount := range discounts {
+		totalDiscount += discount.amount
+		listDiscounts = append(listDiscounts, discount.toMap())
+	}
+	// Each offer is evaluated on its own, so the sum may go beyond the cart value
+	if totalDiscount > subtotal {
+		totalDiscount = subtotal
+	}
+
+	response := utils.Map{
+		FLD_CART_SUBTOTAL:  roundAmount(subtotal),
+		FLD_TOTAL_DISCOUNT: roundAmount(totalDiscount),
+		FLD_DISCOUNTS:      listDiscounts,
+	}
+
+	log.Println("OfferService::Evaluate - End ", totalDiscount)
+	return response, nil
+}
+
+// evaluateActiveOffers - Evaluate all the active offers on the cart lines
+func evaluateActiveOffers(daoOffer sales_repository.OfferDao, lines []cartLine, now time.Time) ([]offerDiscount, error) {
+
+	listdata, err := daoOffer.List("", "", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	discounts := []offerDiscount{}
+	for _, offer := range listResult(listdata) {
+		if !isOfferActive(offer, now) {
+			continue
+		}
+		discount, ok := evaluateOffer(offer, lines)
+		if ok {
+			discounts = append(discounts, discount)
+		}
+	}
+	return discounts, nil
+}
+
+// loadCartLines - Read the cart items, the category and brand are taken from the product and
+// the price is resolved for the customer and location of the cart, the values sent with the items are ignored
+func loadCartLines(resolver *PriceResolver, cart utils.Map) ([]cartLine, error) {
+
+	customerId, _ := cart[sales_common.FLD_CUSTOMER_ID].(string)
+	ctx, err := resolver.loadContext(customerId)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := resolver.locate(cart[FLD_SHOPPER_LOCATION])
+	if err != nil {
+		return nil, err
+	}
+
+	lines := parseCartLines(cart)
+	for idx, line := range lines {
+		product, err := resolver.regionalProduct(line.productId)
+		if err != nil {
+			return nil, err
+		}
+		lines[idx].categoryId, _ = product[sales_common.FLD_CATEGORY_ID].(string)
+		lines[idx].brandId, _ = product[sales_common.FLD_BRAND_ID].(string)
+		lines[idx].unitPrice = toFloat(resolver.resolve(withRegionalPrice(product, loc), ctx, line.quantity)[FLD_UNIT_PRICE])
+	}
+	return lines, nil
+}
+
 func (p *offerBaseService) errorReturn(err error) (OfferService, error) {
 	// Close the Database Connection
 	p.EndService()
@@ -36,6 +36,7 @@ type pageBaseService struct {
 	db_utils.DatabaseService
 	dbRegion    db_utils.DatabaseService
 	daoPage     sales_repository.PageDao
+	seoSlugs    *seoSlugs
 	daoBusiness platform_repository.BusinessDao
 	child       PageService
 	businessId  string
@@ -95,6 +96,7 @@ func (p *pageBaseService) initializeService() {
 	log.Printf("PageService:: GetBusinessDao ")
 	p.daoBusiness = platform_repository.NewBusinessDao(p.GetClient())
 	p.daoPage = sales_repository.NewPageDao(p.dbRegion.GetClient(), p.businessId)
+	p.seoSlugs = newSeoSlugs(p.dbRegion, p.businessId)
 }
 
 // List - List All records
@@ -147,6 +149,12 @@ func (p *pageBaseService) Create(indata utils.Map) (utils.Map, error) {
 	indata[sales_common.FLD_BUSINESS_ID] = p.businessId
 	indata[sales_common.FLD_PAGE_ID] = pageId
 
+	// Slug is generated from the name when not given
+	err := p.seoSlugs.assignOnCreate(SEO_ENTITY_PAGE, pageId, indata)
+	if err != nil {
+		return utils.Map{}, err
+	}
+
 	data, err := p.daoPage.Create(indata)
 	if err != nil {
 		return utils.Map{}, err
@@ -161,6 +169,18 @@ func (p *pageBaseService) Update(pageId string, indata utils.Map) (utils.Map, er
 
 	log.Println("PageService::Update - Begin")
 
+	// Changed slug keeps the old one for the redirect
+	if _, ok := indata[FLD_SEO_SLUG]; ok {
+		current, err := p.daoPage.Get(pageId)
+		if err != nil {
+			return nil, err
+		}
+		err = p.seoSlugs.assignOnUpdate(SEO_ENTITY_PAGE, current, indata)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	data, err := p.daoPage.Update(pageId, indata)
 
 	log.Println("PageService::Update - End ")
@@ -0,0 +1,659 @@
+package sales_service
+
+import (
+	"fmt"
+	"log"
+	"math"
+
+	"github.com/zapscloud/golib-dbutils/db_utils"
+	"github.com/zapscloud/golib-platform-repository/platform_repository"
+	"github.com/zapscloud/golib-platform-service/platform_service"
+	"github.com/zapscloud/golib-sales-repository/sales_common"
+	"github.com/zapscloud/golib-sales-repository/sales_repository"
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+const (
+	// Price list fields in the customer type
+	FLD_PRICE_LIST             = "price_list"
+	FLD_PRICE_LIST_PRODUCTS    = "product_overrides"
+	FLD_PRICE_LIST_CATEGORIES  = "category_overrides"
+	FLD_PRICE_ADJUSTMENT_TYPE  = "adjustment_type"
+	FLD_PRICE_ADJUSTMENT_VALUE = "adjustment_value"
+
+	// Adjustment types, the value may be negative for a discount
+	// For the price type the value is the price itself
+	PRICE_ADJUSTMENT_PERCENT = "percent"
+	PRICE_ADJUSTMENT_FIXED   = "fixed"
+	PRICE_ADJUSTMENT_PRICE   = "price"
+
+	// Resolved price response fields
+	FLD_BASE_PRICE        = "base_price"
+	FLD_UNIT_PRICE        = "unit_price"
+	FLD_TOTAL_PRICE       = "total_price"
+	FLD_PRICE_QUANTITY    = "quantity"
+	FLD_PRICE_ADJUSTMENTS = "adjustments"
+	FLD_PRICE_SOURCE      = "source"
+	FLD_PRICES            = "prices"
+)
+
+// PricingService - Resolve the price of the product for the customer
+// Used by cart, checkout and product listing so that all of them show the same price
+type PricingService interface {
+	// ResolvePrice - Resolve the unit and total price of the product for the customer and quantity
+	ResolvePrice(productId string, customerId string, qty int) (utils.Map, error)
+	// ResolvePrices - Resolve the unit price of the products for the customer, used for listing
+	ResolvePrices(productIds []string, customerId string) (utils.Map, error)
+	// ResolveUnitPrice - Resolve the price of the quantity in the unit, priced per base unit of the product
+	ResolveUnitPrice(productId string, customerId string, quantity float64, unitId string) (utils.Map, error)
+	// ResolveRegionalPrice - Resolve the price for the customer and quantity at the location {region_id, state_id, postal_code}
+	ResolveRegionalPrice(productId string, customerId string, qty int, location utils.Map) (utils.Map, error)
+	// ValidateCart - Check the availability and price of the cart items {"items": [{"product_id": "", "quantity": 1}]} at the location
+	ValidateCart(cart utils.Map, customerId string, location utils.Map) (utils.Map, error)
+
+	EndService()
+}
+
+type pricingBaseService struct {
+	db_utils.DatabaseService
+	dbRegion      db_utils.DatabaseService
+	daoProduct    sales_repository.ProductDao
+	daoBusiness   platform_repository.BusinessDao
+	priceResolver *PriceResolver
+	unitConverter *unitConverter
+	regionLocator *regionLocator
+	child         PricingService
+	businessId    string
+}
+
+// PriceResolver - Resolve the price of the products for the customer
+// Shared by the pricing, cart, checkout, product listing, offers and loyalty so all of them use the same price
+type PriceResolver struct {
+	daoProduct      sales_repository.ProductDao
+	daoCustomer     sales_repository.CustomerDao
+	daoCustomerType sales_repository.CustomerTypeDao
+	daoDealer       sales_repository.DealerDao
+	regionLocator   *regionLocator
+}
+
+// priceContext - Customer details used to resolve the price
+type priceContext struct {
+	customerId     string
+	customerTypeId string
+	priceList      utils.Map
+	dealerId       string
+	dealer         utils.Map
+}
+
+// NewPricingService - Construct Pricing
+func NewPricingService(props utils.Map) (PricingService, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "01"
+
+	log.Printf("PricingService::Start ")
+	// Verify whether the business id data passed
+	businessId, err := utils.GetMemberDataStr(props, sales_common.FLD_BUSINESS_ID)
+	if err != nil {
+		return nil, err
+	}
+
+	p := pricingBaseService{}
+	// Open Database Service
+	err = p.OpenDatabaseService(props)
+	if err != nil {
+		return nil, err
+	}
+
+	// Open RegionDB Service
+	p.dbRegion, err = platform_service.OpenRegionDatabaseService(props)
+	if err != nil {
+		p.CloseDatabaseService()
+		return nil, err
+	}
+
+	// Assign the BusinessId
+	p.businessId = businessId
+	p.initializeService()
+
+	_, err = p.daoBusiness.Get(businessId)
+	if err != nil {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "01",
+			ErrorMsg:    "Invalid BusinessId",
+			ErrorDetail: "Given BusinessId is not exist"}
+		return p.errorReturn(err)
+	}
+
+	p.child = &p
+
+	return &p, err
+}
+
+// EndService - Close all the services
+func (p *pricingBaseService) EndService() {
+	log.Printf("EndService ")
+	p.CloseDatabaseService()
+	p.dbRegion.CloseDatabaseService()
+}
+
+func (p *pricingBaseService) initializeService() {
+	log.Printf("PricingService:: GetBusinessDao ")
+	p.daoBusiness = platform_repository.NewBusinessDao(p.GetClient())
+	p.daoProduct = sales_repository.NewProductDao(p.dbRegion.GetClient(), p.businessId)
+	p.priceResolver = NewPriceResolver(p.dbRegion.GetClient(), p.businessId)
+	p.unitConverter = &unitConverter{daoProductUnit: sales_repository.NewProduct_unitDao(p.dbRegion.GetClient(), p.businessId)}
+	p.regionLocator = &regionLocator{
+		daoRegion: sales_repository.NewRegionDao(p.dbRegion.GetClient(), p.businessId),
+		daoStates: sales_repository.NewStatesDao(p.dbRegion.GetClient(), p.businessId),
+	}
+}
+
+// ResolvePrice - Resolve the unit and total price of the product for the customer and quantity
+func (p *pricingBaseService) ResolvePrice(productId string, customerId string, qty int) (utils.Map, error) {
+
+	log.Println("PricingService::ResolvePrice - Begin", productId, customerId, qty)
+
+	data, err := p.priceResolver.ResolvePrice(productId, customerId, qty)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("PricingService::ResolvePrice - End ", data[FLD_UNIT_PRICE])
+	return data, nil
+}
+
+// ResolveUnitPrice - Resolve the price of the quantity in the unit, priced per base unit of the product
+// Quantity breaks of the dealer contract use the whole base units
+func (p *pricingBaseService) ResolveUnitPrice(productId string, customerId string, quantity float64, unitId string) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "06"
+
+	log.Println("PricingService::ResolveUnitPrice - Begin", productId, customerId, quantity, unitId)
+
+	if quantity <= 0 {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "03",
+			ErrorMsg:    "Invalid quantity",
+			ErrorDetail: fmt.Sprintf("Quantity %g should be greater than 0", quantity)}
+		return nil, err
+	}
+
+	ctx, err := p.priceResolver.loadContext(customerId)
+	if err != nil {
+		return nil, err
+	}
+
+	product, err := getPricedProduct(p.daoProduct, productId)
+	if err != nil {
+		return nil, err
+	}
+
+	baseQuantity, baseUnitId, err := p.unitConverter.toBaseQuantity(product, quantity, unitId)
+	if err != nil {
+		return nil, err
+	}
+
+	breakQty := int(math.Ceil(baseQuantity - unitEpsilon))
+	if breakQty <= 0 {
+		breakQty = 1
+	}
+	data := p.priceResolver.resolve(product, ctx, breakQty)
+	data[sales_common.FLD_PRODUCT_UNIT_ID] = unitId
+	data[FLD_PRICE_QUANTITY] = quantity
+	data[FLD_PRODUCT_BASE_UNIT] = baseUnitId
+	data[FLD_UNIT_BASE_QUANTITY] = baseQuantity
+	data[FLD_TOTAL_PRICE] = roundAmount(toFloat(data[FLD_UNIT_PRICE]) * baseQuantity)
+
+	log.Println("PricingService::ResolveUnitPrice - End ", data[FLD_TOTAL_PRICE])
+	return data, nil
+}
+
+// ResolvePrices - Resolve the unit price of the products for the customer, used for listing
+func (p *pricingBaseService) ResolvePrices(productIds []string, customerId string) (utils.Map, error) {
+
+	log.Println("PricingService::ResolvePrices - Begin", len(productIds), customerId)
+
+	ctx, err := p.priceResolver.loadContext(customerId)
+	if err != nil {
+		return nil, err
+	}
+
+	prices := utils.Map{}
+	for _, productId := range productIds {
+		product, err := getPricedProduct(p.daoProduct, productId)
+		if err != nil {
+			return nil, err
+		}
+		prices[productId] = p.priceResolver.resolve(product, ctx, 1)
+	}
+
+	log.Println("PricingService::ResolvePrices - End ")
+	return utils.Map{FLD_PRICES: prices}, nil
+}
+
+// ResolveRegionalPrice - Resolve the price for the customer and quantity at the location
+// Regional price of the product is the base price for the customer adjustments
+func (p *pricingBaseService) ResolveRegionalPrice(productId string, customerId string, qty int, location utils.Map) (utils.Map, error) {
+
+	log.Println("PricingService::ResolveRegionalPrice - Begin", productId, customerId, qty, location)
+
+	err := validateQuantity(productId, qty)
+	if err != nil {
+		return nil, err
+	}
+
+	loc, err := p.regionLocator.resolve(location)
+	if err != nil {
+		return nil, err
+	}
+	ctx, err := p.priceResolver.loadContext(customerId)
+	if err != nil {
+		return nil, err
+	}
+
+	product, err := p.priceResolver.regionalProduct(productId)
+	if err != nil {
+		return nil, err
+	}
+	data := p.priceResolver.resolve(withRegionalPrice(product, loc), ctx, qty)
+	data[FLD_REGIONAL_IS_AVAILABLE], data[FLD_REGIONAL_UNAVAILABLE_REASON] = productAvailability(product, loc)
+	data[FLD_SHOPPER_LOCATION] = loc.toMap()
+
+	log.Println("PricingService::ResolveRegionalPrice - End ", data[FLD_UNIT_PRICE])
+	return data, nil
+}
+
+// ValidateCart - Check the availability and price of the cart items at the location
+// The cart with any unavailable item is returned along with the error
+func (p *pricingBaseService) ValidateCart(cart utils.Map, customerId string, location utils.Map) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "06"
+
+	log.Println("PricingService::ValidateCart - Begin", customerId, location)
+
+	loc, err := p.regionLocator.resolve(location)
+	if err != nil {
+		return nil, err
+	}
+	ctx, err := p.priceResolver.loadContext(customerId)
+	if err != nil {
+		return nil, err
+	}
+
+	items := []utils.Map{}
+	unavailable := []utils.Map{}
+	total := 0.0
+	for _, item := range toMapSlice(cart[FLD_CART_ITEMS]) {
+		productId, _ := item[sales_common.FLD_PRODUCT_ID].(string)
+		qty := toInt(item[FLD_CART_ITEM_QUANTITY])
+		err := validateQuantity(productId, qty)
+		if err != nil {
+			return nil, err
+		}
+
+		product, err := p.priceResolver.regionalProduct(productId)
+		if err != nil {
+			line := utils.Map{
+				sales_common.FLD_PRODUCT_ID:     productId,
+				FLD_PRICE_QUANTITY:              qty,
+				FLD_REGIONAL_IS_AVAILABLE:       false,
+				FLD_REGIONAL_UNAVAILABLE_REASON: "product is not exist",
+			}
+			items = append(items, line)
+			unavailable = append(unavailable, line)
+			continue
+		}
+
+		line := p.priceResolver.resolve(withRegionalPrice(product, loc), ctx, qty)
+		available, reason := lineAvailability(product, loc)
+		line[FLD_REGIONAL_IS_AVAILABLE] = available
+		line[FLD_REGIONAL_UNAVAILABLE_REASON] = reason
+		items = append(items, line)
+		if !available {
+			unavailable = append(unavailable, line)
+			continue
+		}
+		total += toFloat(line[FLD_TOTAL_PRICE])
+	}
+
+	response := utils.Map{
+		FLD_CART_IS_VALID:    len(unavailable) == 0,
+		FLD_CART_ITEMS:       items,
+		FLD_CART_UNAVAILABLE: unavailable,
+		FLD_CART_TOTAL:       roundAmount(total),
+		FLD_SHOPPER_LOCATION: loc.toMap(),
+	}
+	if len(unavailable) > 0 {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "02",
+			ErrorMsg:    "Cart items not available",
+			ErrorDetail: fmt.Sprintf("%d of %d items cannot be delivered to the location", len(unavailable), len(items))}
+		return response, err
+	}
+
+	log.Println("PricingService::ValidateCart - End ", response[FLD_CART_TOTAL])
+	return response, nil
+}
+
+// lineAvailability - Check whether the product of the cart or order line can be sold at the location
+func lineAvailability(product utils.Map, loc shopperLocation) (bool, string) {
+	if !isProductPublished(product) {
+		return false, "product is not published"
+	}
+	return productAvailability(product, loc)
+}
+
+// CheckItemsAvailable - Verify all the items priced by PriceItems can be delivered to the location
+func CheckItemsAvailable(items []utils.Map) error {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "06"
+
+	for _, item := range items {
+		if available, _ := item[FLD_REGIONAL_IS_AVAILABLE].(bool); !available {
+			err := &utils.AppError{
+				ErrorCode:   funcode + "02",
+				ErrorMsg:    "Items not available",
+				ErrorDetail: fmt.Sprintf("Product %v cannot be delivered to the location, %v", item[sales_common.FLD_PRODUCT_ID], item[FLD_REGIONAL_UNAVAILABLE_REASON])}
+			return err
+		}
+	}
+	return nil
+}
+
+// NewPriceResolver - Construct the price resolver on the region database client of the business
+func NewPriceResolver(client utils.Map, businessId string) *PriceResolver {
+	return &PriceResolver{
+		daoProduct:      sales_repository.NewProductDao(client, businessId),
+		daoCustomer:     sales_repository.NewCustomerDao(client, businessId),
+		daoCustomerType: sales_repository.NewCustomerTypeDao(client, businessId),
+		daoDealer:       sales_repository.NewDealerDao(client, businessId),
+		regionLocator: &regionLocator{
+			daoRegion: sales_repository.NewRegionDao(client, businessId),
+			daoStates: sales_repository.NewStatesDao(client, businessId),
+		},
+	}
+}
+
+// ResolvePrice - Resolve the unit and total price of the product for the customer and quantity
+func (r *PriceResolver) ResolvePrice(productId string, customerId string, qty int) (utils.Map, error) {
+	err := validateQuantity(productId, qty)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, err := r.loadContext(customerId)
+	if err != nil {
+		return nil, err
+	}
+
+	product, err := getPricedProduct(r.daoProduct, productId)
+	if err != nil {
+		return nil, err
+	}
+	return r.resolve(product, ctx, qty), nil
+}
+
+// PriceItems - Set the resolved price on the items [{product_id, quantity}] of the cart or order, returns the total
+// The items are as read from the request or database, the prices sent with the items are replaced.
+// The regional price of the shopper location {region_id, state_id, postal_code} is used and each item is marked
+// with its availability at the location, the total is of the available items.
+func (r *PriceResolver) PriceItems(items any, customerId string, location any) ([]utils.Map, float64, error) {
+	ctx, err := r.loadContext(customerId)
+	if err != nil {
+		return nil, 0, err
+	}
+	loc, err := r.locate(location)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	priced := []utils.Map{}
+	total := 0.0
+	for _, item := range toMapSlice(items) {
+		productId, _ := item[sales_common.FLD_PRODUCT_ID].(string)
+		qty := toInt(item[FLD_CART_ITEM_QUANTITY])
+		err := validateQuantity(productId, qty)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		product, err := r.regionalProduct(productId)
+		if err != nil {
+			return nil, 0, err
+		}
+		price := r.resolve(withRegionalPrice(product, loc), ctx, qty)
+		available, reason := lineAvailability(product, loc)
+
+		line := utils.CopyMap(item)
+		line[FLD_CART_ITEM_QUANTITY] = qty
+		line[FLD_CART_ITEM_PRICE] = price[FLD_UNIT_PRICE]
+		line[FLD_BASE_PRICE] = price[FLD_BASE_PRICE]
+		line[FLD_TOTAL_PRICE] = price[FLD_TOTAL_PRICE]
+		line[FLD_PRICE_ADJUSTMENTS] = price[FLD_PRICE_ADJUSTMENTS]
+		line[FLD_REGIONAL_IS_AVAILABLE] = available
+		line[FLD_REGIONAL_UNAVAILABLE_REASON] = reason
+		priced = append(priced, line)
+		if available {
+			total += toFloat(price[FLD_TOTAL_PRICE])
+		}
+	}
+	return priced, roundAmount(total), nil
+}
+
+// regionalProduct - Priced product with the regional rules, the variant without its own rules follows the parent
+func (r *PriceResolver) regionalProduct(productId string) (utils.Map, error) {
+	product, err := getPricedProduct(r.daoProduct, productId)
+	if err != nil {
+		return nil, err
+	}
+	source, err := regionalSource(r.daoProduct, product)
+	if err != nil {
+		return nil, err
+	}
+	return withRegionalRules(product, source), nil
+}
+
+// locate - Resolve the shopper location given with the cart or order
+func (r *PriceResolver) locate(location any) (shopperLocation, error) {
+	data, _ := toMap(location)
+	return r.regionLocator.resolve(data)
+}
+
+// PriceProducts - Set the resolved unit price of the customer on the listed products
+// The list price stays in the price field, the price for the customer is in the unit_price field
+func (r *PriceResolver) PriceProducts(products []utils.Map, customerId string) error {
+	ctx, err := r.loadContext(customerId)
+	if err != nil {
+		return err
+	}
+
+	for _, product := range products {
+		priced, err := pricedProduct(r.daoProduct, product)
+		if err != nil {
+			return err
+		}
+		setResolvedPrice(product, r.resolve(priced, ctx, 1))
+	}
+	return nil
+}
+
+// validateQuantity - Verify the quantity of the product is greater than zero
+func validateQuantity(productId string, qty int) error {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "06"
+
+	if qty <= 0 {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "03",
+			ErrorMsg:    "Invalid quantity",
+			ErrorDetail: fmt.Sprintf("Quantity %d of product %s should be greater than 0", qty, productId)}
+		return err
+	}
+	return nil
+}
+
+// setResolvedPrice - Set the unit price resolved for the customer on the listed product
+func setResolvedPrice(product utils.Map, price utils.Map) {
+	product[FLD_UNIT_PRICE] = price[FLD_UNIT_PRICE]
+	product[FLD_PRICE_ADJUSTMENTS] = price[FLD_PRICE_ADJUSTMENTS]
+}
+
+// loadContext - Load the customer type with its price list and the dealer, empty for guest customers
+func (r *PriceResolver) loadContext(customerId string) (priceContext, error) {
+	ctx := priceContext{customerId: customerId}
+	if len(customerId) == 0 {
+		return ctx, nil
+	}
+
+	customer, err := r.daoCustomer.Get(customerId)
+	if err != nil {
+		return ctx, err
+	}
+
+	ctx.customerTypeId, _ = customer[sales_common.FLD_CUSTOMER_TYPE_ID].(string)
+	if len(ctx.customerTypeId) > 0 {
+		customerType, err := r.daoCustomerType.Get(ctx.customerTypeId)
+		if err != nil {
+			return ctx, err
+		}
+		ctx.priceList, _ = toMap(customerType[FLD_PRICE_LIST])
+	}
+
+	ctx.dealerId, _ = customer[sales_common.FLD_DEALER_ID].(string)
+	if len(ctx.dealerId) > 0 {
+		ctx.dealer, err = r.daoDealer.Get(ctx.dealerId)
+		if err != nil {
+			return ctx, err
+		}
+	}
+	return ctx, nil
+}
+
+// resolve - Apply the price adjustments on the base price of the product
+// Dealer contract price takes precedence over the customer type price list
+func (r *PriceResolver) resolve(product utils.Map, ctx priceContext, qty int) utils.Map {
+	productId, _ := product[sales_common.FLD_PRODUCT_ID].(string)
+	basePrice := toFloat(product[FLD_PRODUCT_PRICE])
+
+	unitPrice := basePrice
+	adjustments := []utils.Map{}
+
+	if contractPrice, breakQty, ok := dealerContractPrice(ctx.dealer, productId, qty); ok {
+		unitPrice = contractPrice
+		source := "dealer:" + ctx.dealerId + " contract price"
+		if breakQty > 0 {
+			source = fmt.Sprintf("%s for %d or more", source, breakQty)
+		}
+		adjustments = append(adjustments, utils.Map{
+			FLD_PRICE_SOURCE:          source,
+			FLD_PRICE_ADJUSTMENT_TYPE: PRICE_ADJUSTMENT_PRICE,
+			FLD_UNIT_PRICE:            roundAmount(unitPrice),
+		})
+	} else if adjustment, source, ok := priceListAdjustment(ctx.priceList, product); ok {
+		unitPrice = applyPriceAdjustment(unitPrice, adjustment)
+		adjustments = append(adjustments, priceAdjustmentInfo(adjustment, "customer_type:"+ctx.customerTypeId+" "+source, unitPrice))
+	}
+
+	if unitPrice < 0 {
+		unitPrice = 0
+	}
+	unitPrice = roundAmount(unitPrice)
+
+	return utils.Map{
+		sales_common.FLD_PRODUCT_ID:  productId,
+		sales_common.FLD_CUSTOMER_ID: ctx.customerId,
+		FLD_PRICE_QUANTITY:           qty,
+		FLD_BASE_PRICE:               roundAmount(basePrice),
+		FLD_UNIT_PRICE:               unitPrice,
+		FLD_TOTAL_PRICE:              roundAmount(unitPrice * float64(qty)),
+		FLD_PRICE_ADJUSTMENTS:        adjustments,
+	}
+}
+
+// priceListAdjustment - Adjustment of the price list for the product
+// Product override is used first, then the category override and then the price list default
+func priceListAdjustment(priceList utils.Map, product utils.Map) (utils.Map, string, bool) {
+	if priceList == nil {
+		return nil, "", false
+	}
+
+	productId, _ := product[sales_common.FLD_PRODUCT_ID].(string)
+	for _, override := range toMapSlice(priceList[FLD_PRICE_LIST_PRODUCTS]) {
+		if override[sales_common.FLD_PRODUCT_ID] == productId {
+			return override, "product override", true
+		}
+	}
+
+	categoryId, _ := product[sales_common.FLD_CATEGORY_ID].(string)
+	for _, override := range toMapSlice(priceList[FLD_PRICE_LIST_CATEGORIES]) {
+		if len(categoryId) > 0 && override[sales_common.FLD_CATEGORY_ID] == categoryId {
+			return override, "category override", true
+		}
+	}
+
+	if _, ok := priceList[FLD_PRICE_ADJUSTMENT_TYPE]; ok {
+		return priceList, "price list", true
+	}
+	return nil, "", false
+}
+
+// applyPriceAdjustment - Apply the percent, fixed or override price adjustment on the price
+func applyPriceAdjustment(price float64, adjustment utils.Map) float64 {
+	adjustmentType, _ := adjustment[FLD_PRICE_ADJUSTMENT_TYPE].(string)
+	value := toFloat(adjustment[FLD_PRICE_ADJUSTMENT_VALUE])
+
+	switch adjustmentType {
+	case PRICE_ADJUSTMENT_PERCENT:
+		return price * (1 + value/100)
+	case PRICE_ADJUSTMENT_FIXED:
+		return price + value
+	case PRICE_ADJUSTMENT_PRICE:
+		return value
+	}
+	return price
+}
+
+// priceAdjustmentInfo - Details of the adjustment applied, returned along with the price
+func priceAdjustmentInfo(adjustment utils.Map, source string, price float64) utils.Map {
+	return utils.Map{
+		FLD_PRICE_SOURCE:           source,
+		FLD_PRICE_ADJUSTMENT_TYPE:  adjustment[FLD_PRICE_ADJUSTMENT_TYPE],
+		FLD_PRICE_ADJUSTMENT_VALUE: adjustment[FLD_PRICE_ADJUSTMENT_VALUE],
+		FLD_UNIT_PRICE:             roundAmount(price),
+	}
+}
+
+// validatePriceList - Verify the adjustments in the price list
+func validatePriceList(priceList utils.Map) error {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "06"
+
+	adjustments := []utils.Map{}
+	if _, ok := priceList[FLD_PRICE_ADJUSTMENT_TYPE]; ok {
+		adjustments = append(adjustments, priceList)
+	}
+	adjustments = append(adjustments, toMapSlice(priceList[FLD_PRICE_LIST_PRODUCTS])...)
+	adjustments = append(adjustments, toMapSlice(priceList[FLD_PRICE_LIST_CATEGORIES])...)
+
+	for _, adjustment := range adjustments {
+		adjustmentType, _ := adjustment[FLD_PRICE_ADJUSTMENT_TYPE].(string)
+		switch adjustmentType {
+		case PRICE_ADJUSTMENT_PERCENT, PRICE_ADJUSTMENT_FIXED:
+		case PRICE_ADJUSTMENT_PRICE:
+			if toFloat(adjustment[FLD_PRICE_ADJUSTMENT_VALUE]) < 0 {
+				err := &utils.AppError{
+					ErrorCode:   funcode + "01",
+					ErrorMsg:    "Invalid price list",
+					ErrorDetail: "adjustment_value should not be negative for price"}
+				return err
+			}
+		default:
+			err := &utils.AppError{
+				ErrorCode:   funcode + "01",
+				ErrorMsg:    "Invalid price list",
+				ErrorDetail: fmt.Sprintf("adjustment_type %q should be percent, fixed or price", adjustmentType)}
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *pricingBaseService) errorReturn(err error) (PricingService, error) {
+	// Close the Database Connection
+	p.EndService()
+	return nil, err
+}
@@ -0,0 +1,62 @@
+package sales_service
+
+import (
+	"testing"
+
+	"github.com/zapscloud/golib-sales-repository/sales_common"
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+func TestPriceItemsRejectsQuantity(t *testing.T) {
+	resolver := &PriceResolver{}
+	for _, qty := range []int{0, -2} {
+		items := []utils.Map{{sales_common.FLD_PRODUCT_ID: "prod1", FLD_CART_ITEM_QUANTITY: qty}}
+		if _, _, err := resolver.PriceItems(items, "", nil); err == nil {
+			t.Errorf("quantity %d is priced", qty)
+		}
+		if _, err := resolver.ResolvePrice("prod1", "", qty); err == nil {
+			t.Errorf("quantity %d is resolved", qty)
+		}
+	}
+}
+
+func TestResolvePriceList(t *testing.T) {
+	product := roundTrip(t, utils.Map{
+		sales_common.FLD_PRODUCT_ID:  "prod1",
+		sales_common.FLD_CATEGORY_ID: "cat1",
+		FLD_PRODUCT_PRICE:            200,
+	})
+	priceList := roundTrip(t, utils.Map{
+		FLD_PRICE_ADJUSTMENT_TYPE:  PRICE_ADJUSTMENT_PERCENT,
+		FLD_PRICE_ADJUSTMENT_VALUE: -10,
+		FLD_PRICE_LIST_CATEGORIES: []utils.Map{
+			{sales_common.FLD_CATEGORY_ID: "cat1", FLD_PRICE_ADJUSTMENT_TYPE: PRICE_ADJUSTMENT_FIXED, FLD_PRICE_ADJUSTMENT_VALUE: -50},
+		},
+	})
+
+	resolver := &PriceResolver{}
+	price := resolver.resolve(product, priceContext{customerTypeId: "wholesale", priceList: priceList}, 3)
+	if toFloat(price[FLD_UNIT_PRICE]) != 150 || toFloat(price[FLD_TOTAL_PRICE]) != 450 {
+		t.Errorf("category override price = %v, total %v", price[FLD_UNIT_PRICE], price[FLD_TOTAL_PRICE])
+	}
+
+	delete(priceList, FLD_PRICE_LIST_CATEGORIES)
+	price = resolver.resolve(product, priceContext{customerTypeId: "wholesale", priceList: priceList}, 1)
+	if toFloat(price[FLD_UNIT_PRICE]) != 180 {
+		t.Errorf("price list default price = %v", price[FLD_UNIT_PRICE])
+	}
+
+	price = resolver.resolve(product, priceContext{}, 1)
+	if toFloat(price[FLD_UNIT_PRICE]) != 200 || len(toMapSlice(price[FLD_PRICE_ADJUSTMENTS])) != 0 {
+		t.Errorf("guest price = %v", price)
+	}
+}
+
+func TestValidatePriceList(t *testing.T) {
+	if err := validatePriceList(utils.Map{FLD_PRICE_ADJUSTMENT_TYPE: "discount"}); err == nil {
+		t.Error("unknown adjustment type is accepted")
+	}
+	if err := validatePriceList(utils.Map{FLD_PRICE_ADJUSTMENT_TYPE: PRICE_ADJUSTMENT_PRICE, FLD_PRICE_ADJUSTMENT_VALUE: -1}); err == nil {
+		t.Error("negative price is accepted")
+	}
+}
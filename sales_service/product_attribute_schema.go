@@ -0,0 +1,433 @@
+package sales_service
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zapscloud/golib-dbutils/db_common"
+	"github.com/zapscloud/golib-sales-repository/sales_common"
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+const (
+	// Business setting holding the product attribute schema {"fields": [...]}
+	FLD_PRODUCT_ATTRIBUTE_SCHEMA = "product_attribute_schema"
+
+	// Schema field definition {field, type, required, enum_values, enum_source, multiple, min, max, min_length, max_length}
+	// Dotted field names validate the nested values, e.g. dimensions.length
+	FLD_SCHEMA_FIELDS      = "fields"
+	FLD_SCHEMA_FIELD       = "field"
+	FLD_SCHEMA_TYPE        = "type"
+	FLD_SCHEMA_REQUIRED    = "required"
+	FLD_SCHEMA_ENUM_VALUES = "enum_values"
+	FLD_SCHEMA_ENUM_SOURCE = "enum_source"
+	FLD_SCHEMA_MULTIPLE    = "multiple"
+	FLD_SCHEMA_MIN         = "min"
+	FLD_SCHEMA_MAX         = "max"
+	FLD_SCHEMA_MIN_LENGTH  = "min_length"
+	FLD_SCHEMA_MAX_LENGTH  = "max_length"
+
+	ATTRIBUTE_TYPE_STRING  = "string"
+	ATTRIBUTE_TYPE_NUMBER  = "number"
+	ATTRIBUTE_TYPE_INTEGER = "integer"
+	ATTRIBUTE_TYPE_BOOLEAN = "boolean"
+	ATTRIBUTE_TYPE_ENUM    = "enum"
+
+	// Enum values taken from the firmness and material type records
+	ATTRIBUTE_SOURCE_FIRMNESS      = "firmness"
+	ATTRIBUTE_SOURCE_MATERIAL_TYPE = "material_type"
+
+	// Field level errors [{field, error}]
+	FLD_FIELD_ERRORS = "field_errors"
+	FLD_FIELD_ERROR  = "error"
+)
+
+// attributeValue - Get the value of the dotted field
+func attributeValue(data utils.Map, field string) (any, bool) {
+	parts := strings.Split(field, ".")
+	current := data
+	for _, part := range parts[:len(parts)-1] {
+		child, ok := toMap(current[part])
+		if !ok {
+			return nil, false
+		}
+		current = child
+	}
+	value, ok := current[parts[len(parts)-1]]
+	return value, ok
+}
+
+// isEmptyAttribute - Check whether the value is missing for the required field
+func isEmptyAttribute(value any) bool {
+	switch val := value.(type) {
+	case nil:
+		return true
+	case string:
+		return len(strings.TrimSpace(val)) == 0
+	}
+	// Arrays read from database are primitive.A, from the request []any or []string
+	if rval := reflect.ValueOf(value); rval.Kind() == reflect.Slice {
+		return rval.Len() == 0
+	}
+	return false
+}
+
+// attributeNumber - Get the number value, false when the value is not a number
+func attributeNumber(value any) (float64, bool) {
+	switch val := value.(type) {
+	case int, int32, int64, float32, float64:
+		return toFloat(val), true
+	}
+	return 0, false
+}
+
+// fieldError - Field level error
+func fieldError(field string, format string, args ...any) utils.Map {
+	return utils.Map{
+		FLD_SCHEMA_FIELD: field,
+		FLD_FIELD_ERROR:  fmt.Sprintf(format, args...),
+	}
+}
+
+// validateSchemaDefinition - Validate the schema fields, returns the field errors of the schema itself
+func validateSchemaDefinition(schema utils.Map) []utils.Map {
+	errors := []utils.Map{}
+	seen := map[string]bool{}
+	for idx, definition := range toMapSlice(schema[FLD_SCHEMA_FIELDS]) {
+		field, _ := definition[FLD_SCHEMA_FIELD].(string)
+		if len(field) == 0 {
+			errors = append(errors, fieldError(fmt.Sprintf("fields[%d]", idx), "field name is required"))
+			continue
+		}
+		if seen[field] {
+			errors = append(errors, fieldError(field, "field is defined more than once"))
+		}
+		seen[field] = true
+
+		fieldType, _ := definition[FLD_SCHEMA_TYPE].(string)
+		switch fieldType {
+		case ATTRIBUTE_TYPE_STRING, ATTRIBUTE_TYPE_NUMBER, ATTRIBUTE_TYPE_INTEGER, ATTRIBUTE_TYPE_BOOLEAN:
+		case ATTRIBUTE_TYPE_ENUM:
+			source, _ := definition[FLD_SCHEMA_ENUM_SOURCE].(string)
+			if len(source) > 0 && source != ATTRIBUTE_SOURCE_FIRMNESS && source != ATTRIBUTE_SOURCE_MATERIAL_TYPE {
+				errors = append(errors, fieldError(field, "enum_source %s is not one of firmness or material_type", source))
+			}
+			if len(source) == 0 && len(toStringSlice(definition[FLD_SCHEMA_ENUM_VALUES])) == 0 {
+				errors = append(errors, fieldError(field, "enum needs enum_values or enum_source"))
+			}
+		default:
+			errors = append(errors, fieldError(field, "type %s is not one of string, number, integer, boolean or enum", fieldType))
+		}
+
+		minVal, hasMin := attributeNumber(definition[FLD_SCHEMA_MIN])
+		maxVal, hasMax := attributeNumber(definition[FLD_SCHEMA_MAX])
+		if hasMin && hasMax && minVal > maxVal {
+			errors = append(errors, fieldError(field, "min cannot be more than max"))
+		}
+		minLen, hasMinLen := attributeNumber(definition[FLD_SCHEMA_MIN_LENGTH])
+		maxLen, hasMaxLen := attributeNumber(definition[FLD_SCHEMA_MAX_LENGTH])
+		if hasMinLen && hasMaxLen && minLen > maxLen {
+			errors = append(errors, fieldError(field, "min_length cannot be more than max_length"))
+		}
+	}
+	return errors
+}
+
+// validateAttributeValue - Validate the value against the field definition
+func validateAttributeValue(field string, definition utils.Map, value any, enumValues map[string]bool) []utils.Map {
+	fieldType, _ := definition[FLD_SCHEMA_TYPE].(string)
+	switch fieldType {
+	case ATTRIBUTE_TYPE_STRING:
+		text, ok := value.(string)
+		if !ok {
+			return []utils.Map{fieldError(field, "should be a text")}
+		}
+		length := float64(len([]rune(text)))
+		if minLen, ok := attributeNumber(definition[FLD_SCHEMA_MIN_LENGTH]); ok && length < minLen {
+			return []utils.Map{fieldError(field, "should have at least %v characters", minLen)}
+		}
+		if maxLen, ok := attributeNumber(definition[FLD_SCHEMA_MAX_LENGTH]); ok && length > maxLen {
+			return []utils.Map{fieldError(field, "should have at most %v characters", maxLen)}
+		}
+
+	case ATTRIBUTE_TYPE_NUMBER, ATTRIBUTE_TYPE_INTEGER:
+		number, ok := attributeNumber(value)
+		if !ok {
+			return []utils.Map{fieldError(field, "should be a number")}
+		}
+		if fieldType == ATTRIBUTE_TYPE_INTEGER && number != math.Trunc(number) {
+			return []utils.Map{fieldError(field, "should be a whole number")}
+		}
+		if minVal, ok := attributeNumber(definition[FLD_SCHEMA_MIN]); ok && number < minVal {
+			return []utils.Map{fieldError(field, "should be at least %v", minVal)}
+		}
+		if maxVal, ok := attributeNumber(definition[FLD_SCHEMA_MAX]); ok && number > maxVal {
+			return []utils.Map{fieldError(field, "should be at most %v", maxVal)}
+		}
+
+	case ATTRIBUTE_TYPE_BOOLEAN:
+		if _, ok := value.(bool); !ok {
+			return []utils.Map{fieldError(field, "should be true or false")}
+		}
+
+	case ATTRIBUTE_TYPE_ENUM:
+		values := []string{}
+		if multiple, _ := definition[FLD_SCHEMA_MULTIPLE].(bool); multiple {
+			values = toStringSlice(value)
+			if _, isText := value.(string); isText || (len(values) == 0 && !isEmptyAttribute(value)) {
+				return []utils.Map{fieldError(field, "should be a list of values")}
+			}
+		} else if text, ok := value.(string); ok {
+			values = []string{text}
+		} else {
+			return []utils.Map{fieldError(field, "should be one of the values")}
+		}
+
+		for _, val := range values {
+			if !enumValues[val] {
+				allowed := make([]string, 0, len(enumValues))
+				for key := range enumValues {
+					allowed = append(allowed, key)
+				}
+				sort.Strings(allowed)
+				return []utils.Map{fieldError(field, "%s is not one of %s", val, strings.Join(allowed, ", "))}
+			}
+		}
+	}
+	return nil
+}
+
+// validateAttributes - Validate the product data against the schema, returns the field errors
+// Partial data of the update validates only the given fields. Variants inherit the required fields of their parent.
+func validateAttributes(schema utils.Map, indata utils.Map, partial bool, enumValues func(definition utils.Map) (map[string]bool, error)) ([]utils.Map, error) {
+	errors := []utils.Map{}
+	_, isVariant := indata[FLD_PRODUCT_PARENT_ID].(string)
+
+	for _, definition := range toMapSlice(schema[FLD_SCHEMA_FIELDS]) {
+		field, _ := definition[FLD_SCHEMA_FIELD].(string)
+		value, present := attributeValue(indata, field)
+		required, _ := definition[FLD_SCHEMA_REQUIRED].(bool)
+
+		if !present || isEmptyAttribute(value) {
+			if required && !isVariant && (present || !partial) {
+				errors = append(errors, fieldError(field, "is required"))
+			}
+			continue
+		}
+
+		var values map[string]bool
+		if fieldType, _ := definition[FLD_SCHEMA_TYPE].(string); fieldType == ATTRIBUTE_TYPE_ENUM {
+			var err error
+			values, err = enumValues(definition)
+			if err != nil {
+				return nil, err
+			}
+		}
+		errors = append(errors, validateAttributeValue(field, definition, value, values)...)
+	}
+	return errors, nil
+}
+
+// coerceAttributeValues - Convert the text values of the number, integer and boolean fields, used for the CSV import
+func coerceAttributeValues(schema utils.Map, data utils.Map) {
+	for _, definition := range toMapSlice(schema[FLD_SCHEMA_FIELDS]) {
+		field, _ := definition[FLD_SCHEMA_FIELD].(string)
+		value, ok := attributeValue(data, field)
+		text, isText := value.(string)
+		if !ok || !isText {
+			continue
+		}
+
+		var converted any
+		switch definition[FLD_SCHEMA_TYPE] {
+		case ATTRIBUTE_TYPE_NUMBER, ATTRIBUTE_TYPE_INTEGER:
+			if number, err := strconv.ParseFloat(strings.TrimSpace(text), 64); err == nil {
+				converted = number
+			}
+		case ATTRIBUTE_TYPE_BOOLEAN:
+			if flag, err := strconv.ParseBool(strings.TrimSpace(text)); err == nil {
+				converted = flag
+			}
+		}
+		if converted == nil {
+			continue
+		}
+
+		// Set the converted value in the nested object of the field
+		parts := strings.Split(field, ".")
+		target := data
+		for _, part := range parts[:len(parts)-1] {
+			target, _ = toMap(target[part])
+		}
+		target[parts[len(parts)-1]] = converted
+	}
+}
+
+// fieldErrorsDetail - Join the field errors for the error detail
+func fieldErrorsDetail(errors []utils.Map) string {
+	details := make([]string, 0, len(errors))
+	for _, item := range errors {
+		details = append(details, fmt.Sprintf("%v %v", item[FLD_SCHEMA_FIELD], item[FLD_FIELD_ERROR]))
+	}
+	return strings.Join(details, "; ")
+}
+
+// ProductAttributeSchemaService - Product Attribute Schema Service structure
+type ProductAttributeSchemaService interface {
+	// GetAttributeSchema - Get the product attribute schema of the business
+	GetAttributeSchema() (utils.Map, error)
+	// SetAttributeSchema - Set the product attribute schema {"fields": [{field, type, required, enum_values, enum_source, min, max}]}
+	SetAttributeSchema(schema utils.Map) (utils.Map, error)
+	// ValidateAttributes - Validate the product data against the schema, returns the field errors
+	ValidateAttributes(indata utils.Map, partial bool) ([]utils.Map, error)
+
+	EndService()
+}
+
+// productAttributeSchemaBaseService - Product Attribute Schema Service structure, shares the product service
+type productAttributeSchemaBaseService struct {
+	*productBaseService
+}
+
+// NewProductAttributeSchemaService - Construct Product Attribute Schema
+func NewProductAttributeSchemaService(props utils.Map) (ProductAttributeSchemaService, error) {
+
+	log.Printf("ProductAttributeSchemaService::Start ")
+	p, err := newProductBaseService(props)
+	if err != nil {
+		return nil, err
+	}
+	return &productAttributeSchemaBaseService{p}, nil
+}
+
+// GetAttributeSchema - Get the product attribute schema of the business
+func (p *productAttributeSchemaBaseService) GetAttributeSchema() (utils.Map, error) {
+
+	log.Println("ProductAttributeSchemaService::GetAttributeSchema - Begin")
+
+	schema, err := p.attributeSchema()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("ProductAttributeSchemaService::GetAttributeSchema - End ")
+	return schema, nil
+}
+
+// SetAttributeSchema - Set the product attribute schema of the business {"fields": [...]}
+func (p *productAttributeSchemaBaseService) SetAttributeSchema(schema utils.Map) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "18"
+
+	log.Println("ProductAttributeSchemaService::SetAttributeSchema - Begin")
+
+	if errors := validateSchemaDefinition(schema); len(errors) > 0 {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "01",
+			ErrorMsg:    "Invalid attribute schema",
+			ErrorDetail: fieldErrorsDetail(errors)}
+		return utils.Map{FLD_FIELD_ERRORS: errors}, err
+	}
+
+	schema = utils.Map{FLD_SCHEMA_FIELDS: toMapSlice(schema[FLD_SCHEMA_FIELDS])}
+	_, err := updateBusinessSetting(p.daoBusiness, p.businessId, FLD_PRODUCT_ATTRIBUTE_SCHEMA, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("ProductAttributeSchemaService::SetAttributeSchema - End ")
+	return schema, nil
+}
+
+// ValidateAttributes - Validate the product data against the schema, returns the field errors [{field, error}]
+// partial validates only the given fields, as on update
+func (p *productAttributeSchemaBaseService) ValidateAttributes(indata utils.Map, partial bool) ([]utils.Map, error) {
+
+	log.Println("ProductAttributeSchemaService::ValidateAttributes - Begin", partial)
+
+	schema, err := p.attributeSchema()
+	if err != nil {
+		return nil, err
+	}
+	errors, err := validateAttributes(schema, indata, partial, p.attributeEnumValues)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("ProductAttributeSchemaService::ValidateAttributes - End ", len(errors))
+	return errors, nil
+}
+
+// checkAttributes - Validate the product data for the create or update, the field errors are returned as the error
+func (p *productBaseService) checkAttributes(indata utils.Map, partial bool) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "18"
+
+	schema, err := p.attributeSchema()
+	if err != nil {
+		return nil, err
+	}
+	errors, err := validateAttributes(schema, indata, partial, p.attributeEnumValues)
+	if err != nil {
+		return nil, err
+	}
+	if len(errors) > 0 {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "02",
+			ErrorMsg:    "Invalid product attributes",
+			ErrorDetail: fieldErrorsDetail(errors)}
+		return utils.Map{FLD_FIELD_ERRORS: errors}, err
+	}
+	return nil, nil
+}
+
+// attributeSchema - Get the schema from the business settings, empty schema when not set
+func (p *productBaseService) attributeSchema() (utils.Map, error) {
+	value, ok, err := getBusinessSetting(p.daoBusiness, p.businessId, FLD_PRODUCT_ATTRIBUTE_SCHEMA)
+	if err != nil {
+		return nil, err
+	}
+	schema, _ := toMap(value)
+	if !ok || schema == nil {
+		schema = utils.Map{FLD_SCHEMA_FIELDS: []utils.Map{}}
+	}
+	return schema, nil
+}
+
+// attributeEnumValues - Get the allowed values of the enum field, from the firmness or material type records when linked
+func (p *productBaseService) attributeEnumValues(definition utils.Map) (map[string]bool, error) {
+	values := map[string]bool{}
+	for _, value := range toStringSlice(definition[FLD_SCHEMA_ENUM_VALUES]) {
+		values[value] = true
+	}
+
+	var listdata utils.Map
+	var err error
+	idField := ""
+	switch definition[FLD_SCHEMA_ENUM_SOURCE] {
+	case ATTRIBUTE_SOURCE_FIRMNESS:
+		listdata, err = p.daoFirmness.List("", "", 0, 0)
+		idField = sales_common.FLD_FIRMNESS_ID
+	case ATTRIBUTE_SOURCE_MATERIAL_TYPE:
+		listdata, err = p.daoMaterialType.List("", "", 0, 0)
+		idField = sales_common.FLD_MATERIAL_TYPE_ID
+	default:
+		return values, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, record := range listResult(listdata) {
+		if deleted, _ := record[db_common.FLD_IS_DELETED].(bool); deleted {
+			continue
+		}
+		if id, _ := record[idField].(string); len(id) > 0 {
+			values[id] = true
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,137 @@
+package sales_service
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+func testAttributeSchema() utils.Map {
+	return utils.Map{FLD_SCHEMA_FIELDS: []utils.Map{
+		{FLD_SCHEMA_FIELD: "model", FLD_SCHEMA_TYPE: ATTRIBUTE_TYPE_STRING, FLD_SCHEMA_REQUIRED: true, FLD_SCHEMA_MIN_LENGTH: 2, FLD_SCHEMA_MAX_LENGTH: 5},
+		{FLD_SCHEMA_FIELD: "dimensions.length", FLD_SCHEMA_TYPE: ATTRIBUTE_TYPE_NUMBER, FLD_SCHEMA_MIN: 10, FLD_SCHEMA_MAX: 200},
+		{FLD_SCHEMA_FIELD: "layers", FLD_SCHEMA_TYPE: ATTRIBUTE_TYPE_INTEGER},
+		{FLD_SCHEMA_FIELD: "washable", FLD_SCHEMA_TYPE: ATTRIBUTE_TYPE_BOOLEAN},
+		{FLD_SCHEMA_FIELD: "colors", FLD_SCHEMA_TYPE: ATTRIBUTE_TYPE_ENUM, FLD_SCHEMA_MULTIPLE: true, FLD_SCHEMA_ENUM_VALUES: []string{"red", "blue"}},
+		{FLD_SCHEMA_FIELD: "firmness_id", FLD_SCHEMA_TYPE: ATTRIBUTE_TYPE_ENUM, FLD_SCHEMA_REQUIRED: true, FLD_SCHEMA_ENUM_SOURCE: ATTRIBUTE_SOURCE_FIRMNESS},
+	}}
+}
+
+// testEnumValues - Enum values of the definition, the firmness source has soft and firm
+func testEnumValues(definition utils.Map) (map[string]bool, error) {
+	if definition[FLD_SCHEMA_ENUM_SOURCE] == ATTRIBUTE_SOURCE_FIRMNESS {
+		return map[string]bool{"soft": true, "firm": true}, nil
+	}
+	values := map[string]bool{}
+	for _, value := range toStringSlice(definition[FLD_SCHEMA_ENUM_VALUES]) {
+		values[value] = true
+	}
+	return values, nil
+}
+
+// errorFields - Fields of the field errors
+func errorFields(fieldErrors []utils.Map) []string {
+	fields := []string{}
+	for _, item := range fieldErrors {
+		field, _ := item[FLD_SCHEMA_FIELD].(string)
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+func TestValidateSchemaDefinition(t *testing.T) {
+	if fieldErrors := validateSchemaDefinition(testAttributeSchema()); len(fieldErrors) != 0 {
+		t.Fatalf("validateSchemaDefinition = %v, want no errors", fieldErrors)
+	}
+
+	schema := utils.Map{FLD_SCHEMA_FIELDS: []utils.Map{
+		{FLD_SCHEMA_TYPE: ATTRIBUTE_TYPE_STRING},
+		{FLD_SCHEMA_FIELD: "a", FLD_SCHEMA_TYPE: ATTRIBUTE_TYPE_STRING},
+		{FLD_SCHEMA_FIELD: "a", FLD_SCHEMA_TYPE: ATTRIBUTE_TYPE_STRING},
+		{FLD_SCHEMA_FIELD: "b", FLD_SCHEMA_TYPE: "date"},
+		{FLD_SCHEMA_FIELD: "c", FLD_SCHEMA_TYPE: ATTRIBUTE_TYPE_ENUM},
+		{FLD_SCHEMA_FIELD: "d", FLD_SCHEMA_TYPE: ATTRIBUTE_TYPE_ENUM, FLD_SCHEMA_ENUM_SOURCE: "colour"},
+		{FLD_SCHEMA_FIELD: "e", FLD_SCHEMA_TYPE: ATTRIBUTE_TYPE_NUMBER, FLD_SCHEMA_MIN: 5, FLD_SCHEMA_MAX: 1},
+		{FLD_SCHEMA_FIELD: "f", FLD_SCHEMA_TYPE: ATTRIBUTE_TYPE_STRING, FLD_SCHEMA_MIN_LENGTH: 5.0, FLD_SCHEMA_MAX_LENGTH: 1},
+	}}
+	want := []string{"fields[0]", "a", "b", "c", "d", "e", "f"}
+	if got := errorFields(validateSchemaDefinition(schema)); !reflect.DeepEqual(got, want) {
+		t.Errorf("validateSchemaDefinition error fields = %v, want %v", got, want)
+	}
+}
+
+func TestValidateAttributes(t *testing.T) {
+	tests := []struct {
+		name    string
+		indata  utils.Map
+		partial bool
+		want    []string
+	}{
+		{"valid", utils.Map{"model": "M1", "dimensions": utils.Map{"length": 150}, "layers": 3.0, "washable": true,
+			"colors": []any{"red", "blue"}, "firmness_id": "soft"}, false, []string{}},
+		{"missing required", utils.Map{"model": " "}, false, []string{"model", "firmness_id"}},
+		{"partial update of given fields", utils.Map{"layers": 2}, true, []string{}},
+		{"partial update clearing required field", utils.Map{"model": ""}, true, []string{"model"}},
+		{"variant inherits required fields", utils.Map{FLD_PRODUCT_PARENT_ID: "p1"}, false, []string{}},
+		{"string length", utils.Map{"model": "M", "firmness_id": "soft"}, false, []string{"model"}},
+		{"string type", utils.Map{"model": 12, "firmness_id": "soft"}, false, []string{"model"}},
+		{"nested number range", utils.Map{"model": "M1", "firmness_id": "soft", "dimensions": utils.Map{"length": 300}}, false, []string{"dimensions.length"}},
+		{"nested number type", utils.Map{"model": "M1", "firmness_id": "soft", "dimensions": utils.Map{"length": "300"}}, false, []string{"dimensions.length"}},
+		{"whole number", utils.Map{"model": "M1", "firmness_id": "soft", "layers": 2.5}, false, []string{"layers"}},
+		{"boolean", utils.Map{"model": "M1", "firmness_id": "soft", "washable": "yes"}, false, []string{"washable"}},
+		{"enum value", utils.Map{"model": "M1", "firmness_id": "medium"}, false, []string{"firmness_id"}},
+		{"multiple enum as text", utils.Map{"model": "M1", "firmness_id": "soft", "colors": "red"}, false, []string{"colors"}},
+		{"multiple enum value", utils.Map{"model": "M1", "firmness_id": "soft", "colors": []string{"red", "green"}}, false, []string{"colors"}},
+	}
+
+	for _, test := range tests {
+		fieldErrors, err := validateAttributes(testAttributeSchema(), test.indata, test.partial, testEnumValues)
+		if err != nil {
+			t.Errorf("%s: validateAttributes returned %v", test.name, err)
+			continue
+		}
+		if got := errorFields(fieldErrors); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: error fields = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestValidateAttributesEnumSourceError(t *testing.T) {
+	failing := func(definition utils.Map) (map[string]bool, error) {
+		return nil, errors.New("firmness not loaded")
+	}
+	if _, err := validateAttributes(testAttributeSchema(), utils.Map{"firmness_id": "soft"}, true, failing); err == nil {
+		t.Error("validateAttributes ignored the error of the enum values")
+	}
+}
+
+func TestCoerceAttributeValues(t *testing.T) {
+	data := utils.Map{
+		"model":      "100",
+		"dimensions": utils.Map{"length": " 120.5 "},
+		"layers":     "3",
+		"washable":   "TRUE",
+		"colors":     "red",
+	}
+	coerceAttributeValues(testAttributeSchema(), data)
+
+	want := utils.Map{
+		"model":      "100",
+		"dimensions": utils.Map{"length": 120.5},
+		"layers":     3.0,
+		"washable":   true,
+		"colors":     "red",
+	}
+	if !reflect.DeepEqual(data, want) {
+		t.Errorf("coerceAttributeValues = %v, want %v", data, want)
+	}
+
+	// Text not converted is left for the validation to report
+	data = utils.Map{"layers": "three", "washable": "maybe"}
+	coerceAttributeValues(testAttributeSchema(), data)
+	if data["layers"] != "three" || data["washable"] != "maybe" {
+		t.Errorf("coerceAttributeValues changed the invalid text %v", data)
+	}
+}
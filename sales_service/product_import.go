@@ -0,0 +1,564 @@
+package sales_service
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zapscloud/golib-dbutils/db_common"
+	"github.com/zapscloud/golib-sales-repository/sales_common"
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+const (
+	PRODUCT_FORMAT_CSV  = "csv"
+	PRODUCT_FORMAT_JSON = "json"
+
+	// Import response fields
+	FLD_IMPORT_DRY_RUN    = "dry_run"
+	FLD_IMPORT_TOTAL_ROWS = "total_rows"
+	FLD_IMPORT_CREATED    = "created"
+	FLD_IMPORT_UPDATED    = "updated"
+	FLD_IMPORT_FAILED     = "failed"
+	FLD_IMPORT_ERRORS     = "errors"
+	FLD_IMPORT_ROW        = "row"
+	FLD_IMPORT_ACTION     = "action"
+
+	IMPORT_ACTION_CREATE = "create"
+	IMPORT_ACTION_UPDATE = "update"
+)
+
+// productNumberFields - Fields converted to number when imported from CSV
+var productNumberFields = map[string]bool{
+	FLD_PRODUCT_PRICE: true,
+	FLD_PRODUCT_STOCK: true,
+}
+
+// productExportSkipFields - Internal fields left out of the export
+var productExportSkipFields = map[string]bool{
+	db_common.FLD_DEFAULT_ID:     true,
+	FLD_STOCK_RESERVATIONS:       true,
+	FLD_STOCK_RESERVED:           true,
+	FLD_STOCK_COUNTED:            true,
+	FLD_STOCK_COMMITS:            true,
+	FLD_LOCATION_COMMITS:         true,
+	sales_common.FLD_BUSINESS_ID: true,
+}
+
+// importRow - Product row read from the import file
+type importRow struct {
+	row     int
+	product utils.Map
+	action  string
+	errors  []string
+}
+
+// ProductImportService - Product Import Service structure
+type ProductImportService interface {
+	// Import - Import the products from CSV or JSON, with the column mapping and dry run
+	// Imported products are published unless save_as_draft is given, imported changes are drafts to be reviewed and published
+	Import(format string, data []byte, mapping utils.Map, dryRun bool) (utils.Map, error)
+	// Export - Export all the products with their variants as CSV or JSON
+	Export(format string) ([]byte, error)
+
+	EndService()
+}
+
+// productImportBaseService - Product Import Service structure, shares the product service
+type productImportBaseService struct {
+	*productBaseService
+}
+
+// NewProductImportService - Construct Product Import
+func NewProductImportService(props utils.Map) (ProductImportService, error) {
+
+	log.Printf("ProductImportService::Start ")
+	p, err := newProductBaseService(props)
+	if err != nil {
+		return nil, err
+	}
+	return &productImportBaseService{p}, nil
+}
+
+// Import - Import the products from CSV or JSON, products are matched by product_id or sku to update
+// mapping renames the columns of the file to the product fields, e.g. {"Name": "product_name", "Firmness": "variant_attributes.firmness_id"}
+// All the rows are validated before saving any of them, nothing is saved on dry run or when any row fails the validation
+// Created products are published unless the row has save_as_draft, changes of the published products are saved in their drafts
+// and go live when published
+// A row failed on save is reported with its error and the rest are still saved, the matching by product_id or sku
+// lets the same file be imported again to save the remaining rows
+func (p *productImportBaseService) Import(format string, data []byte, mapping utils.Map, dryRun bool) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "22"
+
+	log.Println("ProductImportService::Import - Begin", format, dryRun)
+
+	records, err := parseImportData(funcode, format, data)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]*importRow, 0, len(records))
+	for idx, record := range records {
+		rows = append(rows, &importRow{row: idx + 1, product: mapImportRecord(record, mapping, format == PRODUCT_FORMAT_CSV)})
+	}
+
+	err = p.validateImportRows(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	failed := []utils.Map{}
+	created, updated := 0, 0
+	for _, row := range rows {
+		if len(row.errors) > 0 {
+			failed = append(failed, utils.Map{
+				FLD_IMPORT_ROW:              row.row,
+				sales_common.FLD_PRODUCT_ID: row.product[sales_common.FLD_PRODUCT_ID],
+				FLD_PRODUCT_SKU:             row.product[FLD_PRODUCT_SKU],
+				FLD_IMPORT_ERRORS:           row.errors,
+			})
+		} else if row.action == IMPORT_ACTION_CREATE {
+			created++
+		} else {
+			updated++
+		}
+	}
+
+	response := utils.Map{
+		FLD_IMPORT_DRY_RUN:    dryRun,
+		FLD_IMPORT_TOTAL_ROWS: len(rows),
+		FLD_IMPORT_CREATED:    created,
+		FLD_IMPORT_UPDATED:    updated,
+		FLD_IMPORT_FAILED:     len(failed),
+		FLD_IMPORT_ERRORS:     failed,
+	}
+
+	if len(failed) > 0 {
+		response[FLD_IMPORT_CREATED] = 0
+		response[FLD_IMPORT_UPDATED] = 0
+		err := &utils.AppError{
+			ErrorCode:   funcode + "08",
+			ErrorMsg:    "Invalid import rows",
+			ErrorDetail: fmt.Sprintf("%d of %d rows failed the validation, nothing is imported", len(failed), len(rows))}
+		return response, err
+	}
+
+	if dryRun {
+		log.Println("ProductImportService::Import - End dry run", len(rows))
+		return response, nil
+	}
+
+	// Rows are saved in the file order, the validation ensures the parents come before their variants
+	// Variants of a parent failed on save are skipped
+	saved := map[string]int{IMPORT_ACTION_CREATE: 0, IMPORT_ACTION_UPDATE: 0}
+	unsaved := map[string]bool{}
+	for _, row := range rows {
+		productId, _ := row.product[sales_common.FLD_PRODUCT_ID].(string)
+		var err error
+		if parentId, _ := row.product[FLD_PRODUCT_PARENT_ID].(string); unsaved[parentId] {
+			err = fmt.Errorf("parent product %s is not saved", parentId)
+		} else if row.action == IMPORT_ACTION_CREATE {
+			_, err = p.Create(row.product)
+		} else {
+			_, err = p.Update(productId, row.product)
+		}
+		if err != nil {
+			log.Println("ProductImportService::Import - Failed at row", row.row, err)
+			unsaved[productId] = true
+			failed = append(failed, utils.Map{
+				FLD_IMPORT_ROW:              row.row,
+				sales_common.FLD_PRODUCT_ID: productId,
+				FLD_PRODUCT_SKU:             row.product[FLD_PRODUCT_SKU],
+				FLD_IMPORT_ERRORS:           []string{err.Error()},
+			})
+			continue
+		}
+		saved[row.action]++
+	}
+
+	response[FLD_IMPORT_CREATED] = saved[IMPORT_ACTION_CREATE]
+	response[FLD_IMPORT_UPDATED] = saved[IMPORT_ACTION_UPDATE]
+	response[FLD_IMPORT_FAILED] = len(failed)
+	response[FLD_IMPORT_ERRORS] = failed
+	if len(failed) > 0 {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "12",
+			ErrorMsg:    "Import partially saved",
+			ErrorDetail: fmt.Sprintf("%d of %d rows failed on save, the other rows are saved", len(failed), len(rows))}
+		return response, err
+	}
+
+	log.Println("ProductImportService::Import - End ", saved[IMPORT_ACTION_CREATE], saved[IMPORT_ACTION_UPDATE])
+	return response, nil
+}
+
+// Export - Export all the products with their variants as CSV or JSON
+func (p *productImportBaseService) Export(format string) ([]byte, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "22"
+
+	log.Println("ProductImportService::Export - Begin", format)
+
+	listdata, err := p.daoProduct.List("", "", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each variant is placed after its parent
+	parents := []utils.Map{}
+	variants := map[string][]utils.Map{}
+	for _, product := range listResult(listdata) {
+		if deleted, _ := product[db_common.FLD_IS_DELETED].(bool); deleted {
+			continue
+		}
+		for field := range productExportSkipFields {
+			delete(product, field)
+		}
+		if parentId, _ := product[FLD_PRODUCT_PARENT_ID].(string); len(parentId) > 0 {
+			variants[parentId] = append(variants[parentId], product)
+		} else {
+			parents = append(parents, product)
+		}
+	}
+
+	products := []utils.Map{}
+	for _, parent := range parents {
+		products = append(products, parent)
+		products = append(products, variants[productIdOf(parent)]...)
+		delete(variants, productIdOf(parent))
+	}
+	// Variants of the parents not found are exported at the end
+	parentIds := make([]string, 0, len(variants))
+	for parentId := range variants {
+		parentIds = append(parentIds, parentId)
+	}
+	sort.Strings(parentIds)
+	for _, parentId := range parentIds {
+		products = append(products, variants[parentId]...)
+	}
+
+	var data []byte
+	switch format {
+	case PRODUCT_FORMAT_JSON:
+		data, err = json.Marshal(products)
+	case PRODUCT_FORMAT_CSV:
+		data, err = productsToCsv(products)
+	default:
+		err = &utils.AppError{
+			ErrorCode:   funcode + "09",
+			ErrorMsg:    "Invalid format",
+			ErrorDetail: fmt.Sprintf("Format should be %s or %s", PRODUCT_FORMAT_CSV, PRODUCT_FORMAT_JSON)}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("ProductImportService::Export - End ", len(products))
+	return data, nil
+}
+
+// validateImportRows - Validate the rows and find whether each row creates or updates the product
+func (p *productBaseService) validateImportRows(rows []*importRow) error {
+
+	schema, err := p.attributeSchema()
+	if err != nil {
+		return err
+	}
+
+	fileIds := map[string]int{}
+	fileSkus := map[string]int{}
+	categories := map[string]bool{}
+
+	for _, row := range rows {
+		product := row.product
+		productId, _ := product[sales_common.FLD_PRODUCT_ID].(string)
+		sku, _ := product[FLD_PRODUCT_SKU].(string)
+
+		// Match the existing product by product_id, else by sku
+		var existing utils.Map
+		if len(productId) > 0 {
+			productId = strings.ToLower(productId)
+			product[sales_common.FLD_PRODUCT_ID] = productId
+			data, err := p.daoProduct.Get(productId)
+			if err == nil {
+				existing = data
+			}
+		} else if len(sku) > 0 {
+			matches, err := findActive(p.daoProduct, utils.Map{FLD_PRODUCT_SKU: sku}, 1)
+			if err != nil {
+				return err
+			}
+			if len(matches) > 0 {
+				existing = matches[0]
+				productId = productIdOf(existing)
+				product[sales_common.FLD_PRODUCT_ID] = productId
+			}
+		}
+
+		if existing != nil {
+			row.action = IMPORT_ACTION_UPDATE
+		} else {
+			row.action = IMPORT_ACTION_CREATE
+			if len(productId) == 0 {
+				productId = utils.GenerateUniqueId("prod")
+				product[sales_common.FLD_PRODUCT_ID] = productId
+			}
+			if name, _ := product[FLD_PRODUCT_NAME].(string); len(strings.TrimSpace(name)) == 0 {
+				row.errors = append(row.errors, "product_name is required")
+			}
+		}
+
+		if prevRow, ok := fileIds[productId]; ok {
+			row.errors = append(row.errors, fmt.Sprintf("product_id is repeated, first in row %d", prevRow))
+		}
+		fileIds[productId] = row.row
+
+		if len(sku) > 0 {
+			if prevRow, ok := fileSkus[sku]; ok {
+				row.errors = append(row.errors, fmt.Sprintf("sku is repeated, first in row %d", prevRow))
+			}
+			fileSkus[sku] = row.row
+
+			others, err := findActive(p.daoProduct, utils.Map{FLD_PRODUCT_SKU: sku, sales_common.FLD_PRODUCT_ID: utils.Map{"$ne": productId}}, 1)
+			if err != nil {
+				return err
+			}
+			if len(others) > 0 {
+				row.errors = append(row.errors, fmt.Sprintf("sku is already used by product %s", productIdOf(others[0])))
+			}
+		}
+
+		// Schema attributes are checked with the field errors, the CSV text is converted to the schema types first
+		coerceAttributeValues(schema, product)
+		attributeErrors, err := validateAttributes(schema, product, row.action == IMPORT_ACTION_UPDATE, p.attributeEnumValues)
+		if err != nil {
+			return err
+		}
+		for _, item := range attributeErrors {
+			row.errors = append(row.errors, fmt.Sprintf("%v %v", item[FLD_SCHEMA_FIELD], item[FLD_FIELD_ERROR]))
+		}
+
+		for field := range productNumberFields {
+			if value, ok := product[field]; ok {
+				if _, isString := value.(string); isString || toFloat(value) < 0 {
+					row.errors = append(row.errors, fmt.Sprintf("%s should be a number not less than 0", field))
+				}
+			}
+		}
+
+		if categoryId, _ := product[sales_common.FLD_CATEGORY_ID].(string); len(categoryId) > 0 {
+			found, checked := categories[categoryId]
+			if !checked {
+				_, err := p.daoCategory.Get(categoryId)
+				found = err == nil
+				categories[categoryId] = found
+			}
+			if !found {
+				row.errors = append(row.errors, fmt.Sprintf("category %s is not exist", categoryId))
+			}
+		}
+
+		// Parent should exist already or come earlier in the file
+		if parentId, _ := product[FLD_PRODUCT_PARENT_ID].(string); len(parentId) > 0 {
+			if parentId == productId {
+				row.errors = append(row.errors, "product cannot be the parent of itself")
+			} else if _, inFile := fileIds[parentId]; !inFile {
+				_, err := p.daoProduct.Get(parentId)
+				if err != nil {
+					row.errors = append(row.errors, fmt.Sprintf("parent product %s is not exist or comes later in the file", parentId))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// parseImportData - Read the records from the CSV or JSON data
+func parseImportData(funcode string, format string, data []byte) ([]utils.Map, error) {
+	switch format {
+	case PRODUCT_FORMAT_JSON:
+		records := []utils.Map{}
+		err := json.Unmarshal(data, &records)
+		if err != nil {
+			err := &utils.AppError{
+				ErrorCode:   funcode + "10",
+				ErrorMsg:    "Invalid JSON",
+				ErrorDetail: err.Error()}
+			return nil, err
+		}
+		return records, nil
+
+	case PRODUCT_FORMAT_CSV:
+		reader := csv.NewReader(bytes.NewReader(data))
+		reader.TrimLeadingSpace = true
+		lines, err := reader.ReadAll()
+		if err != nil {
+			err := &utils.AppError{
+				ErrorCode:   funcode + "11",
+				ErrorMsg:    "Invalid CSV",
+				ErrorDetail: err.Error()}
+			return nil, err
+		}
+		if len(lines) == 0 {
+			return []utils.Map{}, nil
+		}
+
+		header := lines[0]
+		records := make([]utils.Map, 0, len(lines)-1)
+		for _, line := range lines[1:] {
+			record := utils.Map{}
+			for idx, column := range header {
+				if idx < len(line) && len(line[idx]) > 0 {
+					record[strings.TrimSpace(column)] = line[idx]
+				}
+			}
+			records = append(records, record)
+		}
+		return records, nil
+	}
+
+	err := &utils.AppError{
+		ErrorCode:   funcode + "09",
+		ErrorMsg:    "Invalid format",
+		ErrorDetail: fmt.Sprintf("Format should be %s or %s", PRODUCT_FORMAT_CSV, PRODUCT_FORMAT_JSON)}
+	return nil, err
+}
+
+// mapImportRecord - Rename the columns as per the mapping, dotted field names build the nested objects
+// Values of CSV are converted to the type of the field
+func mapImportRecord(record utils.Map, mapping utils.Map, fromCsv bool) utils.Map {
+	product := utils.Map{}
+	for column, value := range record {
+		field := column
+		if mapped, ok := mapping[column].(string); ok && len(mapped) > 0 {
+			field = mapped
+		}
+
+		if strValue, ok := value.(string); ok && fromCsv {
+			value = csvValue(field, strValue)
+		}
+
+		parts := strings.Split(field, ".")
+		target := product
+		for _, part := range parts[:len(parts)-1] {
+			child, ok := toMap(target[part])
+			if !ok {
+				child = utils.Map{}
+				target[part] = child
+			}
+			target = child
+		}
+		target[parts[len(parts)-1]] = value
+	}
+	return product
+}
+
+// csvValue - Convert the CSV cell to the type of the field, JSON arrays and objects are decoded
+func csvValue(field string, value string) any {
+	value = strings.TrimSpace(value)
+
+	if productNumberFields[field] {
+		if number, err := strconv.ParseFloat(value, 64); err == nil {
+			return number
+		}
+		return value
+	}
+
+	if strings.HasPrefix(value, "[") || strings.HasPrefix(value, "{") {
+		var decoded any
+		if err := json.Unmarshal([]byte(value), &decoded); err == nil {
+			return decoded
+		}
+	}
+
+	switch strings.ToLower(value) {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return value
+}
+
+// productsToCsv - Write the products as CSV, nested objects are flattened to dotted columns
+func productsToCsv(products []utils.Map) ([]byte, error) {
+	rows := make([]utils.Map, 0, len(products))
+	columnSet := map[string]bool{}
+	for _, product := range products {
+		row := utils.Map{}
+		flattenProduct("", product, row)
+		for column := range row {
+			columnSet[column] = true
+		}
+		rows = append(rows, row)
+	}
+
+	// product_id and sku come first, the other columns in alphabetical order
+	columns := []string{sales_common.FLD_PRODUCT_ID, FLD_PRODUCT_SKU}
+	others := []string{}
+	for column := range columnSet {
+		if column != sales_common.FLD_PRODUCT_ID && column != FLD_PRODUCT_SKU {
+			others = append(others, column)
+		}
+	}
+	sort.Strings(others)
+	columns = append(columns, others...)
+
+	buffer := &bytes.Buffer{}
+	writer := csv.NewWriter(buffer)
+	err := writer.Write(columns)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		line := make([]string, len(columns))
+		for idx, column := range columns {
+			line[idx] = csvCell(row[column])
+		}
+		err = writer.Write(line)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	writer.Flush()
+	return buffer.Bytes(), writer.Error()
+}
+
+// flattenProduct - Flatten the nested objects to dotted field names
+func flattenProduct(prefix string, value utils.Map, result utils.Map) {
+	for key, item := range value {
+		field := key
+		if len(prefix) > 0 {
+			field = prefix + "." + key
+		}
+		if child, ok := toMap(item); ok {
+			flattenProduct(field, child, result)
+			continue
+		}
+		result[field] = item
+	}
+}
+
+// csvCell - Format the value as CSV cell, arrays are written as JSON
+func csvCell(value any) string {
+	switch val := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case bool, int, int32, int64, float32, float64:
+		return fmt.Sprint(val)
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
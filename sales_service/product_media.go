@@ -0,0 +1,301 @@
+package sales_service
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/zapscloud/golib-dbutils/db_common"
+	"github.com/zapscloud/golib-sales-repository/sales_common"
+	"github.com/zapscloud/golib-sales-repository/sales_repository"
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+const (
+	// Media gallery of the product [{media_id, sort_order, is_primary, alt_text, variant_id}]
+	// Items without variant_id are shared by all the variants
+	FLD_PRODUCT_GALLERY    = "media_gallery"
+	FLD_GALLERY_SORT_ORDER = "sort_order"
+	FLD_GALLERY_IS_PRIMARY = "is_primary"
+	FLD_GALLERY_ALT_TEXT   = "alt_text"
+	FLD_GALLERY_VARIANT_ID = "variant_id"
+	FLD_GALLERY_MEDIA      = "media"
+
+	// Media fields of the banners and blogs, single media or list of media
+	FLD_MEDIA_IDS = "media_ids"
+
+	// Media usage response fields
+	FLD_MEDIA_USAGE      = "usage"
+	FLD_MEDIA_IN_USE     = "in_use"
+	FLD_MEDIA_USED_BY    = "used_by"
+	FLD_MEDIA_USED_BY_ID = "id"
+
+	MEDIA_USED_BY_PRODUCT = "product"
+	MEDIA_USED_BY_BANNER  = "banner"
+	MEDIA_USED_BY_BLOG    = "blog"
+)
+
+// sortGallery - Sort the gallery items by the sort order, the given order is kept for the same sort order
+func sortGallery(items []utils.Map) []utils.Map {
+	sorted := make([]utils.Map, len(items))
+	copy(sorted, items)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return toInt(sorted[i][FLD_GALLERY_SORT_ORDER]) < toInt(sorted[j][FLD_GALLERY_SORT_ORDER])
+	})
+	return sorted
+}
+
+// normalizeGallery - Validate the gallery items and renumber them 1..n in the sort order
+// The media must exist, each media is listed once and each variant has at most one primary item.
+// When the shared items have no primary item the first one is the primary.
+func normalizeGallery(funcode string, daoMedia sales_repository.MediaDao, productId string, variantIds []string, items []utils.Map) ([]utils.Map, error) {
+	invalid := func(format string, args ...any) error {
+		return &utils.AppError{
+			ErrorCode:   funcode + "01",
+			ErrorMsg:    "Invalid media gallery",
+			ErrorDetail: fmt.Sprintf(format, args...)}
+	}
+
+	variants := map[string]bool{}
+	for _, variantId := range variantIds {
+		variants[variantId] = true
+	}
+
+	seen := map[string]bool{}
+	primary := map[string]bool{}
+	gallery := make([]utils.Map, 0, len(items))
+	for idx, item := range sortGallery(items) {
+		mediaId, _ := item[sales_common.FLD_MEDIA_ID].(string)
+		if len(mediaId) == 0 || seen[mediaId] {
+			return nil, invalid("Media %q is empty or listed more than once", mediaId)
+		}
+		seen[mediaId] = true
+
+		media, err := daoMedia.Get(mediaId)
+		if err != nil {
+			return nil, invalid("Media %s is not exist", mediaId)
+		}
+		if deleted, _ := media[db_common.FLD_IS_DELETED].(bool); deleted {
+			return nil, invalid("Media %s is deleted", mediaId)
+		}
+
+		variantId, _ := item[FLD_GALLERY_VARIANT_ID].(string)
+		if len(variantId) > 0 && !variants[variantId] {
+			return nil, invalid("Variant %s is not a variant of product %s", variantId, productId)
+		}
+
+		isPrimary, _ := item[FLD_GALLERY_IS_PRIMARY].(bool)
+		if isPrimary && primary[variantId] {
+			return nil, invalid("More than one primary media for %s", galleryOwner(productId, variantId))
+		}
+		primary[variantId] = primary[variantId] || isPrimary
+
+		altText, _ := item[FLD_GALLERY_ALT_TEXT].(string)
+		entry := utils.Map{
+			sales_common.FLD_MEDIA_ID: mediaId,
+			FLD_GALLERY_SORT_ORDER:    idx + 1,
+			FLD_GALLERY_IS_PRIMARY:    isPrimary,
+			FLD_GALLERY_ALT_TEXT:      altText,
+		}
+		if len(variantId) > 0 {
+			entry[FLD_GALLERY_VARIANT_ID] = variantId
+		}
+		gallery = append(gallery, entry)
+	}
+
+	if !primary[""] {
+		for _, entry := range gallery {
+			if _, ok := entry[FLD_GALLERY_VARIANT_ID]; !ok {
+				entry[FLD_GALLERY_IS_PRIMARY] = true
+				break
+			}
+		}
+	}
+	return gallery, nil
+}
+
+// galleryOwner - Name of the product or the variant for the messages
+func galleryOwner(productId string, variantId string) string {
+	if len(variantId) > 0 {
+		return "variant " + variantId
+	}
+	return "product " + productId
+}
+
+// variantGallery - Gallery of the variant, its own items first and then the shared items
+// The primary item of the variant replaces the shared primary item, empty variant gives the shared items only
+func variantGallery(items []utils.Map, variantId string) []utils.Map {
+	own := []utils.Map{}
+	shared := []utils.Map{}
+	for _, item := range sortGallery(items) {
+		itemVariantId, _ := item[FLD_GALLERY_VARIANT_ID].(string)
+		switch itemVariantId {
+		case "":
+			shared = append(shared, utils.CopyMap(item))
+		case variantId:
+			own = append(own, utils.CopyMap(item))
+		}
+	}
+
+	for _, item := range own {
+		if isPrimary, _ := item[FLD_GALLERY_IS_PRIMARY].(bool); isPrimary {
+			for _, sharedItem := range shared {
+				sharedItem[FLD_GALLERY_IS_PRIMARY] = false
+			}
+			break
+		}
+	}
+	return append(own, shared...)
+}
+
+// mediaUsage - Products, banners and blogs using the media, deleted records are not counted
+func mediaUsage(daoProduct listDao, daoBanner listDao, daoBlog listDao, mediaId string) ([]utils.Map, error) {
+	usage := []utils.Map{}
+
+	listdata, err := daoProduct.List(buildFilter(utils.Map{FLD_PRODUCT_GALLERY + "." + sales_common.FLD_MEDIA_ID: mediaId}), "", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, product := range activeRecords(listdata) {
+		usage = append(usage, utils.Map{FLD_MEDIA_USED_BY: MEDIA_USED_BY_PRODUCT, FLD_MEDIA_USED_BY_ID: productIdOf(product)})
+	}
+
+	filter := buildFilter(utils.Map{"$or": []utils.Map{
+		{sales_common.FLD_MEDIA_ID: mediaId},
+		{FLD_MEDIA_IDS: mediaId},
+	}})
+	listdata, err = daoBanner.List(filter, "", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, banner := range activeRecords(listdata) {
+		usage = append(usage, utils.Map{FLD_MEDIA_USED_BY: MEDIA_USED_BY_BANNER, FLD_MEDIA_USED_BY_ID: banner[sales_common.FLD_BANNER_ID]})
+	}
+
+	listdata, err = daoBlog.List(filter, "", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, blog := range activeRecords(listdata) {
+		usage = append(usage, utils.Map{FLD_MEDIA_USED_BY: MEDIA_USED_BY_BLOG, FLD_MEDIA_USED_BY_ID: blog[sales_common.FLD_BLOG_ID]})
+	}
+	return usage, nil
+}
+
+// mediaInUseError - Error for the media used by any product, banner or blog, nil when not used
+func mediaInUseError(funcode string, mediaId string, usage []utils.Map) error {
+	if len(usage) == 0 {
+		return nil
+	}
+
+	usedBy := make([]string, 0, len(usage))
+	for _, item := range usage {
+		usedBy = append(usedBy, fmt.Sprintf("%v %v", item[FLD_MEDIA_USED_BY], item[FLD_MEDIA_USED_BY_ID]))
+	}
+	return &utils.AppError{
+		ErrorCode:   funcode + "02",
+		ErrorMsg:    "Media in use",
+		ErrorDetail: fmt.Sprintf("Media %s is used by %s", mediaId, strings.Join(usedBy, ", "))}
+}
+
+// ProductGalleryService - Product Gallery Service structure
+type ProductGalleryService interface {
+	// SetGallery - Set the media gallery of the product [{media_id, sort_order, is_primary, alt_text, variant_id}]
+	SetGallery(productId string, items []utils.Map) ([]utils.Map, error)
+	// GetGallery - Get the media gallery of the product with the media details, for the variant when given
+	GetGallery(productId string, variantId string) ([]utils.Map, error)
+
+	EndService()
+}
+
+// productGalleryBaseService - Product Gallery Service structure, shares the product service
+type productGalleryBaseService struct {
+	*productBaseService
+}
+
+// NewProductGalleryService - Construct Product Gallery
+func NewProductGalleryService(props utils.Map) (ProductGalleryService, error) {
+
+	log.Printf("ProductGalleryService::Start ")
+	p, err := newProductBaseService(props)
+	if err != nil {
+		return nil, err
+	}
+	return &productGalleryBaseService{p}, nil
+}
+
+// SetGallery - Set the media gallery of the product [{media_id, sort_order, is_primary, alt_text, variant_id}]
+func (p *productGalleryBaseService) SetGallery(productId string, items []utils.Map) ([]utils.Map, error) {
+
+	log.Println("ProductGalleryService::SetGallery - Begin", productId, len(items))
+
+	_, err := p.daoProduct.Get(productId)
+	if err != nil {
+		return nil, err
+	}
+
+	gallery, err := p.validateGallery(productId, items)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = p.daoProduct.Update(productId, utils.Map{FLD_PRODUCT_GALLERY: gallery})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("ProductGalleryService::SetGallery - End ", len(gallery))
+	return gallery, nil
+}
+
+// GetGallery - Get the media gallery of the product with the media details, for the variant when given
+func (p *productGalleryBaseService) GetGallery(productId string, variantId string) ([]utils.Map, error) {
+
+	log.Println("ProductGalleryService::GetGallery - Begin", productId, variantId)
+
+	product, err := p.daoProduct.Get(productId)
+	if err != nil {
+		return nil, err
+	}
+
+	gallery := variantGallery(toMapSlice(product[FLD_PRODUCT_GALLERY]), variantId)
+	for _, item := range gallery {
+		mediaId, _ := item[sales_common.FLD_MEDIA_ID].(string)
+		media, err := p.daoMedia.Get(mediaId)
+		if err != nil {
+			return nil, err
+		}
+		item[FLD_GALLERY_MEDIA] = media
+	}
+
+	log.Println("ProductGalleryService::GetGallery - End ", len(gallery))
+	return gallery, nil
+}
+
+// validateGallery - Validate the gallery items against the media and the variants of the product
+func (p *productBaseService) validateGallery(productId string, items []utils.Map) ([]utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "21"
+
+	// Variants are loaded only when the items refer to them
+	hasVariants := false
+	for _, item := range items {
+		if variantId, _ := item[FLD_GALLERY_VARIANT_ID].(string); len(variantId) > 0 {
+			hasVariants = true
+			break
+		}
+	}
+
+	variantIds := []string{}
+	if hasVariants {
+		listdata, err := p.daoProduct.List(buildFilter(utils.Map{FLD_PRODUCT_PARENT_ID: productId}), "", 0, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, variant := range activeRecords(listdata) {
+			variantIds = append(variantIds, productIdOf(variant))
+		}
+	}
+
+	return normalizeGallery(funcode, p.daoMedia, productId, variantIds, items)
+}
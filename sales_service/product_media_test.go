@@ -0,0 +1,124 @@
+package sales_service
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zapscloud/golib-dbutils/db_common"
+	"github.com/zapscloud/golib-sales-repository/sales_common"
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+// testListDao - List of the fixed records, keeps the filter it was called with
+type testListDao struct {
+	records []utils.Map
+	err     error
+	filter  string
+}
+
+func (d *testListDao) List(filter string, sort string, skip int64, limit int64) (utils.Map, error) {
+	d.filter = filter
+	if d.err != nil {
+		return nil, d.err
+	}
+	return utils.Map{db_common.LIST_RESULT: d.records}, nil
+}
+
+func TestMediaUsage(t *testing.T) {
+	products := &testListDao{records: []utils.Map{
+		{sales_common.FLD_PRODUCT_ID: "prod1"},
+		{sales_common.FLD_PRODUCT_ID: "prod2", db_common.FLD_IS_DELETED: true},
+	}}
+	banners := &testListDao{records: []utils.Map{{sales_common.FLD_BANNER_ID: "ban1"}}}
+	blogs := &testListDao{records: []utils.Map{{sales_common.FLD_BLOG_ID: "blog1", db_common.FLD_IS_DELETED: true}}}
+
+	usage, err := mediaUsage(products, banners, blogs, "med1")
+	if err != nil {
+		t.Fatalf("mediaUsage returned %v", err)
+	}
+
+	// Deleted records are not counted
+	want := []utils.Map{
+		{FLD_MEDIA_USED_BY: MEDIA_USED_BY_PRODUCT, FLD_MEDIA_USED_BY_ID: "prod1"},
+		{FLD_MEDIA_USED_BY: MEDIA_USED_BY_BANNER, FLD_MEDIA_USED_BY_ID: "ban1"},
+	}
+	if !reflect.DeepEqual(usage, want) {
+		t.Errorf("mediaUsage = %v, want %v", usage, want)
+	}
+
+	// Products are matched on the gallery, banners and blogs on the single media or the media list
+	if !strings.Contains(products.filter, `"`+FLD_PRODUCT_GALLERY+"."+sales_common.FLD_MEDIA_ID+`":"med1"`) {
+		t.Errorf("product filter = %s", products.filter)
+	}
+	for _, filter := range []string{banners.filter, blogs.filter} {
+		if !strings.Contains(filter, `"`+sales_common.FLD_MEDIA_ID+`":"med1"`) || !strings.Contains(filter, `"`+FLD_MEDIA_IDS+`":"med1"`) {
+			t.Errorf("banner or blog filter = %s", filter)
+		}
+	}
+}
+
+func TestMediaUsageListError(t *testing.T) {
+	blogs := &testListDao{err: errors.New("list failed")}
+	if _, err := mediaUsage(&testListDao{}, &testListDao{}, blogs, "med1"); err == nil {
+		t.Error("mediaUsage ignored the list error, the media would be taken as not used")
+	}
+}
+
+func TestMediaInUseError(t *testing.T) {
+	if err := mediaInUseError("test", "med1", []utils.Map{}); err != nil {
+		t.Errorf("mediaInUseError for the media not used = %v", err)
+	}
+
+	usage := []utils.Map{
+		{FLD_MEDIA_USED_BY: MEDIA_USED_BY_PRODUCT, FLD_MEDIA_USED_BY_ID: "prod1"},
+		{FLD_MEDIA_USED_BY: MEDIA_USED_BY_BLOG, FLD_MEDIA_USED_BY_ID: "blog1"},
+	}
+	err := mediaInUseError("test", "med1", usage)
+	appErr, ok := err.(*utils.AppError)
+	if !ok || appErr.ErrorCode != "test02" || appErr.ErrorDetail != "Media med1 is used by product prod1, blog blog1" {
+		t.Errorf("mediaInUseError = %v", err)
+	}
+}
+
+func TestVariantGallery(t *testing.T) {
+	items := []utils.Map{
+		{sales_common.FLD_MEDIA_ID: "shared2", FLD_GALLERY_SORT_ORDER: 3},
+		{sales_common.FLD_MEDIA_ID: "shared1", FLD_GALLERY_SORT_ORDER: 1, FLD_GALLERY_IS_PRIMARY: true},
+		{sales_common.FLD_MEDIA_ID: "red1", FLD_GALLERY_SORT_ORDER: 2, FLD_GALLERY_VARIANT_ID: "red", FLD_GALLERY_IS_PRIMARY: true},
+		{sales_common.FLD_MEDIA_ID: "blue1", FLD_GALLERY_SORT_ORDER: 4, FLD_GALLERY_VARIANT_ID: "blue"},
+	}
+
+	mediaIds := func(gallery []utils.Map) []string {
+		ids := []string{}
+		for _, item := range gallery {
+			ids = append(ids, item[sales_common.FLD_MEDIA_ID].(string))
+		}
+		return ids
+	}
+
+	red := variantGallery(items, "red")
+	if got := mediaIds(red); !reflect.DeepEqual(got, []string{"red1", "shared1", "shared2"}) {
+		t.Errorf("gallery of red = %v", got)
+	}
+	// Primary item of the variant replaces the shared primary item, the stored items are not changed
+	if isPrimary, _ := red[1][FLD_GALLERY_IS_PRIMARY].(bool); isPrimary {
+		t.Error("shared primary item is still primary for the variant with its own primary item")
+	}
+	if isPrimary, _ := items[1][FLD_GALLERY_IS_PRIMARY].(bool); !isPrimary {
+		t.Error("variantGallery changed the stored items")
+	}
+
+	blue := variantGallery(items, "blue")
+	if got := mediaIds(blue); !reflect.DeepEqual(got, []string{"blue1", "shared1", "shared2"}) {
+		t.Errorf("gallery of blue = %v", got)
+	}
+	if isPrimary, _ := blue[1][FLD_GALLERY_IS_PRIMARY].(bool); !isPrimary {
+		t.Error("shared primary item is not primary for the variant without its own primary item")
+	}
+
+	if got := mediaIds(variantGallery(items, "")); !reflect.DeepEqual(got, []string{"shared1", "shared2"}) {
+		t.Errorf("shared gallery = %v", got)
+	}
+}
@@ -0,0 +1,503 @@
+package sales_service
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/zapscloud/golib-dbutils/db_common"
+	"github.com/zapscloud/golib-sales-repository/sales_common"
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+const (
+	// Price schedule of the product [{schedule_id, price, effective_from, effective_until, schedule_status, regular_price}]
+	// Schedule without effective_until changes the price for good
+	FLD_PRICE_SCHEDULE        = "price_schedule"
+	FLD_PRICE_SCHEDULE_ID     = "schedule_id"
+	FLD_PRICE_EFFECTIVE_FROM  = "effective_from"
+	FLD_PRICE_EFFECTIVE_UNTIL = "effective_until"
+	FLD_PRICE_SCHEDULE_STATUS = "schedule_status"
+	FLD_PRICE_REGULAR         = "regular_price"
+
+	PRICE_SCHEDULE_PENDING   = "pending"
+	PRICE_SCHEDULE_ACTIVE    = "active"
+	PRICE_SCHEDULE_APPLIED   = "applied"
+	PRICE_SCHEDULE_ENDED     = "ended"
+	PRICE_SCHEDULE_CANCELLED = "cancelled"
+
+	// Price history of the product [{price, previous_price, changed_at, change_source}]
+	FLD_PRICE_HISTORY       = "price_history"
+	FLD_PRICE_PREVIOUS      = "previous_price"
+	FLD_PRICE_CHANGED_AT    = "changed_at"
+	FLD_PRICE_CHANGE_SOURCE = "change_source"
+
+	PRICE_SOURCE_MANUAL       = "manual"
+	PRICE_SOURCE_SCHEDULE     = "schedule"
+	PRICE_SOURCE_SCHEDULE_END = "schedule_end"
+
+	// Was / now price display fields
+	FLD_PRICE_NOW            = "now_price"
+	FLD_PRICE_WAS            = "was_price"
+	FLD_PRICE_IS_REDUCED     = "is_reduced"
+	FLD_PRICE_SAVING         = "saving"
+	FLD_PRICE_SAVING_PERCENT = "saving_percent"
+
+	// Apply job response fields
+	FLD_PRICE_ACTIVATED = "activated"
+	FLD_PRICE_ENDED     = "ended"
+	FLD_PRICE_PRODUCTS  = "products"
+
+	// Days a price drop is shown as was / now
+	PRICE_WAS_WINDOW_DAYS = 30
+)
+
+// scheduleTime - Get the time of the schedule field, zero when not set
+func scheduleTime(schedule utils.Map, field string) time.Time {
+	value, _ := toTime(schedule[field])
+	return value
+}
+
+// appendPriceHistory - Add the price change to the history, unchanged price is not recorded
+func appendPriceHistory(history []utils.Map, previous float64, price float64, source string, at time.Time) []utils.Map {
+	if previous == price && len(history) > 0 {
+		return history
+	}
+	return append(history, utils.Map{
+		FLD_PRODUCT_PRICE:       price,
+		FLD_PRICE_PREVIOUS:      previous,
+		FLD_PRICE_CHANGED_AT:    at,
+		FLD_PRICE_CHANGE_SOURCE: source,
+	})
+}
+
+// applySchedules - Apply the schedules of the product due at the time
+// Returns the changed price, schedule and history of the product, and the count of the activated and ended schedules
+func applySchedules(product utils.Map, now time.Time) (utils.Map, int, int) {
+	price := toFloat(product[FLD_PRODUCT_PRICE])
+	history := toMapSlice(product[FLD_PRICE_HISTORY])
+
+	schedules := []utils.Map{}
+	for _, schedule := range toMapSlice(product[FLD_PRICE_SCHEDULE]) {
+		schedules = append(schedules, utils.CopyMap(schedule))
+	}
+
+	// End the schedules past their until time first, to restore the regular price
+	ended := 0
+	for _, schedule := range schedules {
+		status, _ := schedule[FLD_PRICE_SCHEDULE_STATUS].(string)
+		until := scheduleTime(schedule, FLD_PRICE_EFFECTIVE_UNTIL)
+		if until.IsZero() || now.Before(until) {
+			continue
+		}
+		switch status {
+		case PRICE_SCHEDULE_ACTIVE:
+			regular := toFloat(schedule[FLD_PRICE_REGULAR])
+			history = appendPriceHistory(history, price, regular, PRICE_SOURCE_SCHEDULE_END+":"+fmt.Sprint(schedule[FLD_PRICE_SCHEDULE_ID]), until)
+			price = regular
+			schedule[FLD_PRICE_SCHEDULE_STATUS] = PRICE_SCHEDULE_ENDED
+			ended++
+		case PRICE_SCHEDULE_PENDING:
+			// Never active, the whole window has passed
+			schedule[FLD_PRICE_SCHEDULE_STATUS] = PRICE_SCHEDULE_ENDED
+			ended++
+		}
+	}
+
+	// Start the due schedules in the order of their from time
+	sort.SliceStable(schedules, func(i, j int) bool {
+		return scheduleTime(schedules[i], FLD_PRICE_EFFECTIVE_FROM).Before(scheduleTime(schedules[j], FLD_PRICE_EFFECTIVE_FROM))
+	})
+	activated := 0
+	for _, schedule := range schedules {
+		status, _ := schedule[FLD_PRICE_SCHEDULE_STATUS].(string)
+		from := scheduleTime(schedule, FLD_PRICE_EFFECTIVE_FROM)
+		if status != PRICE_SCHEDULE_PENDING || now.Before(from) {
+			continue
+		}
+
+		newPrice := toFloat(schedule[FLD_PRODUCT_PRICE])
+		history = appendPriceHistory(history, price, newPrice, PRICE_SOURCE_SCHEDULE+":"+fmt.Sprint(schedule[FLD_PRICE_SCHEDULE_ID]), from)
+		schedule[FLD_PRICE_REGULAR] = price
+		schedule[FLD_PRICE_SCHEDULE_STATUS] = PRICE_SCHEDULE_APPLIED
+		if !scheduleTime(schedule, FLD_PRICE_EFFECTIVE_UNTIL).IsZero() {
+			schedule[FLD_PRICE_SCHEDULE_STATUS] = PRICE_SCHEDULE_ACTIVE
+		}
+		price = newPrice
+		activated++
+	}
+
+	updates := utils.Map{
+		FLD_PRODUCT_PRICE:  price,
+		FLD_PRICE_SCHEDULE: schedules,
+		FLD_PRICE_HISTORY:  history,
+	}
+	return updates, activated, ended
+}
+
+// addPriceSchedule - Add the schedule after the stored schedules of the product and apply the due ones
+func addPriceSchedule(product utils.Map, schedule utils.Map, now time.Time) utils.Map {
+	product = utils.CopyMap(product)
+	product[FLD_PRICE_SCHEDULE] = append(toMapSlice(product[FLD_PRICE_SCHEDULE]), schedule)
+	updates, _, _ := applySchedules(product, now)
+	return updates
+}
+
+// withScheduledPrice - Get the product with the price of the schedules due now, even before the apply job runs
+func withScheduledPrice(product utils.Map, now time.Time) utils.Map {
+	if len(toMapSlice(product[FLD_PRICE_SCHEDULE])) == 0 {
+		return product
+	}
+	updates, activated, ended := applySchedules(product, now)
+	if activated == 0 && ended == 0 {
+		return product
+	}
+	product = utils.CopyMap(product)
+	product[FLD_PRODUCT_PRICE] = updates[FLD_PRODUCT_PRICE]
+	return product
+}
+
+// priceAt - Price of the product at the time from the history, the current price when not known
+func priceAt(product utils.Map, at time.Time) float64 {
+	price := toFloat(product[FLD_PRODUCT_PRICE])
+	history := toMapSlice(product[FLD_PRICE_HISTORY])
+	// Walk back the changes made after the time
+	for idx := len(history) - 1; idx >= 0; idx-- {
+		changedAt := scheduleTime(history[idx], FLD_PRICE_CHANGED_AT)
+		if !changedAt.After(at) {
+			break
+		}
+		price = toFloat(history[idx][FLD_PRICE_PREVIOUS])
+	}
+	return price
+}
+
+// priceDisplay - Was / now price of the product
+// Was price is the regular price during a sale, else the price before a drop within the window
+func priceDisplay(product utils.Map, now time.Time) utils.Map {
+	current := toFloat(product[FLD_PRODUCT_PRICE])
+	was := 0.0
+
+	for _, schedule := range toMapSlice(product[FLD_PRICE_SCHEDULE]) {
+		if status, _ := schedule[FLD_PRICE_SCHEDULE_STATUS].(string); status == PRICE_SCHEDULE_ACTIVE {
+			was = toFloat(schedule[FLD_PRICE_REGULAR])
+		}
+	}
+	if was <= current {
+		was = 0
+		windowStart := now.AddDate(0, 0, -PRICE_WAS_WINDOW_DAYS)
+		for _, change := range toMapSlice(product[FLD_PRICE_HISTORY]) {
+			if scheduleTime(change, FLD_PRICE_CHANGED_AT).Before(windowStart) {
+				continue
+			}
+			was = math.Max(was, toFloat(change[FLD_PRICE_PREVIOUS]))
+		}
+	}
+
+	response := utils.Map{
+		sales_common.FLD_PRODUCT_ID: productIdOf(product),
+		FLD_PRICE_NOW:               roundAmount(current),
+		FLD_PRICE_IS_REDUCED:        false,
+	}
+	if was > current {
+		response[FLD_PRICE_WAS] = roundAmount(was)
+		response[FLD_PRICE_IS_REDUCED] = true
+		response[FLD_PRICE_SAVING] = roundAmount(was - current)
+		response[FLD_PRICE_SAVING_PERCENT] = roundAmount((was - current) * 100 / was)
+	}
+	return response
+}
+
+// ProductPriceScheduleService - Product Price Schedule Service structure
+type ProductPriceScheduleService interface {
+	// SchedulePrice - Schedule the price of the product from the time, until the time when not zero
+	SchedulePrice(productId string, price float64, effectiveFrom time.Time, effectiveUntil time.Time) (utils.Map, error)
+	// CancelPriceSchedule - Cancel the pending schedule, the active schedule is ended and the regular price restored
+	CancelPriceSchedule(productId string, scheduleId string) (utils.Map, error)
+	// ApplyPriceSchedules - Apply the due price schedules of all the products, run by the scheduler
+	ApplyPriceSchedules() (utils.Map, error)
+	// GetPriceHistory - Get the price changes of the product, latest first
+	GetPriceHistory(productId string) ([]utils.Map, error)
+	// GetPriceDisplay - Get the was / now price of the product
+	GetPriceDisplay(productId string) (utils.Map, error)
+	// PriceDrops - List the products priced lower now than at the time, for the price drop alerts
+	PriceDrops(since time.Time) ([]utils.Map, error)
+
+	EndService()
+}
+
+// productPriceScheduleBaseService - Product Price Schedule Service structure, shares the product service
+type productPriceScheduleBaseService struct {
+	*productBaseService
+}
+
+// NewProductPriceScheduleService - Construct Product Price Schedule
+func NewProductPriceScheduleService(props utils.Map) (ProductPriceScheduleService, error) {
+
+	log.Printf("ProductPriceScheduleService::Start ")
+	p, err := newProductBaseService(props)
+	if err != nil {
+		return nil, err
+	}
+	return &productPriceScheduleBaseService{p}, nil
+}
+
+// SchedulePrice - Schedule the price of the product from the time, until the time when not zero
+// The regular price is restored once the schedule ends
+func (p *productPriceScheduleBaseService) SchedulePrice(productId string, price float64, effectiveFrom time.Time, effectiveUntil time.Time) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "17"
+
+	log.Println("ProductPriceScheduleService::SchedulePrice - Begin", productId, price, effectiveFrom, effectiveUntil)
+
+	product, err := p.daoProduct.Get(productId)
+	if err != nil {
+		return nil, err
+	}
+	if isBundle(product) {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "01",
+			ErrorMsg:    "Invalid price schedule",
+			ErrorDetail: "Price of the bundle is derived from its components"}
+		return nil, err
+	}
+
+	now := time.Now()
+	if effectiveFrom.IsZero() {
+		effectiveFrom = now
+	}
+	if price < 0 || (!effectiveUntil.IsZero() && !effectiveUntil.After(effectiveFrom)) || (!effectiveUntil.IsZero() && !effectiveUntil.After(now)) {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "02",
+			ErrorMsg:    "Invalid price schedule",
+			ErrorDetail: "Price cannot be negative and effective until must be after effective from and now"}
+		return nil, err
+	}
+
+	// Schedules of the product cannot overlap, open ended schedule runs for ever
+	for _, schedule := range toMapSlice(product[FLD_PRICE_SCHEDULE]) {
+		status, _ := schedule[FLD_PRICE_SCHEDULE_STATUS].(string)
+		if status != PRICE_SCHEDULE_PENDING && status != PRICE_SCHEDULE_ACTIVE {
+			continue
+		}
+		from := scheduleTime(schedule, FLD_PRICE_EFFECTIVE_FROM)
+		until := scheduleTime(schedule, FLD_PRICE_EFFECTIVE_UNTIL)
+		if (until.IsZero() || effectiveFrom.Before(until)) && (effectiveUntil.IsZero() || from.Before(effectiveUntil)) {
+			err := &utils.AppError{
+				ErrorCode:   funcode + "03",
+				ErrorMsg:    "Overlapping price schedule",
+				ErrorDetail: fmt.Sprintf("Price schedule overlaps with the schedule %v", schedule[FLD_PRICE_SCHEDULE_ID])}
+			return nil, err
+		}
+	}
+
+	schedule := utils.Map{
+		FLD_PRICE_SCHEDULE_ID:     utils.GenerateUniqueId("psch"),
+		FLD_PRODUCT_PRICE:         price,
+		FLD_PRICE_EFFECTIVE_FROM:  effectiveFrom,
+		FLD_PRICE_SCHEDULE_STATUS: PRICE_SCHEDULE_PENDING,
+	}
+	if !effectiveUntil.IsZero() {
+		schedule[FLD_PRICE_EFFECTIVE_UNTIL] = effectiveUntil
+	}
+
+	updates := addPriceSchedule(product, schedule, now)
+	_, err = p.daoProduct.Update(productId, updates)
+	if err != nil {
+		return nil, err
+	}
+	p.refreshSearchIndex(productId)
+
+	for _, item := range toMapSlice(updates[FLD_PRICE_SCHEDULE]) {
+		if item[FLD_PRICE_SCHEDULE_ID] == schedule[FLD_PRICE_SCHEDULE_ID] {
+			schedule = item
+		}
+	}
+
+	log.Println("ProductPriceScheduleService::SchedulePrice - End ", schedule[FLD_PRICE_SCHEDULE_ID])
+	return schedule, nil
+}
+
+// CancelPriceSchedule - Cancel the pending schedule, the active schedule is ended and the regular price restored
+func (p *productPriceScheduleBaseService) CancelPriceSchedule(productId string, scheduleId string) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "17"
+
+	log.Println("ProductPriceScheduleService::CancelPriceSchedule - Begin", productId, scheduleId)
+
+	product, err := p.daoProduct.Get(productId)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	price := toFloat(product[FLD_PRODUCT_PRICE])
+	history := toMapSlice(product[FLD_PRICE_HISTORY])
+	schedules := toMapSlice(product[FLD_PRICE_SCHEDULE])
+	found := false
+	for idx, schedule := range schedules {
+		if id, _ := schedule[FLD_PRICE_SCHEDULE_ID].(string); id != scheduleId {
+			continue
+		}
+
+		schedule = utils.CopyMap(schedule)
+		switch schedule[FLD_PRICE_SCHEDULE_STATUS] {
+		case PRICE_SCHEDULE_PENDING:
+			schedule[FLD_PRICE_SCHEDULE_STATUS] = PRICE_SCHEDULE_CANCELLED
+		case PRICE_SCHEDULE_ACTIVE:
+			regular := toFloat(schedule[FLD_PRICE_REGULAR])
+			history = appendPriceHistory(history, price, regular, PRICE_SOURCE_SCHEDULE_END+":"+scheduleId, now)
+			price = regular
+			schedule[FLD_PRICE_SCHEDULE_STATUS] = PRICE_SCHEDULE_ENDED
+			schedule[FLD_PRICE_EFFECTIVE_UNTIL] = now
+		default:
+			err := &utils.AppError{
+				ErrorCode:   funcode + "04",
+				ErrorMsg:    "Invalid price schedule",
+				ErrorDetail: fmt.Sprintf("Price schedule %s is %v already", scheduleId, schedule[FLD_PRICE_SCHEDULE_STATUS])}
+			return nil, err
+		}
+		schedules[idx] = schedule
+		found = true
+	}
+	if !found {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "05",
+			ErrorMsg:    "Invalid price schedule",
+			ErrorDetail: fmt.Sprintf("Given price schedule %s is not exist", scheduleId)}
+		return nil, err
+	}
+
+	data, err := p.daoProduct.Update(productId, utils.Map{
+		FLD_PRODUCT_PRICE:  price,
+		FLD_PRICE_SCHEDULE: schedules,
+		FLD_PRICE_HISTORY:  history,
+	})
+	if err != nil {
+		return nil, err
+	}
+	p.refreshSearchIndex(productId)
+
+	log.Println("ProductPriceScheduleService::CancelPriceSchedule - End ")
+	return data, nil
+}
+
+// ApplyPriceSchedules - Apply the due price schedules of all the products, run by the scheduler
+func (p *productPriceScheduleBaseService) ApplyPriceSchedules() (utils.Map, error) {
+
+	log.Println("ProductPriceScheduleService::ApplyPriceSchedules - Begin")
+
+	filter := buildFilter(utils.Map{
+		FLD_PRICE_SCHEDULE + "." + FLD_PRICE_SCHEDULE_STATUS: utils.Map{"$in": []string{PRICE_SCHEDULE_PENDING, PRICE_SCHEDULE_ACTIVE}},
+	})
+	listdata, err := p.daoProduct.List(filter, "", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	activated, ended, products := 0, 0, 0
+	for _, product := range listResult(listdata) {
+		updates, productActivated, productEnded := applySchedules(product, now)
+		if productActivated == 0 && productEnded == 0 {
+			continue
+		}
+
+		productId := productIdOf(product)
+		_, err = p.daoProduct.Update(productId, updates)
+		if err != nil {
+			log.Println("ProductPriceScheduleService::ApplyPriceSchedules - Update failed", productId, err)
+			continue
+		}
+		p.refreshSearchIndex(productId)
+		activated += productActivated
+		ended += productEnded
+		products++
+	}
+
+	response := utils.Map{
+		FLD_PRICE_ACTIVATED: activated,
+		FLD_PRICE_ENDED:     ended,
+		FLD_PRICE_PRODUCTS:  products,
+	}
+
+	log.Println("ProductPriceScheduleService::ApplyPriceSchedules - End ", response)
+	return response, nil
+}
+
+// GetPriceHistory - Get the price changes of the product, latest first
+func (p *productPriceScheduleBaseService) GetPriceHistory(productId string) ([]utils.Map, error) {
+
+	log.Println("ProductPriceScheduleService::GetPriceHistory - Begin", productId)
+
+	product, err := p.daoProduct.Get(productId)
+	if err != nil {
+		return nil, err
+	}
+
+	history := toMapSlice(product[FLD_PRICE_HISTORY])
+	response := make([]utils.Map, 0, len(history))
+	for idx := len(history) - 1; idx >= 0; idx-- {
+		response = append(response, history[idx])
+	}
+
+	log.Println("ProductPriceScheduleService::GetPriceHistory - End ", len(response))
+	return response, nil
+}
+
+// GetPriceDisplay - Get the was / now price of the product
+func (p *productPriceScheduleBaseService) GetPriceDisplay(productId string) (utils.Map, error) {
+
+	log.Println("ProductPriceScheduleService::GetPriceDisplay - Begin", productId)
+
+	product, err := p.daoProduct.Get(productId)
+	if err != nil {
+		return nil, err
+	}
+
+	// Due schedules are shown before the apply job runs
+	now := time.Now()
+	updates, _, _ := applySchedules(product, now)
+	product = utils.CopyMap(product)
+	for key, value := range updates {
+		product[key] = value
+	}
+	response := priceDisplay(product, now)
+
+	log.Println("ProductPriceScheduleService::GetPriceDisplay - End ", response)
+	return response, nil
+}
+
+// PriceDrops - List the products priced lower now than at the time, for the price drop alerts
+func (p *productPriceScheduleBaseService) PriceDrops(since time.Time) ([]utils.Map, error) {
+
+	log.Println("ProductPriceScheduleService::PriceDrops - Begin", since)
+
+	listdata, err := p.daoProduct.List("", "", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	response := []utils.Map{}
+	for _, product := range listResult(listdata) {
+		if deleted, _ := product[db_common.FLD_IS_DELETED].(bool); deleted {
+			continue
+		}
+		was := priceAt(product, since)
+		now := toFloat(product[FLD_PRODUCT_PRICE])
+		if now >= was {
+			continue
+		}
+		response = append(response, utils.Map{
+			sales_common.FLD_PRODUCT_ID: productIdOf(product),
+			FLD_PRODUCT_NAME:            product[FLD_PRODUCT_NAME],
+			FLD_PRICE_WAS:               roundAmount(was),
+			FLD_PRICE_NOW:               roundAmount(now),
+			FLD_PRICE_SAVING:            roundAmount(was - now),
+			FLD_PRICE_SAVING_PERCENT:    roundAmount((was - now) * 100 / was),
+		})
+	}
+
+	log.Println("ProductPriceScheduleService::PriceDrops - End ", len(response))
+	return response, nil
+}
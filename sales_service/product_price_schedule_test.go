@@ -0,0 +1,74 @@
+package sales_service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+// storeUpdates - Round trip the product with the schedule updates set
+func storeUpdates(t *testing.T, product utils.Map, updates utils.Map) utils.Map {
+	t.Helper()
+	product = utils.CopyMap(product)
+	for field, value := range updates {
+		product[field] = value
+	}
+	return roundTrip(t, product)
+}
+
+func TestStoredPriceSchedules(t *testing.T) {
+	now := time.Now().Truncate(time.Millisecond)
+	product := roundTrip(t, utils.Map{FLD_PRODUCT_PRICE: 100})
+
+	sale := utils.Map{
+		FLD_PRICE_SCHEDULE_ID:     "psch_sale",
+		FLD_PRODUCT_PRICE:         80,
+		FLD_PRICE_EFFECTIVE_FROM:  now.Add(-time.Hour),
+		FLD_PRICE_EFFECTIVE_UNTIL: now.Add(time.Hour),
+		FLD_PRICE_SCHEDULE_STATUS: PRICE_SCHEDULE_PENDING,
+	}
+	product = storeUpdates(t, product, addPriceSchedule(product, sale, now))
+	if price := toFloat(product[FLD_PRODUCT_PRICE]); price != 80 {
+		t.Fatalf("price after the sale started = %g", price)
+	}
+
+	// The next schedule is added to the stored schedules
+	change := utils.Map{
+		FLD_PRICE_SCHEDULE_ID:     "psch_change",
+		FLD_PRODUCT_PRICE:         90,
+		FLD_PRICE_EFFECTIVE_FROM:  now.Add(2 * time.Hour),
+		FLD_PRICE_SCHEDULE_STATUS: PRICE_SCHEDULE_PENDING,
+	}
+	product = storeUpdates(t, product, addPriceSchedule(product, change, now))
+	schedules := toMapSlice(product[FLD_PRICE_SCHEDULE])
+	if len(schedules) != 2 {
+		t.Fatalf("stored schedules = %v", schedules)
+	}
+	if schedules[0][FLD_PRICE_SCHEDULE_STATUS] != PRICE_SCHEDULE_ACTIVE || schedules[1][FLD_PRICE_SCHEDULE_STATUS] != PRICE_SCHEDULE_PENDING {
+		t.Errorf("schedule status = %v, %v", schedules[0][FLD_PRICE_SCHEDULE_STATUS], schedules[1][FLD_PRICE_SCHEDULE_STATUS])
+	}
+	if until := scheduleTime(schedules[0], FLD_PRICE_EFFECTIVE_UNTIL); !until.Equal(now.Add(time.Hour)) {
+		t.Errorf("stored effective_until = %v", until)
+	}
+
+	// Sale ends and the price change starts from the stored dates
+	updates, activated, ended := applySchedules(product, now.Add(3*time.Hour))
+	if activated != 1 || ended != 1 {
+		t.Errorf("applySchedules activated %d ended %d", activated, ended)
+	}
+	product = storeUpdates(t, product, updates)
+	if price := toFloat(product[FLD_PRODUCT_PRICE]); price != 90 {
+		t.Errorf("price after the schedules = %g", price)
+	}
+
+	history := toMapSlice(product[FLD_PRICE_HISTORY])
+	if len(history) != 3 {
+		t.Fatalf("stored history = %v", history)
+	}
+	for at, want := range map[time.Duration]float64{-2 * time.Hour: 100, 0: 80, 90 * time.Minute: 100, 3 * time.Hour: 90} {
+		if price := priceAt(product, now.Add(at)); price != want {
+			t.Errorf("priceAt %v = %g, want %g", at, price, want)
+		}
+	}
+}
@@ -0,0 +1,744 @@
+package sales_service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/zapscloud/golib-dbutils/db_common"
+	"github.com/zapscloud/golib-sales-repository/sales_common"
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+const (
+	// Product fields of the publish workflow
+	// The product fields are the published version, the pending changes are kept in the draft until published.
+	// Products without is_published were created before the workflow and are treated as published.
+	FLD_PRODUCT_IS_PUBLISHED = "is_published"
+	FLD_PRODUCT_DRAFT        = "draft"
+	FLD_DRAFT_STATUS         = "draft_status"
+	FLD_DRAFT_UPDATED_AT     = "draft_updated_at"
+	FLD_DRAFT_REVIEWED_BY    = "reviewed_by"
+	FLD_DRAFT_REVIEWED_AT    = "reviewed_at"
+	FLD_DRAFT_REVIEW_NOTE    = "review_note"
+	FLD_DRAFT_ROLLBACK_OF    = "draft_rollback_of"
+
+	// Create request flag keeping the new product as a draft, the product is published on create by default
+	FLD_PRODUCT_SAVE_AS_DRAFT = "save_as_draft"
+
+	DRAFT_STATUS_DRAFT     = "draft"
+	DRAFT_STATUS_IN_REVIEW = "in_review"
+	DRAFT_STATUS_APPROVED  = "approved"
+	DRAFT_STATUS_REJECTED  = "rejected"
+	DRAFT_STATUS_PUBLISHED = "published"
+
+	// Published versions of the product [{version, data, changes, published_at, published_by, rollback_of}]
+	// Only the latest PRODUCT_VERSIONS_KEPT versions are kept in the product
+	FLD_PRODUCT_VERSION      = "published_version"
+	FLD_PRODUCT_VERSIONS     = "versions"
+	FLD_VERSION              = "version"
+	FLD_VERSION_DATA         = "data"
+	FLD_VERSION_CHANGES      = "changes"
+	FLD_VERSION_PUBLISHED_AT = "published_at"
+	FLD_VERSION_PUBLISHED_BY = "published_by"
+	FLD_VERSION_ROLLBACK_OF  = "rollback_of"
+
+	// Diff entry of the field {field, change, from, to}
+	FLD_DIFF_FIELD  = "field"
+	FLD_DIFF_CHANGE = "change"
+	FLD_DIFF_FROM   = "from"
+	FLD_DIFF_TO     = "to"
+
+	DIFF_ADDED   = "added"
+	DIFF_REMOVED = "removed"
+	DIFF_CHANGED = "changed"
+
+	// Versions kept in the product, the older ones are dropped
+	PRODUCT_VERSIONS_KEPT = 20
+)
+
+// productWorkflowFields - Fields changed by the publishing only
+var productWorkflowFields = map[string]bool{
+	FLD_PRODUCT_IS_PUBLISHED: true,
+	FLD_PRODUCT_DRAFT:        true,
+	FLD_DRAFT_STATUS:         true,
+	FLD_DRAFT_UPDATED_AT:     true,
+	FLD_DRAFT_REVIEWED_BY:    true,
+	FLD_DRAFT_REVIEWED_AT:    true,
+	FLD_DRAFT_REVIEW_NOTE:    true,
+	FLD_DRAFT_ROLLBACK_OF:    true,
+	FLD_PRODUCT_VERSION:      true,
+	FLD_PRODUCT_VERSIONS:     true,
+}
+
+// productManagedFields - Fields kept by the services, not part of the draft and the versions
+var productManagedFields = map[string]bool{
+	"_id":                        true,
+	sales_common.FLD_BUSINESS_ID: true,
+	sales_common.FLD_PRODUCT_ID:  true,
+	db_common.FLD_CREATED_AT:     true,
+	db_common.FLD_UPDATED_AT:     true,
+	db_common.FLD_IS_DELETED:     true,
+	FLD_PRODUCT_IS_PUBLISHED:     true,
+	FLD_PRODUCT_DRAFT:            true,
+	FLD_DRAFT_STATUS:             true,
+	FLD_DRAFT_UPDATED_AT:         true,
+	FLD_DRAFT_REVIEWED_BY:        true,
+	FLD_DRAFT_REVIEWED_AT:        true,
+	FLD_DRAFT_REVIEW_NOTE:        true,
+	FLD_DRAFT_ROLLBACK_OF:        true,
+	FLD_PRODUCT_VERSION:          true,
+	FLD_PRODUCT_VERSIONS:         true,
+	FLD_PRODUCT_PARENT_ID:        true,
+	FLD_PRODUCT_HAS_VARIANTS:     true,
+	FLD_PRODUCT_STOCK:            true,
+	FLD_STOCK_RESERVED:           true,
+	FLD_STOCK_AVAILABLE:          true,
+	FLD_STOCK_RESERVATIONS:       true,
+	FLD_PRODUCT_LOCATION_STOCK:   true,
+	FLD_STOCK_COUNTED:            true,
+	FLD_STOCK_COMMITS:            true,
+	FLD_LOCATION_COMMITS:         true,
+	FLD_PRICE_SCHEDULE:           true,
+	FLD_PRICE_HISTORY:            true,
+	FLD_PRODUCT_RELATED:          true,
+	FLD_RELATED_COMPUTED_AT:      true,
+	FLD_SEO_SLUG_HISTORY:         true,
+}
+
+// isProductPublished - Check whether the product is visible in the storefront
+func isProductPublished(product utils.Map) bool {
+	if deleted, _ := product[db_common.FLD_IS_DELETED].(bool); deleted {
+		return false
+	}
+	published, ok := product[FLD_PRODUCT_IS_PUBLISHED].(bool)
+	return !ok || published
+}
+
+// versionContent - Content fields of the product for the version, fields removed by a rollback are null
+func versionContent(product utils.Map) utils.Map {
+	content := utils.Map{}
+	for key, value := range product {
+		if !productManagedFields[key] && value != nil {
+			content[key] = value
+		}
+	}
+	return content
+}
+
+// publishedView - Product without the draft, the versions and the stock bookkeeping, for the storefront
+func publishedView(product utils.Map) utils.Map {
+	view := utils.CopyMap(product)
+	for _, key := range []string{FLD_PRODUCT_DRAFT, FLD_DRAFT_STATUS, FLD_DRAFT_UPDATED_AT, FLD_DRAFT_REVIEWED_BY,
+		FLD_DRAFT_REVIEWED_AT, FLD_DRAFT_REVIEW_NOTE, FLD_DRAFT_ROLLBACK_OF, FLD_PRODUCT_VERSIONS,
+		FLD_STOCK_RESERVATIONS, FLD_STOCK_COUNTED, FLD_STOCK_COMMITS, FLD_LOCATION_COMMITS} {
+		delete(view, key)
+	}
+	return view
+}
+
+// draftContent - Content of the product with the draft changes applied
+func draftContent(product utils.Map) utils.Map {
+	content := versionContent(product)
+	draft, _ := toMap(product[FLD_PRODUCT_DRAFT])
+	for key, value := range draft {
+		if value == nil {
+			delete(content, key)
+		} else {
+			content[key] = value
+		}
+	}
+	return content
+}
+
+// rollbackContent - Content of the version, the fields added after the version are set to null to remove them
+func rollbackContent(product utils.Map, version utils.Map) utils.Map {
+	data, _ := toMap(version[FLD_VERSION_DATA])
+	content := utils.CopyMap(data)
+	if content == nil {
+		content = utils.Map{}
+	}
+	for key := range versionContent(product) {
+		if _, ok := content[key]; !ok {
+			content[key] = nil
+		}
+	}
+	return content
+}
+
+// appendVersion - Add the version after the versions, only the latest PRODUCT_VERSIONS_KEPT are kept
+func appendVersion(versions []utils.Map, version utils.Map) []utils.Map {
+	versions = append(versions, version)
+	if len(versions) > PRODUCT_VERSIONS_KEPT {
+		versions = versions[len(versions)-PRODUCT_VERSIONS_KEPT:]
+	}
+	return versions
+}
+
+// normalizeValue - Normalize the value through JSON, so the stored and the given values compare equal
+func normalizeValue(value any) any {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	var normalized any
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return value
+	}
+	return normalized
+}
+
+// diffContent - Changed fields between the contents, sorted by the field
+func diffContent(from utils.Map, to utils.Map) []utils.Map {
+	fields := map[string]bool{}
+	for key := range from {
+		fields[key] = true
+	}
+	for key := range to {
+		fields[key] = true
+	}
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	changes := []utils.Map{}
+	for _, key := range keys {
+		fromValue, inFrom := from[key]
+		toValue, inTo := to[key]
+		switch {
+		case !inFrom:
+			changes = append(changes, utils.Map{FLD_DIFF_FIELD: key, FLD_DIFF_CHANGE: DIFF_ADDED, FLD_DIFF_TO: toValue})
+		case !inTo:
+			changes = append(changes, utils.Map{FLD_DIFF_FIELD: key, FLD_DIFF_CHANGE: DIFF_REMOVED, FLD_DIFF_FROM: fromValue})
+		case !reflect.DeepEqual(normalizeValue(fromValue), normalizeValue(toValue)):
+			changes = append(changes, utils.Map{FLD_DIFF_FIELD: key, FLD_DIFF_CHANGE: DIFF_CHANGED, FLD_DIFF_FROM: fromValue, FLD_DIFF_TO: toValue})
+		}
+	}
+	return changes
+}
+
+// productVersion - Get the published version of the product
+func productVersion(funcode string, product utils.Map, version int) (utils.Map, error) {
+	for _, item := range toMapSlice(product[FLD_PRODUCT_VERSIONS]) {
+		if toInt(item[FLD_VERSION]) == version {
+			return item, nil
+		}
+	}
+	err := &utils.AppError{
+		ErrorCode:   funcode + "04",
+		ErrorMsg:    "Invalid version",
+		ErrorDetail: fmt.Sprintf("Version %d of product %s is not exist or no longer kept", version, productIdOf(product))}
+	return nil, err
+}
+
+// draftResponse - Draft details with the changes against the published version
+func draftResponse(product utils.Map) utils.Map {
+	draft, _ := toMap(product[FLD_PRODUCT_DRAFT])
+	if draft == nil {
+		draft = utils.Map{}
+	}
+	return utils.Map{
+		sales_common.FLD_PRODUCT_ID: productIdOf(product),
+		FLD_PRODUCT_IS_PUBLISHED:    isProductPublished(product),
+		FLD_PRODUCT_VERSION:         toInt(product[FLD_PRODUCT_VERSION]),
+		FLD_DRAFT_STATUS:            product[FLD_DRAFT_STATUS],
+		FLD_DRAFT_UPDATED_AT:        product[FLD_DRAFT_UPDATED_AT],
+		FLD_DRAFT_REVIEWED_BY:       product[FLD_DRAFT_REVIEWED_BY],
+		FLD_DRAFT_REVIEWED_AT:       product[FLD_DRAFT_REVIEWED_AT],
+		FLD_DRAFT_REVIEW_NOTE:       product[FLD_DRAFT_REVIEW_NOTE],
+		FLD_DRAFT_ROLLBACK_OF:       toInt(product[FLD_DRAFT_ROLLBACK_OF]),
+		FLD_PRODUCT_DRAFT:           draft,
+		FLD_VERSION_DATA:            draftContent(product),
+		FLD_VERSION_CHANGES:         diffContent(versionContent(product), draftContent(product)),
+	}
+}
+
+// ProductPublishingService - Product Publishing Service structure
+type ProductPublishingService interface {
+	// SaveDraft - Save the changes of the product in the draft, the published version is not changed
+	SaveDraft(productId string, changes utils.Map) (utils.Map, error)
+	// GetDraft - Get the draft of the product with its changes against the published version
+	GetDraft(productId string) (utils.Map, error)
+	// DiscardDraft - Discard the pending changes of the product
+	DiscardDraft(productId string) (utils.Map, error)
+	// SubmitForReview - Submit the draft of the product for the review
+	SubmitForReview(productId string) (utils.Map, error)
+	// ReviewDraft - Approve or reject the draft under review
+	ReviewDraft(productId string, approve bool, reviewerId string, note string) (utils.Map, error)
+	// Publish - Publish the approved draft of the product as the new version
+	Publish(productId string, publisherId string) (utils.Map, error)
+	// ListVersions - List the published versions of the product, latest first
+	ListVersions(productId string) ([]utils.Map, error)
+	// DiffVersions - Changed fields from the version to the other version, toVersion 0 compares with the draft
+	DiffVersions(productId string, fromVersion int, toVersion int) ([]utils.Map, error)
+	// Rollback - Put the content of the earlier version in the draft, it is published after the review
+	Rollback(productId string, version int, requesterId string) (utils.Map, error)
+	// GetPublished - Get the published product for the storefront
+	GetPublished(productId string) (utils.Map, error)
+	// ListPublished - List the published products for the storefront
+	ListPublished(filter string, sort string, skip int64, limit int64) (utils.Map, error)
+
+	EndService()
+}
+
+// productPublishingBaseService - Product Publishing Service structure, shares the product service
+type productPublishingBaseService struct {
+	*productBaseService
+}
+
+// NewProductPublishingService - Construct Product Publishing
+func NewProductPublishingService(props utils.Map) (ProductPublishingService, error) {
+
+	log.Printf("ProductPublishingService::Start ")
+	p, err := newProductBaseService(props)
+	if err != nil {
+		return nil, err
+	}
+	return &productPublishingBaseService{p}, nil
+}
+
+// SaveDraft - Save the changes of the product in the draft, the published version is not changed
+// Changing the draft under review or approved moves it back to draft
+func (p *productPublishingBaseService) SaveDraft(productId string, changes utils.Map) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "19"
+
+	log.Println("ProductPublishingService::SaveDraft - Begin", productId)
+
+	for key := range changes {
+		if productManagedFields[key] {
+			err := &utils.AppError{
+				ErrorCode:   funcode + "01",
+				ErrorMsg:    "Invalid draft",
+				ErrorDetail: fmt.Sprintf("Field %s is not editable in the draft", key)}
+			return nil, err
+		}
+	}
+
+	product, err := p.daoProduct.Get(productId)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := p.saveDraft(product, changes)
+	if err != nil {
+		return response, err
+	}
+
+	log.Println("ProductPublishingService::SaveDraft - End ")
+	return response, nil
+}
+
+// saveDraft - Validate the changes and save them in the draft of the product
+func (p *productBaseService) saveDraft(product utils.Map, changes utils.Map) (utils.Map, error) {
+	productId := productIdOf(product)
+
+	err := p.unitConverter.validateProductUnits(changes)
+	if err != nil {
+		return nil, err
+	}
+	fieldErrors, err := p.checkAttributes(changes, true)
+	if err != nil {
+		return fieldErrors, err
+	}
+
+	// Each field is set in the draft, so the concurrent saves of the other fields are kept
+	updates := utils.Map{
+		FLD_DRAFT_STATUS:      DRAFT_STATUS_DRAFT,
+		FLD_DRAFT_UPDATED_AT:  time.Now(),
+		FLD_DRAFT_REVIEWED_BY: "",
+		FLD_DRAFT_REVIEWED_AT: nil,
+	}
+	for key, value := range changes {
+		updates[FLD_PRODUCT_DRAFT+"."+key] = value
+	}
+	_, err = p.daoProduct.Update(productId, updates)
+	if err != nil {
+		return nil, err
+	}
+
+	draft, _ := toMap(product[FLD_PRODUCT_DRAFT])
+	draft = utils.CopyMap(draft)
+	if draft == nil {
+		draft = utils.Map{}
+	}
+	for key, value := range changes {
+		draft[key] = value
+	}
+	for key, value := range updates {
+		product[key] = value
+	}
+	product[FLD_PRODUCT_DRAFT] = draft
+	return draftResponse(product), nil
+}
+
+// GetDraft - Get the draft of the product with its changes against the published version
+func (p *productPublishingBaseService) GetDraft(productId string) (utils.Map, error) {
+
+	log.Println("ProductPublishingService::GetDraft - Begin", productId)
+
+	product, err := p.daoProduct.Get(productId)
+	if err != nil {
+		return nil, err
+	}
+	response := draftResponse(product)
+
+	log.Println("ProductPublishingService::GetDraft - End ", response[FLD_DRAFT_STATUS])
+	return response, nil
+}
+
+// DiscardDraft - Discard the pending changes of the product
+func (p *productPublishingBaseService) DiscardDraft(productId string) (utils.Map, error) {
+
+	log.Println("ProductPublishingService::DiscardDraft - Begin", productId)
+
+	product, err := p.daoProduct.Get(productId)
+	if err != nil {
+		return nil, err
+	}
+
+	status := DRAFT_STATUS_PUBLISHED
+	if !isProductPublished(product) {
+		status = DRAFT_STATUS_DRAFT
+	}
+	updates := utils.Map{
+		FLD_PRODUCT_DRAFT:     utils.Map{},
+		FLD_DRAFT_STATUS:      status,
+		FLD_DRAFT_UPDATED_AT:  time.Now(),
+		FLD_DRAFT_ROLLBACK_OF: 0,
+	}
+	_, err = p.daoProduct.Update(productId, updates)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range updates {
+		product[key] = value
+	}
+
+	log.Println("ProductPublishingService::DiscardDraft - End ")
+	return draftResponse(product), nil
+}
+
+// SubmitForReview - Submit the draft of the product for the review
+// The product not published yet is submitted with its content even without any draft changes
+func (p *productPublishingBaseService) SubmitForReview(productId string) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "19"
+
+	log.Println("ProductPublishingService::SubmitForReview - Begin", productId)
+
+	product, err := p.daoProduct.Get(productId)
+	if err != nil {
+		return nil, err
+	}
+
+	status, _ := product[FLD_DRAFT_STATUS].(string)
+	if status == DRAFT_STATUS_IN_REVIEW || status == DRAFT_STATUS_APPROVED {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "02",
+			ErrorMsg:    "Invalid draft status",
+			ErrorDetail: fmt.Sprintf("Draft of product %s is %s already", productId, status)}
+		return nil, err
+	}
+	draft, _ := toMap(product[FLD_PRODUCT_DRAFT])
+	if len(draft) == 0 && isProductPublished(product) {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "03",
+			ErrorMsg:    "No draft changes",
+			ErrorDetail: fmt.Sprintf("Product %s has no changes to review", productId)}
+		return nil, err
+	}
+
+	updates := utils.Map{FLD_DRAFT_STATUS: DRAFT_STATUS_IN_REVIEW}
+	_, err = p.daoProduct.Update(productId, updates)
+	if err != nil {
+		return nil, err
+	}
+	product[FLD_DRAFT_STATUS] = DRAFT_STATUS_IN_REVIEW
+
+	log.Println("ProductPublishingService::SubmitForReview - End ")
+	return draftResponse(product), nil
+}
+
+// ReviewDraft - Approve or reject the draft under review, the rejected draft goes back for the changes
+func (p *productPublishingBaseService) ReviewDraft(productId string, approve bool, reviewerId string, note string) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "19"
+
+	log.Println("ProductPublishingService::ReviewDraft - Begin", productId, approve, reviewerId)
+
+	product, err := p.daoProduct.Get(productId)
+	if err != nil {
+		return nil, err
+	}
+
+	if status, _ := product[FLD_DRAFT_STATUS].(string); status != DRAFT_STATUS_IN_REVIEW {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "02",
+			ErrorMsg:    "Invalid draft status",
+			ErrorDetail: fmt.Sprintf("Draft of product %s is not in review", productId)}
+		return nil, err
+	}
+
+	status := DRAFT_STATUS_REJECTED
+	if approve {
+		status = DRAFT_STATUS_APPROVED
+	}
+	updates := utils.Map{
+		FLD_DRAFT_STATUS:      status,
+		FLD_DRAFT_REVIEWED_BY: reviewerId,
+		FLD_DRAFT_REVIEWED_AT: time.Now(),
+		FLD_DRAFT_REVIEW_NOTE: note,
+	}
+	_, err = p.daoProduct.Update(productId, updates)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range updates {
+		product[key] = value
+	}
+
+	log.Println("ProductPublishingService::ReviewDraft - End ", status)
+	return draftResponse(product), nil
+}
+
+// Publish - Publish the approved draft of the product as the new version
+func (p *productPublishingBaseService) Publish(productId string, publisherId string) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "19"
+
+	log.Println("ProductPublishingService::Publish - Begin", productId, publisherId)
+
+	product, err := p.daoProduct.Get(productId)
+	if err != nil {
+		return nil, err
+	}
+
+	if status, _ := product[FLD_DRAFT_STATUS].(string); status != DRAFT_STATUS_APPROVED {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "02",
+			ErrorMsg:    "Invalid draft status",
+			ErrorDetail: fmt.Sprintf("Draft of product %s is not approved", productId)}
+		return nil, err
+	}
+
+	// Draft of a rollback records the rolled back version in the new version
+	draft, _ := toMap(product[FLD_PRODUCT_DRAFT])
+	version, err := p.publishContent(product, draft, publisherId, toInt(product[FLD_DRAFT_ROLLBACK_OF]))
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("ProductPublishingService::Publish - End ", version[FLD_VERSION])
+	return version, nil
+}
+
+// ListVersions - List the published versions of the product, latest first
+func (p *productPublishingBaseService) ListVersions(productId string) ([]utils.Map, error) {
+
+	log.Println("ProductPublishingService::ListVersions - Begin", productId)
+
+	product, err := p.daoProduct.Get(productId)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := toMapSlice(product[FLD_PRODUCT_VERSIONS])
+	response := make([]utils.Map, 0, len(versions))
+	for idx := len(versions) - 1; idx >= 0; idx-- {
+		response = append(response, versions[idx])
+	}
+
+	log.Println("ProductPublishingService::ListVersions - End ", len(response))
+	return response, nil
+}
+
+// DiffVersions - Changed fields from the version to the other version, toVersion 0 compares with the draft
+func (p *productPublishingBaseService) DiffVersions(productId string, fromVersion int, toVersion int) ([]utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "19"
+
+	log.Println("ProductPublishingService::DiffVersions - Begin", productId, fromVersion, toVersion)
+
+	product, err := p.daoProduct.Get(productId)
+	if err != nil {
+		return nil, err
+	}
+
+	from, err := productVersion(funcode, product, fromVersion)
+	if err != nil {
+		return nil, err
+	}
+	fromData, _ := toMap(from[FLD_VERSION_DATA])
+
+	toData := draftContent(product)
+	if toVersion > 0 {
+		to, err := productVersion(funcode, product, toVersion)
+		if err != nil {
+			return nil, err
+		}
+		toData, _ = toMap(to[FLD_VERSION_DATA])
+	}
+	response := diffContent(fromData, toData)
+
+	log.Println("ProductPublishingService::DiffVersions - End ", len(response))
+	return response, nil
+}
+
+// Rollback - Put the content of the earlier version in the draft, fields added after the version are removed
+// The rollback replaces the pending draft and goes through the review like any other draft,
+// it is published as the new version recording the version rolled back to
+func (p *productPublishingBaseService) Rollback(productId string, version int, requesterId string) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "19"
+
+	log.Println("ProductPublishingService::Rollback - Begin", productId, version, requesterId)
+
+	product, err := p.daoProduct.Get(productId)
+	if err != nil {
+		return nil, err
+	}
+
+	earlier, err := productVersion(funcode, product, version)
+	if err != nil {
+		return nil, err
+	}
+
+	updates := utils.Map{
+		FLD_PRODUCT_DRAFT:     rollbackContent(product, earlier),
+		FLD_DRAFT_STATUS:      DRAFT_STATUS_DRAFT,
+		FLD_DRAFT_UPDATED_AT:  time.Now(),
+		FLD_DRAFT_REVIEWED_BY: "",
+		FLD_DRAFT_REVIEWED_AT: nil,
+		FLD_DRAFT_ROLLBACK_OF: version,
+	}
+	_, err = p.daoProduct.Update(productId, updates)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range updates {
+		product[key] = value
+	}
+
+	log.Println("ProductPublishingService::Rollback - End ", version)
+	return draftResponse(product), nil
+}
+
+// GetPublished - Get the published product for the storefront, without the draft
+func (p *productPublishingBaseService) GetPublished(productId string) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "19"
+
+	log.Println("ProductPublishingService::GetPublished - Begin", productId)
+
+	product, err := p.daoProduct.Get(productId)
+	if err != nil {
+		return nil, err
+	}
+	if !isProductPublished(product) {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "05",
+			ErrorMsg:    "Product not published",
+			ErrorDetail: fmt.Sprintf("Product %s is not published", productId)}
+		return nil, err
+	}
+
+	log.Println("ProductPublishingService::GetPublished - End ")
+	return publishedView(product), nil
+}
+
+// ListPublished - List the published products for the storefront, without the drafts
+func (p *productPublishingBaseService) ListPublished(filter string, sort string, skip int64, limit int64) (utils.Map, error) {
+
+	log.Println("ProductPublishingService::ListPublished - Begin", filter)
+
+	// Same as the List of the products, which lists only the published products
+	listdata, err := p.List(filter, sort, skip, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("ProductPublishingService::ListPublished - End ", len(listResult(listdata)))
+	return listdata, nil
+}
+
+// listPublished - List the published content of the published products, not priced for the customer
+func (p *productBaseService) listPublished(filter string, sort string, skip int64, limit int64) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "19"
+
+	query := utils.Map{}
+	if len(filter) > 0 {
+		if err := json.Unmarshal([]byte(filter), &query); err != nil {
+			err := &utils.AppError{
+				ErrorCode:   funcode + "06",
+				ErrorMsg:    "Invalid filter",
+				ErrorDetail: err.Error()}
+			return nil, err
+		}
+	}
+	query[FLD_PRODUCT_IS_PUBLISHED] = utils.Map{"$ne": false}
+	query[db_common.FLD_IS_DELETED] = utils.Map{"$ne": true}
+
+	listdata, err := p.daoProduct.List(buildFilter(query), sort, skip, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	products := listResult(listdata)
+	for idx, product := range products {
+		products[idx] = publishedView(product)
+	}
+	listdata[db_common.LIST_RESULT] = products
+	return listdata, nil
+}
+
+// publishContent - Apply the content to the product and record it as the new version
+// The content goes through updateProduct for the validations, the price history and the search index
+func (p *productBaseService) publishContent(product utils.Map, content utils.Map, publisherId string, rollbackOf int) (utils.Map, error) {
+	productId := productIdOf(product)
+
+	if len(content) > 0 {
+		_, err := p.updateProduct(productId, utils.CopyMap(content))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	current, err := p.daoProduct.Get(productId)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := toMapSlice(current[FLD_PRODUCT_VERSIONS])
+	data := versionContent(current)
+	previous := utils.Map{}
+	if len(versions) > 0 {
+		previous, _ = toMap(versions[len(versions)-1][FLD_VERSION_DATA])
+	}
+
+	number := toInt(current[FLD_PRODUCT_VERSION]) + 1
+	version := utils.Map{
+		FLD_VERSION:              number,
+		FLD_VERSION_DATA:         data,
+		FLD_VERSION_CHANGES:      diffContent(previous, data),
+		FLD_VERSION_PUBLISHED_AT: time.Now(),
+		FLD_VERSION_PUBLISHED_BY: publisherId,
+	}
+	updates := utils.Map{
+		FLD_PRODUCT_IS_PUBLISHED: true,
+		FLD_PRODUCT_VERSION:      number,
+		FLD_PRODUCT_VERSIONS:     appendVersion(versions, version),
+	}
+	if rollbackOf > 0 {
+		version[FLD_VERSION_ROLLBACK_OF] = rollbackOf
+	}
+	updates[FLD_PRODUCT_DRAFT] = utils.Map{}
+	updates[FLD_DRAFT_STATUS] = DRAFT_STATUS_PUBLISHED
+	updates[FLD_DRAFT_ROLLBACK_OF] = 0
+
+	_, err = p.daoProduct.Update(productId, updates)
+	if err != nil {
+		return nil, err
+	}
+	p.refreshSearchIndex(productId)
+
+	return version, nil
+}
@@ -0,0 +1,66 @@
+package sales_service
+
+import (
+	"testing"
+
+	"github.com/zapscloud/golib-sales-repository/sales_common"
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+func TestRollbackContent(t *testing.T) {
+	product := roundTrip(t, utils.Map{
+		sales_common.FLD_PRODUCT_ID: "prod_a",
+		FLD_PRODUCT_NAME:            "Pillow v2",
+		FLD_PRODUCT_DESCRIPTION:     "Added in v2",
+		FLD_PRODUCT_STOCK:           5,
+		FLD_PRODUCT_VERSIONS: []utils.Map{
+			{FLD_VERSION: 1, FLD_VERSION_DATA: utils.Map{FLD_PRODUCT_NAME: "Pillow"}},
+		},
+	})
+
+	earlier, err := productVersion("", product, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := rollbackContent(product, earlier)
+	if content[FLD_PRODUCT_NAME] != "Pillow" {
+		t.Errorf("rolled back name = %v", content[FLD_PRODUCT_NAME])
+	}
+	if value, ok := content[FLD_PRODUCT_DESCRIPTION]; !ok || value != nil {
+		t.Errorf("field added after the version is not removed: %v", content)
+	}
+	if _, ok := content[FLD_PRODUCT_STOCK]; ok {
+		t.Error("managed field is part of the rollback")
+	}
+
+	// Field set to null by the rollback is no longer content
+	product[FLD_PRODUCT_DESCRIPTION] = nil
+	if _, ok := versionContent(roundTrip(t, product))[FLD_PRODUCT_DESCRIPTION]; ok {
+		t.Error("removed field is still in the version content")
+	}
+}
+
+func TestAppendVersionKeepsLatest(t *testing.T) {
+	versions := []utils.Map{}
+	for number := 1; number <= PRODUCT_VERSIONS_KEPT+5; number++ {
+		versions = appendVersion(versions, utils.Map{FLD_VERSION: number})
+	}
+	if len(versions) != PRODUCT_VERSIONS_KEPT {
+		t.Fatalf("kept %d versions", len(versions))
+	}
+	if first := toInt(versions[0][FLD_VERSION]); first != 6 {
+		t.Errorf("oldest kept version = %d", first)
+	}
+}
+
+func TestPublishedViewHidesInternals(t *testing.T) {
+	view := publishedView(utils.Map{
+		FLD_PRODUCT_NAME:       "Pillow",
+		FLD_PRODUCT_DRAFT:      utils.Map{FLD_PRODUCT_NAME: "Pillow v2"},
+		FLD_PRODUCT_VERSIONS:   []utils.Map{},
+		FLD_STOCK_RESERVATIONS: utils.Map{},
+	})
+	if len(view) != 1 || view[FLD_PRODUCT_NAME] != "Pillow" {
+		t.Errorf("publishedView = %v", view)
+	}
+}
@@ -26,6 +26,10 @@ type Product_unitService interface {
 	Create(indata utils.Map) (utils.Map, error)
 	Update(product_unit_id string, indata utils.Map) (utils.Map, error)
 	Delete(product_unit_id string, delete_permanent bool) error
+	// Convert - Convert the quantity between the units, productId is optional for the product conversions
+	Convert(quantity float64, fromUnitId string, toUnitId string, productId string) (utils.Map, error)
+	// ToBaseQuantity - Convert the quantity in the unit to the base unit of the product with its price
+	ToBaseQuantity(productId string, quantity float64, unitId string) (utils.Map, error)
 
 	BeginTransaction()
 	CommitTransaction()
@@ -34,12 +38,14 @@ type Product_unitService interface {
 	EndService()
 }
 
-// LoyaltyCardService - Product_unit Service structure
+// Product_unitBaseService - Product_unit Service structure
 type Product_unitBaseService struct {
 	dbRegion db_utils.DatabaseService
 	db_utils.DatabaseService
 	daoProduct_unit sales_repository.Product_unitDao
+	daoProduct      sales_repository.ProductDao
 	daoBusiness     platform_repository.BusinessDao
+	unitConverter   *unitConverter
 	child           Product_unitService
 	businessID      string
 }
@@ -95,6 +101,8 @@ func (p *Product_unitBaseService) EndService() {
 func (p *Product_unitBaseService) initializeService() {
 	log.Printf("Product_unitService:: GetBusinessDao ")
 	p.daoProduct_unit = sales_repository.NewProduct_unitDao(p.dbRegion.GetClient(), p.businessID)
+	p.daoProduct = sales_repository.NewProductDao(p.dbRegion.GetClient(), p.businessID)
+	p.unitConverter = &unitConverter{daoProductUnit: p.daoProduct_unit}
 	p.daoBusiness = platform_repository.NewBusinessDao(p.GetClient())
 }
 
@@ -150,6 +158,11 @@ func (p *Product_unitBaseService) Create(indata utils.Map) (utils.Map, error) {
 		return indata, err
 	}
 
+	err = p.validateUnit(dataval.(string), indata)
+	if err != nil {
+		return indata, err
+	}
+
 	insertResult, err := p.daoProduct_unit.Create(indata)
 	if err != nil {
 		return indata, err
@@ -168,6 +181,11 @@ func (p *Product_unitBaseService) Update(product_unit_id string, indata utils.Ma
 		return data, err
 	}
 
+	err = p.validateUnit(product_unit_id, indata)
+	if err != nil {
+		return nil, err
+	}
+
 	data, err = p.daoProduct_unit.Update(product_unit_id, indata)
 	log.Println("Product_unitService::Update - End ")
 	return data, err
@@ -197,6 +215,80 @@ func (p *Product_unitBaseService) Delete(product_unit_id string, delete_permanen
 	return nil
 }
 
+// Convert - Convert the quantity between the units, productId is optional for the product conversions
+func (p *Product_unitBaseService) Convert(quantity float64, fromUnitId string, toUnitId string, productId string) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "16"
+
+	log.Println("Product_unitService::Convert - Begin", quantity, fromUnitId, toUnitId, productId)
+
+	product := utils.Map{}
+	if len(productId) > 0 {
+		var err error
+		product, err = p.daoProduct.Get(productId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	converted, err := p.unitConverter.convert(funcode, product, quantity, fromUnitId, toUnitId)
+	if err != nil {
+		return nil, err
+	}
+
+	response := utils.Map{
+		FLD_UNIT_QUANTITY:  quantity,
+		FLD_UNIT_FROM:      fromUnitId,
+		FLD_UNIT_TO:        toUnitId,
+		FLD_UNIT_CONVERTED: converted,
+	}
+
+	log.Println("Product_unitService::Convert - End ", converted)
+	return response, nil
+}
+
+// ToBaseQuantity - Convert the quantity in the unit to the base unit of the product with its price
+// Price of the product is per base unit
+func (p *Product_unitBaseService) ToBaseQuantity(productId string, quantity float64, unitId string) (utils.Map, error) {
+
+	log.Println("Product_unitService::ToBaseQuantity - Begin", productId, quantity, unitId)
+
+	product, err := getPricedProduct(p.daoProduct, productId)
+	if err != nil {
+		return nil, err
+	}
+
+	baseQuantity, baseUnitId, err := p.unitConverter.toBaseQuantity(product, quantity, unitId)
+	if err != nil {
+		return nil, err
+	}
+
+	unitPrice := toFloat(product[FLD_PRODUCT_PRICE])
+	response := utils.Map{
+		sales_common.FLD_PRODUCT_ID:      productId,
+		sales_common.FLD_PRODUCT_UNIT_ID: unitId,
+		FLD_UNIT_QUANTITY:                quantity,
+		FLD_PRODUCT_BASE_UNIT:            baseUnitId,
+		FLD_UNIT_BASE_QUANTITY:           baseQuantity,
+		FLD_UNIT_PRICE:                   roundAmount(unitPrice),
+		FLD_TOTAL_PRICE:                  roundAmount(unitPrice * baseQuantity),
+	}
+
+	log.Println("Product_unitService::ToBaseQuantity - End ", baseQuantity)
+	return response, nil
+}
+
+// validateUnit - Validate the rounding and the conversions of the unit
+func (p *Product_unitBaseService) validateUnit(product_unit_id string, indata utils.Map) error {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "16"
+
+	err := validateUnitRounding(funcode, indata)
+	if err != nil {
+		return err
+	}
+
+	return p.unitConverter.validateConversions(funcode, toMapSlice(indata[FLD_UNIT_CONVERSIONS]), product_unit_id)
+}
+
 func (p *Product_unitBaseService) errorReturn(err error) (Product_unitService, error) {
 	// Close the Database Connection
 	p.EndService()
@@ -0,0 +1,306 @@
+package sales_service
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/zapscloud/golib-dbutils/db_common"
+	"github.com/zapscloud/golib-sales-repository/sales_common"
+	"github.com/zapscloud/golib-sales-repository/sales_repository"
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+const (
+	// Conversions of the unit [{to_unit_id, factor}], 1 unit is factor of the to unit
+	// Product keeps its own conversions [{from_unit_id, to_unit_id, factor}], e.g. pieces in a box
+	FLD_UNIT_CONVERSIONS = "unit_conversions"
+	FLD_UNIT_FROM        = "from_unit_id"
+	FLD_UNIT_TO          = "to_unit_id"
+	FLD_UNIT_FACTOR      = "factor"
+
+	// Rounding of the quantity in the unit, step 0 keeps the quantity as is
+	FLD_UNIT_ROUNDING_MODE = "rounding_mode"
+	FLD_UNIT_ROUNDING_STEP = "rounding_step"
+
+	UNIT_ROUNDING_UP      = "up"
+	UNIT_ROUNDING_DOWN    = "down"
+	UNIT_ROUNDING_NEAREST = "nearest"
+
+	// Product fields, price and stock are kept in the base unit
+	FLD_PRODUCT_BASE_UNIT     = "base_unit_id"
+	FLD_PRODUCT_ALLOWED_UNITS = "allowed_unit_ids"
+
+	// Converted quantity fields
+	FLD_UNIT_QUANTITY      = "quantity"
+	FLD_UNIT_BASE_QUANTITY = "base_quantity"
+	FLD_UNIT_CONVERTED     = "converted_quantity"
+)
+
+// unitEpsilon - Tolerance of the float error before rounding the quantity
+const unitEpsilon = 1e-9
+
+// unitGraph - Conversion factors between the units, both the directions
+type unitGraph map[string]map[string]float64
+
+// add - Add the conversion, 1 from unit is factor of the to unit
+func (g unitGraph) add(fromUnitId string, toUnitId string, factor float64) {
+	if len(fromUnitId) == 0 || len(toUnitId) == 0 || factor <= 0 {
+		return
+	}
+	if g[fromUnitId] == nil {
+		g[fromUnitId] = map[string]float64{}
+	}
+	if g[toUnitId] == nil {
+		g[toUnitId] = map[string]float64{}
+	}
+	g[fromUnitId][toUnitId] = factor
+	g[toUnitId][fromUnitId] = 1 / factor
+}
+
+// factor - Find the factor from the unit to the other unit through the chain of the conversions
+func (g unitGraph) factor(fromUnitId string, toUnitId string) (float64, bool) {
+	if fromUnitId == toUnitId {
+		return 1, true
+	}
+
+	factors := map[string]float64{fromUnitId: 1}
+	queue := []string{fromUnitId}
+	for len(queue) > 0 {
+		unitId := queue[0]
+		queue = queue[1:]
+		for nextId, factor := range g[unitId] {
+			if _, ok := factors[nextId]; ok {
+				continue
+			}
+			factors[nextId] = factors[unitId] * factor
+			if nextId == toUnitId {
+				return factors[nextId], true
+			}
+			queue = append(queue, nextId)
+		}
+	}
+	return 0, false
+}
+
+// buildUnitGraph - Build the conversions of the units, the product conversions replace the unit conversions
+func buildUnitGraph(units map[string]utils.Map, product utils.Map) unitGraph {
+	graph := unitGraph{}
+	for unitId, unit := range units {
+		for _, conversion := range toMapSlice(unit[FLD_UNIT_CONVERSIONS]) {
+			toUnitId, _ := conversion[FLD_UNIT_TO].(string)
+			graph.add(unitId, toUnitId, toFloat(conversion[FLD_UNIT_FACTOR]))
+		}
+	}
+	for _, conversion := range toMapSlice(product[FLD_UNIT_CONVERSIONS]) {
+		fromUnitId, _ := conversion[FLD_UNIT_FROM].(string)
+		toUnitId, _ := conversion[FLD_UNIT_TO].(string)
+		graph.add(fromUnitId, toUnitId, toFloat(conversion[FLD_UNIT_FACTOR]))
+	}
+	return graph
+}
+
+// roundUnitQuantity - Round the quantity to the step of the unit
+func roundUnitQuantity(quantity float64, unit utils.Map) float64 {
+	step := toFloat(unit[FLD_UNIT_ROUNDING_STEP])
+	if step <= 0 {
+		return quantity
+	}
+
+	steps := quantity / step
+	mode, _ := unit[FLD_UNIT_ROUNDING_MODE].(string)
+	switch mode {
+	case UNIT_ROUNDING_UP:
+		steps = math.Ceil(steps - unitEpsilon)
+	case UNIT_ROUNDING_DOWN:
+		steps = math.Floor(steps + unitEpsilon)
+	default:
+		steps = math.Round(steps)
+	}
+	// Round off the float error of the multiplication
+	return math.Round(steps*step*1e6) / 1e6
+}
+
+// validateUnitRounding - Validate the rounding mode and step of the unit
+func validateUnitRounding(funcode string, indata utils.Map) error {
+	if mode, ok := indata[FLD_UNIT_ROUNDING_MODE].(string); ok {
+		switch mode {
+		case UNIT_ROUNDING_UP, UNIT_ROUNDING_DOWN, UNIT_ROUNDING_NEAREST:
+		default:
+			err := &utils.AppError{
+				ErrorCode:   funcode + "02",
+				ErrorMsg:    "Invalid rounding mode",
+				ErrorDetail: fmt.Sprintf("Rounding mode %s is not one of up, down or nearest", mode)}
+			return err
+		}
+	}
+	if toFloat(indata[FLD_UNIT_ROUNDING_STEP]) < 0 {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "02",
+			ErrorMsg:    "Invalid rounding step",
+			ErrorDetail: "Rounding step cannot be negative"}
+		return err
+	}
+	return nil
+}
+
+// unitConverter - Convert the quantities of the products to their base unit
+type unitConverter struct {
+	daoProductUnit sales_repository.Product_unitDao
+}
+
+// loadUnits - Get the units of the business by id
+func (c *unitConverter) loadUnits() (map[string]utils.Map, error) {
+	listdata, err := c.daoProductUnit.List("", "", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	units := map[string]utils.Map{}
+	for _, unit := range listResult(listdata) {
+		if deleted, _ := unit[db_common.FLD_IS_DELETED].(bool); deleted {
+			continue
+		}
+		unitId, _ := unit[sales_common.FLD_PRODUCT_UNIT_ID].(string)
+		units[unitId] = unit
+	}
+	return units, nil
+}
+
+// validateConversions - Validate the conversions refer to the existing units with positive factor
+// fromUnitId is the unit having the conversions, it may not be created yet
+func (c *unitConverter) validateConversions(funcode string, conversions []utils.Map, fromUnitId string) error {
+	if len(conversions) == 0 {
+		return nil
+	}
+
+	units, err := c.loadUnits()
+	if err != nil {
+		return err
+	}
+	for _, conversion := range conversions {
+		from := fromUnitId
+		if len(from) == 0 {
+			from, _ = conversion[FLD_UNIT_FROM].(string)
+			if _, ok := units[from]; !ok {
+				from = ""
+			}
+		}
+		toUnitId, _ := conversion[FLD_UNIT_TO].(string)
+		if _, ok := units[toUnitId]; !ok || len(from) == 0 || from == toUnitId || toFloat(conversion[FLD_UNIT_FACTOR]) <= 0 {
+			err := &utils.AppError{
+				ErrorCode:   funcode + "03",
+				ErrorMsg:    "Invalid unit conversion",
+				ErrorDetail: fmt.Sprintf("Conversion to %s needs existing units and a positive factor", toUnitId)}
+			return err
+		}
+	}
+	return nil
+}
+
+// validateProductUnits - Validate the base unit, the allowed units and the conversions of the product
+func (c *unitConverter) validateProductUnits(indata utils.Map) error {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "16"
+
+	_, hasBase := indata[FLD_PRODUCT_BASE_UNIT]
+	_, hasAllowed := indata[FLD_PRODUCT_ALLOWED_UNITS]
+	_, hasConversions := indata[FLD_UNIT_CONVERSIONS]
+	if !hasBase && !hasAllowed && !hasConversions {
+		return nil
+	}
+
+	units, err := c.loadUnits()
+	if err != nil {
+		return err
+	}
+
+	unitIds := toStringSlice(indata[FLD_PRODUCT_ALLOWED_UNITS])
+	if baseUnitId, _ := indata[FLD_PRODUCT_BASE_UNIT].(string); len(baseUnitId) > 0 {
+		unitIds = append(unitIds, baseUnitId)
+	}
+	for _, unitId := range unitIds {
+		if _, ok := units[unitId]; !ok {
+			err := &utils.AppError{
+				ErrorCode:   funcode + "03",
+				ErrorMsg:    "Invalid product unit",
+				ErrorDetail: fmt.Sprintf("Given unit %s is not exist", unitId)}
+			return err
+		}
+	}
+	return c.validateConversions(funcode, toMapSlice(indata[FLD_UNIT_CONVERSIONS]), "")
+}
+
+// convert - Convert the quantity of the product between the units, rounded to the step of the target unit
+func (c *unitConverter) convert(funcode string, product utils.Map, quantity float64, fromUnitId string, toUnitId string) (float64, error) {
+	units, err := c.loadUnits()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, unitId := range []string{fromUnitId, toUnitId} {
+		if _, ok := units[unitId]; !ok {
+			err := &utils.AppError{
+				ErrorCode:   funcode + "03",
+				ErrorMsg:    "Invalid product unit",
+				ErrorDetail: fmt.Sprintf("Given unit %s is not exist", unitId)}
+			return 0, err
+		}
+	}
+
+	factor, ok := buildUnitGraph(units, product).factor(fromUnitId, toUnitId)
+	if !ok {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "04",
+			ErrorMsg:    "Invalid unit conversion",
+			ErrorDetail: fmt.Sprintf("No conversion from %s to %s", fromUnitId, toUnitId)}
+		return 0, err
+	}
+
+	quantity = roundUnitQuantity(quantity, units[fromUnitId])
+	return roundUnitQuantity(quantity*factor, units[toUnitId]), nil
+}
+
+// toBaseQuantity - Convert the quantity in the unit to the base unit of the product
+// Empty unit is the base unit, else the unit must be one of the allowed units of the product
+func (c *unitConverter) toBaseQuantity(product utils.Map, quantity float64, unitId string) (float64, string, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "16"
+
+	if quantity <= 0 {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "06",
+			ErrorMsg:    "Invalid quantity",
+			ErrorDetail: fmt.Sprintf("Quantity %g should be greater than 0", quantity)}
+		return 0, "", err
+	}
+
+	baseUnitId, _ := product[FLD_PRODUCT_BASE_UNIT].(string)
+	if len(baseUnitId) == 0 {
+		if len(unitId) > 0 {
+			err := &utils.AppError{
+				ErrorCode:   funcode + "05",
+				ErrorMsg:    "Invalid product unit",
+				ErrorDetail: fmt.Sprintf("Product %s has no base unit to convert %s", productIdOf(product), unitId)}
+			return 0, "", err
+		}
+		return quantity, "", nil
+	}
+	if len(unitId) == 0 {
+		unitId = baseUnitId
+	}
+
+	allowed := toStringSlice(product[FLD_PRODUCT_ALLOWED_UNITS])
+	if unitId != baseUnitId && len(allowed) > 0 {
+		if len(removeIds(allowed, []string{unitId})) == len(allowed) {
+			err := &utils.AppError{
+				ErrorCode:   funcode + "05",
+				ErrorMsg:    "Invalid product unit",
+				ErrorDetail: fmt.Sprintf("Unit %s is not allowed for product %s", unitId, productIdOf(product))}
+			return 0, "", err
+		}
+	}
+
+	baseQuantity, err := c.convert(funcode, product, quantity, unitId, baseUnitId)
+	if err != nil {
+		return 0, "", err
+	}
+	return baseQuantity, baseUnitId, nil
+}
@@ -0,0 +1,353 @@
+package sales_service
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/zapscloud/golib-dbutils/db_common"
+	"github.com/zapscloud/golib-sales-repository/sales_common"
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+const (
+	// Variant fields of the product
+	FLD_PRODUCT_PARENT_ID          = "parent_product_id"
+	FLD_PRODUCT_HAS_VARIANTS       = "has_variants"
+	FLD_PRODUCT_VARIANT_ATTRIBUTES = "variant_attributes"
+	FLD_PRODUCT_SKU                = "sku"
+	FLD_PRODUCT_STOCK              = "stock"
+
+	// Response fields of the variant generation
+	FLD_PRODUCT_VARIANTS         = "variants"
+	FLD_PRODUCT_VARIANTS_SKIPPED = "skipped"
+
+	PRODUCT_VARIANT_MAX_COUNT = 1000
+)
+
+// variantAttributeKeys - Attributes allowed to build the variants, in the order used for the SKU
+var variantAttributeKeys = []string{
+	sales_common.FLD_PRODUCT_UNIT_ID,
+	sales_common.FLD_FIRMNESS_ID,
+	sales_common.FLD_MATERIAL_TYPE_ID,
+}
+
+// isVariantAttribute - Check whether the attribute can be used to build the variants
+func isVariantAttribute(key string) bool {
+	for _, attrKey := range variantAttributeKeys {
+		if attrKey == key {
+			return true
+		}
+	}
+	return false
+}
+
+// variantCombinations - Build all the combinations of the selected attribute values
+func variantCombinations(attributes map[string][]string) []utils.Map {
+	combinations := []utils.Map{{}}
+
+	for _, key := range variantAttributeKeys {
+		values, ok := attributes[key]
+		if !ok {
+			continue
+		}
+
+		next := make([]utils.Map, 0, len(combinations)*len(values))
+		for _, combination := range combinations {
+			for _, value := range values {
+				item := utils.CopyMap(combination)
+				item[key] = value
+				next = append(next, item)
+			}
+		}
+		combinations = next
+	}
+	return combinations
+}
+
+// variantKey - Key to identify the attribute combination of the variant
+func variantKey(attributes utils.Map) string {
+	parts := []string{}
+	for _, key := range variantAttributeKeys {
+		if value, ok := attributes[key].(string); ok {
+			parts = append(parts, key+"="+value)
+		}
+	}
+	return strings.Join(parts, "|")
+}
+
+// variantSku - Build the SKU of the variant from the SKU of the parent and the attribute values
+func variantSku(parentSku string, attributes utils.Map) string {
+	parts := []string{parentSku}
+	for _, key := range variantAttributeKeys {
+		if value, ok := attributes[key].(string); ok {
+			parts = append(parts, value)
+		}
+	}
+	return strings.ToUpper(strings.Join(parts, "-"))
+}
+
+// ProductVariantService - Product Variant Service structure
+type ProductVariantService interface {
+	// GenerateVariants - Generate the variants of the product for all combinations of the attribute values
+	GenerateVariants(productId string, attributes utils.Map) (utils.Map, error)
+	// FindVariant - Find the variant of the product by the attribute combination
+	FindVariant(productId string, attributes utils.Map) (utils.Map, error)
+	// ListVariants - List the variants of the product
+	ListVariants(productId string, sort string, skip int64, limit int64) (utils.Map, error)
+
+	EndService()
+}
+
+// productVariantBaseService - Product Variant Service structure, shares the product service
+type productVariantBaseService struct {
+	*productBaseService
+}
+
+// NewProductVariantService - Construct Product Variant
+func NewProductVariantService(props utils.Map) (ProductVariantService, error) {
+
+	log.Printf("ProductVariantService::Start ")
+	p, err := newProductBaseService(props)
+	if err != nil {
+		return nil, err
+	}
+	return &productVariantBaseService{p}, nil
+}
+
+// GenerateVariants - Generate the variants of the product for all combinations of the attribute values
+// attributes holds the selected values of each attribute, e.g. {"firmness_id": ["soft", "medium"]}
+func (p *productVariantBaseService) GenerateVariants(productId string, attributes utils.Map) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "08"
+
+	log.Println("ProductVariantService::GenerateVariants - Begin", productId)
+
+	parent, err := p.daoProduct.Get(productId)
+	if err != nil {
+		return nil, err
+	}
+
+	if parentId, _ := parent[FLD_PRODUCT_PARENT_ID].(string); len(parentId) > 0 {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "01",
+			ErrorMsg:    "Invalid product",
+			ErrorDetail: "Variants cannot be generated for a variant product"}
+		return nil, err
+	}
+
+	attrValues, err := p.validateVariantAttributes(funcode, attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	combinations := variantCombinations(attrValues)
+	if len(combinations) > PRODUCT_VARIANT_MAX_COUNT {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "02",
+			ErrorMsg:    "Too many variants",
+			ErrorDetail: fmt.Sprintf("Selected attribute values build %d variants, maximum allowed is %d", len(combinations), PRODUCT_VARIANT_MAX_COUNT)}
+		return nil, err
+	}
+
+	// Skip the combinations already having a variant
+	existing := map[string]bool{}
+	variants, err := p.listAllVariants(productId)
+	if err != nil {
+		return nil, err
+	}
+	for _, variant := range variants {
+		if variantAttrs, ok := toMap(variant[FLD_PRODUCT_VARIANT_ATTRIBUTES]); ok {
+			existing[variantKey(variantAttrs)] = true
+		}
+	}
+
+	parentSku, _ := parent[FLD_PRODUCT_SKU].(string)
+	if len(parentSku) == 0 {
+		parentSku = productId
+	}
+
+	created := []utils.Map{}
+	skipped := 0
+	for _, combination := range combinations {
+		if existing[variantKey(combination)] {
+			skipped++
+			continue
+		}
+
+		sku := variantSku(parentSku, combination)
+		exists, err := recordExists(p.daoProduct, utils.Map{FLD_PRODUCT_SKU: sku})
+		if err != nil {
+			return utils.Map{FLD_PRODUCT_VARIANTS: created, FLD_PRODUCT_VARIANTS_SKIPPED: skipped}, err
+		}
+		if exists {
+			err := &utils.AppError{
+				ErrorCode:   funcode + "03",
+				ErrorMsg:    "Duplicate SKU",
+				ErrorDetail: fmt.Sprintf("SKU %s is already used by another product", sku)}
+			return utils.Map{FLD_PRODUCT_VARIANTS: created, FLD_PRODUCT_VARIANTS_SKIPPED: skipped}, err
+		}
+
+		indata := utils.Map{
+			FLD_PRODUCT_NAME:               parent[FLD_PRODUCT_NAME],
+			FLD_PRODUCT_DESCRIPTION:        parent[FLD_PRODUCT_DESCRIPTION],
+			FLD_PRODUCT_PRICE:              parent[FLD_PRODUCT_PRICE],
+			sales_common.FLD_CATEGORY_ID:   parent[sales_common.FLD_CATEGORY_ID],
+			sales_common.FLD_BRAND_ID:      parent[sales_common.FLD_BRAND_ID],
+			FLD_PRODUCT_PARENT_ID:          productId,
+			FLD_PRODUCT_VARIANT_ATTRIBUTES: combination,
+			FLD_PRODUCT_SKU:                sku,
+			FLD_PRODUCT_STOCK:              0,
+		}
+
+		// Variants of the published parent are published with it
+		data, err := p.createProduct(indata, isProductPublished(parent))
+		if err != nil {
+			return utils.Map{FLD_PRODUCT_VARIANTS: created, FLD_PRODUCT_VARIANTS_SKIPPED: skipped}, err
+		}
+		created = append(created, data)
+	}
+
+	if len(created) > 0 {
+		_, err = p.daoProduct.Update(productId, utils.Map{FLD_PRODUCT_HAS_VARIANTS: true})
+		if err != nil {
+			return utils.Map{FLD_PRODUCT_VARIANTS: created, FLD_PRODUCT_VARIANTS_SKIPPED: skipped}, err
+		}
+	}
+
+	log.Println("ProductVariantService::GenerateVariants - End ", len(created), skipped)
+	return utils.Map{FLD_PRODUCT_VARIANTS: created, FLD_PRODUCT_VARIANTS_SKIPPED: skipped}, nil
+}
+
+// FindVariant - Find the variant of the product by the attribute combination
+func (p *productVariantBaseService) FindVariant(productId string, attributes utils.Map) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "08"
+
+	log.Println("ProductVariantService::FindVariant - Begin", productId, attributes)
+
+	for key := range attributes {
+		if !isVariantAttribute(key) {
+			err := &utils.AppError{
+				ErrorCode:   funcode + "07",
+				ErrorMsg:    "Invalid variant attribute",
+				ErrorDetail: fmt.Sprintf("Attribute %s is not a variant attribute", key)}
+			return nil, err
+		}
+	}
+
+	variants, err := p.listAllVariants(productId)
+	if err != nil {
+		return nil, err
+	}
+
+	// The variant matches only on the full combination of its attributes, a partial set matches none
+	data := matchVariant(variants, attributes)
+	if data == nil {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "08",
+			ErrorMsg:    "Variant not found",
+			ErrorDetail: fmt.Sprintf("No variant of product %s has the attributes %s, all the variant attributes should be given", productId, variantKey(attributes))}
+		return nil, err
+	}
+
+	log.Println("ProductVariantService::FindVariant - End ")
+	return data, nil
+}
+
+// matchVariant - Find the variant having exactly the given attribute combination, nil when not found
+func matchVariant(variants []utils.Map, attributes utils.Map) utils.Map {
+	key := variantKey(attributes)
+	if len(key) == 0 {
+		return nil
+	}
+	for _, variant := range variants {
+		if deleted, _ := variant[db_common.FLD_IS_DELETED].(bool); deleted {
+			continue
+		}
+		variantAttributes, _ := toMap(variant[FLD_PRODUCT_VARIANT_ATTRIBUTES])
+		if variantKey(variantAttributes) == key {
+			return variant
+		}
+	}
+	return nil
+}
+
+// ListVariants - List the variants of the product
+func (p *productVariantBaseService) ListVariants(productId string, sort string, skip int64, limit int64) (utils.Map, error) {
+
+	log.Println("ProductVariantService::ListVariants - Begin", productId)
+
+	filter := buildFilter(utils.Map{FLD_PRODUCT_PARENT_ID: productId})
+	listdata, err := p.daoProduct.List(filter, sort, skip, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("ProductVariantService::ListVariants - End ")
+	return listdata, nil
+}
+
+// listAllVariants - Get all the variants of the product
+func (p *productVariantBaseService) listAllVariants(productId string) ([]utils.Map, error) {
+	listdata, err := p.ListVariants(productId, "", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	return listResult(listdata), nil
+}
+
+// validateVariantAttributes - Verify the attribute values exist and remove the duplicate values
+func (p *productVariantBaseService) validateVariantAttributes(funcode string, attributes utils.Map) (map[string][]string, error) {
+	attrValues := map[string][]string{}
+
+	for key, value := range attributes {
+		if !isVariantAttribute(key) {
+			err := &utils.AppError{
+				ErrorCode:   funcode + "04",
+				ErrorMsg:    "Invalid variant attribute",
+				ErrorDetail: fmt.Sprintf("Attribute %s is not a variant attribute", key)}
+			return nil, err
+		}
+
+		values := []string{}
+		added := map[string]bool{}
+		for _, attrValue := range toStringSlice(value) {
+			if added[attrValue] {
+				continue
+			}
+
+			var err error
+			switch key {
+			case sales_common.FLD_FIRMNESS_ID:
+				_, err = p.daoFirmness.Get(attrValue)
+			case sales_common.FLD_MATERIAL_TYPE_ID:
+				_, err = p.daoMaterialType.Get(attrValue)
+			case sales_common.FLD_PRODUCT_UNIT_ID:
+				_, err = p.daoProductUnit.Get(attrValue)
+			}
+			if err != nil {
+				err := &utils.AppError{
+					ErrorCode:   funcode + "05",
+					ErrorMsg:    "Invalid attribute value",
+					ErrorDetail: fmt.Sprintf("Given %s %s is not exist", key, attrValue)}
+				return nil, err
+			}
+
+			added[attrValue] = true
+			values = append(values, attrValue)
+		}
+
+		if len(values) > 0 {
+			attrValues[key] = values
+		}
+	}
+
+	if len(attrValues) == 0 {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "06",
+			ErrorMsg:    "Invalid variant attributes",
+			ErrorDetail: "Select the values of at least one variant attribute"}
+		return nil, err
+	}
+
+	return attrValues, nil
+}
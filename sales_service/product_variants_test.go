@@ -0,0 +1,28 @@
+package sales_service
+
+import (
+	"testing"
+
+	"github.com/zapscloud/golib-sales-repository/sales_common"
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+func TestMatchVariantFullCombination(t *testing.T) {
+	variants := []utils.Map{
+		roundTrip(t, utils.Map{sales_common.FLD_PRODUCT_ID: "var1", FLD_PRODUCT_VARIANT_ATTRIBUTES: utils.Map{
+			sales_common.FLD_PRODUCT_UNIT_ID: "queen", sales_common.FLD_FIRMNESS_ID: "soft"}}),
+		roundTrip(t, utils.Map{sales_common.FLD_PRODUCT_ID: "var2", FLD_PRODUCT_VARIANT_ATTRIBUTES: utils.Map{
+			sales_common.FLD_PRODUCT_UNIT_ID: "queen", sales_common.FLD_FIRMNESS_ID: "firm"}}),
+	}
+
+	variant := matchVariant(variants, utils.Map{sales_common.FLD_FIRMNESS_ID: "firm", sales_common.FLD_PRODUCT_UNIT_ID: "queen"})
+	if variant == nil || variant[sales_common.FLD_PRODUCT_ID] != "var2" {
+		t.Errorf("full combination = %v", variant)
+	}
+	if variant := matchVariant(variants, utils.Map{sales_common.FLD_PRODUCT_UNIT_ID: "queen"}); variant != nil {
+		t.Errorf("partial combination matched %v", variant[sales_common.FLD_PRODUCT_ID])
+	}
+	if variant := matchVariant(variants, utils.Map{}); variant != nil {
+		t.Errorf("empty combination matched %v", variant[sales_common.FLD_PRODUCT_ID])
+	}
+}
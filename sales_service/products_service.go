@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/zapscloud/golib-dbutils/db_common"
 	"github.com/zapscloud/golib-dbutils/db_utils"
@@ -14,36 +15,64 @@ import (
 	"github.com/zapscloud/golib-utils/utils"
 )
 
+const (
+	FLD_PRODUCT_NAME        = "product_name"
+	FLD_PRODUCT_DESCRIPTION = "product_description"
+	FLD_PRODUCT_PRICE       = "price"
+)
+
 // ProductService - Business Product Service structure
 type ProductService interface {
-	// List - List All records
+	// List - List the published records
 	List(filter string, sort string, skip int64, limit int64) (utils.Map, error)
 	// Get - Find By Code
 	Get(productId string) (utils.Map, error)
 	// Find - Find the item
 	Find(filter string) (utils.Map, error)
-	// Create - Create Service
+	// Create - Create Service, the new product is published unless save_as_draft is given
 	Create(indata utils.Map) (utils.Map, error)
-	// Update - Update Service
+	// Update - Update Service, content changes of the published product are saved in its draft
 	Update(productId string, indata utils.Map) (utils.Map, error)
 	// Delete - Delete Service
 	Delete(productId string, delete_permanent bool) error
 
+	// ListByCategory - List the products of the category, optionally including the subcategories
+	ListByCategory(categoryId string, includeSubcategories bool, sort string, skip int64, limit int64) (utils.Map, error)
+
 	EndService()
 }
 
 // ProductService - Business Product Service structure
 type productBaseService struct {
 	db_utils.DatabaseService
-	dbRegion    db_utils.DatabaseService
-	daoProduct  sales_repository.ProductDao
-	daoBusiness platform_repository.BusinessDao
-	child       ProductService
-	businessId  string
+	dbRegion        db_utils.DatabaseService
+	daoProduct      sales_repository.ProductDao
+	daoCategory     sales_repository.CategoryDao
+	daoFirmness     sales_repository.FirmnessDao
+	daoMaterialType sales_repository.MaterialTypeDao
+	daoProductUnit  sales_repository.Product_unitDao
+	daoMedia        sales_repository.MediaDao
+	seoSlugs        *seoSlugs
+	unitConverter   *unitConverter
+	regionLocator   *regionLocator
+	priceResolver   *PriceResolver
+	daoBusiness     platform_repository.BusinessDao
+	child           ProductService
+	businessId      string
+	customerId      string
 }
 
 // NewProductService - Construct Product
 func NewProductService(props utils.Map) (ProductService, error) {
+	p, err := newProductBaseService(props)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// newProductBaseService - Open the product service shared by the product feature services
+func newProductBaseService(props utils.Map) (*productBaseService, error) {
 	funcode := sales_common.GetServiceModuleCode() + "M" + "01"
 
 	log.Printf("ProductService::Start ")
@@ -67,8 +96,12 @@ func NewProductService(props utils.Map) (ProductService, error) {
 		return nil, err
 	}
 
+	// Customer to price the listed products for, this is optional parameter
+	customerId, _ := utils.GetMemberDataStr(props, sales_common.FLD_CUSTOMER_ID)
+
 	// Assign the BusinessId
 	p.businessId = businessId
+	p.customerId = customerId
 	p.initializeService()
 
 	_, err = p.daoBusiness.Get(businessId)
@@ -82,7 +115,7 @@ func NewProductService(props utils.Map) (ProductService, error) {
 
 	p.child = &p
 
-	return &p, err
+	return &p, nil
 }
 
 // productBaseService - Close all the services
@@ -95,14 +128,32 @@ func (p *productBaseService) initializeService() {
 	log.Printf("ProductMongoService:: GetBusinessDao ")
 	p.daoBusiness = platform_repository.NewBusinessDao(p.GetClient())
 	p.daoProduct = sales_repository.NewProductDao(p.dbRegion.GetClient(), p.businessId)
+	p.seoSlugs = newSeoSlugs(p.dbRegion, p.businessId)
+	p.daoCategory = sales_repository.NewCategoryDao(p.dbRegion.GetClient(), p.businessId)
+	p.daoFirmness = sales_repository.NewFirmnessDao(p.dbRegion.GetClient(), p.businessId)
+	p.daoMaterialType = sales_repository.NewMaterialTypeDao(p.dbRegion.GetClient(), p.businessId)
+	p.daoProductUnit = sales_repository.NewProduct_unitDao(p.dbRegion.GetClient(), p.businessId)
+	p.daoMedia = sales_repository.NewMediaDao(p.dbRegion.GetClient(), p.businessId)
+	p.unitConverter = &unitConverter{daoProductUnit: p.daoProductUnit}
+	p.regionLocator = &regionLocator{
+		daoRegion: sales_repository.NewRegionDao(p.dbRegion.GetClient(), p.businessId),
+		daoStates: sales_repository.NewStatesDao(p.dbRegion.GetClient(), p.businessId),
+	}
+	p.priceResolver = NewPriceResolver(p.dbRegion.GetClient(), p.businessId)
 }
 
-// List - List All records
+// List - List the published records, the unit_price of the products is resolved for the customer of the service
+// The drafts and the products not published yet are not listed, same as the storefront
 func (p *productBaseService) List(filter string, sort string, skip int64, limit int64) (utils.Map, error) {
 
 	log.Println("ProductService::FindAll - Begin")
 
-	listdata, err := p.daoProduct.List(filter, sort, skip, limit)
+	listdata, err := p.listPublished(filter, sort, skip, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.priceResolver.PriceProducts(listResult(listdata), p.customerId)
 	if err != nil {
 		return nil, err
 	}
@@ -129,10 +180,26 @@ func (p *productBaseService) Find(filter string) (utils.Map, error) {
 	return data, err
 }
 
-// Create - Create Service
+// Create - Create Service, the new product is published unless save_as_draft is given
+// The product saved as draft goes live when it is reviewed and published
 func (p *productBaseService) Create(indata utils.Map) (utils.Map, error) {
 
 	log.Println("ProductService::Create - Begin")
+
+	asDraft, _ := indata[FLD_PRODUCT_SAVE_AS_DRAFT].(bool)
+	delete(indata, FLD_PRODUCT_SAVE_AS_DRAFT)
+
+	data, err := p.createProduct(indata, !asDraft)
+	if err != nil {
+		return data, err
+	}
+
+	log.Println("ProductService::Create - End ")
+	return data, nil
+}
+
+// createProduct - Validate and create the product, published or as a draft
+func (p *productBaseService) createProduct(indata utils.Map, published bool) (utils.Map, error) {
 	var productId string
 
 	dataval, dataok := indata[sales_common.FLD_PRODUCT_ID]
@@ -143,27 +210,175 @@ func (p *productBaseService) Create(indata utils.Map) (utils.Map, error) {
 		log.Println("Unique Product ID", productId)
 	}
 
+	// Variant must belong to an existing parent product
+	if parentId, _ := indata[FLD_PRODUCT_PARENT_ID].(string); len(parentId) > 0 {
+		_, err := p.daoProduct.Get(parentId)
+		if err != nil {
+			return utils.Map{}, err
+		}
+	}
+
 	//BusinessProduct
 	indata[sales_common.FLD_BUSINESS_ID] = p.businessId
 	indata[sales_common.FLD_PRODUCT_ID] = productId
 
-	data, err := p.daoProduct.Create(indata)
+	// Slug is generated from the name when not given
+	err := p.seoSlugs.assignOnCreate(SEO_ENTITY_PRODUCT, productId, indata)
 	if err != nil {
 		return utils.Map{}, err
 	}
 
-	log.Println("ProductService::Create - End ")
+	err = p.unitConverter.validateProductUnits(indata)
+	if err != nil {
+		return utils.Map{}, err
+	}
+
+	err = p.regionLocator.validateRules(toMapSlice(indata[FLD_PRODUCT_REGIONAL_RULES]))
+	if err != nil {
+		return utils.Map{}, err
+	}
+
+	if _, ok := indata[FLD_PRODUCT_GALLERY]; ok {
+		indata[FLD_PRODUCT_GALLERY], err = p.validateGallery(productId, toMapSlice(indata[FLD_PRODUCT_GALLERY]))
+		if err != nil {
+			return utils.Map{}, err
+		}
+	}
+
+	// Attributes must follow the schema of the business
+	fieldErrors, err := p.checkAttributes(indata, false)
+	if err != nil {
+		return fieldErrors, err
+	}
+
+	indata[FLD_PRODUCT_IS_PUBLISHED] = published
+	indata[FLD_DRAFT_STATUS] = DRAFT_STATUS_DRAFT
+	if published {
+		indata[FLD_DRAFT_STATUS] = DRAFT_STATUS_PUBLISHED
+	}
+
+	// Initial price starts the price history
+	if _, ok := indata[FLD_PRODUCT_PRICE]; ok {
+		indata[FLD_PRICE_HISTORY] = appendPriceHistory(nil, 0, toFloat(indata[FLD_PRODUCT_PRICE]), PRICE_SOURCE_MANUAL, time.Now())
+	}
+
+	data, err := p.daoProduct.Create(indata)
+	if err != nil {
+		return utils.Map{}, err
+	}
+	searchIndexProduct(p.businessId, indata)
 	return data, nil
 }
 
 // Update - Update Service
+// Content changes of the published product are saved in its draft and go live when the draft is published,
+// the fields kept by the services like the stock are updated directly
 func (p *productBaseService) Update(productId string, indata utils.Map) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "01"
 
 	log.Println("BusinessProdcutService::Update - Begin")
 
-	data, err := p.daoProduct.Update(productId, indata)
+	for key := range indata {
+		if productWorkflowFields[key] {
+			err := &utils.AppError{
+				ErrorCode:   funcode + "02",
+				ErrorMsg:    "Invalid product update",
+				ErrorDetail: fmt.Sprintf("Field %s is changed by the publishing only", key)}
+			return nil, err
+		}
+	}
+
+	product, err := p.daoProduct.Get(productId)
+	if err != nil {
+		return nil, err
+	}
+
+	var data utils.Map
+	if isProductPublished(product) {
+		changes := utils.Map{}
+		for key, value := range indata {
+			if !productManagedFields[key] {
+				changes[key] = value
+				delete(indata, key)
+			}
+		}
+		if len(changes) > 0 {
+			data, err = p.saveDraft(product, changes)
+			if err != nil {
+				return data, err
+			}
+		}
+	}
+	if data == nil || len(indata) > 0 {
+		data, err = p.updateProduct(productId, indata)
+		if err != nil {
+			return data, err
+		}
+	}
 
 	log.Println("ProductService::Update - End ")
+	return data, nil
+}
+
+// updateProduct - Validate and update the fields of the product directly
+func (p *productBaseService) updateProduct(productId string, indata utils.Map) (utils.Map, error) {
+
+	// Changed slug keeps the old one for the redirect
+	if _, ok := indata[FLD_SEO_SLUG]; ok {
+		current, err := p.daoProduct.Get(productId)
+		if err != nil {
+			return nil, err
+		}
+		err = p.seoSlugs.assignOnUpdate(SEO_ENTITY_PRODUCT, current, indata)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := p.unitConverter.validateProductUnits(indata)
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.regionLocator.validateRules(toMapSlice(indata[FLD_PRODUCT_REGIONAL_RULES]))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := indata[FLD_PRODUCT_GALLERY]; ok {
+		indata[FLD_PRODUCT_GALLERY], err = p.validateGallery(productId, toMapSlice(indata[FLD_PRODUCT_GALLERY]))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Only the given attributes are validated against the schema
+	fieldErrors, err := p.checkAttributes(indata, true)
+	if err != nil {
+		return fieldErrors, err
+	}
+
+	// Stock set on the product is a stock count
+	if _, ok := indata[FLD_PRODUCT_STOCK]; ok {
+		indata[FLD_STOCK_COUNTED] = toInt(indata[FLD_PRODUCT_STOCK])
+		indata[FLD_STOCK_COMMITS] = utils.Map{}
+	}
+
+	// Manual price change is recorded in the price history
+	if _, ok := indata[FLD_PRODUCT_PRICE]; ok {
+		current, err := p.daoProduct.Get(productId)
+		if err != nil {
+			return nil, err
+		}
+		indata[FLD_PRICE_HISTORY] = appendPriceHistory(toMapSlice(current[FLD_PRICE_HISTORY]),
+			toFloat(current[FLD_PRODUCT_PRICE]), toFloat(indata[FLD_PRODUCT_PRICE]), PRICE_SOURCE_MANUAL, time.Now())
+	}
+
+	data, err := p.daoProduct.Update(productId, indata)
+	if err != nil {
+		return data, err
+	}
+	p.refreshSearchIndex(productId)
 	return data, err
 }
 
@@ -177,10 +392,11 @@ func (p *productBaseService) Delete(productId string, delete_permanent bool) err
 		if err != nil {
 			return err
 		}
+		searchRemoveProduct(p.businessId, productId)
 		log.Printf("Delete %v", result)
 	} else {
 		indata := utils.Map{db_common.FLD_IS_DELETED: true}
-		data, err := p.Update(productId, indata)
+		data, err := p.updateProduct(productId, indata)
 		if err != nil {
 			return err
 		}
@@ -191,7 +407,42 @@ func (p *productBaseService) Delete(productId string, delete_permanent bool) err
 	return nil
 }
 
-func (p *productBaseService) errorReturn(err error) (ProductService, error) {
+// ListByCategory - List the products of the category, optionally including the subcategories
+func (p *productBaseService) ListByCategory(categoryId string, includeSubcategories bool, sort string, skip int64, limit int64) (utils.Map, error) {
+
+	log.Println("ProductService::ListByCategory - Begin", categoryId, includeSubcategories)
+
+	categoryIds := []string{categoryId}
+	if includeSubcategories {
+		var err error
+		categoryIds, err = categoryWithDescendantIds(p.daoCategory, categoryId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Listed the same way as List, so the products are priced for the customer
+	filter := buildFilter(utils.Map{sales_common.FLD_CATEGORY_ID: utils.Map{"$in": categoryIds}})
+	listdata, err := p.List(filter, sort, skip, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("ProductService::ListByCategory - End ")
+	return listdata, nil
+}
+
+// refreshSearchIndex - Update the product in the search index after the change
+func (p *productBaseService) refreshSearchIndex(productId string) {
+	product, err := p.daoProduct.Get(productId)
+	if err != nil {
+		searchRemoveProduct(p.businessId, productId)
+		return
+	}
+	searchIndexProduct(p.businessId, product)
+}
+
+func (p *productBaseService) errorReturn(err error) (*productBaseService, error) {
 	// Close the Database Connection
 	p.EndService()
 	return nil, err
@@ -0,0 +1,566 @@
+package sales_service
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zapscloud/golib-dbutils/db_utils"
+	"github.com/zapscloud/golib-platform-repository/platform_repository"
+	"github.com/zapscloud/golib-platform-service/platform_service"
+	"github.com/zapscloud/golib-sales-repository/sales_common"
+	"github.com/zapscloud/golib-sales-repository/sales_repository"
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+// Stacking policies
+const (
+	// Only the single best promotion is applied
+	STACKING_POLICY_EXCLUSIVE = "exclusive"
+	// The combination of promotions giving the highest discount is applied
+	STACKING_POLICY_BEST_FOR_CUSTOMER = "best_for_customer"
+	// Promotions are applied in the order of their priority
+	STACKING_POLICY_PRIORITY = "priority"
+
+	// Maximum stackable promotions the best_for_customer policy searches the combinations of
+	PROMOTION_COMBINATION_MAX_SEARCH = 12
+
+	PROMOTION_TYPE_OFFER  = "offer"
+	PROMOTION_TYPE_COUPON = "coupon"
+)
+
+const (
+	// Business setting for the stacking policy
+	FLD_STACKING_POLICY = "promotion_stacking_policy"
+
+	FLD_PROMOTION_TYPE         = "promotion_type"
+	FLD_PROMOTION_IS_STACKABLE = "is_stackable"
+	FLD_PROMOTION_APPLIED      = "applied"
+	FLD_PROMOTION_SKIPPED      = "skipped"
+	FLD_PROMOTION_REASON       = "reason"
+	FLD_CART_TOTAL             = "total"
+
+	// Promotions applied on the order and their total discount
+	FLD_ORDER_PROMOTIONS = "promotions"
+	FLD_ORDER_DISCOUNT   = "discount_total"
+)
+
+// PromotionService - Combines the offers and coupons applicable to the cart
+type PromotionService interface {
+	// Apply - Apply the offers and the given coupon codes to the cart as per the stacking policy
+	Apply(cart utils.Map, couponCodes []string) (utils.Map, error)
+	// GetStackingPolicy - Get the stacking policy of the business
+	GetStackingPolicy() (string, error)
+	// SetStackingPolicy - Set the stacking policy of the business
+	SetStackingPolicy(policy string) (utils.Map, error)
+
+	EndService()
+}
+
+type promotionBaseService struct {
+	db_utils.DatabaseService
+	dbRegion    db_utils.DatabaseService
+	resolver    *PromotionResolver
+	daoBusiness platform_repository.BusinessDao
+	child       PromotionService
+	businessId  string
+}
+
+// PromotionResolver - Applies the offers and coupons to the carts and orders of the business
+type PromotionResolver struct {
+	businessId    string
+	daoBusiness   platform_repository.BusinessDao
+	daoOffer      sales_repository.OfferDao
+	daoCoupon     sales_repository.CouponDao
+	priceResolver *PriceResolver
+}
+
+// NewPromotionResolver - Construct the PromotionResolver, the business is read from the platform
+// database client and the offers and coupons from the region database client
+func NewPromotionResolver(client utils.Map, regionClient utils.Map, businessId string) *PromotionResolver {
+	return &PromotionResolver{
+		businessId:    businessId,
+		daoBusiness:   platform_repository.NewBusinessDao(client),
+		daoOffer:      sales_repository.NewOfferDao(regionClient, businessId),
+		daoCoupon:     sales_repository.NewCouponDao(regionClient, businessId),
+		priceResolver: NewPriceResolver(regionClient, businessId),
+	}
+}
+
+// NewPromotionService - Construct Promotion
+func NewPromotionService(props utils.Map) (PromotionService, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "01"
+
+	log.Printf("PromotionService::Start ")
+	// Verify whether the business id data passed
+	businessId, err := utils.GetMemberDataStr(props, sales_common.FLD_BUSINESS_ID)
+	if err != nil {
+		return nil, err
+	}
+
+	p := promotionBaseService{}
+	// Open Database Service
+	err = p.OpenDatabaseService(props)
+	if err != nil {
+		return nil, err
+	}
+
+	// Open RegionDB Service
+	p.dbRegion, err = platform_service.OpenRegionDatabaseService(props)
+	if err != nil {
+		p.CloseDatabaseService()
+		return nil, err
+	}
+
+	// Assign the BusinessId
+	p.businessId = businessId
+	p.initializeService()
+
+	_, err = p.daoBusiness.Get(businessId)
+	if err != nil {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "01",
+			ErrorMsg:    "Invalid BusinessId",
+			ErrorDetail: "Given BusinessId is not exist"}
+		return p.errorReturn(err)
+	}
+
+	p.child = &p
+
+	return &p, err
+}
+
+// EndService - Close all the services
+func (p *promotionBaseService) EndService() {
+	log.Printf("EndService ")
+	p.CloseDatabaseService()
+	p.dbRegion.CloseDatabaseService()
+}
+
+func (p *promotionBaseService) initializeService() {
+	log.Printf("PromotionService:: GetBusinessDao ")
+	p.daoBusiness = platform_repository.NewBusinessDao(p.GetClient())
+	p.resolver = NewPromotionResolver(p.GetClient(), p.dbRegion.GetClient(), p.businessId)
+}
+
+// GetStackingPolicy - Get the stacking policy of the business, best for customer by default
+func (p *promotionBaseService) GetStackingPolicy() (string, error) {
+	return p.resolver.StackingPolicy()
+}
+
+// StackingPolicy - Get the stacking policy of the business, best for customer by default
+func (r *PromotionResolver) StackingPolicy() (string, error) {
+
+	value, ok, err := getBusinessSetting(r.daoBusiness, r.businessId, FLD_STACKING_POLICY)
+	if err != nil {
+		return "", err
+	}
+
+	policy, _ := value.(string)
+	if !ok || !isValidStackingPolicy(policy) {
+		policy = STACKING_POLICY_BEST_FOR_CUSTOMER
+	}
+	return policy, nil
+}
+
+// SetStackingPolicy - Set the stacking policy of the business
+func (p *promotionBaseService) SetStackingPolicy(policy string) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "03"
+
+	log.Println("PromotionService::SetStackingPolicy - Begin", policy)
+
+	policy = strings.ToLower(policy)
+	if !isValidStackingPolicy(policy) {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "01",
+			ErrorMsg:    "Invalid stacking policy",
+			ErrorDetail: "Stacking policy should be exclusive, best_for_customer or priority"}
+		return nil, err
+	}
+
+	data, err := updateBusinessSetting(p.daoBusiness, p.businessId, FLD_STACKING_POLICY, policy)
+
+	log.Println("PromotionService::SetStackingPolicy - End", err)
+	return data, err
+}
+
+// Apply - Apply the offers and the given coupon codes to the cart as per the stacking policy
+// Each coupon code is applied once, the repeated codes are skipped
+func (p *promotionBaseService) Apply(cart utils.Map, couponCodes []string) (utils.Map, error) {
+
+	log.Println("PromotionService::Apply - Begin", couponCodes)
+
+	response, err := p.resolver.Apply(cart, couponCodes)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("PromotionService::Apply - End ", response[FLD_TOTAL_DISCOUNT])
+	return response, nil
+}
+
+// Apply - Apply the offers and the given coupon codes to the cart as per the stacking policy
+// Each coupon code is applied once, the repeated codes are skipped
+func (r *PromotionResolver) Apply(cart utils.Map, couponCodes []string) (utils.Map, error) {
+
+	policy, err := r.StackingPolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	lines, err := loadCartLines(r.priceResolver, cart)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	candidates, err := evaluateActiveOffers(r.daoOffer, lines, now)
+	if err != nil {
+		return nil, err
+	}
+
+	// The coupons of another customer cannot be used on this cart
+	customerId, _ := cart[sales_common.FLD_CUSTOMER_ID].(string)
+
+	skipped := []utils.Map{}
+	given := map[string]bool{}
+	for _, code := range couponCodes {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		discount, reason := offerDiscount{}, ""
+		if given[code] {
+			reason = "Coupon code is given more than once"
+		} else {
+			given[code] = true
+			discount, reason, err = r.evaluateCouponCode(code, customerId, lines, now)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if len(reason) > 0 {
+			skipped = append(skipped, utils.Map{
+				FLD_PROMOTION_TYPE:   PROMOTION_TYPE_COUPON,
+				FLD_COUPON_CODE:      code,
+				FLD_PROMOTION_REASON: reason,
+			})
+			continue
+		}
+		candidates = append(candidates, discount)
+	}
+
+	applied, rejected := stackPromotions(policy, candidates, lines)
+
+	subtotal := cartSubtotal(lines)
+	totalDiscount := 0.0
+	listApplied := []utils.Map{}
+	for _, discount := range applied {
+		totalDiscount += discount.amount
+		listApplied = append(listApplied, discount.toMap())
+	}
+	for _, reject := range rejected {
+		data := reject.discount.toMap()
+		delete(data, FLD_DISCOUNT_LINES)
+		data[FLD_PROMOTION_REASON] = reject.reason
+		skipped = append(skipped, data)
+	}
+
+	response := utils.Map{
+		FLD_STACKING_POLICY:   policy,
+		FLD_CART_SUBTOTAL:     roundAmount(subtotal),
+		FLD_TOTAL_DISCOUNT:    roundAmount(totalDiscount),
+		FLD_CART_TOTAL:        roundAmount(subtotal - totalDiscount),
+		FLD_PROMOTION_APPLIED: listApplied,
+		FLD_PROMOTION_SKIPPED: skipped,
+	}
+	return response, nil
+}
+
+// ApplyToOrder - Apply the offers and the coupon codes given on the order to its priced items,
+// the order total is reduced by the discount. Only the coupon codes applied are kept on the order,
+// they are the codes to redeem when the order is placed
+func (r *PromotionResolver) ApplyToOrder(order utils.Map) error {
+
+	promotions, err := r.Apply(order, toStringSlice(order[FLD_ORDER_COUPON_CODES]))
+	if err != nil {
+		return err
+	}
+
+	applied := toMapSlice(promotions[FLD_PROMOTION_APPLIED])
+	couponCodes := []string{}
+	for _, promotion := range applied {
+		if promotion[FLD_PROMOTION_TYPE] == PROMOTION_TYPE_COUPON {
+			code, _ := promotion[FLD_COUPON_CODE].(string)
+			couponCodes = append(couponCodes, code)
+		}
+	}
+
+	discount := toFloat(promotions[FLD_TOTAL_DISCOUNT])
+	order[FLD_ORDER_PROMOTIONS] = applied
+	order[FLD_ORDER_DISCOUNT] = discount
+	order[FLD_ORDER_COUPON_CODES] = couponCodes
+	order[FLD_ORDER_TOTAL] = roundAmount(toFloat(order[FLD_ORDER_TOTAL]) - discount)
+	return nil
+}
+
+// evaluateCouponCode - Compute the discount of the coupon, returns the reason when it cannot be used
+func (r *PromotionResolver) evaluateCouponCode(code string, customerId string, lines []cartLine, now time.Time) (offerDiscount, string, error) {
+
+	coupons, err := findActive(r.daoCoupon, utils.Map{FLD_COUPON_CODE: code}, 1)
+	if err != nil {
+		return offerDiscount{}, "", err
+	}
+	if len(coupons) == 0 {
+		return offerDiscount{}, "Coupon code not found", nil
+	}
+
+	discount, reason := evaluateCoupon(coupons[0], customerId, lines, now)
+	return discount, reason, nil
+}
+
+// evaluateCoupon - Compute the discount of the coupon on the cart lines of the customer
+// Coupons issued to a customer (e.g. referral rewards) are usable only on the carts of that customer
+func evaluateCoupon(coupon utils.Map, customerId string, lines []cartLine, now time.Time) (offerDiscount, string) {
+	discount := offerDiscount{
+		promotionType: PROMOTION_TYPE_COUPON,
+		priority:      toInt(coupon[FLD_OFFER_PRIORITY]),
+		stackable:     isStackable(coupon),
+	}
+	discount.offerId, _ = coupon[sales_common.FLD_COUPON_ID].(string)
+	discount.couponCode, _ = coupon[FLD_COUPON_CODE].(string)
+
+	if voided, _ := coupon[FLD_COUPON_IS_VOIDED].(bool); voided {
+		return discount, "Coupon is voided"
+	}
+	if redeemed, _ := coupon[FLD_COUPON_IS_REDEEMED].(bool); redeemed {
+		return discount, "Coupon is already redeemed"
+	}
+	if !isOfferActive(coupon, now) {
+		return discount, "Coupon is not active"
+	}
+	if owner, _ := coupon[sales_common.FLD_CUSTOMER_ID].(string); len(owner) > 0 && owner != customerId {
+		return discount, "Coupon is issued to another customer"
+	}
+
+	scoped := []cartLine{}
+	for _, line := range lines {
+		if inOfferScope(coupon, line) {
+			scoped = append(scoped, line)
+		}
+	}
+
+	discount.lines, discount.explanation = spendDiscount(coupon, scoped, toFloat(coupon[FLD_COUPON_MIN_ORDER]))
+	for _, line := range discount.lines {
+		discount.amount += line.amount
+	}
+	discount.amount = roundAmount(discount.amount)
+	if discount.amount <= 0 {
+		return discount, "Cart does not meet the coupon conditions"
+	}
+
+	discount.explanation = fmt.Sprintf("Coupon %s: %s", discount.couponCode, discount.explanation)
+	return discount, ""
+}
+
+// rejectedPromotion - Promotion not applied along with the reason
+type rejectedPromotion struct {
+	discount offerDiscount
+	reason   string
+}
+
+// stackPromotions - Select the promotions to apply as per the stacking policy
+func stackPromotions(policy string, candidates []offerDiscount, lines []cartLine) ([]offerDiscount, []rejectedPromotion) {
+	if len(candidates) == 0 {
+		return []offerDiscount{}, []rejectedPromotion{}
+	}
+
+	switch policy {
+	case STACKING_POLICY_EXCLUSIVE:
+		sortByAmount(candidates)
+		return exclusivePromotion(candidates, 0, "Exclusive policy allows a single promotion, a better one was applied")
+
+	case STACKING_POLICY_PRIORITY:
+		sortByPriority(candidates)
+		applied, rejected := combinePromotions(candidates, lines)
+		return applied, rejected
+
+	default:
+		sortByAmount(candidates)
+
+		// Best combination of the stackable promotions
+		stackable := []offerDiscount{}
+		for _, candidate := range candidates {
+			if candidate.stackable {
+				stackable = append(stackable, candidate)
+			}
+		}
+		combination, unused := bestCombination(stackable, lines)
+		bestApplied, bestRejected := combinePromotions(combination, lines)
+		bestTotal := totalOfDiscounts(bestApplied)
+		for _, candidate := range unused {
+			bestRejected = append(bestRejected, rejectedPromotion{candidate, "Does not add to the discount of the best combination"})
+		}
+
+		// Each non stackable promotion on its own
+		bestSingle := -1
+		for idx, candidate := range candidates {
+			if !candidate.stackable && candidate.amount > bestTotal {
+				bestSingle = idx
+				bestTotal = candidate.amount
+			}
+		}
+		if bestSingle >= 0 {
+			return exclusivePromotion(candidates, bestSingle, "A non-stackable promotion gives a better discount")
+		}
+
+		for _, candidate := range candidates {
+			if !candidate.stackable {
+				bestRejected = append(bestRejected, rejectedPromotion{candidate, "Not stackable, the combination of other promotions gives a better discount"})
+			}
+		}
+		return bestApplied, bestRejected
+	}
+}
+
+// bestCombination - Search the combinations of the stackable promotions for the highest discount,
+// returns the promotions of the best combination and the ones left out. Of the combinations giving
+// the same discount the one with fewer promotions is selected, so no coupon is used without adding
+// to the discount. Beyond PROMOTION_COMBINATION_MAX_SEARCH promotions all of them are combined.
+func bestCombination(stackable []offerDiscount, lines []cartLine) ([]offerDiscount, []offerDiscount) {
+	if len(stackable) > PROMOTION_COMBINATION_MAX_SEARCH {
+		return stackable, []offerDiscount{}
+	}
+
+	bestMask, bestTotal, bestCount := 0, 0.0, 0
+	for mask := 1; mask < 1<<len(stackable); mask++ {
+		combination := []offerDiscount{}
+		for idx, candidate := range stackable {
+			if mask&(1<<idx) != 0 {
+				combination = append(combination, candidate)
+			}
+		}
+		applied, _ := combinePromotions(combination, lines)
+		total := roundAmount(totalOfDiscounts(applied))
+		if total > bestTotal || (total == bestTotal && len(combination) < bestCount) {
+			bestMask, bestTotal, bestCount = mask, total, len(combination)
+		}
+	}
+
+	combination := []offerDiscount{}
+	unused := []offerDiscount{}
+	for idx, candidate := range stackable {
+		if bestMask&(1<<idx) != 0 {
+			combination = append(combination, candidate)
+		} else {
+			unused = append(unused, candidate)
+		}
+	}
+	return combination, unused
+}
+
+// exclusivePromotion - Apply only the selected promotion and reject the rest
+func exclusivePromotion(candidates []offerDiscount, selected int, reason string) ([]offerDiscount, []rejectedPromotion) {
+	rejected := []rejectedPromotion{}
+	for idx, candidate := range candidates {
+		if idx != selected {
+			rejected = append(rejected, rejectedPromotion{candidate, reason})
+		}
+	}
+	return []offerDiscount{candidates[selected]}, rejected
+}
+
+// combinePromotions - Apply the promotions in the given order, a line is never discounted beyond its amount
+// A non stackable promotion is applied only when it is the first one and stops the others
+func combinePromotions(candidates []offerDiscount, lines []cartLine) ([]offerDiscount, []rejectedPromotion) {
+	remaining := map[string]float64{}
+	for _, line := range lines {
+		remaining[line.productId] += line.amount()
+	}
+
+	applied := []offerDiscount{}
+	rejected := []rejectedPromotion{}
+	exclusive := false
+	for _, candidate := range candidates {
+		if exclusive {
+			rejected = append(rejected, rejectedPromotion{candidate, "A non-stackable promotion was already applied"})
+			continue
+		}
+		if !candidate.stackable && len(applied) > 0 {
+			rejected = append(rejected, rejectedPromotion{candidate, "Not stackable with the promotions already applied"})
+			continue
+		}
+
+		// Limit the line discounts to the amount left on each line
+		capped := candidate
+		capped.lines = []lineDiscount{}
+		capped.amount = 0
+		for _, line := range candidate.lines {
+			if line.amount > remaining[line.productId] {
+				line.amount = remaining[line.productId]
+			}
+			if line.amount <= 0 {
+				continue
+			}
+			remaining[line.productId] -= line.amount
+			capped.amount += line.amount
+			capped.lines = append(capped.lines, line)
+		}
+		capped.amount = roundAmount(capped.amount)
+		if capped.amount <= 0 {
+			rejected = append(rejected, rejectedPromotion{candidate, "Items already fully discounted by other promotions"})
+			continue
+		}
+
+		applied = append(applied, capped)
+		exclusive = !candidate.stackable
+	}
+	return applied, rejected
+}
+
+func totalOfDiscounts(discounts []offerDiscount) float64 {
+	total := 0.0
+	for _, discount := range discounts {
+		total += discount.amount
+	}
+	return total
+}
+
+// sortByAmount - Highest discount first, ties resolved by priority and then id to keep it deterministic
+func sortByAmount(discounts []offerDiscount) {
+	sort.SliceStable(discounts, func(i, j int) bool {
+		if discounts[i].amount != discounts[j].amount {
+			return discounts[i].amount > discounts[j].amount
+		}
+		if discounts[i].priority != discounts[j].priority {
+			return discounts[i].priority > discounts[j].priority
+		}
+		return discounts[i].offerId < discounts[j].offerId
+	})
+}
+
+// sortByPriority - Highest priority first, ties resolved by amount and then id to keep it deterministic
+func sortByPriority(discounts []offerDiscount) {
+	sort.SliceStable(discounts, func(i, j int) bool {
+		if discounts[i].priority != discounts[j].priority {
+			return discounts[i].priority > discounts[j].priority
+		}
+		if discounts[i].amount != discounts[j].amount {
+			return discounts[i].amount > discounts[j].amount
+		}
+		return discounts[i].offerId < discounts[j].offerId
+	})
+}
+
+func isValidStackingPolicy(policy string) bool {
+	switch policy {
+	case STACKING_POLICY_EXCLUSIVE, STACKING_POLICY_BEST_FOR_CUSTOMER, STACKING_POLICY_PRIORITY:
+		return true
+	}
+	return false
+}
+
+func (p *promotionBaseService) errorReturn(err error) (PromotionService, error) {
+	// Close the Database Connection
+	p.EndService()
+	return nil, err
+}
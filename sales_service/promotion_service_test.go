@@ -0,0 +1,64 @@
+package sales_service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zapscloud/golib-sales-repository/sales_common"
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+func TestCouponOfCustomer(t *testing.T) {
+	coupon := roundTrip(t, utils.Map{
+		FLD_COUPON_CODE:              "REF-ABCD",
+		sales_common.FLD_CUSTOMER_ID: "cust1",
+		FLD_OFFER_DISCOUNT_TYPE:      DISCOUNT_TYPE_FIXED,
+		FLD_OFFER_DISCOUNT_VALUE:     10,
+	})
+	lines := []cartLine{{productId: "prod1", quantity: 1, unitPrice: 100}}
+
+	if _, reason := evaluateCoupon(coupon, "cust2", lines, time.Now()); len(reason) == 0 {
+		t.Error("coupon of another customer is applied")
+	}
+	if discount, reason := evaluateCoupon(coupon, "cust1", lines, time.Now()); len(reason) > 0 || discount.amount != 10 {
+		t.Errorf("coupon of the customer = %v, %q", discount.amount, reason)
+	}
+}
+
+func TestBestForCustomerLeavesOutPromotionNotAdding(t *testing.T) {
+	lines := []cartLine{
+		{productId: "prod1", quantity: 1, unitPrice: 100},
+		{productId: "prod2", quantity: 1, unitPrice: 100},
+	}
+	both := offerDiscount{offerId: "offer1", amount: 120, stackable: true, lines: []lineDiscount{
+		{productId: "prod1", quantity: 1, amount: 60},
+		{productId: "prod2", quantity: 1, amount: 60},
+	}}
+	first := offerDiscount{offerId: "coupon1", promotionType: PROMOTION_TYPE_COUPON, amount: 100, stackable: true,
+		lines: []lineDiscount{{productId: "prod1", quantity: 1, amount: 100}}}
+	second := offerDiscount{offerId: "coupon2", promotionType: PROMOTION_TYPE_COUPON, amount: 100, stackable: true,
+		lines: []lineDiscount{{productId: "prod2", quantity: 1, amount: 100}}}
+
+	applied, rejected := stackPromotions(STACKING_POLICY_BEST_FOR_CUSTOMER, []offerDiscount{both, first, second}, lines)
+	if total := totalOfDiscounts(applied); total != 200 || len(applied) != 2 {
+		t.Fatalf("applied %d promotions for %v, want 2 for 200", len(applied), total)
+	}
+	if len(rejected) != 1 || rejected[0].discount.offerId != "offer1" {
+		t.Errorf("rejected = %v", rejected)
+	}
+}
+
+func TestBestForCustomerNonStackable(t *testing.T) {
+	lines := []cartLine{{productId: "prod1", quantity: 1, unitPrice: 100}}
+	single := offerDiscount{offerId: "offer1", amount: 50,
+		lines: []lineDiscount{{productId: "prod1", quantity: 1, amount: 50}}}
+	stacked := []offerDiscount{single,
+		{offerId: "offer2", amount: 30, stackable: true, lines: []lineDiscount{{productId: "prod1", quantity: 1, amount: 30}}},
+		{offerId: "offer3", amount: 30, stackable: true, lines: []lineDiscount{{productId: "prod1", quantity: 1, amount: 30}}},
+	}
+
+	applied, _ := stackPromotions(STACKING_POLICY_BEST_FOR_CUSTOMER, stacked, lines)
+	if len(applied) != 2 || totalOfDiscounts(applied) != 60 {
+		t.Errorf("applied = %v", applied)
+	}
+}
@@ -0,0 +1,308 @@
+package sales_service
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/zapscloud/golib-sales-repository/sales_common"
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+const (
+	// Branching fields of the question, branches are [{option_id, next_question_id}]
+	// Without a matching branch the question moves to next_question_id, else to the following question
+	FLD_QUIZ_BRANCHES      = "branches"
+	FLD_QUIZ_NEXT_QUESTION = "next_question_id"
+	FLD_QUIZ_IS_OPTIONAL   = "is_optional"
+
+	// Next question id ending the quiz
+	QUIZ_END = "end"
+
+	// Quiz response fields for the progress of the respondent
+	FLD_QUIZ_SESSION_ID      = "session_id"
+	FLD_QUIZ_RESPONSE_STATUS = "response_status"
+	FLD_QUIZ_PATH            = "path"
+	FLD_QUIZ_COMPLETED_AT    = "completed_at"
+	FLD_QUIZ_RECOMMENDED_IDS = "recommended_product_ids"
+	FLD_QUIZ_NEXT_DETAILS    = "next_question"
+
+	QUIZ_STATUS_IN_PROGRESS = "in_progress"
+	QUIZ_STATUS_COMPLETED   = "completed"
+
+	// Quiz analytics fields
+	FLD_QUIZ_TOTAL_RESPONSES       = "total_responses"
+	FLD_QUIZ_COMPLETED             = "completed"
+	FLD_QUIZ_COMPLETION_RATE       = "completion_rate"
+	FLD_QUIZ_QUESTION_STATS        = "question_stats"
+	FLD_QUIZ_REACHED               = "reached"
+	FLD_QUIZ_ANSWERED              = "answered"
+	FLD_QUIZ_DROPPED               = "dropped"
+	FLD_QUIZ_DROP_OFF_RATE         = "drop_off_rate"
+	FLD_QUIZ_DISTRIBUTION          = "distribution"
+	FLD_QUIZ_COUNT                 = "count"
+	FLD_QUIZ_PERCENT               = "percent"
+	FLD_QUIZ_CONVERSION            = "conversion"
+	FLD_QUIZ_CONVERTED             = "converted"
+	FLD_QUIZ_CONVERSION_RATE       = "conversion_rate"
+	FLD_QUIZ_RECOMMENDED_CONVERTED = "recommended_converted"
+	FLD_QUIZ_RECOMMENDED_RATE      = "recommended_conversion_rate"
+)
+
+// quizQuestionIds - Question ids of the quiz in their order
+func quizQuestionIds(quiz utils.Map) []string {
+	ids := []string{}
+	for _, question := range toMapSlice(quiz[FLD_QUIZ_QUESTIONS]) {
+		questionId, _ := question[FLD_QUIZ_QUESTION_ID].(string)
+		ids = append(ids, questionId)
+	}
+	return ids
+}
+
+// quizDefaultNext - Next question without a matching branch, the following question when not given
+func quizDefaultNext(quiz utils.Map, question utils.Map) string {
+	if next, _ := question[FLD_QUIZ_NEXT_QUESTION].(string); len(next) > 0 {
+		return next
+	}
+
+	questionId, _ := question[FLD_QUIZ_QUESTION_ID].(string)
+	ids := quizQuestionIds(quiz)
+	for idx, id := range ids {
+		if id == questionId && idx+1 < len(ids) {
+			return ids[idx+1]
+		}
+	}
+	return QUIZ_END
+}
+
+// quizNextQuestion - Next question after the selected options, the first matching branch wins
+func quizNextQuestion(quiz utils.Map, question utils.Map, optionIds []string) string {
+	for _, branch := range toMapSlice(question[FLD_QUIZ_BRANCHES]) {
+		optionId, _ := branch[FLD_QUIZ_OPTION_ID].(string)
+		for _, selected := range optionIds {
+			if selected == optionId {
+				next, _ := branch[FLD_QUIZ_NEXT_QUESTION].(string)
+				return next
+			}
+		}
+	}
+	return quizDefaultNext(quiz, question)
+}
+
+// validateQuizBranches - Validate the question ids, the branch options and targets, and that the branches have no loop
+func validateQuizBranches(funcode string, quiz utils.Map) error {
+	questions := map[string]utils.Map{}
+	for _, question := range toMapSlice(quiz[FLD_QUIZ_QUESTIONS]) {
+		questionId, _ := question[FLD_QUIZ_QUESTION_ID].(string)
+		if len(questionId) == 0 || questionId == QUIZ_END || questions[questionId] != nil {
+			err := &utils.AppError{
+				ErrorCode:   funcode + "12",
+				ErrorMsg:    "Invalid question",
+				ErrorDetail: fmt.Sprintf("Question id %q is empty, reserved or duplicate", questionId)}
+			return err
+		}
+		questions[questionId] = question
+	}
+
+	validTarget := func(next string) bool {
+		return next == QUIZ_END || questions[next] != nil
+	}
+
+	edges := map[string][]string{}
+	for questionId, question := range questions {
+		for _, branch := range toMapSlice(question[FLD_QUIZ_BRANCHES]) {
+			optionId, _ := branch[FLD_QUIZ_OPTION_ID].(string)
+			next, _ := branch[FLD_QUIZ_NEXT_QUESTION].(string)
+			if _, ok := quizOption(question, optionId); !ok || !validTarget(next) {
+				err := &utils.AppError{
+					ErrorCode:   funcode + "07",
+					ErrorMsg:    "Invalid branch",
+					ErrorDetail: fmt.Sprintf("Branch of question %s has invalid option %s or next question %s", questionId, optionId, next)}
+				return err
+			}
+			edges[questionId] = append(edges[questionId], next)
+		}
+
+		next := quizDefaultNext(quiz, question)
+		if !validTarget(next) {
+			err := &utils.AppError{
+				ErrorCode:   funcode + "07",
+				ErrorMsg:    "Invalid branch",
+				ErrorDetail: fmt.Sprintf("Question %s has invalid next question %s", questionId, next)}
+			return err
+		}
+		edges[questionId] = append(edges[questionId], next)
+	}
+
+	// Depth first search for the loops, 1 is visiting and 2 is done
+	state := map[string]int{}
+	var visit func(questionId string) bool
+	visit = func(questionId string) bool {
+		if questionId == QUIZ_END || state[questionId] == 2 {
+			return true
+		}
+		if state[questionId] == 1 {
+			return false
+		}
+		state[questionId] = 1
+		for _, next := range edges[questionId] {
+			if !visit(next) {
+				return false
+			}
+		}
+		state[questionId] = 2
+		return true
+	}
+	for _, questionId := range quizQuestionIds(quiz) {
+		if !visit(questionId) {
+			err := &utils.AppError{
+				ErrorCode:   funcode + "08",
+				ErrorMsg:    "Invalid branch",
+				ErrorDetail: fmt.Sprintf("Branches of question %s lead back to the same question", questionId)}
+			return err
+		}
+	}
+	return nil
+}
+
+// walkQuizPath - Follow the answers from the first question
+// Returns the answered questions on the path, the first unanswered question (QUIZ_END when none)
+// and the required question left unanswered (empty when the path reaches the end).
+// Answers of the questions not on the path are rejected, so the skipped questions stay unanswered.
+func walkQuizPath(funcode string, quiz utils.Map, answers utils.Map) ([]string, string, string, error) {
+	path := []string{}
+	firstUnanswered := ""
+	current := QUIZ_END
+	if ids := quizQuestionIds(quiz); len(ids) > 0 {
+		current = ids[0]
+	}
+
+	onPath := map[string]bool{}
+	for current != QUIZ_END {
+		question, ok := quizQuestion(quiz, current)
+		if !ok || onPath[current] {
+			err := &utils.AppError{
+				ErrorCode:   funcode + "08",
+				ErrorMsg:    "Invalid branch",
+				ErrorDetail: fmt.Sprintf("Question %s is not exist or repeated in the path", current)}
+			return nil, "", "", err
+		}
+		onPath[current] = true
+
+		answer, answered := answers[current]
+		if !answered {
+			if len(firstUnanswered) == 0 {
+				firstUnanswered = current
+			}
+			// Unanswered optional question moves to its default next question
+			if optional, _ := question[FLD_QUIZ_IS_OPTIONAL].(bool); !optional {
+				break
+			}
+			current = quizDefaultNext(quiz, question)
+			continue
+		}
+
+		path = append(path, current)
+		current = quizNextQuestion(quiz, question, answerOptionIds(answer))
+	}
+
+	for questionId := range answers {
+		if !onPath[questionId] {
+			err := &utils.AppError{
+				ErrorCode:   funcode + "09",
+				ErrorMsg:    "Invalid answer",
+				ErrorDetail: fmt.Sprintf("Question %s is not on the answer path", questionId)}
+			return nil, "", "", err
+		}
+	}
+
+	if len(firstUnanswered) == 0 {
+		firstUnanswered = QUIZ_END
+	}
+	if current == QUIZ_END {
+		current = ""
+	}
+	return path, firstUnanswered, current, nil
+}
+
+// isQuizRespondent - Check whether the response belongs to the customer or the session
+func isQuizRespondent(response utils.Map, customerId string, sessionId string) bool {
+	if len(sessionId) > 0 {
+		if id, _ := response[FLD_QUIZ_SESSION_ID].(string); id == sessionId {
+			return true
+		}
+	}
+	if len(customerId) > 0 {
+		if id, _ := response[sales_common.FLD_CUSTOMER_ID].(string); id == customerId {
+			return true
+		}
+	}
+	return false
+}
+
+// quizRate - Percentage of the count in the total
+func quizRate(count int, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return math.Round(float64(count)/float64(total)*10000) / 100
+}
+
+// quizQuestionStats - Answer distribution and drop-off of each question
+// Reached counts the responses having the question on the path or as the next question,
+// dropped counts the unfinished responses stopped at the question.
+func quizQuestionStats(quiz utils.Map, responses []utils.Map) []utils.Map {
+	stats := []utils.Map{}
+	for _, question := range toMapSlice(quiz[FLD_QUIZ_QUESTIONS]) {
+		questionId, _ := question[FLD_QUIZ_QUESTION_ID].(string)
+
+		reached, answered, dropped := 0, 0, 0
+		counts := map[string]int{}
+		for _, response := range responses {
+			next, _ := response[FLD_QUIZ_NEXT_QUESTION].(string)
+			status, _ := response[FLD_QUIZ_RESPONSE_STATUS].(string)
+			onPath := false
+			for _, id := range toStringSlice(response[FLD_QUIZ_PATH]) {
+				if id == questionId {
+					onPath = true
+					break
+				}
+			}
+			if !onPath && next != questionId {
+				continue
+			}
+
+			reached++
+			if next == questionId && status != QUIZ_STATUS_COMPLETED {
+				dropped++
+			}
+
+			answers, _ := toMap(response[FLD_QUIZ_ANSWERS])
+			if answer, ok := answers[questionId]; ok {
+				answered++
+				for _, optionId := range answerOptionIds(answer) {
+					counts[optionId]++
+				}
+			}
+		}
+
+		distribution := []utils.Map{}
+		for _, option := range toMapSlice(question[FLD_QUIZ_OPTIONS]) {
+			optionId, _ := option[FLD_QUIZ_OPTION_ID].(string)
+			distribution = append(distribution, utils.Map{
+				FLD_QUIZ_OPTION_ID: optionId,
+				FLD_QUIZ_COUNT:     counts[optionId],
+				FLD_QUIZ_PERCENT:   quizRate(counts[optionId], answered),
+			})
+		}
+
+		stats = append(stats, utils.Map{
+			FLD_QUIZ_QUESTION_ID:   questionId,
+			FLD_QUIZ_REACHED:       reached,
+			FLD_QUIZ_ANSWERED:      answered,
+			FLD_QUIZ_DROPPED:       dropped,
+			FLD_QUIZ_DROP_OFF_RATE: quizRate(dropped, reached),
+			FLD_QUIZ_DISTRIBUTION:  distribution,
+		})
+	}
+	return stats
+}
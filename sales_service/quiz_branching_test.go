@@ -0,0 +1,147 @@
+package sales_service
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+// testBranchingQuiz - q1 branches to q3 on option a, else moves to the optional q2, q2 and q3 move to the next question
+func testBranchingQuiz() (utils.Map, []utils.Map) {
+	options := func(ids ...string) []utils.Map {
+		items := []utils.Map{}
+		for _, id := range ids {
+			items = append(items, utils.Map{FLD_QUIZ_OPTION_ID: id})
+		}
+		return items
+	}
+	questions := []utils.Map{
+		{FLD_QUIZ_QUESTION_ID: "q1", FLD_QUIZ_OPTIONS: options("a", "b"),
+			FLD_QUIZ_BRANCHES: []utils.Map{{FLD_QUIZ_OPTION_ID: "a", FLD_QUIZ_NEXT_QUESTION: "q3"}}},
+		{FLD_QUIZ_QUESTION_ID: "q2", FLD_QUIZ_OPTIONS: options("c"), FLD_QUIZ_IS_OPTIONAL: true},
+		{FLD_QUIZ_QUESTION_ID: "q3", FLD_QUIZ_OPTIONS: options("d")},
+	}
+	return utils.Map{FLD_QUIZ_QUESTIONS: questions}, questions
+}
+
+func quizErrorCode(err error) string {
+	if appErr, ok := err.(*utils.AppError); ok {
+		return appErr.ErrorCode
+	}
+	return ""
+}
+
+func TestValidateQuizBranches(t *testing.T) {
+	quiz, _ := testBranchingQuiz()
+	if err := validateQuizBranches("test", quiz); err != nil {
+		t.Fatalf("validateQuizBranches returned %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(questions []utils.Map)
+		code   string
+	}{
+		{"duplicate question", func(questions []utils.Map) { questions[1][FLD_QUIZ_QUESTION_ID] = "q1" }, "test12"},
+		{"reserved question id", func(questions []utils.Map) { questions[1][FLD_QUIZ_QUESTION_ID] = QUIZ_END }, "test12"},
+		{"empty question id", func(questions []utils.Map) { delete(questions[1], FLD_QUIZ_QUESTION_ID) }, "test12"},
+		{"branch of unknown option", func(questions []utils.Map) {
+			questions[0][FLD_QUIZ_BRANCHES] = []utils.Map{{FLD_QUIZ_OPTION_ID: "x", FLD_QUIZ_NEXT_QUESTION: "q3"}}
+		}, "test07"},
+		{"branch to unknown question", func(questions []utils.Map) {
+			questions[0][FLD_QUIZ_BRANCHES] = []utils.Map{{FLD_QUIZ_OPTION_ID: "a", FLD_QUIZ_NEXT_QUESTION: "q9"}}
+		}, "test07"},
+		{"unknown next question", func(questions []utils.Map) { questions[2][FLD_QUIZ_NEXT_QUESTION] = "q9" }, "test07"},
+		{"next question loop", func(questions []utils.Map) { questions[2][FLD_QUIZ_NEXT_QUESTION] = "q1" }, "test08"},
+		{"branch loop", func(questions []utils.Map) {
+			questions[1][FLD_QUIZ_BRANCHES] = []utils.Map{{FLD_QUIZ_OPTION_ID: "c", FLD_QUIZ_NEXT_QUESTION: "q1"}}
+		}, "test08"},
+		{"branch to itself", func(questions []utils.Map) {
+			questions[0][FLD_QUIZ_BRANCHES] = []utils.Map{{FLD_QUIZ_OPTION_ID: "b", FLD_QUIZ_NEXT_QUESTION: "q1"}}
+		}, "test08"},
+	}
+
+	for _, test := range tests {
+		quiz, questions := testBranchingQuiz()
+		test.change(questions)
+		if code := quizErrorCode(validateQuizBranches("test", quiz)); code != test.code {
+			t.Errorf("%s: validateQuizBranches error %q, want %q", test.name, code, test.code)
+		}
+	}
+
+	// Branch ending the quiz is valid
+	quiz, questions := testBranchingQuiz()
+	questions[0][FLD_QUIZ_BRANCHES] = []utils.Map{{FLD_QUIZ_OPTION_ID: "b", FLD_QUIZ_NEXT_QUESTION: QUIZ_END}}
+	if err := validateQuizBranches("test", quiz); err != nil {
+		t.Errorf("validateQuizBranches with branch to the end returned %v", err)
+	}
+}
+
+func TestWalkQuizPath(t *testing.T) {
+	quiz, _ := testBranchingQuiz()
+
+	tests := []struct {
+		answers         utils.Map
+		path            []string
+		firstUnanswered string
+		required        string
+	}{
+		{utils.Map{}, []string{}, "q1", "q1"},
+		// Branch skips q2
+		{utils.Map{"q1": "a"}, []string{"q1"}, "q3", "q3"},
+		{utils.Map{"q1": "a", "q3": "d"}, []string{"q1", "q3"}, QUIZ_END, ""},
+		// Optional question left unanswered does not stop the path
+		{utils.Map{"q1": "b"}, []string{"q1"}, "q2", "q3"},
+		{utils.Map{"q1": "b", "q3": "d"}, []string{"q1", "q3"}, "q2", ""},
+		{utils.Map{"q1": []string{"b"}, "q2": "c", "q3": "d"}, []string{"q1", "q2", "q3"}, QUIZ_END, ""},
+	}
+
+	for _, test := range tests {
+		path, firstUnanswered, required, err := walkQuizPath("test", quiz, test.answers)
+		if err != nil {
+			t.Errorf("walkQuizPath(%v) returned %v", test.answers, err)
+			continue
+		}
+		if !reflect.DeepEqual(path, test.path) || firstUnanswered != test.firstUnanswered || required != test.required {
+			t.Errorf("walkQuizPath(%v) = %v, %q, %q, want %v, %q, %q",
+				test.answers, path, firstUnanswered, required, test.path, test.firstUnanswered, test.required)
+		}
+	}
+}
+
+func TestWalkQuizPathInvalid(t *testing.T) {
+	// Answer of the question skipped by the branch
+	quiz, _ := testBranchingQuiz()
+	if _, _, _, err := walkQuizPath("test", quiz, utils.Map{"q1": "a", "q2": "c"}); quizErrorCode(err) != "test09" {
+		t.Errorf("walkQuizPath with skipped question answered returned %v", err)
+	}
+
+	// Path repeating the question is stopped
+	quiz, questions := testBranchingQuiz()
+	questions[2][FLD_QUIZ_NEXT_QUESTION] = "q1"
+	if _, _, _, err := walkQuizPath("test", quiz, utils.Map{"q1": "a", "q3": "d"}); quizErrorCode(err) != "test08" {
+		t.Errorf("walkQuizPath with loop returned %v", err)
+	}
+}
+
+func TestQuizNextQuestion(t *testing.T) {
+	quiz, questions := testBranchingQuiz()
+
+	tests := []struct {
+		question  utils.Map
+		optionIds []string
+		want      string
+	}{
+		{questions[0], []string{"a"}, "q3"},
+		{questions[0], []string{"b", "a"}, "q3"},
+		{questions[0], []string{"b"}, "q2"},
+		{questions[2], []string{"d"}, QUIZ_END},
+	}
+
+	for _, test := range tests {
+		if got := quizNextQuestion(quiz, test.question, test.optionIds); got != test.want {
+			t.Errorf("quizNextQuestion(%v, %v) = %s, want %s", test.question[FLD_QUIZ_QUESTION_ID], test.optionIds, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package sales_service
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/zapscloud/golib-sales-repository/sales_common"
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+const (
+	// Quiz fields, questions are [{question_id, question_text, multi_select, options: [{option_id, option_text, preferences: [{preference_id, weight}]}]}]
+	FLD_QUIZ_QUESTIONS    = "questions"
+	FLD_QUIZ_QUESTION_ID  = "question_id"
+	FLD_QUIZ_MULTI_SELECT = "multi_select"
+	FLD_QUIZ_OPTIONS      = "options"
+	FLD_QUIZ_OPTION_ID    = "option_id"
+	FLD_QUIZ_RESPONSES    = "quiz_responses"
+
+	// Weighted preferences of the quiz option and the product preference profile
+	FLD_QUIZ_PREFERENCES  = "preferences"
+	FLD_PREFERENCE_WEIGHT = "weight"
+	FLD_PREFERENCE_NAME   = "preference_name"
+
+	// Quiz response fields
+	FLD_QUIZ_RESPONSE_ID = "response_id"
+	FLD_QUIZ_ANSWERS     = "answers"
+	FLD_QUIZ_ANSWERED_AT = "answered_at"
+	FLD_QUIZ_PRODUCTS    = "products"
+	FLD_QUIZ_MATCH_SCORE = "match_score"
+	FLD_QUIZ_MATCH       = "match_explanation"
+	FLD_QUIZ_MATCHED     = "matched"
+	FLD_QUIZ_UNMATCHED   = "unmatched"
+	FLD_QUIZ_SUMMARY     = "summary"
+
+	QUIZ_DEFAULT_RECOMMENDATIONS = 10
+)
+
+// quizOption - Option of the quiz question
+func quizOption(question utils.Map, optionId string) (utils.Map, bool) {
+	for _, option := range toMapSlice(question[FLD_QUIZ_OPTIONS]) {
+		if id, _ := option[FLD_QUIZ_OPTION_ID].(string); id == optionId {
+			return option, true
+		}
+	}
+	return nil, false
+}
+
+// quizQuestion - Question of the quiz
+func quizQuestion(quiz utils.Map, questionId string) (utils.Map, bool) {
+	for _, question := range toMapSlice(quiz[FLD_QUIZ_QUESTIONS]) {
+		if id, _ := question[FLD_QUIZ_QUESTION_ID].(string); id == questionId {
+			return question, true
+		}
+	}
+	return nil, false
+}
+
+// answerOptionIds - Selected options of the answer, the answer is the option id or list of option ids
+func answerOptionIds(answer any) []string {
+	if optionId, ok := answer.(string); ok {
+		return []string{optionId}
+	}
+	return toStringSlice(answer)
+}
+
+// preferenceWeights - Read the weighted preferences [{preference_id, weight}], weight is 1 when not given
+func preferenceWeights(value any) map[string]float64 {
+	weights := map[string]float64{}
+	for _, item := range toMapSlice(value) {
+		preferenceId, _ := item[sales_common.FLD_PREFERENCE_ID].(string)
+		if len(preferenceId) == 0 {
+			continue
+		}
+		weight := 1.0
+		if _, ok := item[FLD_PREFERENCE_WEIGHT]; ok {
+			weight = toFloat(item[FLD_PREFERENCE_WEIGHT])
+		}
+		weights[preferenceId] += weight
+	}
+	return weights
+}
+
+// answerPreferences - Validate the answers {question_id: option_id} and sum the preferences of the selected options
+func answerPreferences(funcode string, quiz utils.Map, answers utils.Map) (map[string]float64, error) {
+	preferences := map[string]float64{}
+	for questionId, answer := range answers {
+		question, ok := quizQuestion(quiz, questionId)
+		if !ok {
+			err := &utils.AppError{
+				ErrorCode:   funcode + "02",
+				ErrorMsg:    "Invalid answer",
+				ErrorDetail: fmt.Sprintf("Given question %s is not exist", questionId)}
+			return nil, err
+		}
+
+		optionIds := answerOptionIds(answer)
+		multiSelect, _ := question[FLD_QUIZ_MULTI_SELECT].(bool)
+		if len(optionIds) == 0 || (len(optionIds) > 1 && !multiSelect) {
+			err := &utils.AppError{
+				ErrorCode:   funcode + "03",
+				ErrorMsg:    "Invalid answer",
+				ErrorDetail: fmt.Sprintf("Question %s needs one option", questionId)}
+			return nil, err
+		}
+
+		for _, optionId := range optionIds {
+			option, ok := quizOption(question, optionId)
+			if !ok {
+				err := &utils.AppError{
+					ErrorCode:   funcode + "04",
+					ErrorMsg:    "Invalid answer",
+					ErrorDetail: fmt.Sprintf("Given option %s is not exist in question %s", optionId, questionId)}
+				return nil, err
+			}
+			for preferenceId, weight := range preferenceWeights(option[FLD_QUIZ_PREFERENCES]) {
+				preferences[preferenceId] += weight
+			}
+		}
+	}
+	return preferences, nil
+}
+
+// productProfiles - Merge the product preference profiles by product, affinity is limited to 0..1
+func productProfiles(profiles []utils.Map) map[string]map[string]float64 {
+	products := map[string]map[string]float64{}
+	for _, profile := range profiles {
+		productId := productIdOf(profile)
+		if len(productId) == 0 {
+			continue
+		}
+		if products[productId] == nil {
+			products[productId] = map[string]float64{}
+		}
+		for preferenceId, affinity := range preferenceWeights(profile[FLD_QUIZ_PREFERENCES]) {
+			products[productId][preferenceId] = math.Min(math.Max(products[productId][preferenceId]+affinity, 0), 1)
+		}
+	}
+	return products
+}
+
+// quizMatch - Match of the product profile with the customer preferences
+type quizMatch struct {
+	productId string
+	score     float64
+	matched   []string
+	unmatched []string
+}
+
+// scoreProfile - Score the product profile 0..100 against the weighted preferences
+// Positive weights add the affinity of the product, negative weights subtract it
+func scoreProfile(preferences map[string]float64, profile map[string]float64) quizMatch {
+	match := quizMatch{}
+	total, score := 0.0, 0.0
+	for preferenceId, weight := range preferences {
+		affinity := profile[preferenceId]
+		total += math.Abs(weight)
+		score += weight * affinity
+
+		if (weight > 0 && affinity > 0) || (weight < 0 && affinity == 0) {
+			match.matched = append(match.matched, preferenceId)
+		} else {
+			match.unmatched = append(match.unmatched, preferenceId)
+		}
+	}
+	if total > 0 {
+		match.score = math.Round(math.Max(score, 0)/total*10000) / 100
+	}
+
+	// Strongest preferences first in the explanation
+	byWeight := func(ids []string) {
+		sort.Slice(ids, func(i, j int) bool {
+			if math.Abs(preferences[ids[i]]) != math.Abs(preferences[ids[j]]) {
+				return math.Abs(preferences[ids[i]]) > math.Abs(preferences[ids[j]])
+			}
+			return ids[i] < ids[j]
+		})
+	}
+	byWeight(match.matched)
+	byWeight(match.unmatched)
+	return match
+}
+
+// rankProfiles - Score all the product profiles, best match first
+func rankProfiles(preferences map[string]float64, profiles map[string]map[string]float64) []quizMatch {
+	matches := []quizMatch{}
+	for productId, profile := range profiles {
+		match := scoreProfile(preferences, profile)
+		match.productId = productId
+		if match.score > 0 {
+			matches = append(matches, match)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return matches[i].productId < matches[j].productId
+	})
+	return matches
+}
+
+// matchExplanation - Explain the match with the names of the matched and unmatched preferences
+func matchExplanation(match quizMatch, preferences map[string]float64, names map[string]string) utils.Map {
+	describe := func(ids []string) ([]utils.Map, []string) {
+		items := []utils.Map{}
+		labels := []string{}
+		for _, preferenceId := range ids {
+			name := names[preferenceId]
+			if len(name) == 0 {
+				name = preferenceId
+			}
+			items = append(items, utils.Map{
+				sales_common.FLD_PREFERENCE_ID: preferenceId,
+				FLD_PREFERENCE_NAME:            name,
+				FLD_PREFERENCE_WEIGHT:          preferences[preferenceId],
+			})
+			// Negative preference is met when the product does not have it
+			if preferences[preferenceId] < 0 {
+				name = "not " + name
+			}
+			labels = append(labels, name)
+		}
+		return items, labels
+	}
+
+	matched, matchedNames := describe(match.matched)
+	unmatched, unmatchedNames := describe(match.unmatched)
+
+	summary := fmt.Sprintf("%.0f%% match", match.score)
+	if len(matchedNames) > 0 {
+		summary += ", matches " + strings.Join(matchedNames, ", ")
+	}
+	if len(unmatchedNames) > 0 {
+		summary += ", does not match " + strings.Join(unmatchedNames, ", ")
+	}
+
+	return utils.Map{
+		FLD_QUIZ_MATCHED:   matched,
+		FLD_QUIZ_UNMATCHED: unmatched,
+		FLD_QUIZ_SUMMARY:   summary,
+	}
+}
+
+// toPreferenceMaps - Convert the weighted preferences to store in the response
+func toPreferenceMaps(preferences map[string]float64) []utils.Map {
+	ids := make([]string, 0, len(preferences))
+	for preferenceId := range preferences {
+		ids = append(ids, preferenceId)
+	}
+	sort.Strings(ids)
+
+	result := make([]utils.Map, 0, len(ids))
+	for _, preferenceId := range ids {
+		result = append(result, utils.Map{
+			sales_common.FLD_PREFERENCE_ID: preferenceId,
+			FLD_PREFERENCE_WEIGHT:          preferences[preferenceId],
+		})
+	}
+	return result
+}
@@ -0,0 +1,127 @@
+package sales_service
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zapscloud/golib-sales-repository/sales_common"
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+func testQuiz() utils.Map {
+	preference := func(preferenceId string, weight float64) utils.Map {
+		return utils.Map{sales_common.FLD_PREFERENCE_ID: preferenceId, FLD_PREFERENCE_WEIGHT: weight}
+	}
+	return utils.Map{FLD_QUIZ_QUESTIONS: []utils.Map{
+		{FLD_QUIZ_QUESTION_ID: "q1", FLD_QUIZ_OPTIONS: []utils.Map{
+			{FLD_QUIZ_OPTION_ID: "o1", FLD_QUIZ_PREFERENCES: []utils.Map{preference("soft", 2)}},
+			{FLD_QUIZ_OPTION_ID: "o2", FLD_QUIZ_PREFERENCES: []utils.Map{preference("firm", 1)}},
+		}},
+		{FLD_QUIZ_QUESTION_ID: "q2", FLD_QUIZ_MULTI_SELECT: true, FLD_QUIZ_OPTIONS: []utils.Map{
+			// Weight is 1 when not given
+			{FLD_QUIZ_OPTION_ID: "o3", FLD_QUIZ_PREFERENCES: []utils.Map{{sales_common.FLD_PREFERENCE_ID: "cool"}}},
+			{FLD_QUIZ_OPTION_ID: "o4", FLD_QUIZ_PREFERENCES: []utils.Map{preference("cool", 1), preference("heavy", -1)}},
+		}},
+	}}
+}
+
+func TestAnswerPreferences(t *testing.T) {
+	answers := utils.Map{"q1": "o1", "q2": []string{"o3", "o4"}}
+	preferences, err := answerPreferences("test", testQuiz(), answers)
+	if err != nil {
+		t.Fatalf("answerPreferences returned %v", err)
+	}
+	if want := map[string]float64{"soft": 2, "cool": 2, "heavy": -1}; !reflect.DeepEqual(preferences, want) {
+		t.Errorf("answerPreferences = %v, want %v", preferences, want)
+	}
+}
+
+func TestAnswerPreferencesInvalid(t *testing.T) {
+	tests := []struct {
+		answers utils.Map
+		code    string
+	}{
+		{utils.Map{"q9": "o1"}, "test02"},
+		{utils.Map{"q1": []string{"o1", "o2"}}, "test03"},
+		{utils.Map{"q1": []string{}}, "test03"},
+		{utils.Map{"q2": []string{"o3", "o9"}}, "test04"},
+		{utils.Map{"q1": "o3"}, "test04"},
+	}
+
+	for _, test := range tests {
+		_, err := answerPreferences("test", testQuiz(), test.answers)
+		if appErr, ok := err.(*utils.AppError); !ok || appErr.ErrorCode != test.code {
+			t.Errorf("answerPreferences(%v) returned %v, want error %s", test.answers, err, test.code)
+		}
+	}
+}
+
+func TestProductProfiles(t *testing.T) {
+	profiles := productProfiles([]utils.Map{
+		{sales_common.FLD_PRODUCT_ID: "a", FLD_QUIZ_PREFERENCES: []utils.Map{
+			{sales_common.FLD_PREFERENCE_ID: "soft"},
+			{sales_common.FLD_PREFERENCE_ID: "cool", FLD_PREFERENCE_WEIGHT: 0.5},
+		}},
+		{sales_common.FLD_PRODUCT_ID: "a", FLD_QUIZ_PREFERENCES: []utils.Map{
+			{sales_common.FLD_PREFERENCE_ID: "cool", FLD_PREFERENCE_WEIGHT: 0.8},
+			{sales_common.FLD_PREFERENCE_ID: "heavy", FLD_PREFERENCE_WEIGHT: -0.4},
+		}},
+		{FLD_QUIZ_PREFERENCES: []utils.Map{{sales_common.FLD_PREFERENCE_ID: "soft"}}},
+	})
+
+	// Affinity is merged per product and limited to 0..1, profiles without product are skipped
+	want := map[string]map[string]float64{"a": {"soft": 1, "cool": 1, "heavy": 0}}
+	if !reflect.DeepEqual(profiles, want) {
+		t.Errorf("productProfiles = %v, want %v", profiles, want)
+	}
+}
+
+func TestScoreAndRankProfiles(t *testing.T) {
+	preferences := map[string]float64{"soft": 2, "cool": 2, "heavy": -1}
+	profiles := map[string]map[string]float64{
+		"a": {"soft": 1, "cool": 1},
+		"b": {"soft": 0.5, "heavy": 1},
+		"c": {"firm": 1},
+		"d": {"cool": 1},
+	}
+
+	match := scoreProfile(preferences, profiles["d"])
+	if match.score != 40 ||
+		!reflect.DeepEqual(match.matched, []string{"cool", "heavy"}) ||
+		!reflect.DeepEqual(match.unmatched, []string{"soft"}) {
+		t.Errorf("scoreProfile of d = %+v", match)
+	}
+
+	// Negative preference met by the product lowers the score, not below 0
+	if match := scoreProfile(preferences, profiles["b"]); match.score != 0 {
+		t.Errorf("scoreProfile of b = %v, want 0", match.score)
+	}
+
+	ranked := rankProfiles(preferences, profiles)
+	got := []string{}
+	for _, match := range ranked {
+		got = append(got, match.productId)
+	}
+	if want := []string{"a", "d"}; !reflect.DeepEqual(got, want) || ranked[0].score != 80 {
+		t.Errorf("rankProfiles = %v (%v), want %v with a scoring 80", got, ranked, want)
+	}
+
+	if ranked := rankProfiles(map[string]float64{}, profiles); len(ranked) != 0 {
+		t.Errorf("rankProfiles without preferences = %v, want none", ranked)
+	}
+}
+
+func TestMatchExplanation(t *testing.T) {
+	preferences := map[string]float64{"soft": 2, "cool": 2, "heavy": -1}
+	match := quizMatch{productId: "d", score: 40, matched: []string{"cool", "heavy"}, unmatched: []string{"soft"}}
+
+	explanation := matchExplanation(match, preferences, map[string]string{"cool": "Cooling", "soft": "Soft feel"})
+	if want := "40% match, matches Cooling, not heavy, does not match Soft feel"; explanation[FLD_QUIZ_SUMMARY] != want {
+		t.Errorf("summary = %q, want %q", explanation[FLD_QUIZ_SUMMARY], want)
+	}
+
+	matched := explanation[FLD_QUIZ_MATCHED].([]utils.Map)
+	if len(matched) != 2 || matched[1][FLD_PREFERENCE_NAME] != "heavy" || matched[1][FLD_PREFERENCE_WEIGHT] != -1.0 {
+		t.Errorf("matched = %v", matched)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/zapscloud/golib-dbutils/db_common"
 	"github.com/zapscloud/golib-dbutils/db_utils"
@@ -11,6 +12,7 @@ import (
 	"github.com/zapscloud/golib-platform-service/platform_service"
 	"github.com/zapscloud/golib-sales-repository/sales_common"
 	"github.com/zapscloud/golib-sales-repository/sales_repository"
+	"github.com/zapscloud/golib-sales-repository/sales_repository/customer_repository"
 	"github.com/zapscloud/golib-utils/utils"
 )
 
@@ -27,17 +29,31 @@ type QuizService interface {
 	Update(quizId string, indata utils.Map) (utils.Map, error)
 	// Delete - Delete Service
 	Delete(quizId string, delete_permanent bool) error
+	// SaveAnswers - Save the partial answers of the respondent {customer_id, session_id} and get the next question
+	SaveAnswers(quizId string, respondent utils.Map, answers utils.Map) (utils.Map, error)
+	// SubmitAnswers - Complete the answers {question_id: option_id} of the respondent {customer_id, session_id} and recommend the matching products
+	SubmitAnswers(quizId string, respondent utils.Map, answers utils.Map) (utils.Map, error)
+	// GetRecommendations - Get the top n products matching the recorded response
+	GetRecommendations(quizId string, responseId string, n int) (utils.Map, error)
+	// ListResponses - List the responses of the respondent {customer_id, session_id}
+	ListResponses(quizId string, respondent utils.Map) ([]utils.Map, error)
+	// Analytics - Answer distributions, drop-off per question and conversion to orders of the quiz
+	Analytics(quizId string) (utils.Map, error)
 
 	EndService()
 }
 
 type quizBaseService struct {
 	db_utils.DatabaseService
-	dbRegion    db_utils.DatabaseService
-	daoQuiz     sales_repository.QuizDao
-	daoBusiness platform_repository.BusinessDao
-	child       QuizService
-	businessId  string
+	dbRegion          db_utils.DatabaseService
+	daoQuiz           sales_repository.QuizDao
+	daoPreference     sales_repository.PreferenceDao
+	daoProdPreference sales_repository.ProdPreferenceDao
+	daoProduct        sales_repository.ProductDao
+	daoCustomer       sales_repository.CustomerDao
+	daoBusiness       platform_repository.BusinessDao
+	child             QuizService
+	businessId        string
 }
 
 // NewQuizService - Construct Quiz
@@ -94,6 +110,10 @@ func (p *quizBaseService) initializeService() {
 	log.Printf("QuizService:: GetBusinessDao ")
 	p.daoBusiness = platform_repository.NewBusinessDao(p.GetClient())
 	p.daoQuiz = sales_repository.NewQuizDao(p.GetClient(), p.businessId)
+	p.daoPreference = sales_repository.NewPreferenceDao(p.dbRegion.GetClient(), p.businessId)
+	p.daoProdPreference = sales_repository.NewProdPreferenceDao(p.dbRegion.GetClient(), p.businessId)
+	p.daoProduct = sales_repository.NewProductDao(p.dbRegion.GetClient(), p.businessId)
+	p.daoCustomer = sales_repository.NewCustomerDao(p.dbRegion.GetClient(), p.businessId)
 }
 
 // List - List All records
@@ -146,6 +166,11 @@ func (p *quizBaseService) Create(indata utils.Map) (utils.Map, error) {
 	indata[sales_common.FLD_BUSINESS_ID] = p.businessId
 	indata[sales_common.FLD_QUIZ_ID] = quizId
 
+	err := validateQuizBranches(sales_common.GetServiceModuleCode()+"M"+"15", indata)
+	if err != nil {
+		return nil, err
+	}
+
 	data, err := p.daoQuiz.Create(indata)
 	if err != nil {
 		return utils.Map{}, err
@@ -160,6 +185,14 @@ func (p *quizBaseService) Update(quizId string, indata utils.Map) (utils.Map, er
 
 	log.Println("QuizService::Update - Begin")
 
+	// Changed questions are validated with their branches
+	if _, ok := indata[FLD_QUIZ_QUESTIONS]; ok {
+		err := validateQuizBranches(sales_common.GetServiceModuleCode()+"M"+"15", indata)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	data, err := p.daoQuiz.Update(quizId, indata)
 
 	log.Println("QuizService::Update - End ")
@@ -190,6 +223,343 @@ func (p *quizBaseService) Delete(quizId string, delete_permanent bool) error {
 	return nil
 }
 
+// SaveAnswers - Save the partial answers of the respondent {customer_id, session_id} and get the next question
+// The answers are merged with the unfinished response of the respondent
+func (p *quizBaseService) SaveAnswers(quizId string, respondent utils.Map, answers utils.Map) (utils.Map, error) {
+
+	log.Println("QuizService::SaveAnswers - Begin", quizId, respondent)
+
+	response, err := p.saveResponse(quizId, respondent, answers, false)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("QuizService::SaveAnswers - End ", response[FLD_QUIZ_RESPONSE_ID])
+	return response, nil
+}
+
+// SubmitAnswers - Complete the answers {question_id: option_id} of the respondent {customer_id, session_id} and recommend the matching products
+// The selected options are mapped to the weighted preferences, the products are scored by their preference profiles
+func (p *quizBaseService) SubmitAnswers(quizId string, respondent utils.Map, answers utils.Map) (utils.Map, error) {
+
+	log.Println("QuizService::SubmitAnswers - Begin", quizId, respondent)
+
+	response, err := p.saveResponse(quizId, respondent, answers, true)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("QuizService::SubmitAnswers - End ", response[FLD_QUIZ_RESPONSE_ID])
+	return response, nil
+}
+
+// saveResponse - Validate the answer path and store the response of the respondent
+// Completing the response needs the complete answer path and stores the recommended products
+func (p *quizBaseService) saveResponse(quizId string, respondent utils.Map, answers utils.Map, complete bool) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "15"
+
+	quiz, err := p.daoQuiz.Get(quizId)
+	if err != nil {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "01",
+			ErrorMsg:    "Invalid quiz",
+			ErrorDetail: fmt.Sprintf("Given quiz %s is not exist", quizId)}
+		return nil, err
+	}
+
+	customerId, _ := respondent[sales_common.FLD_CUSTOMER_ID].(string)
+	sessionId, _ := respondent[FLD_QUIZ_SESSION_ID].(string)
+	if len(customerId) == 0 && len(sessionId) == 0 {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "11",
+			ErrorMsg:    "Invalid respondent",
+			ErrorDetail: "Customer id or session id is required"}
+		return nil, err
+	}
+	if len(customerId) > 0 {
+		_, err = p.daoCustomer.Get(customerId)
+		if err != nil {
+			err := &utils.AppError{
+				ErrorCode:   funcode + "05",
+				ErrorMsg:    "Invalid customer",
+				ErrorDetail: fmt.Sprintf("Given customer %s is not exist", customerId)}
+			return nil, err
+		}
+	}
+
+	// Continue the unfinished response of the respondent
+	responses := toMapSlice(quiz[FLD_QUIZ_RESPONSES])
+	position := -1
+	response := utils.Map{
+		FLD_QUIZ_RESPONSE_ID: utils.GenerateUniqueId("qres"),
+		FLD_QUIZ_ANSWERS:     utils.Map{},
+	}
+	for idx, item := range responses {
+		status, _ := item[FLD_QUIZ_RESPONSE_STATUS].(string)
+		if status == QUIZ_STATUS_IN_PROGRESS && isQuizRespondent(item, customerId, sessionId) {
+			position = idx
+			response = utils.CopyMap(item)
+			break
+		}
+	}
+
+	merged, _ := toMap(response[FLD_QUIZ_ANSWERS])
+	merged = utils.CopyMap(merged)
+	for questionId, answer := range answers {
+		merged[questionId] = answer
+	}
+
+	path, next, missing, err := walkQuizPath(funcode, quiz, merged)
+	if err != nil {
+		return nil, err
+	}
+	preferences, err := answerPreferences(funcode, quiz, merged)
+	if err != nil {
+		return nil, err
+	}
+	if complete && len(missing) > 0 {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "10",
+			ErrorMsg:    "Incomplete answers",
+			ErrorDetail: fmt.Sprintf("Question %s needs answer", missing)}
+		return nil, err
+	}
+
+	// Session response is linked to the customer once known
+	if len(customerId) > 0 {
+		response[sales_common.FLD_CUSTOMER_ID] = customerId
+	}
+	if len(sessionId) > 0 {
+		response[FLD_QUIZ_SESSION_ID] = sessionId
+	}
+	response[FLD_QUIZ_ANSWERS] = merged
+	response[FLD_QUIZ_PATH] = path
+	response[FLD_QUIZ_NEXT_QUESTION] = next
+	response[FLD_QUIZ_PREFERENCES] = toPreferenceMaps(preferences)
+	response[FLD_QUIZ_ANSWERED_AT] = time.Now()
+	response[FLD_QUIZ_RESPONSE_STATUS] = QUIZ_STATUS_IN_PROGRESS
+
+	var products []utils.Map
+	if complete {
+		products, err = p.recommendProducts(preferences, QUIZ_DEFAULT_RECOMMENDATIONS)
+		if err != nil {
+			return nil, err
+		}
+		productIds := []string{}
+		for _, product := range products {
+			productIds = append(productIds, productIdOf(product))
+		}
+		response[FLD_QUIZ_RESPONSE_STATUS] = QUIZ_STATUS_COMPLETED
+		response[FLD_QUIZ_COMPLETED_AT] = response[FLD_QUIZ_ANSWERED_AT]
+		response[FLD_QUIZ_RECOMMENDED_IDS] = productIds
+	}
+
+	if position >= 0 {
+		responses[position] = response
+	} else {
+		responses = append(responses, response)
+	}
+	_, err = p.daoQuiz.Update(quizId, utils.Map{FLD_QUIZ_RESPONSES: responses})
+	if err != nil {
+		return nil, err
+	}
+
+	response = utils.CopyMap(response)
+	if complete {
+		response[FLD_QUIZ_PRODUCTS] = products
+	} else if question, ok := quizQuestion(quiz, next); ok {
+		response[FLD_QUIZ_NEXT_DETAILS] = question
+	}
+	return response, nil
+}
+
+// GetRecommendations - Get the top n products matching the recorded response
+func (p *quizBaseService) GetRecommendations(quizId string, responseId string, n int) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "15"
+
+	log.Println("QuizService::GetRecommendations - Begin", quizId, responseId, n)
+
+	quiz, err := p.daoQuiz.Get(quizId)
+	if err != nil {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "01",
+			ErrorMsg:    "Invalid quiz",
+			ErrorDetail: fmt.Sprintf("Given quiz %s is not exist", quizId)}
+		return nil, err
+	}
+
+	var response utils.Map
+	for _, item := range toMapSlice(quiz[FLD_QUIZ_RESPONSES]) {
+		if id, _ := item[FLD_QUIZ_RESPONSE_ID].(string); id == responseId {
+			response = utils.CopyMap(item)
+			break
+		}
+	}
+	if response == nil {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "06",
+			ErrorMsg:    "Invalid response",
+			ErrorDetail: fmt.Sprintf("Given response %s is not exist", responseId)}
+		return nil, err
+	}
+
+	if n <= 0 {
+		n = QUIZ_DEFAULT_RECOMMENDATIONS
+	}
+	products, err := p.recommendProducts(preferenceWeights(response[FLD_QUIZ_PREFERENCES]), n)
+	if err != nil {
+		return nil, err
+	}
+	response[FLD_QUIZ_PRODUCTS] = products
+
+	log.Println("QuizService::GetRecommendations - End ", len(products))
+	return response, nil
+}
+
+// recommendProducts - Rank the products by the match of their preference profiles, with the match explanation
+func (p *quizBaseService) recommendProducts(preferences map[string]float64, n int) ([]utils.Map, error) {
+	listdata, err := p.daoProdPreference.List("", "", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	matches := rankProfiles(preferences, productProfiles(listResult(listdata)))
+
+	listdata, err = p.daoPreference.List("", "", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]string{}
+	for _, preference := range listResult(listdata) {
+		preferenceId, _ := preference[sales_common.FLD_PREFERENCE_ID].(string)
+		names[preferenceId], _ = preference[FLD_PREFERENCE_NAME].(string)
+	}
+
+	products := []utils.Map{}
+	for _, match := range matches {
+		if len(products) >= n {
+			break
+		}
+		product, err := getPricedProduct(p.daoProduct, match.productId)
+		if err != nil {
+			continue
+		}
+		if deleted, _ := product[db_common.FLD_IS_DELETED].(bool); deleted {
+			continue
+		}
+		product = utils.CopyMap(product)
+		product[FLD_QUIZ_MATCH_SCORE] = match.score
+		product[FLD_QUIZ_MATCH] = matchExplanation(match, preferences, names)
+		products = append(products, product)
+	}
+	return products, nil
+}
+
+// ListResponses - List the responses of the respondent {customer_id, session_id}
+func (p *quizBaseService) ListResponses(quizId string, respondent utils.Map) ([]utils.Map, error) {
+
+	log.Println("QuizService::ListResponses - Begin", quizId, respondent)
+
+	quiz, err := p.daoQuiz.Get(quizId)
+	if err != nil {
+		return nil, err
+	}
+
+	customerId, _ := respondent[sales_common.FLD_CUSTOMER_ID].(string)
+	sessionId, _ := respondent[FLD_QUIZ_SESSION_ID].(string)
+	responses := []utils.Map{}
+	for _, response := range toMapSlice(quiz[FLD_QUIZ_RESPONSES]) {
+		if isQuizRespondent(response, customerId, sessionId) {
+			responses = append(responses, response)
+		}
+	}
+
+	log.Println("QuizService::ListResponses - End ", len(responses))
+	return responses, nil
+}
+
+// Analytics - Answer distributions, drop-off per question and conversion to orders of the quiz
+// Completed response converts when the customer ordered after completing the quiz,
+// and converts to the recommendation when the order has any of the recommended products.
+func (p *quizBaseService) Analytics(quizId string) (utils.Map, error) {
+
+	log.Println("QuizService::Analytics - Begin", quizId)
+
+	quiz, err := p.daoQuiz.Get(quizId)
+	if err != nil {
+		return nil, err
+	}
+	responses := toMapSlice(quiz[FLD_QUIZ_RESPONSES])
+
+	completed, converted, recommendedConverted := 0, 0, 0
+	customerOrders := map[string][]utils.Map{}
+	for _, response := range responses {
+		status, _ := response[FLD_QUIZ_RESPONSE_STATUS].(string)
+		if status != QUIZ_STATUS_COMPLETED {
+			continue
+		}
+		completed++
+
+		customerId, _ := response[sales_common.FLD_CUSTOMER_ID].(string)
+		if len(customerId) == 0 {
+			continue
+		}
+		orders, ok := customerOrders[customerId]
+		if !ok {
+			daoOrder := customer_repository.NewCustomerOrderDao(p.GetClient(), p.businessId, customerId)
+			orderdata, err := daoOrder.List("", "", 0, 0)
+			if err != nil {
+				log.Println("QuizService::Analytics - Orders not found", customerId, err)
+			}
+			orders = listResult(orderdata)
+			customerOrders[customerId] = orders
+		}
+
+		completedAt, _ := toTime(response[FLD_QUIZ_COMPLETED_AT])
+		recommended := map[string]bool{}
+		for _, productId := range toStringSlice(response[FLD_QUIZ_RECOMMENDED_IDS]) {
+			recommended[productId] = true
+		}
+
+		ordered, orderedRecommended := false, false
+		for _, order := range orders {
+			orderStatus, _ := order[FLD_ORDER_STATUS].(string)
+			createdAt, _ := toTime(order[db_common.FLD_CREATED_AT])
+			if orderStatus == ORDER_STATUS_CANCELLED || createdAt.Before(completedAt) {
+				continue
+			}
+			ordered = true
+			for _, item := range toMapSlice(order[FLD_ORDER_ITEMS]) {
+				if recommended[productIdOf(item)] {
+					orderedRecommended = true
+				}
+			}
+		}
+		if ordered {
+			converted++
+		}
+		if orderedRecommended {
+			recommendedConverted++
+		}
+	}
+
+	response := utils.Map{
+		sales_common.FLD_QUIZ_ID: quizId,
+		FLD_QUIZ_TOTAL_RESPONSES: len(responses),
+		FLD_QUIZ_COMPLETED:       completed,
+		FLD_QUIZ_COMPLETION_RATE: quizRate(completed, len(responses)),
+		FLD_QUIZ_QUESTION_STATS:  quizQuestionStats(quiz, responses),
+		FLD_QUIZ_CONVERSION: utils.Map{
+			FLD_QUIZ_CONVERTED:             converted,
+			FLD_QUIZ_CONVERSION_RATE:       quizRate(converted, completed),
+			FLD_QUIZ_RECOMMENDED_CONVERTED: recommendedConverted,
+			FLD_QUIZ_RECOMMENDED_RATE:      quizRate(recommendedConverted, completed),
+		},
+	}
+
+	log.Println("QuizService::Analytics - End ", completed)
+	return response, nil
+}
+
 func (p *quizBaseService) errorReturn(err error) (QuizService, error) {
 	// Close the Database Connection
 	p.EndService()
@@ -0,0 +1,242 @@
+package sales_service
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zapscloud/golib-dbutils/db_common"
+	"github.com/zapscloud/golib-sales-repository/sales_common"
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+const (
+	// Product fields for the related products computed by the batch job
+	FLD_PRODUCT_RELATED     = "related_products"
+	FLD_RELATED_SCORE       = "score"
+	FLD_RELATED_CO_PURCHASE = "co_purchase_count"
+	FLD_RELATED_COMPUTED_AT = "related_computed_at"
+
+	// Weight of the co-purchase and the attribute similarity in the related score
+	relatedCoPurchaseWeight = 0.7
+	relatedAttributeWeight  = 0.3
+
+	// Weight of each shared attribute in the attribute similarity
+	relatedCategoryWeight = 0.4
+	relatedBrandWeight    = 0.3
+	relatedFirmnessWeight = 0.3
+
+	// Related products kept for each product
+	RELATED_PRODUCTS_MAX = 20
+)
+
+// relatedProduct - Product related to another with its score
+type relatedProduct struct {
+	productId  string
+	score      float64
+	coPurchase int
+}
+
+// productFeatures - Attributes of the product compared for the similarity
+type productFeatures struct {
+	categoryId string
+	brandId    string
+	firmness   map[string]bool
+}
+
+// recommendationCache - Related products of the business
+type recommendationCache struct {
+	related    map[string][]relatedProduct
+	computedAt time.Time
+}
+
+// recommendationCaches - Related products cached per business
+var recommendationCaches sync.Map
+
+// toRelatedMaps - Convert the related products to store in the product
+func toRelatedMaps(related []relatedProduct) []utils.Map {
+	result := make([]utils.Map, 0, len(related))
+	for _, item := range related {
+		result = append(result, utils.Map{
+			sales_common.FLD_PRODUCT_ID: item.productId,
+			FLD_RELATED_SCORE:           item.score,
+			FLD_RELATED_CO_PURCHASE:     item.coPurchase,
+		})
+	}
+	return result
+}
+
+// fromRelatedMaps - Read the related products stored in the product
+func fromRelatedMaps(items []utils.Map) []relatedProduct {
+	result := make([]relatedProduct, 0, len(items))
+	for _, item := range items {
+		result = append(result, relatedProduct{
+			productId:  productIdOf(item),
+			score:      toFloat(item[FLD_RELATED_SCORE]),
+			coPurchase: toInt(item[FLD_RELATED_CO_PURCHASE]),
+		})
+	}
+	return result
+}
+
+// recommendableProducts - Index the products by id, variants are mapped to their parent
+// Returns the products and the parent id of each variant
+func recommendableProducts(products []utils.Map) (map[string]utils.Map, map[string]string) {
+	parents := map[string]utils.Map{}
+	variantParent := map[string]string{}
+	for _, product := range products {
+		if deleted, _ := product[db_common.FLD_IS_DELETED].(bool); deleted {
+			continue
+		}
+		productId := productIdOf(product)
+		if parentId, _ := product[FLD_PRODUCT_PARENT_ID].(string); len(parentId) > 0 {
+			variantParent[productId] = parentId
+			continue
+		}
+		parents[productId] = product
+	}
+	return parents, variantParent
+}
+
+// buildProductFeatures - Collect the attributes of the products, firmness of the variants is added to the parent
+func buildProductFeatures(products []utils.Map, variantParent map[string]string) map[string]*productFeatures {
+	features := map[string]*productFeatures{}
+	get := func(productId string) *productFeatures {
+		if features[productId] == nil {
+			features[productId] = &productFeatures{firmness: map[string]bool{}}
+		}
+		return features[productId]
+	}
+
+	for _, product := range products {
+		productId := productIdOf(product)
+		if parentId, ok := variantParent[productId]; ok {
+			attributes, _ := toMap(product[FLD_PRODUCT_VARIANT_ATTRIBUTES])
+			if firmnessId, _ := attributes[sales_common.FLD_FIRMNESS_ID].(string); len(firmnessId) > 0 {
+				get(parentId).firmness[firmnessId] = true
+			}
+			continue
+		}
+
+		feature := get(productId)
+		feature.categoryId, _ = product[sales_common.FLD_CATEGORY_ID].(string)
+		feature.brandId, _ = product[sales_common.FLD_BRAND_ID].(string)
+		if firmnessId, _ := product[sales_common.FLD_FIRMNESS_ID].(string); len(firmnessId) > 0 {
+			feature.firmness[firmnessId] = true
+		}
+	}
+	return features
+}
+
+// attributeSimilarity - Similarity of the products by shared category, brand and firmness
+func attributeSimilarity(first *productFeatures, second *productFeatures) float64 {
+	if first == nil || second == nil {
+		return 0
+	}
+
+	similarity := 0.0
+	if len(first.categoryId) > 0 && first.categoryId == second.categoryId {
+		similarity += relatedCategoryWeight
+	}
+	if len(first.brandId) > 0 && first.brandId == second.brandId {
+		similarity += relatedBrandWeight
+	}
+	for firmnessId := range first.firmness {
+		if second.firmness[firmnessId] {
+			similarity += relatedFirmnessWeight
+			break
+		}
+	}
+	return similarity
+}
+
+// computeRelated - Compute the related products from the products bought together in the orders
+// Co-purchase is the cosine of the order counts, blended with the attribute similarity
+func computeRelated(orders [][]string, products []utils.Map, maxRelated int) map[string][]relatedProduct {
+	parents, variantParent := recommendableProducts(products)
+	features := buildProductFeatures(products, variantParent)
+
+	orderCount := map[string]int{}
+	pairCount := map[string]map[string]int{}
+	for _, order := range orders {
+		// Count each product once per order, variants as their parent
+		inOrder := map[string]bool{}
+		for _, productId := range order {
+			if parentId, ok := variantParent[productId]; ok {
+				productId = parentId
+			}
+			if _, ok := parents[productId]; ok {
+				inOrder[productId] = true
+			}
+		}
+
+		for first := range inOrder {
+			orderCount[first]++
+			for second := range inOrder {
+				if first == second {
+					continue
+				}
+				if pairCount[first] == nil {
+					pairCount[first] = map[string]int{}
+				}
+				pairCount[first][second]++
+			}
+		}
+	}
+
+	// Products of the same category are considered, so the products not sold yet get the related products
+	byCategory := map[string][]string{}
+	for productId := range parents {
+		if feature := features[productId]; feature != nil && len(feature.categoryId) > 0 {
+			byCategory[feature.categoryId] = append(byCategory[feature.categoryId], productId)
+		}
+	}
+
+	related := map[string][]relatedProduct{}
+	for productId := range parents {
+		candidates := map[string]bool{}
+		for otherId := range pairCount[productId] {
+			candidates[otherId] = true
+		}
+		if feature := features[productId]; feature != nil {
+			for _, otherId := range byCategory[feature.categoryId] {
+				candidates[otherId] = true
+			}
+		}
+		delete(candidates, productId)
+
+		items := []relatedProduct{}
+		for otherId := range candidates {
+			count := pairCount[productId][otherId]
+			coPurchase := 0.0
+			if count > 0 {
+				coPurchase = float64(count) / math.Sqrt(float64(orderCount[productId]*orderCount[otherId]))
+			}
+			score := relatedCoPurchaseWeight*coPurchase + relatedAttributeWeight*attributeSimilarity(features[productId], features[otherId])
+			if score > 0 {
+				items = append(items, relatedProduct{productId: otherId, score: math.Round(score*10000) / 10000, coPurchase: count})
+			}
+		}
+
+		sortRelated(items)
+		if len(items) > maxRelated {
+			items = items[:maxRelated]
+		}
+		related[productId] = items
+	}
+	return related
+}
+
+// sortRelated - Sort by score, by co-purchase count and product id on a tie
+func sortRelated(items []relatedProduct) {
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].score != items[j].score {
+			return items[i].score > items[j].score
+		}
+		if items[i].coPurchase != items[j].coPurchase {
+			return items[i].coPurchase > items[j].coPurchase
+		}
+		return items[i].productId < items[j].productId
+	})
+}
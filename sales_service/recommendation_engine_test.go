@@ -0,0 +1,119 @@
+package sales_service
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zapscloud/golib-dbutils/db_common"
+	"github.com/zapscloud/golib-sales-repository/sales_common"
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+func testRecommendationProducts() []utils.Map {
+	return []utils.Map{
+		{sales_common.FLD_PRODUCT_ID: "p1", sales_common.FLD_CATEGORY_ID: "A", sales_common.FLD_BRAND_ID: "X", sales_common.FLD_FIRMNESS_ID: "f1"},
+		{sales_common.FLD_PRODUCT_ID: "p2", sales_common.FLD_CATEGORY_ID: "A", sales_common.FLD_BRAND_ID: "Y"},
+		{sales_common.FLD_PRODUCT_ID: "p3", sales_common.FLD_CATEGORY_ID: "B", sales_common.FLD_BRAND_ID: "X"},
+		{sales_common.FLD_PRODUCT_ID: "p4", sales_common.FLD_CATEGORY_ID: "C"},
+		{sales_common.FLD_PRODUCT_ID: "v1", FLD_PRODUCT_PARENT_ID: "p2",
+			FLD_PRODUCT_VARIANT_ATTRIBUTES: utils.Map{sales_common.FLD_FIRMNESS_ID: "f1"}},
+		{sales_common.FLD_PRODUCT_ID: "p5", sales_common.FLD_CATEGORY_ID: "A", db_common.FLD_IS_DELETED: true},
+	}
+}
+
+func TestAttributeSimilarity(t *testing.T) {
+	products := testRecommendationProducts()
+	_, variantParent := recommendableProducts(products)
+	features := buildProductFeatures(products, variantParent)
+
+	tests := []struct {
+		first  string
+		second string
+		want   float64
+	}{
+		// Same category, firmness of the variant counted for the parent
+		{"p1", "p2", relatedCategoryWeight + relatedFirmnessWeight},
+		{"p1", "p3", relatedBrandWeight},
+		{"p2", "p3", 0},
+		{"p1", "p4", 0},
+		{"p1", "unknown", 0},
+	}
+
+	for _, test := range tests {
+		if got := attributeSimilarity(features[test.first], features[test.second]); got != test.want {
+			t.Errorf("attributeSimilarity(%s, %s) = %v, want %v", test.first, test.second, got, test.want)
+		}
+	}
+}
+
+func TestComputeRelated(t *testing.T) {
+	orders := [][]string{
+		{"p1", "v1"},
+		{"p1", "p2", "p3", "p1"},
+		{"p3"},
+		{"p1", "p5"},
+	}
+
+	related := computeRelated(orders, testRecommendationProducts(), RELATED_PRODUCTS_MAX)
+
+	// Variants and deleted products get no related products of their own
+	if _, ok := related["v1"]; ok {
+		t.Error("computeRelated returned the related products of the variant")
+	}
+	if _, ok := related["p5"]; ok {
+		t.Error("computeRelated returned the related products of the deleted product")
+	}
+
+	// p1 is in 3 orders, p2 in 2 (once as variant) and p3 in 2; p1 is bought with p2 twice and with p3 once
+	want := []relatedProduct{
+		{productId: "p2", score: 0.7815, coPurchase: 2},
+		{productId: "p3", score: 0.3758, coPurchase: 1},
+	}
+	if !reflect.DeepEqual(related["p1"], want) {
+		t.Errorf("related of p1 = %+v, want %+v", related["p1"], want)
+	}
+
+	// Product not sold yet and alone in its category has no related products
+	if len(related["p4"]) != 0 {
+		t.Errorf("related of p4 = %+v, want none", related["p4"])
+	}
+
+	limited := computeRelated(orders, testRecommendationProducts(), 1)
+	if len(limited["p1"]) != 1 || limited["p1"][0].productId != "p2" {
+		t.Errorf("related of p1 limited to 1 = %+v", limited["p1"])
+	}
+}
+
+func TestComputeRelatedSameCategoryWithoutOrders(t *testing.T) {
+	related := computeRelated(nil, testRecommendationProducts(), RELATED_PRODUCTS_MAX)
+
+	want := []relatedProduct{{productId: "p1", score: 0.21}}
+	if !reflect.DeepEqual(related["p2"], want) {
+		t.Errorf("related of p2 = %+v, want %+v", related["p2"], want)
+	}
+}
+
+func TestSortRelated(t *testing.T) {
+	items := []relatedProduct{
+		{productId: "c", score: 0.5, coPurchase: 1},
+		{productId: "b", score: 0.5, coPurchase: 1},
+		{productId: "a", score: 0.5, coPurchase: 3},
+		{productId: "d", score: 0.9},
+	}
+	sortRelated(items)
+
+	got := []string{}
+	for _, item := range items {
+		got = append(got, item.productId)
+	}
+	if want := []string{"d", "a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sortRelated = %v, want %v", got, want)
+	}
+}
+
+func TestRelatedMapsRoundTrip(t *testing.T) {
+	related := []relatedProduct{{productId: "p2", score: 0.7815, coPurchase: 2}}
+	if got := fromRelatedMaps(toMapSlice(roundTrip(t, utils.Map{"items": toRelatedMaps(related)})["items"])); !reflect.DeepEqual(got, related) {
+		t.Errorf("related products after store = %+v, want %+v", got, related)
+	}
+}
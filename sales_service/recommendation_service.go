@@ -0,0 +1,327 @@
+package sales_service
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/zapscloud/golib-dbutils/db_common"
+	"github.com/zapscloud/golib-dbutils/db_utils"
+	"github.com/zapscloud/golib-platform-repository/platform_repository"
+	"github.com/zapscloud/golib-platform-service/platform_service"
+	"github.com/zapscloud/golib-sales-repository/sales_common"
+	"github.com/zapscloud/golib-sales-repository/sales_repository"
+	"github.com/zapscloud/golib-sales-repository/sales_repository/customer_repository"
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+const (
+	// Recompute response fields
+	FLD_RECOMMENDATION_ORDERS   = "orders"
+	FLD_RECOMMENDATION_PRODUCTS = "products"
+
+	RECOMMENDATION_DEFAULT_COUNT = 5
+)
+
+// RecommendationService - Related products mined from the orders bought together
+type RecommendationService interface {
+	// Related - Get the top n products related to the product
+	Related(productId string, n int) ([]utils.Map, error)
+	// CartRecommendations - Get the top n products for the cart, cart is {"items": [{"product_id": "", "quantity": 1}]}
+	CartRecommendations(cart utils.Map, n int) ([]utils.Map, error)
+	// Recompute - Mine the orders of all the customers and store the related products of the business
+	Recompute() (utils.Map, error)
+
+	EndService()
+}
+
+type recommendationBaseService struct {
+	db_utils.DatabaseService
+	dbRegion    db_utils.DatabaseService
+	daoProduct  sales_repository.ProductDao
+	daoCustomer sales_repository.CustomerDao
+	daoBusiness platform_repository.BusinessDao
+	child       RecommendationService
+	businessId  string
+}
+
+// NewRecommendationService - Construct Recommendation
+func NewRecommendationService(props utils.Map) (RecommendationService, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "01"
+
+	log.Printf("RecommendationService::Start ")
+	// Verify whether the business id data passed
+	businessId, err := utils.GetMemberDataStr(props, sales_common.FLD_BUSINESS_ID)
+	if err != nil {
+		return nil, err
+	}
+
+	p := recommendationBaseService{}
+	// Open Database Service
+	err = p.OpenDatabaseService(props)
+	if err != nil {
+		return nil, err
+	}
+
+	// Open RegionDB Service
+	p.dbRegion, err = platform_service.OpenRegionDatabaseService(props)
+	if err != nil {
+		p.CloseDatabaseService()
+		return nil, err
+	}
+
+	// Assign the BusinessId
+	p.businessId = businessId
+	p.initializeService()
+
+	_, err = p.daoBusiness.Get(businessId)
+	if err != nil {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "01",
+			ErrorMsg:    "Invalid BusinessId",
+			ErrorDetail: "Given BusinessId is not exist"}
+		return p.errorReturn(err)
+	}
+
+	p.child = &p
+
+	return &p, err
+}
+
+// EndService - Close all the services
+func (p *recommendationBaseService) EndService() {
+	log.Printf("EndService ")
+	p.CloseDatabaseService()
+	p.dbRegion.CloseDatabaseService()
+}
+
+func (p *recommendationBaseService) initializeService() {
+	log.Printf("RecommendationService:: GetBusinessDao ")
+	p.daoBusiness = platform_repository.NewBusinessDao(p.GetClient())
+	p.daoProduct = sales_repository.NewProductDao(p.dbRegion.GetClient(), p.businessId)
+	p.daoCustomer = sales_repository.NewCustomerDao(p.dbRegion.GetClient(), p.businessId)
+}
+
+// Related - Get the top n products related to the product
+func (p *recommendationBaseService) Related(productId string, n int) ([]utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "14"
+
+	log.Println("RecommendationService::Related - Begin", productId, n)
+
+	product, err := p.daoProduct.Get(productId)
+	if err != nil {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "01",
+			ErrorMsg:    "Invalid product",
+			ErrorDetail: fmt.Sprintf("Given product %s is not exist", productId)}
+		return nil, err
+	}
+	// Variants share the related products of their parent
+	if parentId, _ := product[FLD_PRODUCT_PARENT_ID].(string); len(parentId) > 0 {
+		productId = parentId
+	}
+
+	cache, err := p.loadCache()
+	if err != nil {
+		return nil, err
+	}
+
+	response := p.relatedProducts(cache.related[productId], map[string]bool{productId: true}, n)
+
+	log.Println("RecommendationService::Related - End ", len(response))
+	return response, nil
+}
+
+// CartRecommendations - Get the top n products for the cart, the related products of the cart items are summed
+func (p *recommendationBaseService) CartRecommendations(cart utils.Map, n int) ([]utils.Map, error) {
+
+	log.Println("RecommendationService::CartRecommendations - Begin", cart, n)
+
+	cache, err := p.loadCache()
+	if err != nil {
+		return nil, err
+	}
+
+	inCart := map[string]bool{}
+	for _, item := range toMapSlice(cart[FLD_ORDER_ITEMS]) {
+		productId := productIdOf(item)
+		if product, err := p.daoProduct.Get(productId); err == nil {
+			if parentId, _ := product[FLD_PRODUCT_PARENT_ID].(string); len(parentId) > 0 {
+				productId = parentId
+			}
+		}
+		inCart[productId] = true
+	}
+
+	scores := map[string]*relatedProduct{}
+	for productId := range inCart {
+		for _, item := range cache.related[productId] {
+			if scores[item.productId] == nil {
+				scores[item.productId] = &relatedProduct{productId: item.productId}
+			}
+			scores[item.productId].score += item.score
+			scores[item.productId].coPurchase += item.coPurchase
+		}
+	}
+
+	candidates := make([]relatedProduct, 0, len(scores))
+	for _, item := range scores {
+		candidates = append(candidates, *item)
+	}
+	sortRelated(candidates)
+
+	response := p.relatedProducts(candidates, inCart, n)
+
+	log.Println("RecommendationService::CartRecommendations - End ", len(response))
+	return response, nil
+}
+
+// Recompute - Mine the orders of all the customers and store the related products of the business
+func (p *recommendationBaseService) Recompute() (utils.Map, error) {
+
+	log.Println("RecommendationService::Recompute - Begin")
+
+	listdata, err := p.daoProduct.List("", "", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	products := listResult(listdata)
+
+	orders, err := p.orderProducts()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	related := computeRelated(orders, products, RELATED_PRODUCTS_MAX)
+	for productId, items := range related {
+		_, err = p.daoProduct.Update(productId, utils.Map{
+			FLD_PRODUCT_RELATED:     toRelatedMaps(items),
+			FLD_RELATED_COMPUTED_AT: now,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	recommendationCaches.Store(p.businessId, &recommendationCache{related: related, computedAt: now})
+
+	response := utils.Map{
+		FLD_RECOMMENDATION_ORDERS:   len(orders),
+		FLD_RECOMMENDATION_PRODUCTS: len(related),
+		FLD_RELATED_COMPUTED_AT:     now,
+	}
+
+	log.Println("RecommendationService::Recompute - End ", response)
+	return response, nil
+}
+
+// orderProducts - Get the product ids of each order of all the customers, cancelled and returned orders are skipped
+func (p *recommendationBaseService) orderProducts() ([][]string, error) {
+	listdata, err := p.daoCustomer.List("", "", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	orders := [][]string{}
+	for _, customer := range listResult(listdata) {
+		customerId, _ := customer[sales_common.FLD_CUSTOMER_ID].(string)
+
+		daoOrder := customer_repository.NewCustomerOrderDao(p.GetClient(), p.businessId, customerId)
+		orderdata, err := daoOrder.List("", "", 0, 0)
+		if err != nil {
+			log.Println("RecommendationService::orderProducts - Orders not found", customerId, err)
+			continue
+		}
+
+		for _, order := range listResult(orderdata) {
+			orderStatus, _ := order[FLD_ORDER_STATUS].(string)
+			if orderStatus == ORDER_STATUS_CANCELLED || orderStatus == ORDER_STATUS_RETURNED {
+				continue
+			}
+
+			productIds := []string{}
+			for _, item := range toMapSlice(order[FLD_ORDER_ITEMS]) {
+				if productId := productIdOf(item); len(productId) > 0 {
+					productIds = append(productIds, productId)
+				}
+			}
+			if len(productIds) > 1 {
+				orders = append(orders, productIds)
+			}
+		}
+	}
+	return orders, nil
+}
+
+// loadCache - Get the related products of the business
+// Loaded from the products stored by the last recompute, recomputed when never computed
+func (p *recommendationBaseService) loadCache() (*recommendationCache, error) {
+	if cached, ok := recommendationCaches.Load(p.businessId); ok {
+		return cached.(*recommendationCache), nil
+	}
+
+	listdata, err := p.daoProduct.List("", "", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	cache := &recommendationCache{related: map[string][]relatedProduct{}}
+	for _, product := range listResult(listdata) {
+		computedAt, ok := toTime(product[FLD_RELATED_COMPUTED_AT])
+		if !ok {
+			continue
+		}
+		cache.related[productIdOf(product)] = fromRelatedMaps(toMapSlice(product[FLD_PRODUCT_RELATED]))
+		if computedAt.After(cache.computedAt) {
+			cache.computedAt = computedAt
+		}
+	}
+
+	if len(cache.related) == 0 {
+		if _, err := p.Recompute(); err != nil {
+			return nil, err
+		}
+		cached, _ := recommendationCaches.Load(p.businessId)
+		return cached.(*recommendationCache), nil
+	}
+
+	actual, _ := recommendationCaches.LoadOrStore(p.businessId, cache)
+	return actual.(*recommendationCache), nil
+}
+
+// relatedProducts - Get the top n related products, skipping the excluded and deleted products
+func (p *recommendationBaseService) relatedProducts(items []relatedProduct, exclude map[string]bool, n int) []utils.Map {
+	if n <= 0 {
+		n = RECOMMENDATION_DEFAULT_COUNT
+	}
+
+	response := []utils.Map{}
+	for _, item := range items {
+		if len(response) >= n {
+			break
+		}
+		if exclude[item.productId] {
+			continue
+		}
+
+		product, err := getPricedProduct(p.daoProduct, item.productId)
+		if err != nil {
+			continue
+		}
+		if deleted, _ := product[db_common.FLD_IS_DELETED].(bool); deleted {
+			continue
+		}
+		product = utils.CopyMap(product)
+		delete(product, FLD_PRODUCT_RELATED)
+		product[FLD_RELATED_SCORE] = item.score
+		product[FLD_RELATED_CO_PURCHASE] = item.coPurchase
+		response = append(response, product)
+	}
+	return response
+}
+
+func (p *recommendationBaseService) errorReturn(err error) (RecommendationService, error) {
+	// Close the Database Connection
+	p.EndService()
+	return nil, err
+}
@@ -0,0 +1,636 @@
+package sales_service
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/zapscloud/golib-dbutils/db_common"
+	"github.com/zapscloud/golib-dbutils/db_utils"
+	"github.com/zapscloud/golib-platform-repository/platform_repository"
+	"github.com/zapscloud/golib-platform-service/platform_service"
+	"github.com/zapscloud/golib-sales-repository/sales_common"
+	"github.com/zapscloud/golib-sales-repository/sales_repository"
+	"github.com/zapscloud/golib-sales-repository/sales_repository/customer_repository"
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+const (
+	// Customer fields for the referral
+	FLD_REFERRAL_CODE      = "referral_code"
+	FLD_REFERRED_BY        = "referred_by"
+	FLD_REFERRAL_STATUS    = "referral_status"
+	FLD_REFERRAL_ORDER_ID  = "referral_order_id"
+	FLD_REFERRAL_DEVICE_ID = "referral_device_id"
+	FLD_REFERRAL_REWARDS   = "referral_rewards"
+	FLD_REFERRAL_REASON    = "referral_reason"
+	FLD_DEVICE_ID          = "device_id"
+	FLD_WALLET_BALANCE     = "wallet_balance"
+	// Wallet credits of the customer keyed by the reward id {reward_id: amount},
+	// the wallet balance is the total of the credits
+	FLD_WALLET_CREDITS = "wallet_credits"
+
+	// Reward fields, the rewards of the referral are keyed by the side {referrer: reward, referee: reward}
+	FLD_REFERRAL_REWARD_ID     = "reward_id"
+	FLD_REFERRAL_REWARD_STATUS = "reward_status"
+
+	// Business setting for the referral program
+	FLD_REFERRAL_PROGRAM            = "referral_program"
+	FLD_REFERRAL_REWARD_TYPE        = "reward_type"
+	FLD_REFERRAL_REWARD_VALUE       = "reward_value"
+	FLD_REFERRAL_REFEREE_VALUE      = "referee_reward_value"
+	FLD_REFERRAL_COUPON_TEMPLATE    = "coupon_template_id"
+	FLD_REFERRAL_RETURN_WINDOW_DAYS = "return_window_days"
+
+	// Order fields used for the referral, the delivered time is set by the order update
+	// when the order status is changed to delivered
+	FLD_ORDER_STATUS       = "order_status"
+	FLD_ORDER_DELIVERED_AT = "delivered_at"
+
+	ORDER_STATUS_DELIVERED = "delivered"
+	ORDER_STATUS_CANCELLED = "cancelled"
+	ORDER_STATUS_RETURNED  = "returned"
+
+	REFERRAL_STATUS_SIGNED_UP = "signed_up"
+	REFERRAL_STATUS_ORDERED   = "ordered"
+	REFERRAL_STATUS_REWARDED  = "rewarded"
+	REFERRAL_STATUS_REJECTED  = "rejected"
+	REFERRAL_STATUS_PENDING   = "pending"
+	REFERRAL_STATUS_FAILED    = "failed"
+
+	REFERRAL_REWARD_COUPON = "coupon"
+	REFERRAL_REWARD_WALLET = "wallet"
+
+	REFERRAL_SIDE_REFERRER = "referrer"
+	REFERRAL_SIDE_REFEREE  = "referee"
+
+	REFERRAL_REWARD_STATUS_ISSUING = "issuing"
+	REFERRAL_REWARD_STATUS_ISSUED  = "issued"
+
+	REFERRAL_CODE_PATTERN               = "XXXXXXXX"
+	REFERRAL_REWARD_COUPON_PATTERN      = "REF-XXXX-XXXX"
+	REFERRAL_DEFAULT_RETURN_WINDOW_DAYS = 30
+	// Days after the registration the customer can sign up with a referral code
+	REFERRAL_SIGNUP_WINDOW_DAYS = 7
+)
+
+// ReferralService - Customer referral program
+type ReferralService interface {
+	// GetReferralCode - Get the referral code of the customer, created on the first call
+	GetReferralCode(customerId string) (utils.Map, error)
+	// AttributeSignup - Attribute the new customer to the referrer of the code
+	AttributeSignup(refereeId string, referralCode string, deviceId string) (utils.Map, error)
+	// AttributeOrder - Attribute the first order of the referred customer
+	AttributeOrder(refereeId string, orderId string) (utils.Map, error)
+	// ProcessRewards - Issue the rewards for the delivered orders past the return window
+	ProcessRewards() (utils.Map, error)
+	// ListReferrals - List the customers referred by the customer
+	ListReferrals(referrerId string, sort string, skip int64, limit int64) (utils.Map, error)
+
+	EndService()
+}
+
+type referralBaseService struct {
+	db_utils.DatabaseService
+	dbRegion    db_utils.DatabaseService
+	daoCustomer sales_repository.CustomerDao
+	daoCoupon   sales_repository.CouponDao
+	daoBusiness platform_repository.BusinessDao
+	child       ReferralService
+	businessId  string
+}
+
+// NewReferralService - Construct Referral
+func NewReferralService(props utils.Map) (ReferralService, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "01"
+
+	log.Printf("ReferralService::Start ")
+	// Verify whether the business id data passed
+	businessId, err := utils.GetMemberDataStr(props, sales_common.FLD_BUSINESS_ID)
+	if err != nil {
+		return nil, err
+	}
+
+	p := referralBaseService{}
+	// Open Database Service
+	err = p.OpenDatabaseService(props)
+	if err != nil {
+		return nil, err
+	}
+
+	// Open RegionDB Service
+	p.dbRegion, err = platform_service.OpenRegionDatabaseService(props)
+	if err != nil {
+		p.CloseDatabaseService()
+		return nil, err
+	}
+
+	// Assign the BusinessId
+	p.businessId = businessId
+	p.initializeService()
+
+	_, err = p.daoBusiness.Get(businessId)
+	if err != nil {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "01",
+			ErrorMsg:    "Invalid BusinessId",
+			ErrorDetail: "Given BusinessId is not exist"}
+		return p.errorReturn(err)
+	}
+
+	p.child = &p
+
+	return &p, err
+}
+
+// EndService - Close all the services
+func (p *referralBaseService) EndService() {
+	log.Printf("EndService ")
+	p.CloseDatabaseService()
+	p.dbRegion.CloseDatabaseService()
+}
+
+func (p *referralBaseService) initializeService() {
+	log.Printf("ReferralService:: GetBusinessDao ")
+	p.daoBusiness = platform_repository.NewBusinessDao(p.GetClient())
+	p.daoCustomer = sales_repository.NewCustomerDao(p.dbRegion.GetClient(), p.businessId)
+	p.daoCoupon = sales_repository.NewCouponDao(p.dbRegion.GetClient(), p.businessId)
+}
+
+// GetReferralCode - Get the referral code of the customer, created on the first call
+func (p *referralBaseService) GetReferralCode(customerId string) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "04"
+
+	log.Println("ReferralService::GetReferralCode - Begin", customerId)
+
+	customer, err := p.daoCustomer.Get(customerId)
+	if err != nil {
+		return nil, err
+	}
+
+	code, _ := customer[FLD_REFERRAL_CODE].(string)
+	for attempt := 0; len(code) == 0; attempt++ {
+		if attempt >= 10 {
+			err := &utils.AppError{
+				ErrorCode:   funcode + "01",
+				ErrorMsg:    "Unable to generate referral code",
+				ErrorDetail: "Unable to generate unique referral code, try again"}
+			return nil, err
+		}
+
+		code, err = generateCouponCode(REFERRAL_CODE_PATTERN)
+		if err != nil {
+			return nil, err
+		}
+		exists, err := recordExists(p.daoCustomer, utils.Map{FLD_REFERRAL_CODE: code})
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			// Already used by another customer
+			code = ""
+			continue
+		}
+
+		_, err = p.daoCustomer.Update(customerId, utils.Map{FLD_REFERRAL_CODE: code})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	log.Println("ReferralService::GetReferralCode - End ", code)
+	return utils.Map{sales_common.FLD_CUSTOMER_ID: customerId, FLD_REFERRAL_CODE: code}, nil
+}
+
+// AttributeSignup - Attribute the new customer to the referrer of the code
+func (p *referralBaseService) AttributeSignup(refereeId string, referralCode string, deviceId string) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "04"
+
+	log.Println("ReferralService::AttributeSignup - Begin", refereeId, referralCode, deviceId)
+
+	referee, err := p.daoCustomer.Get(refereeId)
+	if err != nil {
+		return nil, err
+	}
+	if referredBy, _ := referee[FLD_REFERRED_BY].(string); len(referredBy) > 0 {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "02",
+			ErrorMsg:    "Already referred",
+			ErrorDetail: "Customer is already attributed to a referrer"}
+		return nil, err
+	}
+
+	// Only the new customers can be referred, registered recently and without any order
+	isNew, err := p.isNewCustomer(referee)
+	if err != nil {
+		return nil, err
+	}
+	if !isNew {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "08",
+			ErrorMsg:    "Not a new customer",
+			ErrorDetail: "Only the newly registered customers without orders can use a referral code"}
+		return nil, err
+	}
+
+	// Use the device of the customer record when not passed
+	if len(deviceId) == 0 {
+		deviceId, _ = referee[FLD_DEVICE_ID].(string)
+	}
+
+	referralCode = strings.ToUpper(strings.TrimSpace(referralCode))
+	referrers, err := findActive(p.daoCustomer, utils.Map{FLD_REFERRAL_CODE: referralCode}, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(referralCode) == 0 || len(referrers) == 0 {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "03",
+			ErrorMsg:    "Invalid referral code",
+			ErrorDetail: "Given referral code is not exist"}
+		return nil, err
+	}
+	referrer := referrers[0]
+	referrerId, _ := referrer[sales_common.FLD_CUSTOMER_ID].(string)
+
+	// Self referral guard
+	referrerDevice, _ := referrer[FLD_DEVICE_ID].(string)
+	if referrerId == refereeId || (len(deviceId) > 0 && deviceId == referrerDevice) {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "04",
+			ErrorMsg:    "Self referral not allowed",
+			ErrorDetail: "Customer cannot use own referral code"}
+		return nil, err
+	}
+
+	// Duplicate device guard, a device can be used for one referral only
+	if len(deviceId) > 0 {
+		used, err := recordExists(p.daoCustomer, utils.Map{FLD_REFERRAL_DEVICE_ID: deviceId})
+		if err != nil {
+			return nil, err
+		}
+		if used {
+			err := &utils.AppError{
+				ErrorCode:   funcode + "05",
+				ErrorMsg:    "Duplicate device",
+				ErrorDetail: "Given device is already used for a referral"}
+			return nil, err
+		}
+	}
+
+	indata := utils.Map{
+		FLD_REFERRED_BY:        referrerId,
+		FLD_REFERRAL_STATUS:    REFERRAL_STATUS_SIGNED_UP,
+		FLD_REFERRAL_DEVICE_ID: deviceId,
+	}
+	data, err := p.daoCustomer.Update(refereeId, indata)
+
+	log.Println("ReferralService::AttributeSignup - End ", err)
+	return data, err
+}
+
+// AttributeOrder - Attribute the first order of the referred customer
+func (p *referralBaseService) AttributeOrder(refereeId string, orderId string) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "04"
+
+	log.Println("ReferralService::AttributeOrder - Begin", refereeId, orderId)
+
+	referee, err := p.daoCustomer.Get(refereeId)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the first order after the signup is attributed
+	status, _ := referee[FLD_REFERRAL_STATUS].(string)
+	if status != REFERRAL_STATUS_SIGNED_UP {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "06",
+			ErrorMsg:    "Referral not open",
+			ErrorDetail: "Customer is not referred or the first order is already attributed"}
+		return nil, err
+	}
+
+	daoOrder := customer_repository.NewCustomerOrderDao(p.GetClient(), p.businessId, refereeId)
+	_, err = daoOrder.Get(orderId)
+	if err != nil {
+		return nil, err
+	}
+
+	indata := utils.Map{
+		FLD_REFERRAL_STATUS:   REFERRAL_STATUS_ORDERED,
+		FLD_REFERRAL_ORDER_ID: orderId,
+	}
+	data, err := p.daoCustomer.Update(refereeId, indata)
+
+	log.Println("ReferralService::AttributeOrder - End ", err)
+	return data, err
+}
+
+// ProcessRewards - Issue the rewards for the delivered orders past the return window
+// It is meant to be called periodically by a batch job
+func (p *referralBaseService) ProcessRewards() (utils.Map, error) {
+
+	log.Println("ReferralService::ProcessRewards - Begin")
+
+	program, err := p.referralProgram()
+	if err != nil {
+		return nil, err
+	}
+	returnWindow := REFERRAL_DEFAULT_RETURN_WINDOW_DAYS
+	if _, ok := program[FLD_REFERRAL_RETURN_WINDOW_DAYS]; ok {
+		returnWindow = toInt(program[FLD_REFERRAL_RETURN_WINDOW_DAYS])
+	}
+
+	listdata, err := p.daoCustomer.List(buildFilter(utils.Map{FLD_REFERRAL_STATUS: REFERRAL_STATUS_ORDERED}), "", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	rewarded, rejected, pending, failed := 0, 0, 0, 0
+	now := time.Now()
+	for _, referee := range listResult(listdata) {
+		// A failed referee is counted and the others are processed, it is retried on the next run
+		status, err := p.processReferee(program, referee, returnWindow, now)
+		if err != nil {
+			log.Println("ReferralService::ProcessRewards - Referee failed", referee[sales_common.FLD_CUSTOMER_ID], err)
+			failed++
+			continue
+		}
+		switch status {
+		case REFERRAL_STATUS_REWARDED:
+			rewarded++
+		case REFERRAL_STATUS_REJECTED:
+			rejected++
+		default:
+			pending++
+		}
+	}
+
+	response := utils.Map{
+		REFERRAL_STATUS_REWARDED: rewarded,
+		REFERRAL_STATUS_REJECTED: rejected,
+		REFERRAL_STATUS_PENDING:  pending,
+		REFERRAL_STATUS_FAILED:   failed,
+	}
+
+	log.Println("ReferralService::ProcessRewards - End ", response)
+	return response, nil
+}
+
+// processReferee - Issue the rewards of the referee when the order is delivered and past the return window,
+// returns the referral status of the referee
+func (p *referralBaseService) processReferee(program utils.Map, referee utils.Map, returnWindow int, now time.Time) (string, error) {
+	refereeId, _ := referee[sales_common.FLD_CUSTOMER_ID].(string)
+	referrerId, _ := referee[FLD_REFERRED_BY].(string)
+	orderId, _ := referee[FLD_REFERRAL_ORDER_ID].(string)
+
+	daoOrder := customer_repository.NewCustomerOrderDao(p.GetClient(), p.businessId, refereeId)
+	order, err := daoOrder.Get(orderId)
+	if err != nil {
+		log.Println("ReferralService::ProcessRewards - Order not found", refereeId, orderId, err)
+		return REFERRAL_STATUS_PENDING, nil
+	}
+
+	status, reason := referralOutcome(order, returnWindow, now)
+	switch status {
+	case REFERRAL_STATUS_REJECTED:
+		_, err = p.daoCustomer.Update(refereeId, utils.Map{
+			FLD_REFERRAL_STATUS: REFERRAL_STATUS_REJECTED,
+			FLD_REFERRAL_REASON: reason,
+		})
+		return status, err
+	case REFERRAL_STATUS_PENDING:
+		return status, nil
+	}
+
+	// Each reward is recorded on the referee before it is issued, so a run failed in between
+	// continues with the same reward and never issues it twice
+	rewards, _ := toMap(referee[FLD_REFERRAL_REWARDS])
+	if rewards == nil {
+		rewards = utils.Map{}
+	}
+	_, err = p.rewardOnce(program, refereeId, rewards, REFERRAL_SIDE_REFERRER, referrerId, toFloat(program[FLD_REFERRAL_REWARD_VALUE]))
+	if err != nil {
+		return "", err
+	}
+	if refereeValue := toFloat(program[FLD_REFERRAL_REFEREE_VALUE]); refereeValue > 0 {
+		_, err = p.rewardOnce(program, refereeId, rewards, REFERRAL_SIDE_REFEREE, refereeId, refereeValue)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	_, err = p.daoCustomer.Update(refereeId, utils.Map{FLD_REFERRAL_STATUS: REFERRAL_STATUS_REWARDED})
+	if err != nil {
+		return "", err
+	}
+	return REFERRAL_STATUS_REWARDED, nil
+}
+
+// referralOutcome - Referral status for the order of the referee, rewarded when the order is delivered
+// and past the return window, rejected along with the reason when the order is cancelled or returned
+func referralOutcome(order utils.Map, returnWindow int, now time.Time) (string, string) {
+	orderStatus, _ := order[FLD_ORDER_STATUS].(string)
+	switch orderStatus {
+	case ORDER_STATUS_CANCELLED, ORDER_STATUS_RETURNED:
+		return REFERRAL_STATUS_REJECTED, "Order " + orderStatus
+	case ORDER_STATUS_DELIVERED:
+	default:
+		return REFERRAL_STATUS_PENDING, ""
+	}
+
+	deliveredAt, ok := toTime(order[FLD_ORDER_DELIVERED_AT])
+	if !ok || now.Before(deliveredAt.AddDate(0, 0, returnWindow)) {
+		return REFERRAL_STATUS_PENDING, ""
+	}
+	return REFERRAL_STATUS_REWARDED, ""
+}
+
+// ListReferrals - List the customers referred by the customer
+func (p *referralBaseService) ListReferrals(referrerId string, sort string, skip int64, limit int64) (utils.Map, error) {
+
+	log.Println("ReferralService::ListReferrals - Begin", referrerId)
+
+	listdata, err := p.daoCustomer.List(buildFilter(utils.Map{FLD_REFERRED_BY: referrerId}), sort, skip, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	// Remove the Password
+	for _, referee := range listResult(listdata) {
+		delete(referee, sales_common.FLD_CUSTOMER_PASSWORD)
+	}
+
+	log.Println("ReferralService::ListReferrals - End ")
+	return listdata, nil
+}
+
+// referralProgram - Get the referral program settings of the business
+func (p *referralBaseService) referralProgram() (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "04"
+
+	value, _, err := getBusinessSetting(p.daoBusiness, p.businessId, FLD_REFERRAL_PROGRAM)
+	if err != nil {
+		return nil, err
+	}
+
+	program, ok := toMap(value)
+	if !ok {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "07",
+			ErrorMsg:    "Referral program not configured",
+			ErrorDetail: "Referral program settings are not configured for the business"}
+		return nil, err
+	}
+	return program, nil
+}
+
+// isNewCustomer - Verify the customer is registered recently and has no orders
+func (p *referralBaseService) isNewCustomer(customer utils.Map) (bool, error) {
+	if createdAt, ok := toTime(customer[db_common.FLD_CREATED_AT]); ok &&
+		time.Now().After(createdAt.AddDate(0, 0, REFERRAL_SIGNUP_WINDOW_DAYS)) {
+		return false, nil
+	}
+
+	customerId, _ := customer[sales_common.FLD_CUSTOMER_ID].(string)
+	daoOrder := customer_repository.NewCustomerOrderDao(p.GetClient(), p.businessId, customerId)
+	hasOrders, err := recordExists(daoOrder, utils.Map{})
+	if err != nil {
+		return false, err
+	}
+	return !hasOrders, nil
+}
+
+// rewardOnce - Issue the reward of the side once, the reward is recorded on the referee before it is issued
+// The recorded reward id makes the retry reuse the same coupon or wallet credit
+func (p *referralBaseService) rewardOnce(program utils.Map, refereeId string, rewards utils.Map, side string, customerId string, value float64) (utils.Map, error) {
+
+	reward, _ := toMap(rewards[side])
+	if status, _ := reward[FLD_REFERRAL_REWARD_STATUS].(string); status == REFERRAL_REWARD_STATUS_ISSUED {
+		return reward, nil
+	}
+
+	if reward == nil {
+		rewardType, _ := program[FLD_REFERRAL_REWARD_TYPE].(string)
+		if rewardType != REFERRAL_REWARD_WALLET {
+			rewardType = REFERRAL_REWARD_COUPON
+		}
+		reward = utils.Map{
+			FLD_REFERRAL_REWARD_ID:       utils.GenerateUniqueId("rwd"),
+			FLD_REFERRAL_REWARD_STATUS:   REFERRAL_REWARD_STATUS_ISSUING,
+			sales_common.FLD_CUSTOMER_ID: customerId,
+			FLD_REFERRAL_REWARD_TYPE:     rewardType,
+			FLD_REFERRAL_REWARD_VALUE:    value,
+		}
+		_, err := p.daoCustomer.Update(refereeId, utils.Map{FLD_REFERRAL_REWARDS + "." + side: reward})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	reward, err := p.issueReward(program, reward)
+	if err != nil {
+		return nil, err
+	}
+	reward[FLD_REFERRAL_REWARD_STATUS] = REFERRAL_REWARD_STATUS_ISSUED
+	_, err = p.daoCustomer.Update(refereeId, utils.Map{FLD_REFERRAL_REWARDS + "." + side: reward})
+	if err != nil {
+		return nil, err
+	}
+	rewards[side] = reward
+	return reward, nil
+}
+
+// issueReward - Issue the coupon or wallet credit of the recorded reward
+// Issuing the same reward again does not create another coupon or credit
+func (p *referralBaseService) issueReward(program utils.Map, reward utils.Map) (utils.Map, error) {
+
+	reward = utils.CopyMap(reward)
+	rewardId, _ := reward[FLD_REFERRAL_REWARD_ID].(string)
+	customerId, _ := reward[sales_common.FLD_CUSTOMER_ID].(string)
+	value := toFloat(reward[FLD_REFERRAL_REWARD_VALUE])
+
+	switch reward[FLD_REFERRAL_REWARD_TYPE] {
+	case REFERRAL_REWARD_WALLET:
+		// The credit is set under the reward id, setting it again keeps the single credit
+		_, err := p.daoCustomer.Update(customerId, utils.Map{FLD_WALLET_CREDITS + "." + rewardId: value})
+		if err != nil {
+			return nil, err
+		}
+		customer, err := p.daoCustomer.Get(customerId)
+		if err != nil {
+			return nil, err
+		}
+		_, err = p.daoCustomer.Update(customerId, utils.Map{FLD_WALLET_BALANCE: walletBalance(customer)})
+		if err != nil {
+			return nil, err
+		}
+
+	default:
+		// The coupon id is the reward id, the coupon created by the failed run is reused
+		coupons, err := findActive(p.daoCoupon, utils.Map{sales_common.FLD_COUPON_ID: rewardId}, 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(coupons) > 0 {
+			reward[sales_common.FLD_COUPON_ID] = rewardId
+			reward[FLD_COUPON_CODE] = coupons[0][FLD_COUPON_CODE]
+			return reward, nil
+		}
+
+		// Coupon for the customer, copied from the template coupon when configured
+		coupon := utils.Map{
+			FLD_OFFER_DISCOUNT_TYPE:  DISCOUNT_TYPE_FIXED,
+			FLD_OFFER_DISCOUNT_VALUE: value,
+		}
+		if templateId, _ := program[FLD_REFERRAL_COUPON_TEMPLATE].(string); len(templateId) > 0 {
+			template, err := p.daoCoupon.Get(templateId)
+			if err != nil {
+				return nil, err
+			}
+			coupon = utils.CopyMap(template)
+			delete(coupon, db_common.FLD_DEFAULT_ID)
+			delete(coupon, db_common.FLD_CREATED_AT)
+			delete(coupon, db_common.FLD_UPDATED_AT)
+			coupon[FLD_COUPON_PARENT_ID] = templateId
+		}
+
+		code, err := generateCouponCode(REFERRAL_REWARD_COUPON_PATTERN)
+		if err != nil {
+			return nil, err
+		}
+
+		coupon[sales_common.FLD_BUSINESS_ID] = p.businessId
+		coupon[sales_common.FLD_COUPON_ID] = rewardId
+		coupon[sales_common.FLD_CUSTOMER_ID] = customerId
+		coupon[FLD_COUPON_CODE] = code
+		coupon[FLD_COUPON_IS_SINGLE_USE] = true
+		coupon[FLD_COUPON_IS_REDEEMED] = false
+		coupon[FLD_COUPON_IS_VOIDED] = false
+
+		_, err = p.daoCoupon.Create(coupon)
+		if err != nil {
+			return nil, err
+		}
+		reward[sales_common.FLD_COUPON_ID] = rewardId
+		reward[FLD_COUPON_CODE] = code
+	}
+
+	return reward, nil
+}
+
+// walletBalance - Wallet balance of the customer, the total of the wallet credits
+func walletBalance(customer utils.Map) float64 {
+	credits, _ := toMap(customer[FLD_WALLET_CREDITS])
+	balance := 0.0
+	for _, amount := range credits {
+		balance += toFloat(amount)
+	}
+	return roundAmount(balance)
+}
+
+func (p *referralBaseService) errorReturn(err error) (ReferralService, error) {
+	// Close the Database Connection
+	p.EndService()
+	return nil, err
+}
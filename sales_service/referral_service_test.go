@@ -0,0 +1,41 @@
+package sales_service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+func TestReferralOutcome(t *testing.T) {
+	now := time.Date(2030, 3, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		order  utils.Map
+		status string
+	}{
+		{"placed", utils.Map{FLD_ORDER_STATUS: "placed"}, REFERRAL_STATUS_PENDING},
+		{"cancelled", utils.Map{FLD_ORDER_STATUS: ORDER_STATUS_CANCELLED}, REFERRAL_STATUS_REJECTED},
+		{"returned", utils.Map{FLD_ORDER_STATUS: ORDER_STATUS_RETURNED}, REFERRAL_STATUS_REJECTED},
+		{"delivered without time", utils.Map{FLD_ORDER_STATUS: ORDER_STATUS_DELIVERED}, REFERRAL_STATUS_PENDING},
+		{"within return window", utils.Map{FLD_ORDER_STATUS: ORDER_STATUS_DELIVERED, FLD_ORDER_DELIVERED_AT: now.AddDate(0, 0, -10)}, REFERRAL_STATUS_PENDING},
+		{"past return window", utils.Map{FLD_ORDER_STATUS: ORDER_STATUS_DELIVERED, FLD_ORDER_DELIVERED_AT: now.AddDate(0, 0, -31)}, REFERRAL_STATUS_REWARDED},
+	}
+
+	for _, test := range tests {
+		status, reason := referralOutcome(roundTrip(t, test.order), 30, now)
+		if status != test.status {
+			t.Errorf("%s: status = %s, want %s", test.name, status, test.status)
+		}
+		if status == REFERRAL_STATUS_REJECTED && len(reason) == 0 {
+			t.Errorf("%s: rejected without a reason", test.name)
+		}
+	}
+}
+
+func TestWalletBalance(t *testing.T) {
+	customer := roundTrip(t, utils.Map{FLD_WALLET_CREDITS: utils.Map{"rwd1": 10.10, "rwd2": 5.20}})
+	if balance := walletBalance(customer); balance != 15.3 {
+		t.Errorf("walletBalance = %v", balance)
+	}
+}
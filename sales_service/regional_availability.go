@@ -0,0 +1,466 @@
+package sales_service
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/zapscloud/golib-dbutils/db_common"
+	"github.com/zapscloud/golib-sales-repository/sales_common"
+	"github.com/zapscloud/golib-sales-repository/sales_repository"
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+const (
+	// Region and state fields for the serviceability
+	// Postal codes are the exact code, the prefix ending with * or the range from-to, e.g. 560001, 5600*, 560001-560099
+	// Region lists its states, so the region of the state is known
+	FLD_POSTAL_CODES     = "postal_codes"
+	FLD_REGION_STATE_IDS = "state_ids"
+
+	// Shopper location {region_id, state_id, postal_code}
+	FLD_SHOPPER_LOCATION = "location"
+	FLD_POSTAL_CODE      = "postal_code"
+	FLD_IS_SERVICEABLE   = "is_serviceable"
+
+	// Product availability and price by region or state [{region_id | state_id, is_available, price}]
+	// The state rule takes precedence over the region rule, the products without a rule use available_by_default
+	FLD_PRODUCT_REGIONAL_RULES      = "regional_availability"
+	FLD_PRODUCT_AVAILABLE_DEFAULT   = "available_by_default"
+	FLD_REGIONAL_IS_AVAILABLE       = "is_available"
+	FLD_REGIONAL_DEFAULT_PRICE      = "default_price"
+	FLD_REGIONAL_UNAVAILABLE_REASON = "reason"
+
+	// Cart validation response fields
+	FLD_CART_IS_VALID    = "is_valid"
+	FLD_CART_UNAVAILABLE = "unavailable_items"
+)
+
+// shopperLocation - Location of the shopper resolved from the region, state and postal code
+type shopperLocation struct {
+	regionId    string
+	stateId     string
+	postalCode  string
+	serviceable bool
+}
+
+// toMap - Location details for the response
+func (loc shopperLocation) toMap() utils.Map {
+	return utils.Map{
+		sales_common.FLD_REGION_ID: loc.regionId,
+		sales_common.FLD_STATE_ID:  loc.stateId,
+		FLD_POSTAL_CODE:            loc.postalCode,
+		FLD_IS_SERVICEABLE:         loc.serviceable,
+	}
+}
+
+// postalCodeMatches - Check whether the postal code matches any of the patterns
+func postalCodeMatches(patterns []string, postalCode string) bool {
+	postalCode = strings.ToUpper(strings.ReplaceAll(postalCode, " ", ""))
+	for _, pattern := range patterns {
+		pattern = strings.ToUpper(strings.ReplaceAll(pattern, " ", ""))
+		switch {
+		case strings.HasSuffix(pattern, "*"):
+			if strings.HasPrefix(postalCode, strings.TrimSuffix(pattern, "*")) {
+				return true
+			}
+		case strings.Contains(pattern, "-"):
+			bounds := strings.SplitN(pattern, "-", 2)
+			// Range compares the codes of the same length, so the text order is the number order
+			if len(bounds[0]) == len(postalCode) && len(bounds[1]) == len(postalCode) &&
+				postalCode >= bounds[0] && postalCode <= bounds[1] {
+				return true
+			}
+		case pattern == postalCode:
+			return true
+		}
+	}
+	return false
+}
+
+// regionalRule - Rule of the product for the location, the state rule first and then the region rule
+func regionalRule(product utils.Map, loc shopperLocation) (utils.Map, bool) {
+	rules := toMapSlice(product[FLD_PRODUCT_REGIONAL_RULES])
+	if len(loc.stateId) > 0 {
+		for _, rule := range rules {
+			if stateId, _ := rule[sales_common.FLD_STATE_ID].(string); stateId == loc.stateId {
+				return rule, true
+			}
+		}
+	}
+	if len(loc.regionId) > 0 {
+		for _, rule := range rules {
+			if regionId, _ := rule[sales_common.FLD_REGION_ID].(string); regionId == loc.regionId {
+				return rule, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// productAvailability - Check whether the product can be delivered to the location, with the reason when not
+func productAvailability(product utils.Map, loc shopperLocation) (bool, string) {
+	if !loc.serviceable {
+		return false, fmt.Sprintf("postal code %s is not serviceable", loc.postalCode)
+	}
+
+	if rule, ok := regionalRule(product, loc); ok {
+		if available, ok := rule[FLD_REGIONAL_IS_AVAILABLE].(bool); ok {
+			if !available {
+				if stateId, _ := rule[sales_common.FLD_STATE_ID].(string); len(stateId) > 0 {
+					return false, fmt.Sprintf("not available in state %s", stateId)
+				}
+				return false, fmt.Sprintf("not available in region %v", rule[sales_common.FLD_REGION_ID])
+			}
+			return true, ""
+		}
+	}
+
+	if available, ok := product[FLD_PRODUCT_AVAILABLE_DEFAULT].(bool); ok && !available {
+		return false, "not available in the region"
+	}
+	return true, ""
+}
+
+// withRegionalPrice - Product with the price of its regional rule, the default price is kept for the display
+// Regional price replaces the scheduled price as well
+func withRegionalPrice(product utils.Map, loc shopperLocation) utils.Map {
+	rule, ok := regionalRule(product, loc)
+	if !ok {
+		return product
+	}
+	if _, ok := rule[FLD_PRODUCT_PRICE]; !ok {
+		return product
+	}
+
+	product = utils.CopyMap(product)
+	product[FLD_REGIONAL_DEFAULT_PRICE] = product[FLD_PRODUCT_PRICE]
+	product[FLD_PRODUCT_PRICE] = toFloat(rule[FLD_PRODUCT_PRICE])
+	return product
+}
+
+// regionLocator - Resolve the shopper location and validate the regional rules
+type regionLocator struct {
+	daoRegion sales_repository.RegionDao
+	daoStates sales_repository.StatesDao
+}
+
+// activeRecords - Records of the list which are not deleted
+func activeRecords(listdata utils.Map) []utils.Map {
+	records := []utils.Map{}
+	for _, record := range listResult(listdata) {
+		if deleted, _ := record[db_common.FLD_IS_DELETED].(bool); !deleted {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// resolve - Resolve the location {region_id, state_id, postal_code}
+// The postal code finds the state and region when not given, and the region of the state is taken from the region states.
+// Location without the postal code is serviceable, else the postal code must be in any region or state.
+func (l *regionLocator) resolve(location utils.Map) (shopperLocation, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "20"
+
+	loc := shopperLocation{serviceable: true}
+	loc.regionId, _ = location[sales_common.FLD_REGION_ID].(string)
+	loc.stateId, _ = location[sales_common.FLD_STATE_ID].(string)
+	loc.postalCode, _ = location[FLD_POSTAL_CODE].(string)
+	loc.postalCode = strings.TrimSpace(loc.postalCode)
+
+	if len(loc.regionId) > 0 {
+		_, err := l.daoRegion.Get(loc.regionId)
+		if err != nil {
+			return loc, invalidLocationError(funcode, "region", loc.regionId)
+		}
+	}
+	if len(loc.stateId) > 0 {
+		_, err := l.daoStates.Get(loc.stateId)
+		if err != nil {
+			return loc, invalidLocationError(funcode, "state", loc.stateId)
+		}
+	}
+
+	if len(loc.postalCode) == 0 && (len(loc.stateId) == 0 || len(loc.regionId) > 0) {
+		return loc, nil
+	}
+
+	listdata, err := l.daoRegion.List("", "", 0, 0)
+	if err != nil {
+		return loc, err
+	}
+	regions := activeRecords(listdata)
+
+	if len(loc.postalCode) > 0 {
+		listdata, err = l.daoStates.List("", "", 0, 0)
+		if err != nil {
+			return loc, err
+		}
+
+		matched := false
+		for _, state := range activeRecords(listdata) {
+			if postalCodeMatches(toStringSlice(state[FLD_POSTAL_CODES]), loc.postalCode) {
+				matched = true
+				if len(loc.stateId) == 0 {
+					loc.stateId, _ = state[sales_common.FLD_STATE_ID].(string)
+				}
+				break
+			}
+		}
+		for _, region := range regions {
+			if postalCodeMatches(toStringSlice(region[FLD_POSTAL_CODES]), loc.postalCode) {
+				matched = true
+				if len(loc.regionId) == 0 {
+					loc.regionId, _ = region[sales_common.FLD_REGION_ID].(string)
+				}
+				break
+			}
+		}
+		loc.serviceable = matched
+	}
+
+	if len(loc.regionId) == 0 && len(loc.stateId) > 0 {
+		for _, region := range regions {
+			stateIds := toStringSlice(region[FLD_REGION_STATE_IDS])
+			if len(removeIds(stateIds, []string{loc.stateId})) < len(stateIds) {
+				loc.regionId, _ = region[sales_common.FLD_REGION_ID].(string)
+				break
+			}
+		}
+	}
+	return loc, nil
+}
+
+// validateRules - Validate the regional rules refer to one existing region or state with the price not negative
+func (l *regionLocator) validateRules(rules []utils.Map) error {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "20"
+
+	seen := map[string]bool{}
+	for _, rule := range rules {
+		regionId, _ := rule[sales_common.FLD_REGION_ID].(string)
+		stateId, _ := rule[sales_common.FLD_STATE_ID].(string)
+
+		var err error
+		key := ""
+		switch {
+		case len(regionId) > 0 && len(stateId) > 0, len(regionId) == 0 && len(stateId) == 0:
+			err = fmt.Errorf("rule needs either region_id or state_id")
+		case len(regionId) > 0:
+			key = "region:" + regionId
+			if _, getErr := l.daoRegion.Get(regionId); getErr != nil {
+				err = fmt.Errorf("region %s is not exist", regionId)
+			}
+		default:
+			key = "state:" + stateId
+			if _, getErr := l.daoStates.Get(stateId); getErr != nil {
+				err = fmt.Errorf("state %s is not exist", stateId)
+			}
+		}
+		if err == nil && seen[key] {
+			err = fmt.Errorf("%s has more than one rule", key)
+		}
+		if _, ok := rule[FLD_PRODUCT_PRICE]; err == nil && ok && toFloat(rule[FLD_PRODUCT_PRICE]) < 0 {
+			err = fmt.Errorf("price of %s cannot be negative", key)
+		}
+		if err != nil {
+			return &utils.AppError{
+				ErrorCode:   funcode + "02",
+				ErrorMsg:    "Invalid regional rule",
+				ErrorDetail: err.Error()}
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// invalidLocationError - Error for the region or state not exist
+func invalidLocationError(funcode string, kind string, id string) error {
+	return &utils.AppError{
+		ErrorCode:   funcode + "01",
+		ErrorMsg:    "Invalid location",
+		ErrorDetail: fmt.Sprintf("Given %s %s is not exist", kind, id)}
+}
+
+// regionalSource - Product holding the regional rules, the variant without its own rules follows the parent
+func regionalSource(daoProduct sales_repository.ProductDao, product utils.Map) (utils.Map, error) {
+	parentId, _ := product[FLD_PRODUCT_PARENT_ID].(string)
+	_, hasRules := product[FLD_PRODUCT_REGIONAL_RULES]
+	_, hasDefault := product[FLD_PRODUCT_AVAILABLE_DEFAULT]
+	if len(parentId) == 0 || hasRules || hasDefault {
+		return product, nil
+	}
+	return daoProduct.Get(parentId)
+}
+
+// withRegionalRules - Product with the regional rules of its source, used for the variant following the parent
+func withRegionalRules(product utils.Map, source utils.Map) utils.Map {
+	if productIdOf(source) == productIdOf(product) {
+		return product
+	}
+	product = utils.CopyMap(product)
+	product[FLD_PRODUCT_REGIONAL_RULES] = source[FLD_PRODUCT_REGIONAL_RULES]
+	product[FLD_PRODUCT_AVAILABLE_DEFAULT] = source[FLD_PRODUCT_AVAILABLE_DEFAULT]
+	return product
+}
+
+// ProductRegionalService - Product Regional Availability Service structure
+type ProductRegionalService interface {
+	// SetRegionalAvailability - Set the availability and price rules of the product by region or state
+	SetRegionalAvailability(productId string, rules []utils.Map, availableByDefault bool) (utils.Map, error)
+	// CheckAvailability - Check whether the product is available for the location {region_id, state_id, postal_code}
+	CheckAvailability(productId string, location utils.Map) (utils.Map, error)
+	// ListForLocation - List the published products available for the location, priced for the location
+	ListForLocation(location utils.Map, filter string, sort string, skip int64, limit int64) (utils.Map, error)
+
+	EndService()
+}
+
+// productRegionalBaseService - Product Regional Availability Service structure, shares the product service
+type productRegionalBaseService struct {
+	*productBaseService
+}
+
+// NewProductRegionalService - Construct Product Regional Availability
+func NewProductRegionalService(props utils.Map) (ProductRegionalService, error) {
+
+	log.Printf("ProductRegionalService::Start ")
+	p, err := newProductBaseService(props)
+	if err != nil {
+		return nil, err
+	}
+	return &productRegionalBaseService{p}, nil
+}
+
+// SetRegionalAvailability - Set the availability and price rules of the product by region or state
+// Product without a rule for the location is available when availableByDefault
+func (p *productRegionalBaseService) SetRegionalAvailability(productId string, rules []utils.Map, availableByDefault bool) (utils.Map, error) {
+
+	log.Println("ProductRegionalService::SetRegionalAvailability - Begin", productId, len(rules), availableByDefault)
+
+	_, err := p.daoProduct.Get(productId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.regionLocator.validateRules(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := p.daoProduct.Update(productId, utils.Map{
+		FLD_PRODUCT_REGIONAL_RULES:    rules,
+		FLD_PRODUCT_AVAILABLE_DEFAULT: availableByDefault,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("ProductRegionalService::SetRegionalAvailability - End ")
+	return data, nil
+}
+
+// CheckAvailability - Check whether the product is available for the location {region_id, state_id, postal_code}, with its regional price
+func (p *productRegionalBaseService) CheckAvailability(productId string, location utils.Map) (utils.Map, error) {
+
+	log.Println("ProductRegionalService::CheckAvailability - Begin", productId, location)
+
+	loc, err := p.regionLocator.resolve(location)
+	if err != nil {
+		return nil, err
+	}
+
+	product, err := getPricedProduct(p.daoProduct, productId)
+	if err != nil {
+		return nil, err
+	}
+	source, err := regionalSource(p.daoProduct, product)
+	if err != nil {
+		return nil, err
+	}
+
+	available, reason := productAvailability(source, loc)
+	if !isProductPublished(product) {
+		available, reason = false, "product is not published"
+	}
+	priced := withRegionalPrice(withRegionalRules(product, source), loc)
+
+	response := utils.Map{
+		sales_common.FLD_PRODUCT_ID:     productId,
+		FLD_REGIONAL_IS_AVAILABLE:       available,
+		FLD_REGIONAL_UNAVAILABLE_REASON: reason,
+		FLD_PRODUCT_PRICE:               priced[FLD_PRODUCT_PRICE],
+		FLD_REGIONAL_DEFAULT_PRICE:      product[FLD_PRODUCT_PRICE],
+		FLD_SHOPPER_LOCATION:            loc.toMap(),
+	}
+
+	log.Println("ProductRegionalService::CheckAvailability - End ", available)
+	return response, nil
+}
+
+// ListForLocation - List the published products available for the location, priced for the location
+// Availability is checked on the listed products, so the paging is applied after the check
+func (p *productRegionalBaseService) ListForLocation(location utils.Map, filter string, sort string, skip int64, limit int64) (utils.Map, error) {
+
+	log.Println("ProductRegionalService::ListForLocation - Begin", location, filter)
+
+	loc, err := p.regionLocator.resolve(location)
+	if err != nil {
+		return nil, err
+	}
+
+	listdata, err := p.listPublished(filter, sort, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	ctx, err := p.priceResolver.loadContext(p.customerId)
+	if err != nil {
+		return nil, err
+	}
+
+	products := []utils.Map{}
+	parents := map[string]utils.Map{}
+	for _, product := range listResult(listdata) {
+		// Parents are loaded once for their variants
+		parentId, _ := product[FLD_PRODUCT_PARENT_ID].(string)
+		source, ok := parents[parentId]
+		if !ok {
+			source, err = regionalSource(p.daoProduct, product)
+			if err != nil {
+				return nil, err
+			}
+			if productIdOf(source) == parentId {
+				parents[parentId] = source
+			}
+		}
+		if available, _ := productAvailability(withRegionalRules(product, source), loc); !available {
+			continue
+		}
+		product = withRegionalPrice(withScheduledPrice(withRegionalRules(product, source), time.Now()), loc)
+		setResolvedPrice(product, p.priceResolver.resolve(product, ctx, 1))
+		products = append(products, product)
+	}
+
+	total := len(products)
+	if int(skip) >= len(products) {
+		products = []utils.Map{}
+	} else if skip > 0 {
+		products = products[skip:]
+	}
+	if limit > 0 && int(limit) < len(products) {
+		products = products[:limit]
+	}
+
+	response := utils.Map{
+		db_common.LIST_SUMMARY: utils.Map{
+			db_common.LIST_TOTALSIZE:    total,
+			db_common.LIST_FILTEREDSIZE: total,
+			db_common.LIST_RESULTSIZE:   len(products),
+		},
+		db_common.LIST_RESULT: products,
+		FLD_SHOPPER_LOCATION:  loc.toMap(),
+	}
+
+	log.Println("ProductRegionalService::ListForLocation - End ", len(products))
+	return response, nil
+}
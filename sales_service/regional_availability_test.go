@@ -0,0 +1,160 @@
+package sales_service
+
+import (
+	"testing"
+
+	"github.com/zapscloud/golib-dbutils/db_common"
+	"github.com/zapscloud/golib-sales-repository/sales_common"
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+func TestPostalCodeMatches(t *testing.T) {
+	patterns := []string{"560001", "5601*", "570010-570099", "sw1a *"}
+	tests := []struct {
+		postalCode string
+		want       bool
+	}{
+		{"560001", true},
+		{"560002", false},
+		{"560155", true},
+		{"570010", true},
+		{"570099", true},
+		{"570100", false},
+		// Range compares the codes of the same length only
+		{"57005", false},
+		{"SW1A 1AA", true},
+		{"sw1a1aa", true},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		if got := postalCodeMatches(patterns, test.postalCode); got != test.want {
+			t.Errorf("postalCodeMatches(%q) = %v, want %v", test.postalCode, got, test.want)
+		}
+	}
+}
+
+func testRegionalProduct() utils.Map {
+	return utils.Map{
+		sales_common.FLD_PRODUCT_ID: "prod1",
+		FLD_PRODUCT_PRICE:           100,
+		FLD_PRODUCT_REGIONAL_RULES: []utils.Map{
+			{sales_common.FLD_REGION_ID: "south", FLD_REGIONAL_IS_AVAILABLE: true, FLD_PRODUCT_PRICE: 90},
+			{sales_common.FLD_STATE_ID: "ka", FLD_REGIONAL_IS_AVAILABLE: false},
+			{sales_common.FLD_STATE_ID: "tn", FLD_PRODUCT_PRICE: 95},
+		},
+	}
+}
+
+func TestProductAvailability(t *testing.T) {
+	product := testRegionalProduct()
+	tests := []struct {
+		name string
+		loc  shopperLocation
+		want bool
+	}{
+		{"region rule", shopperLocation{regionId: "south", serviceable: true}, true},
+		// State rule takes precedence over the region rule
+		{"state rule", shopperLocation{regionId: "south", stateId: "ka", serviceable: true}, false},
+		// State rule without is_available falls back to the default
+		{"state rule without availability", shopperLocation{regionId: "south", stateId: "tn", serviceable: true}, true},
+		{"no rule", shopperLocation{regionId: "north", serviceable: true}, true},
+		{"not serviceable", shopperLocation{regionId: "south", postalCode: "999999"}, false},
+	}
+
+	for _, test := range tests {
+		if got, reason := productAvailability(product, test.loc); got != test.want || (!got && len(reason) == 0) {
+			t.Errorf("%s: productAvailability = %v, %q, want %v", test.name, got, reason, test.want)
+		}
+	}
+
+	product[FLD_PRODUCT_AVAILABLE_DEFAULT] = false
+	if available, _ := productAvailability(product, shopperLocation{regionId: "north", serviceable: true}); available {
+		t.Error("product not available by default is available without a rule")
+	}
+	if available, _ := productAvailability(product, shopperLocation{regionId: "south", serviceable: true}); !available {
+		t.Error("region rule does not override the default availability")
+	}
+}
+
+func TestWithRegionalPrice(t *testing.T) {
+	product := testRegionalProduct()
+	tests := []struct {
+		loc  shopperLocation
+		want float64
+	}{
+		{shopperLocation{regionId: "south"}, 90},
+		// State rule takes precedence, the state rule without price keeps the default price
+		{shopperLocation{regionId: "south", stateId: "tn"}, 95},
+		{shopperLocation{regionId: "south", stateId: "ka"}, 100},
+		{shopperLocation{regionId: "north"}, 100},
+	}
+
+	for _, test := range tests {
+		if got := toFloat(withRegionalPrice(product, test.loc)[FLD_PRODUCT_PRICE]); got != test.want {
+			t.Errorf("withRegionalPrice(%+v) = %v, want %v", test.loc, got, test.want)
+		}
+	}
+
+	priced := withRegionalPrice(product, shopperLocation{regionId: "south"})
+	if toFloat(priced[FLD_REGIONAL_DEFAULT_PRICE]) != 100 || toFloat(product[FLD_PRODUCT_PRICE]) != 100 {
+		t.Errorf("default price is not kept: %v, product %v", priced[FLD_REGIONAL_DEFAULT_PRICE], product[FLD_PRODUCT_PRICE])
+	}
+}
+
+func TestWithRegionalRules(t *testing.T) {
+	parent := testRegionalProduct()
+	variant := utils.Map{sales_common.FLD_PRODUCT_ID: "prod1-red", FLD_PRODUCT_PARENT_ID: "prod1", FLD_PRODUCT_PRICE: 120}
+
+	merged := withRegionalRules(variant, parent)
+	if len(toMapSlice(merged[FLD_PRODUCT_REGIONAL_RULES])) != 3 {
+		t.Errorf("variant does not follow the rules of the parent: %v", merged)
+	}
+	if _, ok := variant[FLD_PRODUCT_REGIONAL_RULES]; ok {
+		t.Error("withRegionalRules changed the variant")
+	}
+	if got := toFloat(withRegionalPrice(merged, shopperLocation{regionId: "south"})[FLD_PRODUCT_PRICE]); got != 90 {
+		t.Errorf("regional price of the variant = %v, want 90", got)
+	}
+}
+
+func TestLineAvailability(t *testing.T) {
+	loc := shopperLocation{regionId: "south", serviceable: true}
+
+	product := testRegionalProduct()
+	if available, _ := lineAvailability(product, loc); !available {
+		t.Error("published product is not available")
+	}
+
+	product[FLD_PRODUCT_IS_PUBLISHED] = false
+	if available, _ := lineAvailability(product, loc); available {
+		t.Error("product not published is available")
+	}
+
+	product = testRegionalProduct()
+	product[db_common.FLD_IS_DELETED] = true
+	if available, _ := lineAvailability(product, loc); available {
+		t.Error("deleted product is available")
+	}
+}
+
+func TestCheckItemsAvailable(t *testing.T) {
+	items := []utils.Map{
+		{sales_common.FLD_PRODUCT_ID: "prod1", FLD_REGIONAL_IS_AVAILABLE: true},
+		{sales_common.FLD_PRODUCT_ID: "prod2", FLD_REGIONAL_IS_AVAILABLE: true},
+	}
+	if err := CheckItemsAvailable(items); err != nil {
+		t.Errorf("CheckItemsAvailable returned %v", err)
+	}
+
+	items = append(items, utils.Map{sales_common.FLD_PRODUCT_ID: "prod3", FLD_REGIONAL_IS_AVAILABLE: false,
+		FLD_REGIONAL_UNAVAILABLE_REASON: "not available in state ka"})
+	if err := CheckItemsAvailable(items); err == nil {
+		t.Error("CheckItemsAvailable accepted the item not available at the location")
+	}
+
+	// Item not priced for the location is not taken as available
+	if err := CheckItemsAvailable([]utils.Map{{sales_common.FLD_PRODUCT_ID: "prod1"}}); err == nil {
+		t.Error("CheckItemsAvailable accepted the item without availability")
+	}
+}
@@ -28,6 +28,9 @@ type RegionService interface {
 	// Delete - Delete Service
 	Delete(regionId string, delete_permanent bool) error
 
+	// CheckServiceability - Find the region and state serving the postal code
+	CheckServiceability(postalCode string) (utils.Map, error)
+
 	EndService()
 }
 
@@ -35,7 +38,9 @@ type regionBaseService struct {
 	db_utils.DatabaseService
 	dbRegion    db_utils.DatabaseService
 	daoRegion   sales_repository.RegionDao
+	daoStates   sales_repository.StatesDao
 	daoBusiness platform_repository.BusinessDao
+	locator     *regionLocator
 	child       RegionService
 	businessId  string
 }
@@ -94,6 +99,8 @@ func (p *regionBaseService) initializeService() {
 	log.Printf("RegionService:: GetBusinessDao ")
 	p.daoBusiness = platform_repository.NewBusinessDao(p.GetClient())
 	p.daoRegion = sales_repository.NewRegionDao(p.dbRegion.GetClient(), p.businessId)
+	p.daoStates = sales_repository.NewStatesDao(p.dbRegion.GetClient(), p.businessId)
+	p.locator = &regionLocator{daoRegion: p.daoRegion, daoStates: p.daoStates}
 }
 
 // List - List All records
@@ -190,6 +197,29 @@ func (p *regionBaseService) Delete(regionId string, delete_permanent bool) error
 	return nil
 }
 
+// CheckServiceability - Find the region and state serving the postal code
+func (p *regionBaseService) CheckServiceability(postalCode string) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "20"
+
+	log.Println("RegionService::CheckServiceability - Begin", postalCode)
+
+	if len(strings.TrimSpace(postalCode)) == 0 {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "03",
+			ErrorMsg:    "Invalid postal code",
+			ErrorDetail: "Postal code is required"}
+		return nil, err
+	}
+
+	loc, err := p.locator.resolve(utils.Map{FLD_POSTAL_CODE: postalCode})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("RegionService::CheckServiceability - End ", loc.serviceable)
+	return loc.toMap(), nil
+}
+
 func (p *regionBaseService) errorReturn(err error) (RegionService, error) {
 	// Close the Database Connection
 	p.EndService()
@@ -0,0 +1,376 @@
+package sales_service
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/zapscloud/golib-sales-repository/sales_common"
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+const (
+	// BM25 parameters
+	searchBM25K1 = 1.2
+	searchBM25B  = 0.75
+
+	// Terms of the product name are weighted more than the description
+	searchNameBoost = 3
+
+	// Facet fields of the search
+	FLD_SEARCH_PRICE_RANGE = "price_range"
+)
+
+// searchFacetFields - Fields the search results are counted and filtered by
+var searchFacetFields = []string{
+	sales_common.FLD_BRAND_ID,
+	sales_common.FLD_CATEGORY_ID,
+	sales_common.FLD_FIRMNESS_ID,
+	sales_common.FLD_MATERIAL_TYPE_ID,
+}
+
+// searchIndexes - Search index per business, built on the first search of the business
+// The index is kept in memory of each process, it is not shared by the instances of the service.
+// The changes made in the process update the index directly. The changes made by the other instances
+// are taken when the search finds a product changed after the index was built, the index is then
+// built again; the products removed permanently are taken after SEARCH_INDEX_TTL_MINUTES or on Reindex.
+var searchIndexes sync.Map
+
+// searchDoc - Indexed product
+type searchDoc struct {
+	product utils.Map
+	length  int
+	terms   map[string]int
+	facets  map[string]map[string]bool
+	price   float64
+}
+
+// searchIndex - In-process inverted index of the products
+type searchIndex struct {
+	mu       sync.RWMutex
+	loaded   bool
+	builtAt  time.Time
+	docs     map[string]*searchDoc
+	postings map[string]map[string]int
+	totalLen int
+	// Attributes of the variants, so the parent product is found by them
+	variants map[string]map[string]utils.Map
+}
+
+// searchMatch - Product matched by the search with its relevance
+type searchMatch struct {
+	productId string
+	score     float64
+}
+
+// getSearchIndex - Get the search index of the business
+func getSearchIndex(businessId string) *searchIndex {
+	index, _ := searchIndexes.LoadOrStore(businessId, &searchIndex{
+		docs:     map[string]*searchDoc{},
+		postings: map[string]map[string]int{},
+		variants: map[string]map[string]utils.Map{},
+	})
+	return index.(*searchIndex)
+}
+
+// searchIndexProduct - Update the product in the search index of the business, when the index is built already
+func searchIndexProduct(businessId string, product utils.Map) {
+	index := getSearchIndex(businessId)
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	if index.loaded {
+		index.put(product)
+	}
+}
+
+// searchRemoveProduct - Remove the product from the search index of the business, when the index is built already
+func searchRemoveProduct(businessId string, productId string) {
+	index := getSearchIndex(businessId)
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	if index.loaded {
+		index.remove(productId)
+	}
+}
+
+// rebuild - Replace the content of the index with the products
+func (idx *searchIndex) rebuild(products []utils.Map) {
+	idx.docs = map[string]*searchDoc{}
+	idx.postings = map[string]map[string]int{}
+	idx.variants = map[string]map[string]utils.Map{}
+	idx.totalLen = 0
+
+	// Index the variants first, so the parents get their attributes
+	for _, product := range products {
+		if parentId, _ := product[FLD_PRODUCT_PARENT_ID].(string); len(parentId) > 0 {
+			idx.put(product)
+		}
+	}
+	for _, product := range products {
+		if parentId, _ := product[FLD_PRODUCT_PARENT_ID].(string); len(parentId) == 0 {
+			idx.put(product)
+		}
+	}
+	idx.loaded = true
+	idx.builtAt = time.Now()
+}
+
+// put - Add or replace the product in the index, deleted and unpublished products are removed
+func (idx *searchIndex) put(product utils.Map) {
+	productId := productIdOf(product)
+	if len(productId) == 0 {
+		return
+	}
+
+	if !isProductPublished(product) {
+		idx.remove(productId)
+		return
+	}
+
+	// Variants are not listed in the search, their attributes are added to the parent
+	if parentId, _ := product[FLD_PRODUCT_PARENT_ID].(string); len(parentId) > 0 {
+		if idx.variants[parentId] == nil {
+			idx.variants[parentId] = map[string]utils.Map{}
+		}
+		attributes, _ := toMap(product[FLD_PRODUCT_VARIANT_ATTRIBUTES])
+		idx.variants[parentId][productId] = attributes
+		if parent, ok := idx.docs[parentId]; ok {
+			idx.put(parent.product)
+		}
+		return
+	}
+
+	idx.removeDoc(productId)
+
+	doc := &searchDoc{
+		product: product,
+		terms:   map[string]int{},
+		facets:  map[string]map[string]bool{},
+		price:   toFloat(product[FLD_PRODUCT_PRICE]),
+	}
+
+	name, _ := product[FLD_PRODUCT_NAME].(string)
+	for _, term := range searchTokenize(name) {
+		doc.terms[term] += searchNameBoost
+		doc.length++
+	}
+	description, _ := product[FLD_PRODUCT_DESCRIPTION].(string)
+	for _, term := range searchTokenize(description) {
+		doc.terms[term]++
+		doc.length++
+	}
+
+	for _, field := range searchFacetFields {
+		values := map[string]bool{}
+		if value, ok := product[field].(string); ok && len(value) > 0 {
+			values[value] = true
+		}
+		for _, attributes := range idx.variants[productId] {
+			if value, ok := attributes[field].(string); ok && len(value) > 0 {
+				values[value] = true
+			}
+		}
+		doc.facets[field] = values
+	}
+
+	for term, freq := range doc.terms {
+		if idx.postings[term] == nil {
+			idx.postings[term] = map[string]int{}
+		}
+		idx.postings[term][productId] = freq
+	}
+	idx.docs[productId] = doc
+	idx.totalLen += doc.length
+}
+
+// remove - Remove the product or the variant from the index
+func (idx *searchIndex) remove(productId string) {
+	for parentId, variants := range idx.variants {
+		if _, ok := variants[productId]; ok {
+			delete(variants, productId)
+			if parent, ok := idx.docs[parentId]; ok {
+				idx.put(parent.product)
+			}
+			return
+		}
+	}
+
+	idx.removeDoc(productId)
+	delete(idx.variants, productId)
+}
+
+// removeDoc - Remove the document of the product and its postings
+func (idx *searchIndex) removeDoc(productId string) {
+	doc, ok := idx.docs[productId]
+	if !ok {
+		return
+	}
+
+	for term := range doc.terms {
+		delete(idx.postings[term], productId)
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+		}
+	}
+	idx.totalLen -= doc.length
+	delete(idx.docs, productId)
+}
+
+// match - Score the products for the query terms, all the products when there are no terms.
+// Each query term should match a product term exactly or within the typo tolerance.
+func (idx *searchIndex) match(queryTerms []string) map[string]float64 {
+	scores := map[string]float64{}
+	if len(queryTerms) == 0 {
+		for productId := range idx.docs {
+			scores[productId] = 0
+		}
+		return scores
+	}
+
+	docCount := float64(len(idx.docs))
+	avgLen := 1.0
+	if len(idx.docs) > 0 && idx.totalLen > 0 {
+		avgLen = float64(idx.totalLen) / docCount
+	}
+
+	for pos, queryTerm := range queryTerms {
+		termScores := map[string]float64{}
+		for term, weight := range idx.expandTerm(queryTerm) {
+			postings := idx.postings[term]
+			idf := math.Log(1 + (docCount-float64(len(postings))+0.5)/(float64(len(postings))+0.5))
+			for productId, freq := range postings {
+				tf := float64(freq)
+				norm := 1 - searchBM25B + searchBM25B*float64(idx.docs[productId].length)/avgLen
+				score := weight * idf * tf * (searchBM25K1 + 1) / (tf + searchBM25K1*norm)
+				if score > termScores[productId] {
+					termScores[productId] = score
+				}
+			}
+		}
+
+		// Products should match all the query terms
+		if pos == 0 {
+			scores = termScores
+			continue
+		}
+		for productId := range scores {
+			if termScore, ok := termScores[productId]; ok {
+				scores[productId] += termScore
+			} else {
+				delete(scores, productId)
+			}
+		}
+	}
+	return scores
+}
+
+// expandTerm - Get the index terms for the query term with their weight
+// Exact match has full weight, terms within the typo tolerance or having the query term as prefix weigh less
+func (idx *searchIndex) expandTerm(queryTerm string) map[string]float64 {
+	terms := map[string]float64{}
+	if _, ok := idx.postings[queryTerm]; ok {
+		terms[queryTerm] = 1
+	}
+
+	maxTypos := searchTypoTolerance(queryTerm)
+	for term := range idx.postings {
+		if term == queryTerm {
+			continue
+		}
+		if len([]rune(queryTerm)) >= 3 && strings.HasPrefix(term, queryTerm) {
+			terms[term] = 0.8
+			continue
+		}
+		if maxTypos == 0 {
+			continue
+		}
+		if dist := levenshtein(queryTerm, term, maxTypos); dist <= maxTypos {
+			terms[term] = 1 / float64(1+dist)
+		}
+	}
+	return terms
+}
+
+// sortedMatches - Sort the matches by relevance, by product name when the relevance is same
+func (idx *searchIndex) sortedMatches(scores map[string]float64) []searchMatch {
+	matches := make([]searchMatch, 0, len(scores))
+	for productId, score := range scores {
+		matches = append(matches, searchMatch{productId: productId, score: score})
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		nameI, _ := idx.docs[matches[i].productId].product[FLD_PRODUCT_NAME].(string)
+		nameJ, _ := idx.docs[matches[j].productId].product[FLD_PRODUCT_NAME].(string)
+		if nameI != nameJ {
+			return strings.ToLower(nameI) < strings.ToLower(nameJ)
+		}
+		return matches[i].productId < matches[j].productId
+	})
+	return matches
+}
+
+// searchTokenize - Split the text into lower case terms
+func searchTokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// searchTypoTolerance - Number of typos allowed for the term by its length
+func searchTypoTolerance(term string) int {
+	length := len([]rune(term))
+	switch {
+	case length >= 8:
+		return 2
+	case length >= 4:
+		return 1
+	}
+	return 0
+}
+
+// levenshtein - Edit distance between the terms counting the swap of adjacent letters as one typo,
+// stops counting beyond maxDist
+func levenshtein(first string, second string, maxDist int) int {
+	a, b := []rune(first), []rune(second)
+	if diff := len(a) - len(b); diff > maxDist || -diff > maxDist {
+		return maxDist + 1
+	}
+
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(minInt(prev[j]+1, curr[j-1]+1), prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				curr[j] = minInt(curr[j], prev2[j-2]+1)
+			}
+			if curr[j] < rowMin {
+				rowMin = curr[j]
+			}
+		}
+		if rowMin > maxDist {
+			return maxDist + 1
+		}
+		prev2, prev, curr = prev, curr, prev2
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,318 @@
+package sales_service
+
+import (
+	"log"
+	"time"
+
+	"github.com/zapscloud/golib-dbutils/db_common"
+	"github.com/zapscloud/golib-dbutils/db_utils"
+	"github.com/zapscloud/golib-platform-repository/platform_repository"
+	"github.com/zapscloud/golib-platform-service/platform_service"
+	"github.com/zapscloud/golib-sales-repository/sales_common"
+	"github.com/zapscloud/golib-sales-repository/sales_repository"
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+const (
+	// Search request fields
+	FLD_SEARCH_QUERY        = "q"
+	FLD_SEARCH_FILTERS      = "filters"
+	FLD_SEARCH_PRICE_MIN    = "price_min"
+	FLD_SEARCH_PRICE_MAX    = "price_max"
+	FLD_SEARCH_PRICE_RANGES = "price_ranges"
+	FLD_SEARCH_SKIP         = "skip"
+	FLD_SEARCH_LIMIT        = "limit"
+
+	// Search response fields
+	FLD_SEARCH_RESULTS    = "results"
+	FLD_SEARCH_TOTAL      = "total"
+	FLD_SEARCH_FACETS     = "facets"
+	FLD_SEARCH_SCORE      = "search_score"
+	FLD_SEARCH_RANGE_FROM = "from"
+	FLD_SEARCH_RANGE_TO   = "to"
+	FLD_SEARCH_COUNT      = "count"
+	FLD_SEARCH_INDEXED    = "indexed_products"
+
+	SEARCH_DEFAULT_LIMIT = 20
+
+	// Search index is built again after these minutes, to take the changes made by the other instances
+	SEARCH_INDEX_TTL_MINUTES = 5
+)
+
+// searchDefaultPriceRanges - Boundaries of the price range facet, when not given in the request
+var searchDefaultPriceRanges = []float64{0, 5000, 10000, 25000, 50000, 100000}
+
+// SearchService - Product search with relevance, typo tolerance and facets
+type SearchService interface {
+	// Search - Search the products, query holds q, filters, price_ranges, skip and limit
+	// filters are {"brand_id": ["b1"], "category_id": [...], "firmness_id": [...], "material_type_id": [...], "price_min": 0, "price_max": 0}
+	Search(query utils.Map) (utils.Map, error)
+	// Reindex - Rebuild the search index of the business from the products
+	Reindex() (utils.Map, error)
+
+	EndService()
+}
+
+type searchBaseService struct {
+	db_utils.DatabaseService
+	dbRegion    db_utils.DatabaseService
+	daoProduct  sales_repository.ProductDao
+	daoBusiness platform_repository.BusinessDao
+	child       SearchService
+	businessId  string
+}
+
+// NewSearchService - Construct Search
+func NewSearchService(props utils.Map) (SearchService, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "01"
+
+	log.Printf("SearchService::Start ")
+	// Verify whether the business id data passed
+	businessId, err := utils.GetMemberDataStr(props, sales_common.FLD_BUSINESS_ID)
+	if err != nil {
+		return nil, err
+	}
+
+	p := searchBaseService{}
+	// Open Database Service
+	err = p.OpenDatabaseService(props)
+	if err != nil {
+		return nil, err
+	}
+
+	// Open RegionDB Service
+	p.dbRegion, err = platform_service.OpenRegionDatabaseService(props)
+	if err != nil {
+		p.CloseDatabaseService()
+		return nil, err
+	}
+
+	// Assign the BusinessId
+	p.businessId = businessId
+	p.initializeService()
+
+	_, err = p.daoBusiness.Get(businessId)
+	if err != nil {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "01",
+			ErrorMsg:    "Invalid BusinessId",
+			ErrorDetail: "Given BusinessId is not exist"}
+		return p.errorReturn(err)
+	}
+
+	p.child = &p
+
+	return &p, err
+}
+
+// EndService - Close all the services
+func (p *searchBaseService) EndService() {
+	log.Printf("EndService ")
+	p.CloseDatabaseService()
+	p.dbRegion.CloseDatabaseService()
+}
+
+func (p *searchBaseService) initializeService() {
+	log.Printf("SearchService:: GetBusinessDao ")
+	p.daoBusiness = platform_repository.NewBusinessDao(p.GetClient())
+	p.daoProduct = sales_repository.NewProductDao(p.dbRegion.GetClient(), p.businessId)
+}
+
+// Search - Search the products, query holds q, filters, price_ranges, skip and limit
+func (p *searchBaseService) Search(query utils.Map) (utils.Map, error) {
+
+	log.Println("SearchService::Search - Begin", query[FLD_SEARCH_QUERY])
+
+	index, err := p.loadIndex(false)
+	if err != nil {
+		return nil, err
+	}
+
+	queryText, _ := query[FLD_SEARCH_QUERY].(string)
+	filters, _ := toMap(query[FLD_SEARCH_FILTERS])
+	priceRanges := searchDefaultPriceRanges
+	if ranges := toSlice(query[FLD_SEARCH_PRICE_RANGES]); len(ranges) > 0 {
+		priceRanges = make([]float64, 0, len(ranges))
+		for _, boundary := range ranges {
+			priceRanges = append(priceRanges, toFloat(boundary))
+		}
+	}
+
+	skip := toInt(query[FLD_SEARCH_SKIP])
+	limit := toInt(query[FLD_SEARCH_LIMIT])
+	if limit <= 0 {
+		limit = SEARCH_DEFAULT_LIMIT
+	}
+
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+
+	scores := index.match(searchTokenize(queryText))
+
+	// Facets of a field are counted with the filters of the other fields,
+	// so the other values of the field filtered remain selectable
+	facets := utils.Map{}
+	facetCounts := map[string]map[string]int{}
+	for _, field := range searchFacetFields {
+		facetCounts[field] = map[string]int{}
+	}
+	rangeCounts := make([]int, len(priceRanges))
+
+	matched := map[string]float64{}
+	for productId, score := range scores {
+		doc := index.docs[productId]
+		failed := searchFailedFilters(doc, filters)
+		if len(failed) == 0 {
+			matched[productId] = score
+		}
+
+		for _, field := range searchFacetFields {
+			if len(failed) == 0 || (len(failed) == 1 && failed[0] == field) {
+				for value := range doc.facets[field] {
+					facetCounts[field][value]++
+				}
+			}
+		}
+		if len(failed) == 0 || (len(failed) == 1 && failed[0] == FLD_SEARCH_PRICE_RANGE) {
+			if idx := priceRangeIndex(priceRanges, doc.price); idx >= 0 {
+				rangeCounts[idx]++
+			}
+		}
+	}
+
+	for field, counts := range facetCounts {
+		facets[field] = counts
+	}
+	ranges := []utils.Map{}
+	for idx, boundary := range priceRanges {
+		item := utils.Map{FLD_SEARCH_RANGE_FROM: boundary, FLD_SEARCH_COUNT: rangeCounts[idx]}
+		if idx+1 < len(priceRanges) {
+			item[FLD_SEARCH_RANGE_TO] = priceRanges[idx+1]
+		}
+		ranges = append(ranges, item)
+	}
+	facets[FLD_SEARCH_PRICE_RANGE] = ranges
+
+	matches := index.sortedMatches(matched)
+	results := []utils.Map{}
+	for pos := skip; pos < len(matches) && pos < skip+limit; pos++ {
+		product := publishedView(index.docs[matches[pos].productId].product)
+		product[FLD_SEARCH_SCORE] = matches[pos].score
+		results = append(results, product)
+	}
+
+	response := utils.Map{
+		FLD_SEARCH_RESULTS: results,
+		FLD_SEARCH_TOTAL:   len(matches),
+		FLD_SEARCH_FACETS:  facets,
+	}
+
+	log.Println("SearchService::Search - End ", len(matches))
+	return response, nil
+}
+
+// Reindex - Rebuild the search index of the business from the products
+func (p *searchBaseService) Reindex() (utils.Map, error) {
+
+	log.Println("SearchService::Reindex - Begin")
+
+	index, err := p.loadIndex(true)
+	if err != nil {
+		return nil, err
+	}
+
+	index.mu.RLock()
+	count := len(index.docs)
+	index.mu.RUnlock()
+
+	log.Println("SearchService::Reindex - End ", count)
+	return utils.Map{FLD_SEARCH_INDEXED: count}, nil
+}
+
+// loadIndex - Get the search index of the business, build it when not built yet, older than the TTL or forced
+// The index is built again as well when a product is changed after it was built, by this or another instance
+func (p *searchBaseService) loadIndex(force bool) (*searchIndex, error) {
+	index := getSearchIndex(p.businessId)
+
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	if index.loaded && !force && time.Since(index.builtAt) < SEARCH_INDEX_TTL_MINUTES*time.Minute {
+		changed, err := p.productsChangedSince(index.builtAt)
+		if err != nil {
+			return nil, err
+		}
+		if !changed {
+			return index, nil
+		}
+	}
+
+	listdata, err := p.daoProduct.List("", "", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	index.rebuild(listResult(listdata))
+	return index, nil
+}
+
+// productsChangedSince - Check whether the latest change of the products is after the time
+// The products removed permanently are not found here, they are taken when the TTL expires
+func (p *searchBaseService) productsChangedSince(since time.Time) (bool, error) {
+	sort := buildFilter(utils.Map{db_common.FLD_UPDATED_AT: -1})
+	listdata, err := p.daoProduct.List("", sort, 0, 1)
+	if err != nil {
+		return false, err
+	}
+
+	for _, product := range listResult(listdata) {
+		if updatedAt, ok := toTime(product[db_common.FLD_UPDATED_AT]); ok && updatedAt.After(since) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (p *searchBaseService) errorReturn(err error) (SearchService, error) {
+	// Close the Database Connection
+	p.EndService()
+	return nil, err
+}
+
+// searchFailedFilters - Get the filter fields the document does not match
+func searchFailedFilters(doc *searchDoc, filters utils.Map) []string {
+	failed := []string{}
+	for _, field := range searchFacetFields {
+		values := toStringSlice(filters[field])
+		if len(values) == 0 {
+			continue
+		}
+
+		found := false
+		for _, value := range values {
+			if doc.facets[field][value] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			failed = append(failed, field)
+		}
+	}
+
+	if min, ok := filters[FLD_SEARCH_PRICE_MIN]; ok && doc.price < toFloat(min) {
+		failed = append(failed, FLD_SEARCH_PRICE_RANGE)
+	} else if max, ok := filters[FLD_SEARCH_PRICE_MAX]; ok && doc.price > toFloat(max) {
+		failed = append(failed, FLD_SEARCH_PRICE_RANGE)
+	}
+	return failed
+}
+
+// priceRangeIndex - Index of the price range the price falls in, -1 when below the first range
+func priceRangeIndex(priceRanges []float64, price float64) int {
+	for idx := len(priceRanges) - 1; idx >= 0; idx-- {
+		if price >= priceRanges[idx] {
+			return idx
+		}
+	}
+	return -1
+}
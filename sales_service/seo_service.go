@@ -0,0 +1,135 @@
+package sales_service
+
+import (
+	"log"
+
+	"github.com/zapscloud/golib-dbutils/db_utils"
+	"github.com/zapscloud/golib-platform-repository/platform_repository"
+	"github.com/zapscloud/golib-platform-service/platform_service"
+	"github.com/zapscloud/golib-sales-repository/sales_common"
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+// SeoService - Resolve the storefront slugs of products, categories, pages and blogs
+type SeoService interface {
+	// Resolve - Map the current or old slug to the entity, old slugs are returned as 301 redirect to the current slug
+	Resolve(slug string) (utils.Map, error)
+	// IsSlugAvailable - Check whether the slug is not used by any product, category, page or blog
+	IsSlugAvailable(slug string) (bool, error)
+
+	EndService()
+}
+
+type seoBaseService struct {
+	db_utils.DatabaseService
+	dbRegion    db_utils.DatabaseService
+	seoSlugs    *seoSlugs
+	daoBusiness platform_repository.BusinessDao
+	child       SeoService
+	businessId  string
+}
+
+// NewSeoService - Construct Seo
+func NewSeoService(props utils.Map) (SeoService, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "01"
+
+	log.Printf("SeoService::Start ")
+	// Verify whether the business id data passed
+	businessId, err := utils.GetMemberDataStr(props, sales_common.FLD_BUSINESS_ID)
+	if err != nil {
+		return nil, err
+	}
+
+	p := seoBaseService{}
+	// Open Database Service
+	err = p.OpenDatabaseService(props)
+	if err != nil {
+		return nil, err
+	}
+
+	// Open RegionDB Service
+	p.dbRegion, err = platform_service.OpenRegionDatabaseService(props)
+	if err != nil {
+		p.CloseDatabaseService()
+		return nil, err
+	}
+
+	// Assign the BusinessId
+	p.businessId = businessId
+	p.initializeService()
+
+	_, err = p.daoBusiness.Get(businessId)
+	if err != nil {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "01",
+			ErrorMsg:    "Invalid BusinessId",
+			ErrorDetail: "Given BusinessId is not exist"}
+		return p.errorReturn(err)
+	}
+
+	p.child = &p
+
+	return &p, err
+}
+
+// EndService - Close all the services
+func (p *seoBaseService) EndService() {
+	log.Printf("EndService ")
+	p.CloseDatabaseService()
+	p.dbRegion.CloseDatabaseService()
+}
+
+func (p *seoBaseService) initializeService() {
+	log.Printf("SeoService:: GetBusinessDao ")
+	p.daoBusiness = platform_repository.NewBusinessDao(p.GetClient())
+	p.seoSlugs = newSeoSlugs(p.dbRegion, p.businessId)
+}
+
+// Resolve - Map the current or old slug to the entity, old slugs are returned as 301 redirect to the current slug
+func (p *seoBaseService) Resolve(slug string) (utils.Map, error) {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "12"
+
+	log.Println("SeoService::Resolve - Begin", slug)
+
+	slug = utils.GenerateSeoKeyId(slug)
+	entity, data, found := p.seoSlugs.lookup(slug)
+	if !found {
+		err := &utils.AppError{
+			ErrorCode:   funcode + "02",
+			ErrorMsg:    "Invalid slug",
+			ErrorDetail: "Given slug is not exist"}
+		return nil, err
+	}
+
+	currentSlug, _ := data[FLD_SEO_SLUG].(string)
+	response := utils.Map{
+		FLD_SEO_ENTITY_TYPE: entity.entityType,
+		FLD_SEO_ENTITY_ID:   data[entity.idField],
+		FLD_SEO_SLUG:        currentSlug,
+		FLD_SEO_IS_REDIRECT: currentSlug != slug,
+		FLD_SEO_STATUS_CODE: SEO_STATUS_OK,
+	}
+	if currentSlug != slug {
+		response[FLD_SEO_STATUS_CODE] = SEO_STATUS_REDIRECT
+	}
+
+	log.Println("SeoService::Resolve - End ", response)
+	return response, nil
+}
+
+// IsSlugAvailable - Check whether the slug is not used by any product, category, page or blog
+func (p *seoBaseService) IsSlugAvailable(slug string) (bool, error) {
+
+	log.Println("SeoService::IsSlugAvailable - Begin", slug)
+
+	_, _, found := p.seoSlugs.lookup(utils.GenerateSeoKeyId(slug))
+
+	log.Println("SeoService::IsSlugAvailable - End ", !found)
+	return !found, nil
+}
+
+func (p *seoBaseService) errorReturn(err error) (SeoService, error) {
+	// Close the Database Connection
+	p.EndService()
+	return nil, err
+}
@@ -0,0 +1,177 @@
+package sales_service
+
+import (
+	"fmt"
+
+	"github.com/zapscloud/golib-dbutils/db_utils"
+	"github.com/zapscloud/golib-sales-repository/sales_common"
+	"github.com/zapscloud/golib-sales-repository/sales_repository"
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+const (
+	// SEO fields of the product, category, page and blog
+	FLD_SEO_SLUG         = "seo_slug"
+	FLD_SEO_SLUG_HISTORY = "slug_history"
+
+	// Fields the slug is generated from
+	FLD_PAGE_TITLE = "page_title"
+	FLD_BLOG_TITLE = "blog_title"
+
+	// Resolver response fields
+	FLD_SEO_ENTITY_TYPE = "entity_type"
+	FLD_SEO_ENTITY_ID   = "entity_id"
+	FLD_SEO_IS_REDIRECT = "is_redirect"
+	FLD_SEO_STATUS_CODE = "status_code"
+
+	SEO_ENTITY_PRODUCT  = "product"
+	SEO_ENTITY_CATEGORY = "category"
+	SEO_ENTITY_PAGE     = "page"
+	SEO_ENTITY_BLOG     = "blog"
+
+	SEO_STATUS_OK       = 200
+	SEO_STATUS_REDIRECT = 301
+)
+
+// seoEntity - Entity type having the slug
+type seoEntity struct {
+	entityType string
+	idField    string
+	nameField  string
+	find       func(filter string) (utils.Map, error)
+}
+
+// seoSlugs - Slugs of the business, unique across the products, categories, pages and blogs
+type seoSlugs struct {
+	entities []seoEntity
+}
+
+// newSeoSlugs - Construct the slugs of the business
+func newSeoSlugs(dbRegion db_utils.DatabaseService, businessId string) *seoSlugs {
+	daoProduct := sales_repository.NewProductDao(dbRegion.GetClient(), businessId)
+	daoCategory := sales_repository.NewCategoryDao(dbRegion.GetClient(), businessId)
+	daoPage := sales_repository.NewPageDao(dbRegion.GetClient(), businessId)
+	daoBlog := sales_repository.NewBlogDao(dbRegion.GetClient(), businessId)
+
+	return &seoSlugs{entities: []seoEntity{
+		{SEO_ENTITY_PRODUCT, sales_common.FLD_PRODUCT_ID, FLD_PRODUCT_NAME, daoProduct.Find},
+		{SEO_ENTITY_CATEGORY, sales_common.FLD_CATEGORY_ID, FLD_CATEGORY_NAME, daoCategory.Find},
+		{SEO_ENTITY_PAGE, sales_common.FLD_PAGE_ID, FLD_PAGE_TITLE, daoPage.Find},
+		{SEO_ENTITY_BLOG, sales_common.FLD_BLOG_ID, FLD_BLOG_TITLE, daoBlog.Find},
+	}}
+}
+
+// entity - Get the entity type
+func (s *seoSlugs) entity(entityType string) seoEntity {
+	for _, entity := range s.entities {
+		if entity.entityType == entityType {
+			return entity
+		}
+	}
+	return seoEntity{}
+}
+
+// lookup - Find the entity having the slug as current or old slug
+func (s *seoSlugs) lookup(slug string) (seoEntity, utils.Map, bool) {
+	filter := buildFilter(utils.Map{"$or": []utils.Map{
+		{FLD_SEO_SLUG: slug},
+		{FLD_SEO_SLUG_HISTORY: slug},
+	}})
+
+	for _, entity := range s.entities {
+		data, err := entity.find(filter)
+		if err == nil && data != nil {
+			return entity, data, true
+		}
+	}
+	return seoEntity{}, nil, false
+}
+
+// isTaken - Check whether the slug is used by any other entity
+func (s *seoSlugs) isTaken(slug string, entityType string, entityId string) bool {
+	entity, data, found := s.lookup(slug)
+	if !found {
+		return false
+	}
+	return entity.entityType != entityType || data[entity.idField] != entityId
+}
+
+// uniqueSlug - Generate the slug from the name, suffixed with number when it is taken already
+func (s *seoSlugs) uniqueSlug(name string, entityType string, entityId string) string {
+	slug := utils.GenerateSeoKeyId(name)
+	if len(slug) == 0 {
+		slug = utils.GenerateSeoKeyId(entityId)
+	}
+
+	for s.isTaken(slug, entityType, entityId) {
+		slug = utils.GenerateNextKeyId(slug)
+	}
+	return slug
+}
+
+// assignOnCreate - Set the slug of the new entity, generated from the name when not given
+func (s *seoSlugs) assignOnCreate(entityType string, entityId string, indata utils.Map) error {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "12"
+	entity := s.entity(entityType)
+
+	if slugVal, ok := indata[FLD_SEO_SLUG].(string); ok && len(slugVal) > 0 {
+		slug := utils.GenerateSeoKeyId(slugVal)
+		if s.isTaken(slug, entityType, entityId) {
+			return slugTakenError(funcode, slug)
+		}
+		indata[FLD_SEO_SLUG] = slug
+	} else {
+		name, _ := indata[entity.nameField].(string)
+		indata[FLD_SEO_SLUG] = s.uniqueSlug(name, entityType, entityId)
+	}
+
+	indata[FLD_SEO_SLUG_HISTORY] = []string{}
+	return nil
+}
+
+// assignOnUpdate - Verify the changed slug, the old slug is kept in the history to redirect
+func (s *seoSlugs) assignOnUpdate(entityType string, current utils.Map, indata utils.Map) error {
+	funcode := sales_common.GetServiceModuleCode() + "M" + "12"
+	entity := s.entity(entityType)
+	entityId, _ := current[entity.idField].(string)
+
+	slugVal, ok := indata[FLD_SEO_SLUG].(string)
+	if !ok {
+		return nil
+	}
+
+	slug := utils.GenerateSeoKeyId(slugVal)
+	oldSlug, _ := current[FLD_SEO_SLUG].(string)
+	// Empty slug is generated again from the name
+	if len(slug) == 0 {
+		name, ok := indata[entity.nameField].(string)
+		if !ok {
+			name, _ = current[entity.nameField].(string)
+		}
+		slug = s.uniqueSlug(name, entityType, entityId)
+	}
+	indata[FLD_SEO_SLUG] = slug
+	if slug == oldSlug {
+		return nil
+	}
+
+	if s.isTaken(slug, entityType, entityId) {
+		return slugTakenError(funcode, slug)
+	}
+
+	// Reusing an old slug removes it from the history
+	history := removeIds(toStringSlice(current[FLD_SEO_SLUG_HISTORY]), []string{slug})
+	if len(oldSlug) > 0 {
+		history = insertIds(history, []string{oldSlug}, len(history))
+	}
+	indata[FLD_SEO_SLUG_HISTORY] = history
+	return nil
+}
+
+// slugTakenError - Error for the slug used by another entity
+func slugTakenError(funcode string, slug string) error {
+	return &utils.AppError{
+		ErrorCode:   funcode + "01",
+		ErrorMsg:    "Duplicate slug",
+		ErrorDetail: fmt.Sprintf("Slug %s is already used", slug)}
+}
@@ -0,0 +1,152 @@
+package sales_service
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/zapscloud/golib-sales-repository/sales_common"
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+// testSlugFind - Find of the entity over the records, matching the current or old slug of the lookup filter
+func testSlugFind(records []utils.Map) func(filter string) (utils.Map, error) {
+	return func(filter string) (utils.Map, error) {
+		var query struct {
+			Or []map[string]string `json:"$or"`
+		}
+		if err := json.Unmarshal([]byte(filter), &query); err != nil || len(query.Or) == 0 {
+			return nil, errors.New("invalid filter")
+		}
+		slug := query.Or[0][FLD_SEO_SLUG]
+
+		for _, record := range records {
+			if record[FLD_SEO_SLUG] == slug {
+				return record, nil
+			}
+			for _, old := range toStringSlice(record[FLD_SEO_SLUG_HISTORY]) {
+				if old == slug {
+					return record, nil
+				}
+			}
+		}
+		return nil, errors.New("not found")
+	}
+}
+
+func testSeoSlugs(products []utils.Map, categories []utils.Map) *seoSlugs {
+	return &seoSlugs{entities: []seoEntity{
+		{SEO_ENTITY_PRODUCT, sales_common.FLD_PRODUCT_ID, FLD_PRODUCT_NAME, testSlugFind(products)},
+		{SEO_ENTITY_CATEGORY, sales_common.FLD_CATEGORY_ID, FLD_CATEGORY_NAME, testSlugFind(categories)},
+	}}
+}
+
+func TestUniqueSlug(t *testing.T) {
+	slugs := testSeoSlugs(
+		[]utils.Map{
+			{sales_common.FLD_PRODUCT_ID: "p1", FLD_SEO_SLUG: "red-shirt"},
+			{sales_common.FLD_PRODUCT_ID: "p2", FLD_SEO_SLUG: "red-shirt-1"},
+		},
+		[]utils.Map{
+			{sales_common.FLD_CATEGORY_ID: "c1", FLD_SEO_SLUG: "shoes", FLD_SEO_SLUG_HISTORY: []string{"footwear"}},
+		})
+
+	tests := []struct {
+		name       string
+		entityType string
+		entityId   string
+		want       string
+	}{
+		{"Blue Shirt", SEO_ENTITY_PRODUCT, "p3", "blue-shirt"},
+		{"  Red   Shirt!! ", SEO_ENTITY_PRODUCT, "p3", "red-shirt-2"},
+		{"Red Shirt", SEO_ENTITY_PRODUCT, "p1", "red-shirt"},
+		{"Shoes", SEO_ENTITY_PRODUCT, "p3", "shoes-1"},
+		{"Footwear", SEO_ENTITY_PRODUCT, "p3", "footwear-1"},
+		{"Footwear", SEO_ENTITY_CATEGORY, "c1", "footwear"},
+		{"***", SEO_ENTITY_PRODUCT, "P 4", "p-4"},
+	}
+
+	for _, test := range tests {
+		if got := slugs.uniqueSlug(test.name, test.entityType, test.entityId); got != test.want {
+			t.Errorf("uniqueSlug(%q, %s, %s) = %s, want %s", test.name, test.entityType, test.entityId, got, test.want)
+		}
+	}
+}
+
+func TestAssignSlugOnCreate(t *testing.T) {
+	slugs := testSeoSlugs([]utils.Map{{sales_common.FLD_PRODUCT_ID: "p1", FLD_SEO_SLUG: "red-shirt"}}, nil)
+
+	indata := utils.Map{FLD_PRODUCT_NAME: "Red Shirt"}
+	if err := slugs.assignOnCreate(SEO_ENTITY_PRODUCT, "p2", indata); err != nil {
+		t.Fatalf("assignOnCreate returned %v", err)
+	}
+	if indata[FLD_SEO_SLUG] != "red-shirt-1" || !reflect.DeepEqual(indata[FLD_SEO_SLUG_HISTORY], []string{}) {
+		t.Errorf("generated slug = %v, history %v", indata[FLD_SEO_SLUG], indata[FLD_SEO_SLUG_HISTORY])
+	}
+
+	indata = utils.Map{FLD_PRODUCT_NAME: "Red Shirt", FLD_SEO_SLUG: "Summer Sale"}
+	if err := slugs.assignOnCreate(SEO_ENTITY_PRODUCT, "p2", indata); err != nil || indata[FLD_SEO_SLUG] != "summer-sale" {
+		t.Errorf("given slug = %v, %v", indata[FLD_SEO_SLUG], err)
+	}
+
+	indata = utils.Map{FLD_PRODUCT_NAME: "Other", FLD_SEO_SLUG: "Red Shirt"}
+	if err := slugs.assignOnCreate(SEO_ENTITY_PRODUCT, "p2", indata); err == nil {
+		t.Error("assignOnCreate accepted the slug of another product")
+	}
+}
+
+func TestAssignSlugOnUpdate(t *testing.T) {
+	current := utils.Map{
+		sales_common.FLD_PRODUCT_ID: "p1",
+		FLD_PRODUCT_NAME:            "Red Shirt",
+		FLD_SEO_SLUG:                "red-shirt",
+		FLD_SEO_SLUG_HISTORY:        []string{"shirt-red"},
+	}
+	slugs := testSeoSlugs([]utils.Map{
+		current,
+		{sales_common.FLD_PRODUCT_ID: "p2", FLD_SEO_SLUG: "blue-shirt"},
+	}, nil)
+
+	// Slug not in the update is left as it is
+	indata := utils.Map{FLD_PRODUCT_NAME: "Crimson Shirt"}
+	if err := slugs.assignOnUpdate(SEO_ENTITY_PRODUCT, current, indata); err != nil || len(indata) != 1 {
+		t.Errorf("update without slug = %v, %v", indata, err)
+	}
+
+	// Changed slug keeps the old slug in the history
+	indata = utils.Map{FLD_SEO_SLUG: "Crimson Shirt"}
+	if err := slugs.assignOnUpdate(SEO_ENTITY_PRODUCT, current, indata); err != nil {
+		t.Fatalf("assignOnUpdate returned %v", err)
+	}
+	if indata[FLD_SEO_SLUG] != "crimson-shirt" ||
+		!reflect.DeepEqual(indata[FLD_SEO_SLUG_HISTORY], []string{"shirt-red", "red-shirt"}) {
+		t.Errorf("changed slug = %v, history %v", indata[FLD_SEO_SLUG], indata[FLD_SEO_SLUG_HISTORY])
+	}
+
+	// Reusing an old slug takes it out of the history
+	indata = utils.Map{FLD_SEO_SLUG: "shirt-red"}
+	if err := slugs.assignOnUpdate(SEO_ENTITY_PRODUCT, current, indata); err != nil {
+		t.Fatalf("assignOnUpdate returned %v", err)
+	}
+	if !reflect.DeepEqual(indata[FLD_SEO_SLUG_HISTORY], []string{"red-shirt"}) {
+		t.Errorf("history after reusing the old slug = %v", indata[FLD_SEO_SLUG_HISTORY])
+	}
+
+	// Same slug does not change the history
+	indata = utils.Map{FLD_SEO_SLUG: "Red-Shirt"}
+	if err := slugs.assignOnUpdate(SEO_ENTITY_PRODUCT, current, indata); err != nil || indata[FLD_SEO_SLUG_HISTORY] != nil {
+		t.Errorf("same slug = %v, %v", indata, err)
+	}
+
+	// Empty slug is generated again from the name
+	indata = utils.Map{FLD_SEO_SLUG: "", FLD_PRODUCT_NAME: "Blue Shirt"}
+	if err := slugs.assignOnUpdate(SEO_ENTITY_PRODUCT, current, indata); err != nil || indata[FLD_SEO_SLUG] != "blue-shirt-1" {
+		t.Errorf("empty slug = %v, %v", indata[FLD_SEO_SLUG], err)
+	}
+
+	indata = utils.Map{FLD_SEO_SLUG: "blue-shirt"}
+	if err := slugs.assignOnUpdate(SEO_ENTITY_PRODUCT, current, indata); err == nil {
+		t.Error("assignOnUpdate accepted the slug of another product")
+	}
+}
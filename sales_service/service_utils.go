@@ -0,0 +1,184 @@
+package sales_service
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/zapscloud/golib-dbutils/db_common"
+	"github.com/zapscloud/golib-utils/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// toFloat - Convert the numeric value read from database or request to float64
+func toFloat(value any) float64 {
+	switch val := value.(type) {
+	case int:
+		return float64(val)
+	case int32:
+		return float64(val)
+	case int64:
+		return float64(val)
+	case float32:
+		return float64(val)
+	case float64:
+		return val
+	case string:
+		fval, err := strconv.ParseFloat(val, 64)
+		if err == nil {
+			return fval
+		}
+	}
+	return 0
+}
+
+// toInt - Convert the numeric value read from database or request to int
+func toInt(value any) int {
+	return int(math.Round(toFloat(value)))
+}
+
+// toSlice - Convert the array value read from database or request to []any
+// The database returns the arrays as primitive.A, the requests as []any or typed slices
+func toSlice(value any) []any {
+	switch val := value.(type) {
+	case nil:
+		return nil
+	case []any:
+		return val
+	case primitive.A:
+		return val
+	}
+
+	rval := reflect.ValueOf(value)
+	if rval.Kind() != reflect.Slice && rval.Kind() != reflect.Array {
+		return nil
+	}
+	result := make([]any, 0, rval.Len())
+	for idx := 0; idx < rval.Len(); idx++ {
+		result = append(result, rval.Index(idx).Interface())
+	}
+	return result
+}
+
+// toStringSlice - Convert the array value read from database or request to []string
+func toStringSlice(value any) []string {
+	if val, ok := value.([]string); ok {
+		return val
+	}
+	items := toSlice(value)
+	result := make([]string, 0, len(items))
+	for _, item := range items {
+		if str, ok := item.(string); ok {
+			result = append(result, str)
+		}
+	}
+	return result
+}
+
+// toMapSlice - Convert the array value read from database or request to []utils.Map
+func toMapSlice(value any) []utils.Map {
+	if val, ok := value.([]utils.Map); ok {
+		return val
+	}
+	items := toSlice(value)
+	result := make([]utils.Map, 0, len(items))
+	for _, item := range items {
+		if mapItem, ok := toMap(item); ok {
+			result = append(result, mapItem)
+		}
+	}
+	return result
+}
+
+// toMap - Convert the object value read from database or request to utils.Map
+func toMap(value any) (utils.Map, bool) {
+	switch val := value.(type) {
+	case utils.Map:
+		return val, true
+	case map[string]any:
+		return utils.Map(val), true
+	case primitive.M:
+		return utils.Map(val), true
+	case primitive.D:
+		result := utils.Map{}
+		for _, elem := range val {
+			result[elem.Key] = elem.Value
+		}
+		return result, true
+	}
+	return nil, false
+}
+
+// toTime - Convert the date value read from database or request to time.Time
+func toTime(value any) (time.Time, bool) {
+	switch val := value.(type) {
+	case time.Time:
+		return val, true
+	case primitive.DateTime:
+		return val.Time(), true
+	case primitive.Timestamp:
+		return time.Unix(int64(val.T), 0), true
+	case string:
+		tval, err := time.Parse(time.RFC3339, val)
+		if err == nil {
+			return tval, true
+		}
+		tval, err = time.Parse("2006-01-02", val)
+		if err == nil {
+			return tval, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// listResult - Get the records from the response of the List call
+func listResult(listdata utils.Map) []utils.Map {
+	return toMapSlice(listdata[db_common.LIST_RESULT])
+}
+
+// roundAmount - Round the amount to 2 decimals
+func roundAmount(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// buildFilter - Build the filter string for the List / Find calls
+// The values are JSON encoded, so the values passed by the user are safe to use
+func buildFilter(filter utils.Map) string {
+	data, err := json.Marshal(filter)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// listDao - List call of the repository DAOs
+type listDao interface {
+	List(filter string, sort string, skip int64, limit int64) (utils.Map, error)
+}
+
+// findActive - Find the active records matching the filter, limit 0 returns all the records
+// List is used instead of Find since Find returns an error for a missing record,
+// so an error here is always a database error and never means "not found"
+func findActive(dao listDao, filter utils.Map, limit int64) ([]utils.Map, error) {
+	query := buildFilter(utils.Map{"$and": []utils.Map{
+		filter,
+		{db_common.FLD_IS_DELETED: utils.Map{"$ne": true}},
+	}})
+
+	listdata, err := dao.List(query, "", 0, limit)
+	if err != nil {
+		return nil, err
+	}
+	return activeRecords(listdata), nil
+}
+
+// recordExists - Check whether any active record matches the filter
+func recordExists(dao listDao, filter utils.Map) (bool, error) {
+	records, err := findActive(dao, filter, 1)
+	if err != nil {
+		return false, err
+	}
+	return len(records) > 0, nil
+}
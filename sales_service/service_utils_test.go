@@ -0,0 +1,80 @@
+package sales_service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zapscloud/golib-utils/utils"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// roundTrip - Store and read back the record the way the mongo DAO does
+func roundTrip(t *testing.T, record utils.Map) utils.Map {
+	t.Helper()
+
+	data, err := bson.Marshal(record)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	stored := utils.Map{}
+	if err := bson.Unmarshal(data, &stored); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	return stored
+}
+
+func TestHelpersReadStoredValues(t *testing.T) {
+	validTill := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	stored := roundTrip(t, utils.Map{
+		"ids":        []string{"a", "b"},
+		"tiers":      []utils.Map{{"min_quantity": 2}, {"min_quantity": 5}},
+		"valid_till": validTill,
+		"nested":     utils.Map{"key": "value"},
+	})
+
+	if ids := toStringSlice(stored["ids"]); len(ids) != 2 || ids[0] != "a" || ids[1] != "b" {
+		t.Errorf("toStringSlice = %v", ids)
+	}
+	tiers := toMapSlice(stored["tiers"])
+	if len(tiers) != 2 || toInt(tiers[1]["min_quantity"]) != 5 {
+		t.Errorf("toMapSlice = %v", tiers)
+	}
+	if till, ok := toTime(stored["valid_till"]); !ok || !till.Equal(validTill) {
+		t.Errorf("toTime = %v, %v", till, ok)
+	}
+	if nested, ok := toMap(stored["nested"]); !ok || nested["key"] != "value" {
+		t.Errorf("toMap = %v, %v", nested, ok)
+	}
+}
+
+func TestHelpersReadBsonTypes(t *testing.T) {
+	data, err := bson.Marshal(bson.M{"doc": bson.M{"key": "value"}, "list": bson.A{bson.D{{Key: "key", Value: "value"}}}})
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	stored := bson.M{}
+	if err := bson.Unmarshal(data, &stored); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	if doc, ok := toMap(stored["doc"]); !ok || doc["key"] != "value" {
+		t.Errorf("toMap = %v, %v", doc, ok)
+	}
+	if list := toMapSlice(stored["list"]); len(list) != 1 || list[0]["key"] != "value" {
+		t.Errorf("toMapSlice = %v", list)
+	}
+}
+
+func TestStoredOfferScope(t *testing.T) {
+	offer := roundTrip(t, utils.Map{
+		FLD_OFFER_PRODUCT_IDS:  []string{"prod1"},
+		FLD_OFFER_CATEGORY_IDS: []any{"cat1"},
+	})
+
+	if !inOfferScope(offer, cartLine{productId: "prod1", categoryId: "cat1"}) {
+		t.Error("product in scope is not matched")
+	}
+	if inOfferScope(offer, cartLine{productId: "prod2", categoryId: "cat1"}) {
+		t.Error("product out of scope is matched")
+	}
+}
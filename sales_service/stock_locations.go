@@ -0,0 +1,197 @@
+package sales_service
+
+import (
+	"math"
+	"sort"
+
+	"github.com/zapscloud/golib-sales-repository/sales_common"
+	"github.com/zapscloud/golib-utils/utils"
+)
+
+const (
+	// Business setting for the stock locations
+	FLD_STOCK_LOCATIONS = "stock_locations"
+
+	// Stock location fields
+	FLD_LOCATION_ID         = "location_id"
+	FLD_LOCATION_NAME       = "location_name"
+	FLD_LOCATION_TYPE       = "location_type"
+	FLD_LOCATION_REGION_IDS = "region_ids"
+	FLD_LOCATION_LATITUDE   = "latitude"
+	FLD_LOCATION_LONGITUDE  = "longitude"
+	FLD_LOCATION_DEALER_ID  = "dealer_id"
+
+	// Product field for the stock per location
+	FLD_PRODUCT_LOCATION_STOCK = "location_stock"
+
+	// Sourcing request and response fields
+	FLD_SOURCING_ALLOW_SPLIT = "allow_split"
+	FLD_SOURCING_SHIPMENTS   = "shipments"
+	FLD_SOURCING_IS_SPLIT    = "is_split"
+	FLD_SOURCING_DISTANCE_KM = "distance_km"
+	FLD_SOURCING_SHORTAGE    = "shortage"
+
+	LOCATION_TYPE_WAREHOUSE    = "warehouse"
+	LOCATION_TYPE_DEALER_STORE = "dealer_store"
+
+	earthRadiusKm = 6371.0
+)
+
+// sourceLocation - Stock location considered for the order
+type sourceLocation struct {
+	location utils.Map
+	distance float64
+	hasDist  bool
+	stock    map[string]int
+}
+
+// locationId - Id of the stock location
+func (s *sourceLocation) locationId() string {
+	locationId, _ := s.location[FLD_LOCATION_ID].(string)
+	return locationId
+}
+
+// servesRegion - Check whether the location ships to the region, all regions when the region is not given
+func servesRegion(location utils.Map, regionId string) bool {
+	if len(regionId) == 0 {
+		return true
+	}
+	for _, locRegionId := range toStringSlice(location[FLD_LOCATION_REGION_IDS]) {
+		if locRegionId == regionId {
+			return true
+		}
+	}
+	return false
+}
+
+// locationDistance - Distance in km between the location and the delivery coordinates
+func locationDistance(location utils.Map, latitude any, longitude any) (float64, bool) {
+	if location[FLD_LOCATION_LATITUDE] == nil || location[FLD_LOCATION_LONGITUDE] == nil || latitude == nil || longitude == nil {
+		return 0, false
+	}
+
+	lat1 := toFloat(location[FLD_LOCATION_LATITUDE]) * math.Pi / 180
+	lat2 := toFloat(latitude) * math.Pi / 180
+	dLat := lat2 - lat1
+	dLon := (toFloat(longitude) - toFloat(location[FLD_LOCATION_LONGITUDE])) * math.Pi / 180
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return earthRadiusKm * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a)), true
+}
+
+// sortByProximity - Sort the locations nearest first, locations without coordinates are placed last
+func sortByProximity(sources []*sourceLocation) {
+	sort.SliceStable(sources, func(i, j int) bool {
+		if sources[i].hasDist != sources[j].hasDist {
+			return sources[i].hasDist
+		}
+		return sources[i].distance < sources[j].distance
+	})
+}
+
+// canFulfill - Check whether the location has the stock for all the items
+func (s *sourceLocation) canFulfill(quantities map[string]int) bool {
+	for productId, quantity := range quantities {
+		if s.stock[productId] < quantity {
+			return false
+		}
+	}
+	return true
+}
+
+// coverage - Quantity of the remaining items the location can ship
+func (s *sourceLocation) coverage(remaining map[string]int) int {
+	covered := 0
+	for productId, quantity := range remaining {
+		covered += minInt(quantity, s.stock[productId])
+	}
+	return covered
+}
+
+// selectSources - Pick the locations for the items, a single location is preferred over the split shipment.
+// On split, the location covering the most of the remaining quantity is picked first, nearest on a tie.
+// Returns the allocation per location id in the order picked and the quantities not available.
+func selectSources(sources []*sourceLocation, quantities map[string]int, allowSplit bool) ([]*sourceLocation, map[string]map[string]int, map[string]int) {
+	sortByProximity(sources)
+
+	for _, source := range sources {
+		if source.canFulfill(quantities) {
+			return []*sourceLocation{source}, map[string]map[string]int{source.locationId(): quantities}, map[string]int{}
+		}
+	}
+
+	if !allowSplit {
+		return nil, nil, quantities
+	}
+
+	remaining := map[string]int{}
+	for productId, quantity := range quantities {
+		remaining[productId] = quantity
+	}
+
+	picked := []*sourceLocation{}
+	allocations := map[string]map[string]int{}
+	used := map[string]bool{}
+	for len(remaining) > 0 {
+		var best *sourceLocation
+		bestCoverage := 0
+		for _, source := range sources {
+			if used[source.locationId()] {
+				continue
+			}
+			// Sources are sorted by proximity, so the first one wins the tie
+			if covered := source.coverage(remaining); covered > bestCoverage {
+				best = source
+				bestCoverage = covered
+			}
+		}
+		if best == nil {
+			break
+		}
+
+		used[best.locationId()] = true
+		picked = append(picked, best)
+		allocation := map[string]int{}
+		for productId, quantity := range remaining {
+			shipQty := minInt(quantity, best.stock[productId])
+			if shipQty <= 0 {
+				continue
+			}
+			allocation[productId] = shipQty
+			if shipQty == quantity {
+				delete(remaining, productId)
+			} else {
+				remaining[productId] = quantity - shipQty
+			}
+		}
+		allocations[best.locationId()] = allocation
+	}
+
+	return picked, allocations, remaining
+}
+
+// shipmentItems - Build the item list of the shipment
+func shipmentItems(allocation map[string]int) []utils.Map {
+	productIds := make([]string, 0, len(allocation))
+	for productId := range allocation {
+		productIds = append(productIds, productId)
+	}
+	sort.Strings(productIds)
+
+	items := make([]utils.Map, 0, len(productIds))
+	for _, productId := range productIds {
+		items = append(items, utils.Map{
+			sales_common.FLD_PRODUCT_ID: productId,
+			FLD_RESERVATION_QUANTITY:    allocation[productId],
+		})
+	}
+	return items
+}
+
+// minInt - Smaller of the two values
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}